@@ -0,0 +1,147 @@
+// Package audit builds the entries of the audit log: the fields an action changed, and the hash
+// chaining every entry to the one before it, so that editing or removing an entry shows.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+// Diff returns the top level fields that differ between before and after, as they are
+// serialized to JSON, sorted by field. Either may be nil, for something created or deleted.
+// Fields never serialized, such as passwords and tokens, never show.
+func Diff(before, after interface{}) []models.AuditChange {
+	older, newer := fields(before), fields(after)
+	names := make([]string, 0, len(older)+len(newer))
+	for name := range older {
+		names = append(names, name)
+	}
+	for name := range newer {
+		if _, ok := older[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := make([]models.AuditChange, 0)
+	for _, name := range names {
+		from, to := older[name], newer[name]
+		if equalJSON(from, to) {
+			continue
+		}
+		changes = append(changes, models.AuditChange{Field: name, Before: string(from), After: string(to)})
+	}
+	return changes
+}
+
+// fields splits the JSON object of value into its fields; anything but an object has none.
+func fields(value interface{}) map[string]json.RawMessage {
+	object := map[string]json.RawMessage{}
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil()) {
+		return object
+	}
+	data, err := json.Marshal(value)
+	if err != nil || json.Unmarshal(data, &object) != nil {
+		return map[string]json.RawMessage{}
+	}
+	return object
+}
+
+// equalJSON compares two JSON values whatever their formatting and key order, a missing value
+// being equal to null.
+func equalJSON(a, b json.RawMessage) bool {
+	var x, y interface{}
+	if len(a) > 0 && json.Unmarshal(a, &x) != nil {
+		return false
+	}
+	if len(b) > 0 && json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// Precision is the precision timestamps are stored, and so hashed, with.
+const Precision = time.Millisecond
+
+// hashed lists, in a fixed order, everything the hash of an entry covers.
+type hashed struct {
+	Seq         int64                `json:"seq"`
+	Prev_Hash   string               `json:"prev_hash"`
+	Action      string               `json:"action"`
+	Actor_ID    string               `json:"actor_id"`
+	API_Key_ID  string               `json:"api_key_id"`
+	Target_Type string               `json:"target_type"`
+	Target_ID   string               `json:"target_id"`
+	Changes     []models.AuditChange `json:"changes"`
+	Details     map[string]string    `json:"details"`
+	IP          string               `json:"ip"`
+	Request_ID  string               `json:"request_id"`
+	Created_At  string               `json:"created_at"`
+}
+
+// Hash returns the hex sha256 of the entry's content and the hash of the entry before it,
+// Prev_Hash. Its own Hash field is left out.
+func Hash(entry *models.AuditEntry) string {
+	changes := entry.Changes
+	if changes == nil {
+		changes = []models.AuditChange{}
+	}
+	details := entry.Details
+	if details == nil {
+		details = map[string]string{}
+	}
+	// maps are marshalled with sorted keys, so the encoding is canonical
+	data, _ := json.Marshal(hashed{
+		Seq:         entry.Seq,
+		Prev_Hash:   entry.Prev_Hash,
+		Action:      entry.Action,
+		Actor_ID:    entry.Actor_ID,
+		API_Key_ID:  entry.API_Key_ID,
+		Target_Type: entry.Target_Type,
+		Target_ID:   entry.Target_ID,
+		Changes:     changes,
+		Details:     details,
+		IP:          entry.IP,
+		Request_ID:  entry.Request_ID,
+		Created_At:  entry.Created_At.UTC().Truncate(Precision).Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Chain makes entry the one following previous, nil for the first entry, and seals it with its hash.
+func Chain(entry, previous *models.AuditEntry) {
+	entry.Seq, entry.Prev_Hash = 1, ""
+	if previous != nil {
+		entry.Seq, entry.Prev_Hash = previous.Seq+1, previous.Hash
+	}
+	entry.Created_At = entry.Created_At.Truncate(Precision)
+	entry.Hash = Hash(entry)
+}
+
+// Check tells why entry can't follow previous, nil for the first entry, or returns "" when it
+// can: its sequence number and previous hash must follow on, and its hash match its content.
+func Check(entry, previous *models.AuditEntry) string {
+	if previous == nil {
+		if entry.Seq != 1 || entry.Prev_Hash != "" {
+			return "the log doesn't start at the first entry"
+		}
+	} else {
+		if entry.Seq != previous.Seq+1 {
+			return "entries are missing before this one"
+		}
+		if entry.Prev_Hash != previous.Hash {
+			return "the entry doesn't follow the one before it"
+		}
+	}
+	if Hash(entry) != entry.Hash {
+		return "the entry was changed after it was written"
+	}
+	return ""
+}
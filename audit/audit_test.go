@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+type account struct {
+	Name     string   `json:"name"`
+	Role     string   `json:"role,omitempty"`
+	Tags     []string `json:"tags"`
+	Password string   `json:"-"`
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after interface{}
+		changes       []models.AuditChange
+	}{
+		{
+			"nothing changed",
+			&account{Name: "Ana", Tags: []string{"a"}, Password: "old"},
+			&account{Name: "Ana", Tags: []string{"a"}, Password: "new"},
+			[]models.AuditChange{},
+		},
+		{
+			"fields changed, sorted",
+			&account{Name: "Ana", Role: "USER"},
+			&account{Name: "Bea", Role: "ADMIN"},
+			[]models.AuditChange{
+				{Field: "name", Before: `"Ana"`, After: `"Bea"`},
+				{Field: "role", Before: `"USER"`, After: `"ADMIN"`},
+			},
+		},
+		{
+			"field removed",
+			&account{Name: "Ana", Role: "USER", Tags: []string{}},
+			&account{Name: "Ana", Tags: []string{}},
+			[]models.AuditChange{{Field: "role", Before: `"USER"`}},
+		},
+		{
+			"created",
+			nil,
+			map[string]int{"stock": 3},
+			[]models.AuditChange{{Field: "stock", After: "3"}},
+		},
+		{
+			"deleted through a nil pointer",
+			map[string]int{"stock": 3},
+			(*account)(nil),
+			[]models.AuditChange{{Field: "stock", Before: "3"}},
+		},
+		{
+			"null and missing are the same",
+			map[string]interface{}{"note": nil},
+			map[string]interface{}{},
+			[]models.AuditChange{},
+		},
+	}
+	for _, test := range tests {
+		if changes := Diff(test.before, test.after); !reflect.DeepEqual(changes, test.changes) {
+			t.Errorf("%s: Diff = %+v, want %+v", test.name, changes, test.changes)
+		}
+	}
+}
+
+// chain returns n entries chained from the first.
+func chain(n int) []*models.AuditEntry {
+	entries := make([]*models.AuditEntry, n)
+	var previous *models.AuditEntry
+	for i := range entries {
+		entries[i] = &models.AuditEntry{
+			Action:      models.AuditLoginFailed,
+			Target_Type: models.AuditTargetUser,
+			Target_ID:   "user",
+			Details:     map[string]string{"email": "ana@example.com"},
+			IP:          "192.0.2.1",
+			Created_At:  time.Date(2024, 1, 1, 0, 0, i, 123456789, time.UTC),
+		}
+		Chain(entries[i], previous)
+		previous = entries[i]
+	}
+	return entries
+}
+
+func TestChain(t *testing.T) {
+	entries := chain(3)
+	for i, entry := range entries {
+		if entry.Seq != int64(i+1) {
+			t.Errorf("entry %d: Seq = %d, want %d", i, entry.Seq, i+1)
+		}
+		if entry.Created_At.Nanosecond()%int(Precision) != 0 {
+			t.Errorf("entry %d: Created_At %v is not truncated to %v", i, entry.Created_At, Precision)
+		}
+	}
+	if entries[0].Prev_Hash != "" || entries[1].Prev_Hash != entries[0].Hash || entries[2].Prev_Hash != entries[1].Hash {
+		t.Error("the entries don't carry the hash of the one before them")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(entries []*models.AuditEntry)
+		at      int
+		invalid bool
+	}{
+		{"intact", func([]*models.AuditEntry) {}, 0, false},
+		{"content changed", func(entries []*models.AuditEntry) { entries[1].Details["email"] = "bea@example.com" }, 1, true},
+		{"time changed", func(entries []*models.AuditEntry) { entries[1].Created_At = entries[1].Created_At.Add(time.Second) }, 1, true},
+		{"entry removed", func(entries []*models.AuditEntry) { entries[1] = entries[2] }, 1, true},
+		{"entry rehashed", func(entries []*models.AuditEntry) {
+			entries[1].Target_ID = "someone else"
+			entries[1].Hash = Hash(entries[1])
+		}, 2, true},
+		{"first entry replaced", func(entries []*models.AuditEntry) { Chain(entries[0], entries[2]) }, 0, true},
+	}
+	for _, test := range tests {
+		entries := chain(3)
+		test.tamper(entries)
+
+		at, reason := -1, ""
+		var previous *models.AuditEntry
+		for i, entry := range entries {
+			if reason = Check(entry, previous); reason != "" {
+				at = i
+				break
+			}
+			previous = entry
+		}
+		switch {
+		case !test.invalid && reason != "":
+			t.Errorf("%s: Check reported %q at entry %d", test.name, reason, at)
+		case test.invalid && at != test.at:
+			t.Errorf("%s: Check broke at entry %d (%q), want entry %d", test.name, at, reason, test.at)
+		}
+	}
+}
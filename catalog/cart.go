@@ -0,0 +1,88 @@
+package catalog
+
+import (
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// cartKey tells apart the lines of a cart: a product, or one of its variants.
+type cartKey struct {
+	product primitive.ObjectID
+	variant primitive.ObjectID
+}
+
+func keyOf(item models.ProductUser) cartKey {
+	key := cartKey{product: item.Product_ID}
+	if item.Variant_ID != nil {
+		key.variant = *item.Variant_ID
+	}
+	return key
+}
+
+// MergeCart adds the units of a guest cart to a user cart. Lines in both carts are combined,
+// and every merged line is rebuilt from the current product so it carries today's price.
+// Guest units of products that are gone, sold out or short on stock are dropped; the
+// number dropped is returned. Lines only in the user cart are left as they are.
+func MergeCart(userCart, guestCart []models.ProductUser, products map[primitive.ObjectID]*models.Product) ([]models.ProductUser, int) {
+	guestUnits := make(map[cartKey]int)
+	var order []cartKey
+	for _, item := range guestCart {
+		key := keyOf(item)
+		if guestUnits[key] == 0 {
+			order = append(order, key)
+		}
+		guestUnits[key]++
+	}
+
+	merged := make([]models.ProductUser, 0, len(userCart)+len(guestCart))
+	userLines := make(map[cartKey][]models.ProductUser)
+	for _, item := range userCart {
+		key := keyOf(item)
+		if guestUnits[key] == 0 {
+			merged = append(merged, item)
+			continue
+		}
+		userLines[key] = append(userLines[key], item)
+	}
+
+	dropped := 0
+	for _, key := range order {
+		var entry models.ProductUser
+		var err error = ErrUnknownVariant
+		if product := products[key.product]; product != nil {
+			var variantID *primitive.ObjectID
+			if !key.variant.IsZero() {
+				variantID = &key.variant
+			}
+			entry, err = CartItem(product, variantID)
+			if err == nil {
+				quantity := len(userLines[key]) + guestUnits[key]
+				if stock := stockOf(product, variantID); stock != nil && quantity > *stock {
+					quantity = max(*stock, len(userLines[key]))
+				}
+				dropped += len(userLines[key]) + guestUnits[key] - quantity
+				for i := 0; i < quantity; i++ {
+					merged = append(merged, entry)
+				}
+				continue
+			}
+		}
+
+		// the guest's units can't be had, the user keeps theirs until checkout says otherwise
+		merged = append(merged, userLines[key]...)
+		dropped += guestUnits[key]
+	}
+	return merged, dropped
+}
+
+// stockOf returns the tracked stock of a product or of its variant, nil when untracked.
+func stockOf(product *models.Product, variantID *primitive.ObjectID) *int {
+	if variantID == nil {
+		return product.Stock
+	}
+	variant, err := FindVariant(product, *variantID)
+	if err != nil {
+		return nil
+	}
+	return variant.Stock
+}
@@ -0,0 +1,53 @@
+package catalog
+
+import (
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+// Discount is what a coupon takes off an items subtotal, never more than the subtotal.
+func Discount(coupon *models.Coupon, subtotal int) int {
+	discount := coupon.Amount_Off
+	if coupon.Percent_Off > 0 {
+		discount = subtotal * coupon.Percent_Off / 100
+	}
+	if discount > subtotal {
+		discount = subtotal
+	}
+	if discount < 0 {
+		discount = 0
+	}
+	return discount
+}
+
+// ApplyDiscount spreads a discount over the items in proportion to their prices, so taxes
+// and invoices see the prices actually charged. The cents lost to rounding come off the
+// first items.
+func ApplyDiscount(items []models.ProductUser, discount int) []models.ProductUser {
+	subtotal := 0
+	for _, item := range items {
+		subtotal += item.Price
+	}
+	if discount <= 0 || subtotal <= 0 {
+		return items
+	}
+	if discount > subtotal {
+		discount = subtotal
+	}
+
+	discounted := make([]models.ProductUser, len(items))
+	left := discount
+	for i, item := range items {
+		cut := item.Price * discount / subtotal
+		item.Price -= cut
+		left -= cut
+		discounted[i] = item
+	}
+	// each item lost less than a cent to rounding, so one pass settles the rest
+	for i := 0; left > 0 && i < len(discounted); i++ {
+		if discounted[i].Price > 0 {
+			discounted[i].Price--
+			left--
+		}
+	}
+	return discounted
+}
@@ -0,0 +1,100 @@
+package catalog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RevalidateCart checks a cart against the current products. It returns the cart as it can be
+// ordered now, with today's prices, without the lines that are gone or sold out and with the
+// short lines cut down to the stock left, along with an issue for every line that changed.
+func RevalidateCart(cart []models.ProductUser, products map[primitive.ObjectID]*models.Product) models.CartReview {
+	units := make(map[cartKey][]models.ProductUser)
+	var order []cartKey
+	for _, item := range cart {
+		key := keyOf(item)
+		if len(units[key]) == 0 {
+			order = append(order, key)
+		}
+		units[key] = append(units[key], item)
+	}
+
+	review := models.CartReview{Items: make([]models.ProductUser, 0, len(cart)), Issues: make([]models.CartIssue, 0)}
+	for _, key := range order {
+		lines := units[key]
+		issue := models.CartIssue{Product_ID: key.product, Variant_ID: lines[0].Variant_ID, Product_Name: lines[0].Product_Name}
+
+		product := products[key.product]
+		if product == nil {
+			issue.Kind = models.CartItemRemoved
+			review.Issues = append(review.Issues, issue)
+			continue
+		}
+		entry, err := CartItem(product, lines[0].Variant_ID)
+		switch err {
+		case nil:
+		case ErrOutOfStock:
+			issue.Kind = models.CartOutOfStock
+			issue.Requested = len(lines)
+			review.Issues = append(review.Issues, issue)
+			continue
+		default:
+			issue.Kind = models.CartItemRemoved
+			review.Issues = append(review.Issues, issue)
+			continue
+		}
+
+		// units added at different times may carry different prices, report each old price once
+		seen := make(map[int]bool)
+		for _, line := range lines {
+			if line.Price != entry.Price && !seen[line.Price] {
+				seen[line.Price] = true
+				changed := issue
+				changed.Kind = models.CartPriceChanged
+				changed.Old_Price = line.Price
+				changed.New_Price = entry.Price
+				review.Issues = append(review.Issues, changed)
+			}
+		}
+
+		quantity := len(lines)
+		if stock := stockOf(product, lines[0].Variant_ID); stock != nil && quantity > *stock {
+			short := issue
+			short.Kind = models.CartInsufficientStock
+			short.Requested = quantity
+			short.Available = *stock
+			review.Issues = append(review.Issues, short)
+			quantity = *stock
+		}
+		for i := 0; i < quantity; i++ {
+			review.Items = append(review.Items, entry)
+			review.Total += entry.Price
+		}
+	}
+
+	if len(review.Issues) > 0 {
+		review.Acknowledgement = Acknowledgement(review.Issues)
+	}
+	return review
+}
+
+// Acknowledgement fingerprints a list of cart issues. Checkout compares it with the one the
+// shopper was shown, so new changes are never accepted on their behalf.
+func Acknowledgement(issues []models.CartIssue) string {
+	var canonical strings.Builder
+	for _, issue := range issues {
+		variant := ""
+		if issue.Variant_ID != nil {
+			variant = issue.Variant_ID.Hex()
+		}
+		fmt.Fprintf(&canonical, "%s|%s|%s|%d|%d|%d|%d\n", issue.Product_ID.Hex(), variant, issue.Kind,
+			issue.Old_Price, issue.New_Price, issue.Requested, issue.Available)
+	}
+	sum := sha256.Sum256([]byte(canonical.String()))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,329 @@
+package catalog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Bulk transfer formats.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+var (
+	ErrUnknownFormat = errors.New("format must be csv or jsonl")
+	ErrRowRequired   = errors.New("sku, product_name and price are required")
+)
+
+// Columns are the CSV columns, in export order. Options and variants only travel in JSON Lines.
+var Columns = []string{"sku", "product_name", "price", "image", "weight", "length", "width", "height", "tax_class", "stock", "category_ids"}
+
+// requiredColumns must be present in the header of an imported CSV.
+var requiredColumns = []string{"sku", "product_name", "price"}
+
+// Row is a decoded import row. Product holds only the fields present in the row;
+// Err is set when the row could not be decoded.
+type Row struct {
+	Number  int
+	Product models.Product
+	Err     error
+}
+
+// Reader decodes products one row at a time.
+type Reader interface {
+	// Next returns the next row, or io.EOF once the input is exhausted.
+	Next() (Row, error)
+}
+
+// NewReader returns a reader for the format. A CSV input must start with a header
+// naming its columns, in any order.
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("can't read the csv header: %w", err)
+		}
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+			if !knownColumn(name) {
+				return nil, fmt.Errorf("unknown column %q", name)
+			}
+			columns[name] = i
+		}
+		for _, name := range requiredColumns {
+			if _, ok := columns[name]; !ok {
+				return nil, fmt.Errorf("missing column %q", name)
+			}
+		}
+		// the header is line 1
+		return &csvReader{reader: reader, columns: columns, line: 1}, nil
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 4<<20)
+		return &jsonlReader{scanner: scanner}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	line    int
+}
+
+func (c *csvReader) Next() (Row, error) {
+	record, err := c.reader.Read()
+	if err == io.EOF {
+		return Row{}, io.EOF
+	}
+	c.line++
+	row := Row{Number: c.line}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if !errors.As(err, &parseErr) {
+			return row, err
+		}
+		row.Err = err
+		return row, nil
+	}
+
+	cell := func(name string) string {
+		if i, ok := c.columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	row.Product, row.Err = parseRecord(cell)
+	return row, nil
+}
+
+func parseRecord(cell func(string) string) (models.Product, error) {
+	var product models.Product
+	if value := cell("sku"); value != "" {
+		product.Sku = &value
+	}
+	if value := cell("product_name"); value != "" {
+		product.Product_Name = &value
+	}
+	if value := cell("image"); value != "" {
+		product.Image = &value
+	}
+	if value := cell("tax_class"); value != "" {
+		product.Tax_Class = &value
+	}
+
+	var err error
+	if product.Price, err = parseUint(cell, "price"); err != nil {
+		return product, err
+	}
+	if product.Weight, err = parseUint(cell, "weight"); err != nil {
+		return product, err
+	}
+	if value := cell("stock"); value != "" {
+		stock, err := strconv.Atoi(value)
+		if err != nil || stock < 0 {
+			return product, fmt.Errorf("stock: %q is not a whole number", value)
+		}
+		product.Stock = &stock
+	}
+
+	if cell("length") != "" || cell("width") != "" || cell("height") != "" {
+		var dimensions [3]*uint64
+		for i, name := range []string{"length", "width", "height"} {
+			if dimensions[i], err = parseUint(cell, name); err != nil {
+				return product, err
+			}
+			if dimensions[i] == nil {
+				return product, errors.New("length, width and height go together")
+			}
+		}
+		product.Dimensions = &models.Dimensions{Length: *dimensions[0], Width: *dimensions[1], Height: *dimensions[2]}
+	}
+
+	if value := cell("category_ids"); value != "" {
+		for _, hex := range strings.Split(value, "|") {
+			categoryID, err := primitive.ObjectIDFromHex(strings.TrimSpace(hex))
+			if err != nil {
+				return product, fmt.Errorf("category_ids: %q is not a valid id", hex)
+			}
+			product.Category_IDs = append(product.Category_IDs, categoryID)
+		}
+	}
+	return product, checkRow(product)
+}
+
+func parseUint(cell func(string) string, name string) (*uint64, error) {
+	value := cell(name)
+	if value == "" {
+		return nil, nil
+	}
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %q is not a whole number of minor units", name, value)
+	}
+	return &number, nil
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (j *jsonlReader) Next() (Row, error) {
+	for j.scanner.Scan() {
+		j.line++
+		line := strings.TrimSpace(j.scanner.Text())
+		if line == "" {
+			continue
+		}
+		row := Row{Number: j.line}
+		if err := json.Unmarshal([]byte(line), &row.Product); err != nil {
+			row.Err = err
+			return row, nil
+		}
+		row.Err = checkRow(row.Product)
+		if row.Err == nil {
+			row.Err = PrepareVariants(&row.Product)
+		}
+		return row, nil
+	}
+	if err := j.scanner.Err(); err != nil {
+		return Row{}, err
+	}
+	return Row{}, io.EOF
+}
+
+func checkRow(product models.Product) error {
+	if product.Sku == nil || *product.Sku == "" || product.Product_Name == nil || *product.Product_Name == "" || product.Price == nil {
+		return ErrRowRequired
+	}
+	return nil
+}
+
+func knownColumn(name string) bool {
+	for _, column := range Columns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Writer encodes products in an export format.
+type Writer interface {
+	Write(product models.Product) error
+	// Flush writes out anything buffered and reports the first write error.
+	Flush() error
+}
+
+// NewWriter returns a writer for the format. CSV output starts with a header.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(Columns); err != nil {
+			return nil, err
+		}
+		return &csvWriter{writer: writer}, nil
+	case FormatJSONL:
+		buffered := bufio.NewWriter(w)
+		return &jsonlWriter{buffered: buffered, encoder: json.NewEncoder(buffered)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func (c *csvWriter) Write(product models.Product) error {
+	record := make([]string, len(Columns))
+	for i, column := range Columns {
+		switch column {
+		case "sku":
+			record[i] = stringOf(product.Sku)
+		case "product_name":
+			record[i] = stringOf(product.Product_Name)
+		case "price":
+			record[i] = uintOf(product.Price)
+		case "image":
+			record[i] = stringOf(product.Image)
+		case "weight":
+			record[i] = uintOf(product.Weight)
+		case "length":
+			if product.Dimensions != nil {
+				record[i] = strconv.FormatUint(product.Dimensions.Length, 10)
+			}
+		case "width":
+			if product.Dimensions != nil {
+				record[i] = strconv.FormatUint(product.Dimensions.Width, 10)
+			}
+		case "height":
+			if product.Dimensions != nil {
+				record[i] = strconv.FormatUint(product.Dimensions.Height, 10)
+			}
+		case "tax_class":
+			record[i] = stringOf(product.Tax_Class)
+		case "stock":
+			if product.Stock != nil {
+				record[i] = strconv.Itoa(*product.Stock)
+			}
+		case "category_ids":
+			ids := make([]string, len(product.Category_IDs))
+			for j, categoryID := range product.Category_IDs {
+				ids[j] = categoryID.Hex()
+			}
+			record[i] = strings.Join(ids, "|")
+		}
+	}
+	return c.writer.Write(record)
+}
+
+func (c *csvWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type jsonlWriter struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (j *jsonlWriter) Write(product models.Product) error {
+	return j.encoder.Encode(product)
+}
+
+func (j *jsonlWriter) Flush() error {
+	return j.buffered.Flush()
+}
+
+func stringOf(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func uintOf(value *uint64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatUint(*value, 10)
+}
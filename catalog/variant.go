@@ -0,0 +1,171 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrVariantRequired = errors.New("this product comes in several options, choose a variant")
+	ErrUnknownVariant  = errors.New("the product has no such variant")
+	ErrOutOfStock      = errors.New("the product is out of stock")
+	ErrNoVariants      = errors.New("a product with options needs at least one variant")
+	ErrNoOptions       = errors.New("variants need the product to declare its options")
+)
+
+// PrepareVariants checks that the variants of a product match its options: every
+// variant sets each option to one of its values, combinations and SKUs are
+// unique. New variants get their ids here.
+func PrepareVariants(product *models.Product) error {
+	if len(product.Options) == 0 {
+		if len(product.Variants) > 0 {
+			return ErrNoOptions
+		}
+		return nil
+	}
+	if len(product.Variants) == 0 {
+		return ErrNoVariants
+	}
+
+	allowed := make(map[string]map[string]bool, len(product.Options))
+	for _, option := range product.Options {
+		if _, duplicate := allowed[option.Name]; duplicate {
+			return fmt.Errorf("option %q is declared twice", option.Name)
+		}
+		allowed[option.Name] = make(map[string]bool, len(option.Values))
+		for _, value := range option.Values {
+			allowed[option.Name][value] = true
+		}
+	}
+
+	combinations := make(map[string]bool, len(product.Variants))
+	skus := make(map[string]bool, len(product.Variants))
+	for i := range product.Variants {
+		variant := &product.Variants[i]
+		if len(variant.Attributes) != len(allowed) {
+			return fmt.Errorf("variant %s must set exactly the options %s", sku(variant), optionNames(product))
+		}
+		for name, value := range variant.Attributes {
+			values, ok := allowed[name]
+			if !ok {
+				return fmt.Errorf("variant %s sets unknown option %q", sku(variant), name)
+			}
+			if !values[value] {
+				return fmt.Errorf("variant %s sets %s to %q, which isn't one of its values", sku(variant), name, value)
+			}
+		}
+
+		key := Combination(variant.Attributes)
+		if combinations[key] {
+			return fmt.Errorf("two variants share the options %s", key)
+		}
+		combinations[key] = true
+
+		if skus[*variant.Sku] {
+			return fmt.Errorf("two variants share the sku %s", *variant.Sku)
+		}
+		skus[*variant.Sku] = true
+
+		if variant.Variant_ID.IsZero() {
+			variant.Variant_ID = primitive.NewObjectID()
+		}
+	}
+	return nil
+}
+
+// Combination describes a set of option values in a stable order, such as "color=red, size=M".
+func Combination(attributes map[string]string) string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+attributes[name])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FindVariant returns the variant of the product with the given id.
+func FindVariant(product *models.Product, variantID primitive.ObjectID) (*models.Variant, error) {
+	for i := range product.Variants {
+		if product.Variants[i].Variant_ID == variantID {
+			return &product.Variants[i], nil
+		}
+	}
+	return nil, ErrUnknownVariant
+}
+
+// CartItem builds the cart entry for a product, taking price, weight and image from
+// the chosen variant. Products with options can only be bought as a variant.
+func CartItem(product *models.Product, variantID *primitive.ObjectID) (models.ProductUser, error) {
+	item := models.ProductUser{
+		Product_ID:   product.Product_ID,
+		Product_Name: product.Product_Name,
+		Rating:       product.Rating,
+		Image:        product.Image,
+		Weight:       product.Weight,
+		Tax_Class:    product.Tax_Class,
+		Sku:          product.Sku,
+	}
+	if product.Price != nil {
+		item.Price = int(*product.Price)
+	}
+
+	if len(product.Options) == 0 {
+		if variantID != nil {
+			return item, ErrUnknownVariant
+		}
+		if product.Stock != nil && *product.Stock <= 0 {
+			return item, ErrOutOfStock
+		}
+		return item, nil
+	}
+
+	if variantID == nil {
+		return item, ErrVariantRequired
+	}
+	variant, err := FindVariant(product, *variantID)
+	if err != nil {
+		return item, err
+	}
+	item.Variant_ID = &variant.Variant_ID
+	item.Sku = variant.Sku
+	item.Attributes = variant.Attributes
+	if variant.Price != nil {
+		item.Price = int(*variant.Price)
+	}
+	if variant.Weight != nil {
+		item.Weight = variant.Weight
+	}
+	if len(variant.Images) > 0 {
+		item.Image = &variant.Images[0]
+	}
+	// the entry is filled in either way, wishlists still show sold out variants
+	if variant.Stock != nil && *variant.Stock <= 0 {
+		return item, ErrOutOfStock
+	}
+	return item, nil
+}
+
+func sku(variant *models.Variant) string {
+	if variant.Sku == nil {
+		return "without sku"
+	}
+	return *variant.Sku
+}
+
+func optionNames(product *models.Product) string {
+	names := make([]string, 0, len(product.Options))
+	for _, option := range product.Options {
+		names = append(names, option.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,29 @@
+package catalog
+
+import (
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+// FlagWishlistItem fills in the current details of a saved item from its product, which
+// is nil when the product was deleted. An item is unavailable when its product or
+// variant is gone, and its price changed when it differs from the price it was saved at.
+func FlagWishlistItem(item *models.WishlistItem, product *models.Product) {
+	item.Available = false
+	item.In_Stock = false
+	item.Price_Changed = false
+	if product == nil {
+		return
+	}
+
+	entry, err := CartItem(product, item.Variant_ID)
+	item.Product_Name = entry.Product_Name
+	item.Image = entry.Image
+	item.Attributes = entry.Attributes
+	if err != nil && err != ErrOutOfStock {
+		return
+	}
+	item.Available = true
+	item.In_Stock = err == nil
+	item.Price = entry.Price
+	item.Price_Changed = entry.Price != item.Price_Added
+}
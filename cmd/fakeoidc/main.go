@@ -0,0 +1,43 @@
+// Command fakeoidc serves a fake OpenID provider to try the social login locally. It signs in
+// the user given by its flags without asking anything. Run it with
+//
+//	go run ./cmd/fakeoidc -addr :9999 -email jane@example.com
+//
+// and start the application with OIDC_PROVIDERS=fake, OIDC_FAKE_ISSUER=http://localhost:9999,
+// OIDC_FAKE_CLIENT_ID=ecommerce and OIDC_FAKE_CLIENT_SECRET=secret.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/ravelinejunior/golang_ecommerce/oidc"
+)
+
+func main() {
+	addr := flag.String("addr", ":9999", "address to listen on")
+	issuer := flag.String("issuer", "", "issuer URL, by default the address requests are sent to")
+	clientID := flag.String("client-id", "ecommerce", "client id of the application")
+	clientSecret := flag.String("client-secret", "secret", "client secret of the application")
+	subject := flag.String("subject", "fake-user", "subject of the user signing in")
+	email := flag.String("email", "fake.user@example.com", "email of the user signing in")
+	verified := flag.Bool("email-verified", true, "whether the provider vouches for the email")
+	givenName := flag.String("given-name", "Fake", "first name of the user signing in")
+	familyName := flag.String("family-name", "User", "last name of the user signing in")
+	flag.Parse()
+
+	fake, err := oidc.NewFake(*clientID, *clientSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fake.Issuer = *issuer
+	fake.Subject = *subject
+	fake.Email = *email
+	fake.Email_Verified = *verified
+	fake.Given_Name = *givenName
+	fake.Family_Name = *familyName
+
+	log.Println("fake OpenID provider listening on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, fake))
+}
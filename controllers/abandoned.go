@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/notify"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var CouponCollection *mongo.Collection = database.OpenCollection(database.Client, "Coupons")
+var CartReminderCollection *mongo.Collection = database.OpenCollection(database.Client, "CartReminders")
+
+// CartNotifier delivers the abandoned cart reminders, see notify.FromEnv.
+var CartNotifier notify.Notifier = notify.FromEnv()
+
+// Abandoned cart settings, read from the environment.
+var (
+	// AbandonedCartAfter is how long a cart stays untouched before its owner is reminded.
+	AbandonedCartAfter = envDuration("ABANDONED_CART_AFTER", 24*time.Hour)
+	// AbandonedCartRetention is how long an untouched cart is kept before it is emptied.
+	AbandonedCartRetention = envDuration("ABANDONED_CART_RETENTION", 30*24*time.Hour)
+	// AbandonedCartInterval is how often the scheduler looks for abandoned carts.
+	AbandonedCartInterval = envDuration("ABANDONED_CART_INTERVAL", 15*time.Minute)
+	// AbandonedCartCouponPercent is the discount of the coupon sent along, 0 to send none.
+	AbandonedCartCouponPercent = envInt("ABANDONED_CART_COUPON_PERCENT", 0)
+	// AbandonedCartCouponTTL is how long a reminder coupon can be redeemed.
+	AbandonedCartCouponTTL = envDuration("ABANDONED_CART_COUPON_TTL", 72*time.Hour)
+	// CartRecoveryWindow is how long after a reminder an order counts as recovered.
+	CartRecoveryWindow = envDuration("CART_RECOVERY_WINDOW", 7*24*time.Hour)
+)
+
+// abandonedCartBatch bounds the reminders sent per sweep.
+const abandonedCartBatch = 100
+
+// StartCartRecovery runs the abandoned cart scheduler until the context is cancelled: every
+// AbandonedCartInterval it reminds the owners of idle carts and empties the expired ones.
+func StartCartRecovery(ctx context.Context) {
+	ticker := time.NewTicker(AbandonedCartInterval)
+	defer ticker.Stop()
+	for {
+		sweepAbandonedCarts(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepAbandonedCarts reminds the owners of idle carts, then empties the carts past retention.
+func sweepAbandonedCarts(parent context.Context) {
+	var ctx, cancel = context.WithTimeout(parent, AbandonedCartInterval)
+	defer cancel()
+
+	if err := database.StampCartActivity(ctx, UserCollection); err != nil {
+		log.Println("abandoned carts:", err)
+		return
+	}
+
+	users, err := database.FindAbandonedCarts(ctx, UserCollection, time.Now().Add(-AbandonedCartAfter), abandonedCartBatch)
+	if err != nil {
+		log.Println("abandoned carts:", err)
+		return
+	}
+	for i := range users {
+		if err := remindAbandonedCart(ctx, &users[i]); err != nil {
+			log.Println("abandoned cart of user", users[i].User_ID+":", err)
+		}
+	}
+
+	expired, err := database.ExpireStaleCarts(ctx, UserCollection, time.Now().Add(-AbandonedCartRetention))
+	if err != nil {
+		log.Println("abandoned carts:", err)
+		return
+	}
+	if expired > 0 {
+		log.Println("abandoned carts: emptied", expired, "carts idle for over", AbandonedCartRetention)
+	}
+}
+
+// remindAbandonedCart sends one reminder about the cart of a user, with a coupon when configured.
+func remindAbandonedCart(ctx context.Context, user *models.User) error {
+	claimed, err := database.ClaimCartReminder(ctx, UserCollection, user)
+	if err != nil || !claimed {
+		return err
+	}
+
+	// remind about the cart as it can be ordered now
+	review, err := database.RevalidateCart(ctx, ProductCollection, user.UserCart)
+	if err != nil {
+		database.UnclaimCartReminder(ctx, UserCollection, user)
+		return err
+	}
+	if len(review.Items) == 0 {
+		// nothing left that can be bought, don't nag about it
+		return nil
+	}
+
+	var coupon *models.Coupon
+	if AbandonedCartCouponPercent > 0 {
+		coupon = &models.Coupon{
+			Percent_Off: AbandonedCartCouponPercent,
+			User_ID:     user.User_ID,
+			Source:      models.CouponAbandonedCart,
+			Expires_At:  time.Now().Add(AbandonedCartCouponTTL),
+		}
+		if err := database.CreateCoupon(ctx, CouponCollection, coupon); err != nil {
+			database.UnclaimCartReminder(ctx, UserCollection, user)
+			return err
+		}
+	}
+
+	message := cartReminderMessage(user, review, coupon)
+	if err := CartNotifier.Notify(ctx, message); err != nil {
+		if coupon != nil {
+			database.DeleteCoupon(ctx, CouponCollection, coupon.Coupon_ID)
+		}
+		database.UnclaimCartReminder(ctx, UserCollection, user)
+		return err
+	}
+
+	reminder := models.CartReminder{
+		User_ID:    user.User_ID,
+		Channel:    CartNotifier.Name(),
+		Items:      len(review.Items),
+		Cart_Total: review.Total,
+		Sent_At:    time.Now(),
+	}
+	if coupon != nil {
+		reminder.Coupon_Code = coupon.Code
+	}
+	return database.RecordCartReminder(ctx, CartReminderCollection, &reminder)
+}
+
+// cartReminderMessage writes the reminder about a cart.
+func cartReminderMessage(user *models.User, review models.CartReview, coupon *models.Coupon) notify.Message {
+	message := notify.Message{
+		Kind:    "abandoned_cart",
+		User_ID: user.User_ID,
+		Subject: "You left something in your cart",
+		Body:    fmt.Sprintf("You still have %d items in your cart, %d in total. They are waiting for you.", len(review.Items), review.Total),
+		Data:    map[string]interface{}{"items": review.Items, "total": review.Total},
+	}
+	if user.Email != nil {
+		message.Email = *user.Email
+	}
+	if user.First_Name != nil {
+		message.Name = *user.First_Name
+	}
+	if coupon != nil {
+		message.Body += fmt.Sprintf(" Use the code %s before %s for %d%% off.", coupon.Code, coupon.Expires_At.Format("January 2"), coupon.Percent_Off)
+		message.Data["coupon_code"] = coupon.Code
+		message.Data["coupon_expires_at"] = coupon.Expires_At
+	}
+	return message
+}
+
+// claimCheckoutCoupon claims the coupon passed as ?coupon= for the logged in user's checkout,
+// answering 400 when it can't be used. It reports whether the checkout can go on.
+func claimCheckoutCoupon(ctx context.Context, gCtx *gin.Context, checkout *database.CheckoutOptions) bool {
+	code := gCtx.Query("coupon")
+	if code == "" {
+		return true
+	}
+	coupon, err := database.ClaimCoupon(ctx, CouponCollection, code, middleware.UserID(gCtx))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == database.ErrInvalidCoupon {
+			status = http.StatusBadRequest
+		}
+		gCtx.IndentedJSON(status, gin.H{"error": err.Error()})
+		return false
+	}
+	checkout.Coupon = coupon
+	return true
+}
+
+// settleCheckout records what an order of the logged in user means for its coupon and the cart
+// recovery report, or frees the coupon when the checkout failed.
+func settleCheckout(ctx context.Context, gCtx *gin.Context, checkout database.CheckoutOptions, order *models.Order) {
+	if order == nil {
+		if checkout.Coupon != nil {
+			database.ReleaseCoupon(ctx, CouponCollection, checkout.Coupon.Coupon_ID)
+		}
+		return
+	}
+	if checkout.Coupon != nil {
+		database.SetCouponOrder(ctx, CouponCollection, checkout.Coupon.Coupon_ID, order.Order_ID)
+	}
+	database.TrackCartRecovery(ctx, CartReminderCollection, middleware.UserID(gCtx), order, CartRecoveryWindow)
+}
+
+// CartRecoveryReport godoc
+// @Summary Abandoned cart recovery report
+// @Description Counts the abandoned cart reminders sent over the last days, how many led to an order within the recovery window and the revenue of those orders
+// @Tags Cart
+// @Produce json
+// @Param days query int false "Days to report on, 30 by default"
+// @Success 200 {object} models.RecoveryStats
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/cart-recovery [get]
+func CartRecoveryReport() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		days, err := strconv.Atoi(gCtx.DefaultQuery("days", "30"))
+		if err != nil || days < 1 {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "days must be a positive number"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		stats, err := database.CartRecoveryStats(ctx, CartReminderCollection, time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, stats)
+	}
+}
+
+// envDuration reads a duration such as 36h from the environment
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// envInt reads a whole number from the environment
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
 	"github.com/ravelinejunior/golang_ecommerce/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -19,44 +20,34 @@ import (
 // @ID AddAddress
 // @Accept  json
 // @Produce  json
-// @Param id path string true "User ID"
 // @Param body body models.Address true "Address Object"
 // @Success 200 {object} models.Address
 // @Failure 400,404 {object} models.Error
-// @Router /add-address?id={id} [post]
+// @Router /addaddress [post]
 func AddAddress() gin.HandlerFunc {
 	return func(gCtx *gin.Context) {
-		userID := gCtx.Query("id")
-
-		if userID == "" {
-			gCtx.Header("Content-Type", "application/json")
-			gCtx.JSON(http.StatusNotFound, gin.H{"erro": "invalid code"})
-			gCtx.Abort()
-			return
-		}
-
-		address, err := primitive.ObjectIDFromHex(userID)
-		if err != nil {
-			gCtx.IndentedJSON(http.StatusInternalServerError, "Internal Server Error")
-		}
+		userID := middleware.UserID(gCtx)
 
 		var addresses models.Address
 
 		addresses.Address_ID = primitive.NewObjectID()
 
-		if err = gCtx.BindJSON(&addresses); err != nil {
+		if err := gCtx.BindJSON(&addresses); err != nil {
 			gCtx.IndentedJSON(http.StatusNotAcceptable, err.Error())
+			return
 		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
-		matchFilter := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: address}}}}
+		matchFilter := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "user_id", Value: userID}}}}
 		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$address"}}}}
 		group := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "address_id"}, {Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}}}}}
 		pointCursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{matchFilter, unwind, group})
 
 		if err != nil {
 			gCtx.IndentedJSON(http.StatusInternalServerError, "Internal Server Error")
+			return
 		}
 
 		var addressinfo []bson.M
@@ -70,7 +61,7 @@ func AddAddress() gin.HandlerFunc {
 			size = count.(int32)
 		}
 		if size < 2 {
-			filter := bson.D{primitive.E{Key: "_id", Value: address}}
+			filter := bson.D{primitive.E{Key: "user_id", Value: userID}}
 			update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
 			_, err := UserCollection.UpdateOne(ctx, filter, update)
 
@@ -81,8 +72,6 @@ func AddAddress() gin.HandlerFunc {
 		} else {
 			gCtx.IndentedJSON(http.StatusNotFound, "Not Allowed")
 		}
-
-		defer cancel()
 		ctx.Done()
 	}
 }
@@ -93,26 +82,13 @@ func AddAddress() gin.HandlerFunc {
 // @ID EditHomeAddress
 // @Accept  json
 // @Produce  json
-// @Param id path string true "User ID"
 // @Param body body models.Address true "Address Object"
 // @Success 200 {object} models.Address
 // @Failure 400,404 {object} models.Error
-// @Router /edit-home-address?id={id} [post]
+// @Router /edithomeaddress [put]
 func EditHomeAddress() gin.HandlerFunc {
 	return func(gCtx *gin.Context) {
-		userId := gCtx.Query("id")
-
-		if userId == "" {
-			gCtx.Header("Content-Type", "application/json")
-			gCtx.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
-			gCtx.Abort()
-			return
-		}
-
-		usertId, err := primitive.ObjectIDFromHex(userId)
-		if err != nil {
-			gCtx.IndentedJSON(http.StatusInternalServerError, "Internal Server Error")
-		}
+		userID := middleware.UserID(gCtx)
 
 		var editAddress models.Address
 		if err := gCtx.BindJSON(&editAddress); err != nil {
@@ -122,9 +98,9 @@ func EditHomeAddress() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		filter := bson.D{primitive.E{Key: "_id", Value: usertId}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.0.house_name", Value: editAddress.House}, {Key: "address.0.street_name", Value: editAddress.Street}, {Key: "address.0.city_name", Value: editAddress.City}, {Key: "address.0.pin_code", Value: editAddress.PinCode}}}}
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+		filter := bson.D{primitive.E{Key: "user_id", Value: userID}}
+		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.0.house_name", Value: editAddress.House}, {Key: "address.0.street_name", Value: editAddress.Street}, {Key: "address.0.city_name", Value: editAddress.City}, {Key: "address.0.pin_code", Value: editAddress.PinCode}, {Key: "address.0.country", Value: editAddress.Country}}}}
+		_, err := UserCollection.UpdateOne(ctx, filter, update)
 		if err != nil {
 			gCtx.IndentedJSON(http.StatusInternalServerError, "Something went wrong while updating Home Address")
 			return
@@ -142,26 +118,13 @@ func EditHomeAddress() gin.HandlerFunc {
 // @ID EditWorkAddress
 // @Accept  json
 // @Produce  json
-// @Param id path string true "User ID"
 // @Param body body models.Address true "Address Object"
 // @Success 200 {object} models.Address
 // @Failure 400,404 {object} models.Error
-// @Router /edit-work-address?id={id} [post]
+// @Router /editworkaddress [put]
 func EditWorkAddress() gin.HandlerFunc {
 	return func(gCtx *gin.Context) {
-		userId := gCtx.Query("id")
-
-		if userId == "" {
-			gCtx.Header("Content-Type", "application/json")
-			gCtx.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
-			gCtx.Abort()
-			return
-		}
-
-		usertId, err := primitive.ObjectIDFromHex(userId)
-		if err != nil {
-			gCtx.IndentedJSON(http.StatusInternalServerError, "Internal Server Error")
-		}
+		userID := middleware.UserID(gCtx)
 
 		var editAddress models.Address
 		if err := gCtx.BindJSON(&editAddress); err != nil {
@@ -171,9 +134,9 @@ func EditWorkAddress() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		filter := bson.D{primitive.E{Key: "_id", Value: usertId}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.1.house_name", Value: editAddress.House}, {Key: "address.1.street_name", Value: editAddress.Street}, {Key: "address.1.city_name", Value: editAddress.City}, {Key: "address.1.pin_code", Value: editAddress.PinCode}}}}
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+		filter := bson.D{primitive.E{Key: "user_id", Value: userID}}
+		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.1.house_name", Value: editAddress.House}, {Key: "address.1.street_name", Value: editAddress.Street}, {Key: "address.1.city_name", Value: editAddress.City}, {Key: "address.1.pin_code", Value: editAddress.PinCode}, {Key: "address.1.country", Value: editAddress.Country}}}}
+		_, err := UserCollection.UpdateOne(ctx, filter, update)
 		if err != nil {
 			gCtx.IndentedJSON(http.StatusInternalServerError, "Something went wrong while updating work address")
 			return
@@ -191,33 +154,21 @@ func EditWorkAddress() gin.HandlerFunc {
 // @ID DeleteAddress
 // @Accept  json
 // @Produce  json
-// @Param id path string true "User ID"
 // @Success 200 {object} string "Successfully Deleted"
 // @Failure 400,404 {object} models.Error
-// @Router /delete-address?id={id} [delete]
+// @Router /deleteaddresses [get]
 func DeleteAddress() gin.HandlerFunc {
 	return func(gCtx *gin.Context) {
-		userId := gCtx.Query("id")
-
-		if userId == "" {
-			gCtx.Header("Content-Type", "application/json")
-			gCtx.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
-			gCtx.Abort()
-			return
-		}
+		userID := middleware.UserID(gCtx)
 
 		addresses := make([]models.Address, 0)
-		usertId, err := primitive.ObjectIDFromHex(userId)
-		if err != nil {
-			gCtx.IndentedJSON(http.StatusInternalServerError, "Ineternal Server Error")
-		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		filter := bson.D{primitive.E{Key: "_id", Value: usertId}}
-		update := bson.D{{Key: "", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+		filter := bson.D{primitive.E{Key: "user_id", Value: userID}}
+		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
+		_, err := UserCollection.UpdateOne(ctx, filter, update)
 		if err != nil {
 			gCtx.IndentedJSON(http.StatusNotFound, "Wrong command")
 			return
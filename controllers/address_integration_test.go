@@ -0,0 +1,43 @@
+//go:build integration
+
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+)
+
+func TestAddAndDeleteAddresses(t *testing.T) {
+	router := gin.New()
+	authenticated := router.Group("", middleware.Authentication())
+	authenticated.POST("/addaddress", AddAddress())
+	authenticated.GET("/deleteaddresses", DeleteAddress())
+	email := uniqueEmail()
+	header := bearer(t, createUser(t, email, true))
+
+	// BindJSON answers 400 itself before the handler's own answer
+	if answer := serve(router, http.MethodPost, "/addaddress", `{"house_name": `, header); answer.Code != http.StatusBadRequest {
+		t.Errorf("adding a malformed address answered %d, want %d", answer.Code, http.StatusBadRequest)
+	}
+	if addresses := storedUser(t, email).Address_Details; len(addresses) != 0 {
+		t.Fatalf("a malformed address was added: %+v", addresses)
+	}
+
+	body := `{"house_name": "1", "street_name": "Main Street", "city_name": "Springfield", "pin_code": "12345", "country": "US"}`
+	if answer := serve(router, http.MethodPost, "/addaddress", body, header); answer.Code != http.StatusOK {
+		t.Fatalf("adding an address answered %d: %s", answer.Code, answer.Body)
+	}
+	if addresses := storedUser(t, email).Address_Details; len(addresses) != 1 || *addresses[0].City != "Springfield" {
+		t.Fatalf("addresses = %+v, want the one added", addresses)
+	}
+
+	if answer := serve(router, http.MethodGet, "/deleteaddresses", "", header); answer.Code != http.StatusOK {
+		t.Fatalf("deleting the addresses answered %d: %s", answer.Code, answer.Body)
+	}
+	if addresses := storedUser(t, email).Address_Details; len(addresses) != 0 {
+		t.Errorf("addresses = %+v after deleting them", addresses)
+	}
+}
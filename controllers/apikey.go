@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var APIKeyCollection *mongo.Collection = database.OpenCollection(database.Client, "APIKeys")
+
+// apiKeyErrorStatus maps the errors of the API key functions to a status code.
+func apiKeyErrorStatus(err error) int {
+	switch err {
+	case database.ErrCantFindAPIKey:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// CreateAPIKey godoc
+// @Summary Create an API key
+// @Description Creates an API key acting as the admin for other systems, limited to its scopes and optionally expiring. The key is only shown in this answer; send it in the X-API-Key header
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/api-keys [post]
+func CreateAPIKey() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Name            string   `json:"name" validate:"required,max=100"`
+			Scopes          []string `json:"scopes" validate:"required,min=1"`
+			Expires_In_Days int      `json:"expires_in_days" validate:"omitempty,min=1"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		scopes := make([]string, 0, len(body.Scopes))
+		seen := map[string]bool{}
+		for _, scope := range body.Scopes {
+			known := false
+			for _, candidate := range models.APIScopes {
+				if scope == candidate {
+					known = true
+				}
+			}
+			if !known {
+				gCtx.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + scope, "scopes": models.APIScopes})
+				return
+			}
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+		var expiresAt *time.Time
+		if body.Expires_In_Days > 0 {
+			at := time.Now().AddDate(0, 0, body.Expires_In_Days)
+			expiresAt = &at
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		key, secret, err := database.CreateAPIKey(ctx, APIKeyCollection, middleware.UserID(gCtx), body.Name, scopes, expiresAt)
+		if err != nil {
+			gCtx.JSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, models.AuditEntry{Action: models.AuditAPIKeyCreated, Target_Type: models.AuditTargetAPIKey, Target_ID: key.Key_ID.Hex()}, nil, key)
+		gCtx.IndentedJSON(http.StatusCreated, gin.H{"api_key": key, "key": secret, "header": middleware.APIKeyHeader})
+	}
+}
+
+// ListAPIKeys godoc
+// @Summary List API keys
+// @Description Lists the API keys of every admin, or of one with ?user_id=, newest first, with when each was last used
+// @Tags Admin
+// @Produce json
+// @Param user_id query string false "Only the keys of this user"
+// @Success 200 {array} models.APIKey
+// @Failure 500 {object} models.Error
+// @Router /admin/api-keys [get]
+func ListAPIKeys() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		keys, err := database.ListAPIKeys(ctx, APIKeyCollection, gCtx.Query("user_id"))
+		if err != nil {
+			gCtx.JSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, keys)
+	}
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Stops an API key from working at once
+// @Tags Admin
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} models.APIKey
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/api-keys/{id} [delete]
+func RevokeAPIKey() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		keyID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid API key id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		key, err := database.RevokeAPIKey(ctx, APIKeyCollection, keyID)
+		if err != nil {
+			gCtx.JSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, models.AuditEntry{
+			Action: models.AuditAPIKeyRevoked, Target_Type: models.AuditTargetAPIKey, Target_ID: keyID.Hex(),
+			Details: map[string]string{"user_id": key.User_ID},
+		}, nil, nil)
+		gCtx.IndentedJSON(http.StatusOK, key)
+	}
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/audit"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var AuditCollection *mongo.Collection = database.OpenCollection(database.Client, "AuditLog")
+
+// recordAudit appends an action on the target of the entry to the audit trail, with who took it,
+// the user or API key behind the request unless the entry names the actor, from where, and the
+// fields it changed between before and after, either of which may be nil. A failure is only
+// logged, the action being done already.
+func recordAudit(ctx context.Context, gCtx *gin.Context, entry models.AuditEntry, before, after interface{}) {
+	if principal, ok := middleware.CurrentPrincipal(gCtx); ok && entry.Actor_ID == "" {
+		entry.Actor_ID = principal.User_ID
+		entry.API_Key_ID = principal.API_Key_ID
+	}
+	entry.IP = gCtx.ClientIP()
+	entry.Request_ID = middleware.CurrentRequestID(gCtx)
+	if before != nil || after != nil {
+		entry.Changes = audit.Diff(before, after)
+	}
+	if err := database.RecordAudit(ctx, AuditCollection, &entry); err != nil {
+		log.Println("could not audit", entry.Action, "on", entry.Target_Type, entry.Target_ID+":", err)
+	}
+}
+
+// auditTime reads a time of the query string, as RFC 3339 or a date, reporting false when it's
+// missing.
+func auditTime(gCtx *gin.Context, key string) (time.Time, bool, error) {
+	value := gCtx.Query(key)
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, true, nil
+	}
+	at, err := time.Parse("2006-01-02", value)
+	return at, err == nil, err
+}
+
+// SearchAuditLog godoc
+// @Summary Search the audit log
+// @Description Lists the audit entries, newest first, a page at a time. ?actor=, ?action=, ?target=, ?target_type=, ?request_id= and ?ip= match exactly; an action ending in a dot, such as order., matches every action starting with it. ?since= and ?until= take RFC 3339 times or dates
+// @Tags Admin
+// @Produce json
+// @Param actor query string false "User ID of the actor"
+// @Param action query string false "Action, or action prefix ending in a dot"
+// @Param target query string false "ID of the target"
+// @Param target_type query string false "user, api_key, product, order, shipment or job"
+// @Param request_id query string false "Request ID"
+// @Param since query string false "From this time on"
+// @Param until query string false "Before this time"
+// @Param page query int false "Page, from 1"
+// @Param per_page query int false "Entries per page, up to 100"
+// @Success 200 {object} models.Page
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/audit [get]
+func SearchAuditLog() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		filter := bson.M{}
+		for key, field := range map[string]string{
+			"actor": "actor_id", "target": "target_id", "target_type": "target_type", "request_id": "request_id", "ip": "ip",
+		} {
+			if value := gCtx.Query(key); value != "" {
+				filter[field] = value
+			}
+		}
+		if action := gCtx.Query("action"); strings.HasSuffix(action, ".") {
+			filter["action"] = bson.M{"$regex": "^" + regexp.QuoteMeta(action)}
+		} else if action != "" {
+			filter["action"] = action
+		}
+
+		createdAt := bson.M{}
+		for key, operator := range map[string]string{"since": "$gte", "until": "$lt"} {
+			at, ok, err := auditTime(gCtx, key)
+			if err != nil {
+				gCtx.JSON(http.StatusBadRequest, gin.H{"error": key + " must be an RFC 3339 time or a date"})
+				return
+			}
+			if ok {
+				createdAt[operator] = at
+			}
+		}
+		if len(createdAt) > 0 {
+			filter["created_at"] = createdAt
+		}
+		page, perPage := pageQuery(gCtx)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		entries, err := database.SearchAudit(ctx, AuditCollection, filter, page, perPage)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, entries)
+	}
+}
+
+// VerifyAuditLog godoc
+// @Summary Verify the audit log
+// @Description Walks the hash chain of the audit log from its first entry and reports the first entry that was changed, removed or inserted since it was written
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} models.AuditVerification
+// @Failure 500 {object} models.Error
+// @Router /admin/audit/verify [get]
+func VerifyAuditLog() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		result, err := database.VerifyAuditChain(ctx, AuditCollection)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, result)
+	}
+}
+
+// productAudit is the audit entry of an action on a product.
+func productAudit(action string, productID primitive.ObjectID, details map[string]string) models.AuditEntry {
+	return models.AuditEntry{Action: action, Target_Type: models.AuditTargetProduct, Target_ID: productID.Hex(), Details: details}
+}
+
+// productState returns the product as the audit trail compares it before and after an action,
+// or nil when it can't be found, the action then failing on its own.
+func productState(ctx context.Context, productID primitive.ObjectID) *models.Product {
+	var product models.Product
+	if err := ProductCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+		return nil
+	}
+	return &product
+}
+
+// orderAudit is the audit entry of an action on an order.
+func orderAudit(action string, orderID primitive.ObjectID, details map[string]string) models.AuditEntry {
+	return models.AuditEntry{Action: action, Target_Type: models.AuditTargetOrder, Target_ID: orderID.Hex(), Details: details}
+}
+
+// orderState returns the order as the audit trail compares it before and after an action, or nil
+// when it can't be found.
+func orderState(ctx context.Context, orderID primitive.ObjectID) *models.Order {
+	order, _, err := database.FindOrder(ctx, UserCollection, orderID)
+	if err != nil {
+		return nil
+	}
+	return order
+}
+
+// shipmentState returns the shipment as the audit trail compares it before and after an action,
+// or nil when it can't be found.
+func shipmentState(ctx context.Context, shipmentID primitive.ObjectID) *models.Shipment {
+	var shipment models.Shipment
+	if err := ShipmentCollection.FindOne(ctx, bson.M{"_id": shipmentID}).Decode(&shipment); err != nil {
+		return nil
+	}
+	return &shipment
+}
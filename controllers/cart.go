@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
 	"github.com/ravelinejunior/golang_ecommerce/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -28,7 +29,21 @@ func NewApplication(prodCollection, userCollection *mongo.Collection) *Applicati
 	}
 }
 
-// AddToCart adds a product to the cart
+// variantQuery reads the optional variant id from the query string
+func variantQuery(ctx *gin.Context) (*primitive.ObjectID, error) {
+	variantQueryID := ctx.Query("variant")
+	if variantQueryID == "" {
+		return nil, nil
+	}
+
+	variantID, err := primitive.ObjectIDFromHex(variantQueryID)
+	if err != nil {
+		return nil, errors.New("variant id is not valid")
+	}
+	return &variantID, nil
+}
+
+// AddToCart adds a product to the cart of the logged in user
 func (app *Application) AddToCart() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// productQueryID is the id of the product to be added to the cart
@@ -41,15 +56,6 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 			return
 		}
 
-		// userQueryID is the id of the user who is adding the product to the cart
-		userQueryID := ctx.Query("userID")
-		if userQueryID == "" {
-			log.Println("user id is empty")
-			// return an error with status code 400 Bad Request
-			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
-			return
-		}
-
 		// convert the product id to a primitive.ObjectID type
 		productID, err := primitive.ObjectIDFromHex(productQueryID)
 		if err != nil {
@@ -59,11 +65,17 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 			return
 		}
 
+		// products with options are added as one of their variants
+		variantID, err := variantQuery(ctx)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
 		// add the product to the cart
-		err = database.AddProductToCart(context.Background(), app.prodCollection, app.userCollection, productID, userQueryID)
+		err = database.AddProductToCart(context.Background(), app.prodCollection, app.userCollection, productID, variantID, middleware.UserID(ctx))
 		if err != nil {
-			// return an internal server error
-			ctx.IndentedJSON(http.StatusInternalServerError, err)
+			ctx.IndentedJSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
@@ -72,7 +84,7 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 	}
 }
 
-// RemoveItem removes an item from the cart
+// RemoveItem removes an item from the cart of the logged in user
 func (app *Application) RemoveItem() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// productQueryID is the id of the product to be removed from the cart
@@ -85,15 +97,6 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 			return
 		}
 
-		// userQueryID is the id of the user who is removing the product from the cart
-		userQueryID := ctx.Query("userID")
-		if userQueryID == "" {
-			log.Println("user id is empty")
-			// return an error with status code 400 Bad Request
-			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
-			return
-		}
-
 		// convert the product id to a primitive.ObjectID type
 		productID, err := primitive.ObjectIDFromHex(productQueryID)
 		if err != nil {
@@ -103,8 +106,15 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 			return
 		}
 
+		// remove a single variant when one is given
+		variantID, err := variantQuery(ctx)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
 		// remove the product from the cart
-		err = database.RemoveCartItem(context.Background(), app.prodCollection, app.userCollection, productID, userQueryID)
+		err = database.RemoveCartItem(context.Background(), app.prodCollection, app.userCollection, productID, variantID, middleware.UserID(ctx))
 		if err != nil {
 			// return an internal server error
 			ctx.IndentedJSON(http.StatusInternalServerError, err)
@@ -116,28 +126,9 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 	}
 }
 
-// GetItemFromCart returns the items in the cart of a user
+// GetItemFromCart returns the items in the cart of the logged in user
 func GetItemFromCart() gin.HandlerFunc {
 	return func(gCtx *gin.Context) {
-		// userID is the id of the user whose cart items are to be returned
-		userID := gCtx.Query("id")
-
-		// if the user id is empty, return an error
-		if userID == "" {
-			gCtx.Header("Content-Type", "application/json")
-			gCtx.JSON(http.StatusNotFound, gin.H{"erro": "invalid id"})
-			gCtx.Abort()
-			return
-		}
-
-		// convert the user id to a primitive.ObjectID type
-		usertId, err := primitive.ObjectIDFromHex(userID)
-		if err != nil {
-			log.Println(err)
-			gCtx.AbortWithStatus(http.StatusBadRequest)
-			return
-		}
-
 		// create a context with a timeout of 100 seconds
 		var ctx context.Context
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
@@ -145,7 +136,7 @@ func GetItemFromCart() gin.HandlerFunc {
 
 		// find the user in the database
 		var filledCart models.User
-		err = UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usertId}}).Decode(&filledCart)
+		err := UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "user_id", Value: middleware.UserID(gCtx)}}).Decode(&filledCart)
 		if err != nil {
 			// if the user is not found, return an error
 			if err == mongo.ErrNoDocuments {
@@ -157,44 +148,27 @@ func GetItemFromCart() gin.HandlerFunc {
 			return
 		}
 
-		// create a pipeline to filter, unwind, and group the cart items
-		filterMatch := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: usertId}}}}
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-		grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
-
-		// run the pipeline and retrieve the results
-		pointCursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{filterMatch, unwind, grouping})
+		// check the cart against the current products and prices
+		review, err := database.RevalidateCart(ctx, ProductCollection, filledCart.UserCart)
 		if err != nil {
 			log.Println(err)
 			gCtx.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 
-		// create a slice to store the results
-		var listing []bson.M
-		if err = pointCursor.All(ctx, &listing); err != nil {
-			log.Println(err)
-			gCtx.AbortWithStatus(http.StatusInternalServerError)
-		}
-
-		// iterate over the results and return them in JSON format
-		for _, json := range listing {
-			gCtx.IndentedJSON(http.StatusOK, json["total"])
-			gCtx.IndentedJSON(http.StatusOK, filledCart.UserCart)
-		}
+		// return the cart as it would be ordered now, with what changed since the items were added
+		gCtx.IndentedJSON(http.StatusOK, review)
 	}
 }
 
-// BuyFromCart handles the buying process of an item from the cart
+// BuyFromCart handles the buying process of the cart of the logged in user
 func (app *Application) BuyFromCart() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		// userQueryID is the id of the user who is buying the product
-		userQueryID := ctx.Query("id")
-
-		// if the user id is empty, return an error
-		if userQueryID == "" {
-			log.Panicln("user id is empty")
-			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty"))
+		// read the shipping method and address chosen by the shopper
+		checkout, err := checkoutOptions(ctx)
+		if err != nil {
+			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
 		// create a context with a timeout of 100 seconds
@@ -202,18 +176,34 @@ func (app *Application) BuyFromCart() gin.HandlerFunc {
 
 		defer cancel()
 
+		// hold the coupon, if any, for this checkout
+		if !claimCheckoutCoupon(contx, ctx, &checkout) {
+			return
+		}
+
 		// buy the product from the cart
-		err := database.BuyItemFromCart(contx, app.userCollection, userQueryID)
+		order, err := database.BuyItemFromCart(contx, app.prodCollection, app.userCollection, ShippingZoneCollection, TaxProvider, middleware.UserID(ctx), checkout)
+		settleCheckout(contx, ctx, checkout, order)
+		var changed *database.CartChangedError
+		if errors.As(err, &changed) {
+			// show what changed so the shopper can acknowledge it and check out again
+			ctx.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error(), "cart": changed.Review})
+			return
+		}
 		if err != nil {
-			ctx.IndentedJSON(http.StatusInternalServerError, err)
+			ctx.IndentedJSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
 		}
 
-		// return a status code of 200 OK and a message indicating that the product was bought successfully
-		ctx.IndentedJSON(http.StatusOK, "Successfully placed the order")
+		// invoice the order right away
+		issueInvoice(order)
+
+		// return a status code of 200 OK and the placed order
+		ctx.IndentedJSON(http.StatusOK, order)
 	}
 }
 
-// InstantBuy handles the buying process of an item from the cart
+// InstantBuy handles the buying process of a single product for the logged in user
 func (app *Application) InstantBuy() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// productQueryID is the id of the product to be bought
@@ -226,15 +216,6 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 			return
 		}
 
-		// userQueryID is the id of the user who is buying the product
-		userQueryID := ctx.Query("userID")
-		if userQueryID == "" {
-			log.Println("user id is empty")
-			// return an error with status code 400 Bad Request
-			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
-			return
-		}
-
 		// convert the product id to a primitive.ObjectID type
 		productID, err := primitive.ObjectIDFromHex(productQueryID)
 		if err != nil {
@@ -244,20 +225,42 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 			return
 		}
 
+		// products with options are bought as one of their variants
+		variantID, err := variantQuery(ctx)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		// read the shipping method and address chosen by the shopper
+		checkout, err := checkoutOptions(ctx)
+		if err != nil {
+			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		// create a context with a timeout of 5 seconds
 		var contx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 
 		defer cancel()
 
-		// buy the product from the cart
-		err = database.InstantBuyer(contx, app.prodCollection, app.userCollection, productID, userQueryID)
+		// hold the coupon, if any, for this checkout
+		if !claimCheckoutCoupon(contx, ctx, &checkout) {
+			return
+		}
+
+		// buy the product right away
+		order, err := database.InstantBuyer(contx, app.prodCollection, app.userCollection, ShippingZoneCollection, TaxProvider, productID, variantID, middleware.UserID(ctx), checkout)
+		settleCheckout(contx, ctx, checkout, order)
 		if err != nil {
-			// return an internal server error
-			ctx.IndentedJSON(http.StatusInternalServerError, err)
+			ctx.IndentedJSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		// return a status code of 200 OK and a message indicating that the product was bought successfully
-		ctx.IndentedJSON(http.StatusOK, "Successfully placed the order")
+		// invoice the order right away
+		issueInvoice(order)
+
+		// return a status code of 200 OK and the placed order
+		ctx.IndentedJSON(http.StatusOK, order)
 	}
 }
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var CategoryCollection *mongo.Collection = database.OpenCollection(database.Client, "Categories")
+
+// categoryErrorStatus maps the errors of the category store to an http status
+func categoryErrorStatus(err error) int {
+	switch err {
+	case database.ErrCantFindCategory, database.ErrCantFindProduct:
+		return http.StatusNotFound
+	case database.ErrCategorySlugTaken, database.ErrCategoryCycle, database.ErrCategoryHasChildren:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// ListCategories godoc
+// @Summary List the category tree
+// @Description Returns the root categories with their subcategories nested under children
+// @Tags Categories
+// @Produce json
+// @Success 200 {array} models.CategoryNode
+// @Failure 500 {object} models.Error
+// @Router /categories [get]
+func ListCategories() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		categories, err := database.ListCategories(ctx, CategoryCollection)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, database.CategoryTree(categories))
+	}
+}
+
+// CategoryProducts godoc
+// @Summary List the products of a category
+// @Description Lists the products of the category and of all its descendants
+// @Tags Categories
+// @Produce json
+// @Param slug path string true "Category slug"
+// @Success 200 {array} models.Product
+// @Failure 404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /categories/{slug}/products [get]
+func CategoryProducts() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		category, err := database.FindCategoryBySlug(ctx, CategoryCollection, gCtx.Param("slug"))
+		if err != nil {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		products, err := database.CategoryProducts(ctx, CategoryCollection, ProductCollection, category.Category_ID)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, products)
+	}
+}
+
+// AddCategory godoc
+// @Summary Add a category
+// @Description Adds a category under parent_id, or as a root category when it is empty. The slug is derived from the name when omitted
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param category body models.Category true "Category"
+// @Success 201 {object} models.Category
+// @Failure 400,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/categories [post]
+func AddCategory() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var category models.Category
+		if err := gCtx.BindJSON(&category); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(category); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.AddCategory(ctx, CategoryCollection, &category); err != nil {
+			gCtx.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusCreated, category)
+	}
+}
+
+// UpdateCategory godoc
+// @Summary Update a category
+// @Description Renames a category or moves it under another parent. Moving a category under itself or one of its descendants is refused
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param id path string true "Category ID"
+// @Param category body models.Category true "Category"
+// @Success 200 {object} models.Category
+// @Failure 400,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/categories/{id} [put]
+func UpdateCategory() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		categoryID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid category id"})
+			return
+		}
+
+		var changes models.Category
+		if err := gCtx.BindJSON(&changes); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(changes); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		category, err := database.UpdateCategory(ctx, CategoryCollection, categoryID, &changes)
+		if err != nil {
+			gCtx.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, category)
+	}
+}
+
+// DeleteCategory godoc
+// @Summary Delete a category
+// @Description Deletes a category without subcategories and removes it from its products
+// @Tags Categories
+// @Produce json
+// @Param id path string true "Category ID"
+// @Success 200 {string} string "Category deleted"
+// @Failure 400,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/categories/{id} [delete]
+func DeleteCategory() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		categoryID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid category id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err = database.DeleteCategory(ctx, CategoryCollection, ProductCollection, categoryID); err != nil {
+			gCtx.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, "Category deleted")
+	}
+}
+
+// SetProductCategories godoc
+// @Summary Assign a product to categories
+// @Description Replaces the categories a product is listed in
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {string} string "Product categories updated"
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/products/{id}/categories [put]
+func SetProductCategories() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+			return
+		}
+
+		var body struct {
+			Category_IDs []primitive.ObjectID `json:"category_ids"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		before := productState(ctx, productID)
+		err = database.SetProductCategories(ctx, CategoryCollection, ProductCollection, productID, body.Category_IDs)
+		if err != nil {
+			gCtx.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, productAudit(models.AuditProductCategorized, productID, nil), before, productState(ctx, productID))
+
+		gCtx.IndentedJSON(http.StatusOK, "Product categories updated")
+	}
+}
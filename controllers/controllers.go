@@ -2,13 +2,14 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
 	"github.com/ravelinejunior/golang_ecommerce/database"
 	"github.com/ravelinejunior/golang_ecommerce/models"
 	generate "github.com/ravelinejunior/golang_ecommerce/tokens"
@@ -74,9 +75,9 @@ func VerifyPassword(userPassword string, typedPass string) (bool, string) {
 // @Tags Auth
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User object"
-// @Success 201 {object} models.User
-// @Failure 400 {object} models.Error
+// @Param user body models.Signup true "User object"
+// @Success 201 {string} string
+// @Failure 400,409 {object} models.Error
 // @Failure 500 {object} models.Error
 // @Router /auth/signup [post]
 func Signup() gin.HandlerFunc {
@@ -84,62 +85,68 @@ func Signup() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var user models.User
-		if err := c.BindJSON(&user); err != nil {
+		var body models.Signup
+		if err := c.BindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		validationErr := Validate.Struct(user)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr})
-			return
-		}
-
-		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
-		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-			return
-		}
-
-		if count > 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "user already exists"})
+		if body.Email != nil {
+			email := database.NormalizeEmail(*body.Email)
+			body.Email = &email
 		}
 
-		count, err = UserCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
-
-		defer cancel()
-		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		validationErr := Validate.Struct(body)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
 
-		if count > 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "this phone number is already in use"})
-			return
+		// only the fields of the form are taken from the request, everything else is set here
+		user := models.User{
+			First_Name: body.First_Name,
+			Last_Name:  body.Last_Name,
+			Email:      body.Email,
+			Phone:      body.Phone,
 		}
-
-		password := HashPassword(*user.Password)
+		password := HashPassword(*body.Password)
 		user.Password = &password
 
 		user.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.User_ID = user.ID.Hex()
-		token, refreshToken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID)
+		// roles are only ever granted by an admin, never at signup
+		role := models.RoleUser
+		user.Role = &role
+		// the address is only trusted once the user follows the emailed link
+		sentAt := time.Now()
+		user.Email_Verified = false
+		user.Email_Verified_At = nil
+		user.Verification_Sent_At = &sentAt
+		// two-factor authentication is only turned on by confirming a code from the app
+		user.Mfa_Enabled = false
+		user.Mfa_Enabled_At = nil
+		// external identities are only linked by signing in with the provider
+		user.Identities = nil
+		token, refreshToken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID, user.Session_Version)
 		user.Token = &token
 		user.Refresh_Token = &refreshToken
 		user.UserCart = make([]models.ProductUser, 0)
 		user.Address_Details = make([]models.Address, 0)
 		user.Order_Status = make([]models.Order, 0)
-		_, inserterr := UserCollection.InsertOne(ctx, user)
-		if inserterr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create the user"})
+		// the unique indexes on email and phone refuse an account that already exists
+		if err := database.CreateUser(ctx, UserCollection, &user); err != nil {
+			c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		defer cancel()
+
+		// a lost email isn't fatal, the user can ask for another one
+		if err := sendVerificationEmail(ctx, &user); err != nil {
+			log.Println("could not send the verification email:", err)
+		}
+
+		// bring along the cart the visitor filled before signing up
+		mergeGuestCart(ctx, c, user.User_ID)
 
 		c.JSON(http.StatusCreated, "Successfully signed in.")
 	}
@@ -151,9 +158,9 @@ func Signup() gin.HandlerFunc {
 // @Tags Auth
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User object"
-// @Success 200 {object} models.User
-// @Failure 400 {object} models.Error
+// @Param user body models.Credentials true "Email and password"
+// @Success 200 {object} models.LoggedIn
+// @Failure 400,401,429 {object} models.Error
 // @Failure 500 {object} models.Error
 // @Router /auth/login [post]
 func Login() gin.HandlerFunc {
@@ -161,41 +168,105 @@ func Login() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var user models.User
+		var user models.Credentials
 		var foundUser models.User
 		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if user.Email != nil {
+			email := database.NormalizeEmail(*user.Email)
+			user.Email = &email
+		}
+		if err := Validate.Struct(user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
 			return
 		}
 
-		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
-		defer cancel()
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "login or password incorrect"})
+		// refuse guesses while the account or the address is locked out
+		keys := loginKeys(*user.Email, c.ClientIP())
+		if loginLocked(ctx, c, keys) {
+			return
 		}
 
-		PasswordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
-		defer cancel()
+		// an unknown email costs the same bcrypt comparison and gets the same answer as a wrong
+		// password, so neither the timing nor the error tells which emails are registered
+		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		hash := dummyPasswordHash
+		if err == nil && foundUser.Password != nil {
+			hash = *foundUser.Password
+		}
+		PasswordIsValid, _ := VerifyPassword(*user.Password, hash)
+		if err != nil || foundUser.Password == nil || !PasswordIsValid {
+			if failures := recordLoginFailure(ctx, keys); loginFailureAudited(failures) {
+				var known *models.User
+				if err == nil {
+					known = &foundUser
+				}
+				recordAudit(ctx, c, failedLoginAudit(*user.Email, known, failures), nil, nil)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login or password incorrect"})
+			return
+		}
 
-		if !PasswordIsValid {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			fmt.Println(msg)
+		// with two-factor authentication on, the password only earns a short lived token to send
+		// along with the code, see VerifyMfaLogin. The failures of the account are only forgotten
+		// once the code is right too, or the password alone would reset the count of wrong codes
+		if foundUser.Mfa_Enabled {
+			startMfaLogin(c, &foundUser)
 			return
 		}
+		clearLoginFailures(ctx, keys)
 
-		token, refreshToken, _ := generate.TokenGenerator(*foundUser.Email, *foundUser.First_Name, *foundUser.Last_Name, foundUser.User_ID)
-		defer cancel()
+		completeLogin(ctx, c, &foundUser)
+	}
+}
+
+// failedLoginAudit is the audit entry of a wrong password, the failures-th in a row of the
+// account. It targets the user when one is given, and nothing for an unknown email. The email
+// tried is only kept hashed, as the key of its failed logins, since the audit log outlives
+// accounts and nothing personal goes to it.
+func failedLoginAudit(email string, user *models.User, failures int) models.AuditEntry {
+	details := map[string]string{"account": database.HashToken(database.AccountLoginKey(email)), "failures": strconv.Itoa(failures)}
+	entry := models.AuditEntry{Action: models.AuditLoginFailed, Details: details}
+	if user != nil {
+		entry.Target_Type, entry.Target_ID = models.AuditTargetUser, user.User_ID
+	}
+	return entry
+}
 
-		generate.UpdateAllTokens(token, refreshToken, foundUser.User_ID)
-		c.IndentedJSON(http.StatusOK, foundUser)
+// completeLogin issues the tokens of a user who proved who they are and answers with the user
+// and the tokens.
+func completeLogin(ctx context.Context, c *gin.Context, user *models.User) {
+	if accountDisabled(c, user) {
+		return
+	}
+	token, refreshToken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID, user.Session_Version)
+	generate.UpdateAllTokens(token, refreshToken, user.User_ID)
+	login := userAudit(models.AuditLoginSucceeded, user.User_ID, nil)
+	login.Actor_ID = user.User_ID
+	recordAudit(ctx, c, login, nil, nil)
+
+	// bring along the cart the visitor filled before logging in
+	if cart := mergeGuestCart(ctx, c, user.User_ID); cart != nil {
+		user.UserCart = cart
+	}
+	c.IndentedJSON(http.StatusOK, models.LoggedIn{User: user, Token: token, Refresh_Token: refreshToken})
+}
 
+// accountDisabled refuses, with 403, to log in a user whose account an admin disabled. It reports
+// whether the login was refused.
+func accountDisabled(c *gin.Context, user *models.User) bool {
+	if user.Disabled_At == nil {
+		return false
 	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "this account is disabled, contact support"})
+	return true
 }
 
 // ProductViewerAdmin godoc
 // @Summary Add a new product to the database
-// @Description Adds a new product to the database
+// @Description Adds a new product to the database, recording it in the audit log
 // @Tags Products
 // @Accept json
 // @Produce json
@@ -203,7 +274,7 @@ func Login() gin.HandlerFunc {
 // @Success 200 {object} models.Product
 // @Failure 400 {object} models.Error
 // @Failure 500 {object} models.Error
-// @Router /product/admin [post]
+// @Router /admin/add_product [post]
 func ProductViewerAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -213,12 +284,26 @@ func ProductViewerAdmin() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if err := Validate.Struct(products); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// variants must match the options the product is sold in
+		if err := catalog.PrepareVariants(&products); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		products.Product_ID = primitive.NewObjectID()
 		_, anyerr := ProductCollection.InsertOne(ctx, products)
+		if mongo.IsDuplicateKeyError(anyerr) {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrSkuTaken.Error()})
+			return
+		}
 		if anyerr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Not Created"})
 			return
 		}
+		recordAudit(ctx, c, productAudit(models.AuditProductCreated, products.Product_ID, nil), nil, products)
 		defer cancel()
 		c.JSON(http.StatusOK, "Successfully added our Product Admin!!")
 	}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	generate "github.com/ravelinejunior/golang_ecommerce/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var GuestCartCollection *mongo.Collection = database.OpenCollection(database.Client, "GuestCarts")
+
+// cartTokenHeader carries the signed token of a guest cart
+const cartTokenHeader = "Cart-Token"
+
+// guestCartErrorStatus maps the errors of the guest cart store to an http status
+func guestCartErrorStatus(err error) int {
+	switch err {
+	case database.ErrCantFindGuestCart, database.ErrCantFindProduct:
+		return http.StatusNotFound
+	case catalog.ErrVariantRequired, catalog.ErrUnknownVariant:
+		return http.StatusBadRequest
+	case catalog.ErrOutOfStock:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// guestCartToken reads the guest cart id from the Cart-Token header, reporting whether a
+// valid token was sent
+func guestCartToken(gCtx *gin.Context) (primitive.ObjectID, bool) {
+	signed := gCtx.GetHeader(cartTokenHeader)
+	if signed == "" {
+		return primitive.NilObjectID, false
+	}
+	claims, msg := generate.ValidateCartToken(signed)
+	if msg != "" {
+		return primitive.NilObjectID, false
+	}
+	cartID, err := primitive.ObjectIDFromHex(claims.Cart_ID)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	return cartID, true
+}
+
+// guestCartParam reads the guest cart id from the request, answering 401 when the token is missing or invalid
+func guestCartParam(gCtx *gin.Context) (primitive.ObjectID, bool) {
+	cartID, ok := guestCartToken(gCtx)
+	if !ok {
+		gCtx.JSON(http.StatusUnauthorized, gin.H{"error": "a valid " + cartTokenHeader + " header is required"})
+	}
+	return cartID, ok
+}
+
+// mergeGuestCart merges the guest cart named by the Cart-Token header, if any, into the cart of
+// the user who just logged in or signed up. A failed merge never fails the login, it is only
+// logged. It returns the new cart, or nil when nothing was merged.
+func mergeGuestCart(ctx context.Context, gCtx *gin.Context, userID string) []models.ProductUser {
+	cartID, ok := guestCartToken(gCtx)
+	if !ok {
+		return nil
+	}
+	cart, dropped, err := database.MergeGuestCart(ctx, ProductCollection, UserCollection, GuestCartCollection, cartID, userID)
+	if err != nil {
+		log.Println("could not merge guest cart", cartID.Hex(), "into user", userID+":", err)
+		return nil
+	}
+	if dropped > 0 {
+		log.Println(dropped, "units of guest cart", cartID.Hex(), "were out of stock and not merged")
+	}
+	return cart
+}
+
+// CreateGuestCart godoc
+// @Summary Start a guest cart
+// @Description Creates an empty cart for a visitor who isn't logged in. Send the returned cart_token in the Cart-Token header of the guest cart routes, and of signup or login to merge the cart into the user's
+// @Tags Cart
+// @Produce json
+// @Success 201 {object} models.GuestCart
+// @Failure 500 {object} models.Error
+// @Router /guest/cart [post]
+func CreateGuestCart() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		cart, err := database.CreateGuestCart(ctx, GuestCartCollection)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		token, err := generate.CartTokenGenerator(cart.Cart_ID.Hex())
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not sign the cart token"})
+			return
+		}
+
+		gCtx.Header(cartTokenHeader, token)
+		gCtx.IndentedJSON(http.StatusCreated, gin.H{"cart_token": token, "cart": cart})
+	}
+}
+
+// GetGuestCart godoc
+// @Summary List the guest cart
+// @Description Returns the items of the guest cart named by the Cart-Token header
+// @Tags Cart
+// @Produce json
+// @Param Cart-Token header string true "Guest cart token"
+// @Success 200 {object} models.GuestCart
+// @Failure 401,404 {object} models.Error
+// @Router /guest/cart [get]
+func GetGuestCart() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		cartID, ok := guestCartParam(gCtx)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		cart, err := database.FindGuestCart(ctx, GuestCartCollection, cartID)
+		if err != nil {
+			gCtx.JSON(guestCartErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, cart)
+	}
+}
+
+// AddGuestCartItem godoc
+// @Summary Add a product to the guest cart
+// @Description Adds quantity units (1 by default) of a product, or of a variant of it with variant_id, to the guest cart
+// @Tags Cart
+// @Accept json
+// @Produce json
+// @Param Cart-Token header string true "Guest cart token"
+// @Success 200 {object} models.GuestCart
+// @Failure 400,401,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /guest/cart/items [post]
+func AddGuestCartItem() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		cartID, ok := guestCartParam(gCtx)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Product_ID primitive.ObjectID  `json:"product_id" validate:"required"`
+			Variant_ID *primitive.ObjectID `json:"variant_id"`
+			Quantity   int                 `json:"quantity" validate:"omitempty,min=1,max=100"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Quantity == 0 {
+			body.Quantity = 1
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		cart, err := database.AddGuestCartItem(ctx, ProductCollection, GuestCartCollection, cartID, body.Product_ID, body.Variant_ID, body.Quantity)
+		if err != nil {
+			gCtx.JSON(guestCartErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, cart)
+	}
+}
+
+// RemoveGuestCartItem godoc
+// @Summary Remove a product from the guest cart
+// @Description Takes every unit of a product, or of one variant of it with ?variant=, out of the guest cart
+// @Tags Cart
+// @Produce json
+// @Param Cart-Token header string true "Guest cart token"
+// @Param product_id path string true "Product ID"
+// @Param variant query string false "Variant ID"
+// @Success 200 {object} models.GuestCart
+// @Failure 400,401,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /guest/cart/items/{product_id} [delete]
+func RemoveGuestCartItem() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		cartID, ok := guestCartParam(gCtx)
+		if !ok {
+			return
+		}
+		productID, err := primitive.ObjectIDFromHex(gCtx.Param("product_id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+			return
+		}
+		variantID, err := variantQuery(gCtx)
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		cart, err := database.RemoveGuestCartItem(ctx, GuestCartCollection, cartID, productID, variantID)
+		if err != nil {
+			gCtx.JSON(guestCartErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, cart)
+	}
+}
@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/media"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ImageStore keeps the uploaded product images, on disk or in an S3-compatible bucket
+// depending on STORAGE_BACKEND.
+var ImageStore storage.Store = imageStore()
+
+func imageStore() storage.Store {
+	store, err := storage.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return store
+}
+
+func imageErrorStatus(err error) int {
+	switch err {
+	case database.ErrCantFindProduct, database.ErrCantFindImage:
+		return http.StatusNotFound
+	case database.ErrImageOrder:
+		return http.StatusConflict
+	case media.ErrUnsupportedType:
+		return http.StatusUnsupportedMediaType
+	case media.ErrTooLarge, media.ErrTooManyPixels:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// UploadProductImage godoc
+// @Summary Upload a product image
+// @Description Uploads a jpeg, png or gif image in the multipart field "image". Small, medium and large thumbnails are generated, and the first image of a product becomes its main image. Use the position form field to insert it elsewhere than last
+// @Tags Products
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param image formData file true "Image"
+// @Param position formData int false "Position"
+// @Success 201 {object} models.ProductImage
+// @Failure 400,404,413,415 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/products/{id}/images [post]
+func UploadProductImage() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+			return
+		}
+
+		// leave room for the multipart framing around the image
+		gCtx.Request.Body = http.MaxBytesReader(gCtx.Writer, gCtx.Request.Body, int64(media.MaxBytes)+1<<20)
+		header, err := gCtx.FormFile("image")
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "the image field is required and must be at most " + fmt.Sprint(media.MaxBytes) + " bytes"})
+			return
+		}
+		position := -1
+		if value := gCtx.PostForm("position"); value != "" {
+			if _, err := fmt.Sscan(value, &position); err != nil || position < 0 {
+				gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid position"})
+				return
+			}
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+
+		img, err := media.Read(file)
+		if err != nil {
+			gCtx.JSON(imageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		image := models.ProductImage{
+			Image_ID:     primitive.NewObjectID(),
+			Content_Type: img.Content_Type,
+			Width:        img.Width,
+			Height:       img.Height,
+			Size:         len(img.Data),
+			Uploaded_At:  time.Now(),
+		}
+		prefix := "products/" + productID.Hex() + "/" + image.Image_ID.Hex()
+		image.Key = prefix + media.Extensions[img.Content_Type]
+		if err := ImageStore.Put(ctx, image.Key, bytes.NewReader(img.Data), int64(len(img.Data)), img.Content_Type); err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not store the image"})
+			return
+		}
+		image.URL = ImageStore.URL(image.Key)
+
+		for _, size := range media.ThumbnailSizes {
+			data, contentType, err := img.Thumbnail(size.Max)
+			if err == nil {
+				key := prefix + "_" + size.Name + media.Extensions[contentType]
+				err = ImageStore.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType)
+				image.Thumbnails = append(image.Thumbnails, models.Thumbnail{Size: size.Name, Key: key, URL: ImageStore.URL(key)})
+			}
+			if err != nil {
+				log.Println(err)
+				deleteImageBlobs(ctx, image)
+				gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not store the thumbnails"})
+				return
+			}
+		}
+
+		if err := database.AddProductImage(ctx, ProductCollection, productID, image, position); err != nil {
+			deleteImageBlobs(ctx, image)
+			gCtx.JSON(imageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, productAudit(models.AuditProductImageAdded, productID, map[string]string{"image_id": image.Image_ID.Hex()}), nil, image)
+		gCtx.IndentedJSON(http.StatusCreated, image)
+	}
+}
+
+// DeleteProductImage godoc
+// @Summary Delete a product image
+// @Description Removes an image and its thumbnails from a product and from storage
+// @Tags Products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param image_id path string true "Image ID"
+// @Success 200 {string} string
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/products/{id}/images/{image_id} [delete]
+func DeleteProductImage() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+			return
+		}
+		imageID, err := primitive.ObjectIDFromHex(gCtx.Param("image_id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid image id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		image, err := database.RemoveProductImage(ctx, ProductCollection, productID, imageID)
+		if err != nil {
+			gCtx.JSON(imageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if image != nil {
+			deleteImageBlobs(ctx, *image)
+		}
+		recordAudit(ctx, gCtx, productAudit(models.AuditProductImageDeleted, productID, map[string]string{"image_id": imageID.Hex()}), image, nil)
+		gCtx.IndentedJSON(http.StatusOK, "Successfully deleted the image")
+	}
+}
+
+// ReorderProductImages godoc
+// @Summary Reorder the images of a product
+// @Description Sets the display order of the images of a product from the list of all its image ids. The first image becomes the main image
+// @Tags Products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {array} models.ProductImage
+// @Failure 400,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/products/{id}/images/order [put]
+func ReorderProductImages() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+			return
+		}
+
+		var body struct {
+			Image_IDs []primitive.ObjectID `json:"image_ids" validate:"required"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		before := productState(ctx, productID)
+		images, err := database.ReorderProductImages(ctx, ProductCollection, productID, body.Image_IDs)
+		if err != nil {
+			gCtx.JSON(imageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, productAudit(models.AuditProductImagesSorted, productID, nil), before, productState(ctx, productID))
+		gCtx.IndentedJSON(http.StatusOK, images)
+	}
+}
+
+// deleteImageBlobs removes an image and its thumbnails from storage. Failures are only
+// logged, an orphaned blob is harmless.
+func deleteImageBlobs(ctx context.Context, image models.ProductImage) {
+	keys := []string{image.Key}
+	for _, thumbnail := range image.Thumbnails {
+		keys = append(keys, thumbnail.Key)
+	}
+	for _, key := range keys {
+		if err := ImageStore.Delete(ctx, key); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+//go:build integration
+
+// The tests of this build tag run the handlers against the MongoDB of docker-compose:
+//
+//	docker compose up -d mongo && go test -tags integration ./controllers
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/mail"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	generate "github.com/ravelinejunior/golang_ecommerce/tokens"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// capture receives every email the handlers send during the tests.
+var capture = mail.NewCapture(false)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	// tokens are signed with a key made up for the run
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	key, err := generate.NewKey("test", private)
+	if err != nil {
+		log.Fatal(err)
+	}
+	generate.Keys.Grace = time.Hour
+	if err := generate.Keys.Rotate(key, nil, time.Now()); err != nil {
+		log.Fatal(err)
+	}
+
+	Mailer = capture
+	os.Exit(m.Run())
+}
+
+// testCount makes the emails, phone numbers and subjects of a run unique.
+var testCount int
+
+// uniqueEmail returns an address no account uses yet.
+func uniqueEmail() string {
+	testCount++
+	return fmt.Sprintf("test.%d.%d@example.com", time.Now().UnixNano(), testCount)
+}
+
+// forgetUser deletes the account of the email once the test is over.
+func forgetUser(t *testing.T, email string) {
+	t.Cleanup(func() {
+		if _, err := UserCollection.DeleteMany(context.Background(), bson.M{"email": email}); err != nil {
+			t.Log(err)
+		}
+	})
+}
+
+// createUser stores an account with the email, the password "password" and an unverified or
+// verified address, as Signup would.
+func createUser(t *testing.T, email string, verified bool) *models.User {
+	t.Helper()
+	first, last, role := "Test", "User", models.RoleUser
+	password := HashPassword("password")
+	phone := fmt.Sprint(time.Now().UnixNano())
+	now := time.Now()
+	user := &models.User{
+		ID:              primitive.NewObjectID(),
+		First_Name:      &first,
+		Last_Name:       &last,
+		Password:        &password,
+		Email:           &email,
+		Email_Verified:  verified,
+		Phone:           &phone,
+		Role:            &role,
+		Created_At:      now,
+		Updated_At:      now,
+		UserCart:        make([]models.ProductUser, 0),
+		Address_Details: make([]models.Address, 0),
+		Order_Status:    make([]models.Order, 0),
+	}
+	user.User_ID = user.ID.Hex()
+	if verified {
+		user.Email_Verified_At = &now
+	}
+	forgetUser(t, email)
+	if err := database.CreateUser(context.Background(), UserCollection, user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// storedUser reads the account of the email back.
+func storedUser(t *testing.T, email string) *models.User {
+	t.Helper()
+	var user models.User
+	if err := UserCollection.FindOne(context.Background(), bson.M{"email": email}).Decode(&user); err != nil {
+		t.Fatalf("finding %s: %v", email, err)
+	}
+	return &user
+}
+
+// bearer returns the Authorization header of a fresh session of the user.
+func bearer(t *testing.T, user *models.User) http.Header {
+	t.Helper()
+	token, _, err := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID, user.Session_Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// serve sends a request to the router and returns the recorded answer.
+func serve(router http.Handler, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	request := httptest.NewRequest(method, target, reader)
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
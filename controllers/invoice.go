@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/invoice"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var InvoiceCollection *mongo.Collection = database.OpenCollection(database.Client, "Invoices")
+
+// issueInvoice invoices a freshly placed order. Failures are only logged: the invoice is
+// issued again the first time it is requested.
+func issueInvoice(order *models.Order) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := database.InvoiceForOrder(ctx, UserCollection, InvoiceCollection, order.Order_ID); err != nil {
+		log.Println("could not invoice order", order.Order_ID.Hex(), err)
+	}
+}
+
+// renderInvoice answers with the invoice as a PDF when asked through ?format=pdf or the
+// Accept header, and as an HTML page otherwise
+func renderInvoice(gCtx *gin.Context, document *models.Invoice) {
+	format := gCtx.Query("format")
+	if format == "" && strings.Contains(gCtx.GetHeader("Accept"), "application/pdf") {
+		format = "pdf"
+	}
+
+	switch format {
+	case "pdf":
+		gCtx.Header("Content-Type", "application/pdf")
+		gCtx.Header("Content-Disposition", `inline; filename="`+document.Reference+`.pdf"`)
+		gCtx.Status(http.StatusOK)
+		if err := invoice.RenderPDF(gCtx.Writer, document); err != nil {
+			log.Println(err)
+		}
+	case "json":
+		gCtx.IndentedJSON(http.StatusOK, document)
+	default:
+		gCtx.Header("Content-Type", "text/html; charset=utf-8")
+		gCtx.Status(http.StatusOK)
+		if err := invoice.RenderHTML(gCtx.Writer, document); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// OrderInvoice godoc
+// @Summary Get the invoice of an order
+// @Description Returns the invoice of one of the user's orders as HTML, PDF (?format=pdf) or JSON (?format=json), issuing it on first request
+// @Tags Invoices
+// @Produce html,application/pdf,json
+// @Param id path string true "Order ID"
+// @Param format query string false "html, pdf or json"
+// @Success 200 {object} models.Invoice
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /orders/{id}/invoice [get]
+func OrderInvoice() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// answer not found for other users' orders rather than revealing they exist
+		_, userID, err := database.FindOrder(ctx, UserCollection, orderID)
+		if err != nil || userID != middleware.UserID(gCtx) {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindOrder.Error()})
+			return
+		}
+
+		document, err := database.InvoiceForOrder(ctx, UserCollection, InvoiceCollection, orderID)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		renderInvoice(gCtx, document)
+	}
+}
+
+// OrderCreditNotes godoc
+// @Summary List the credit notes of an order
+// @Description Lists the credit notes issued for refunds of one of the user's orders
+// @Tags Invoices
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {array} models.Invoice
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /orders/{id}/credit-notes [get]
+func OrderCreditNotes() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		_, userID, err := database.FindOrder(ctx, UserCollection, orderID)
+		if err != nil || userID != middleware.UserID(gCtx) {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindOrder.Error()})
+			return
+		}
+
+		document, err := database.InvoiceForOrder(ctx, UserCollection, InvoiceCollection, orderID)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		notes, err := database.CreditNotes(ctx, InvoiceCollection, document.Invoice_ID)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, notes)
+	}
+}
+
+// GetInvoice godoc
+// @Summary Get an invoice or credit note
+// @Description Returns one of the user's invoices or credit notes as HTML, PDF (?format=pdf) or JSON (?format=json)
+// @Tags Invoices
+// @Produce html,application/pdf,json
+// @Param id path string true "Invoice ID"
+// @Param format query string false "html, pdf or json"
+// @Success 200 {object} models.Invoice
+// @Failure 400,404 {object} models.Error
+// @Router /invoices/{id} [get]
+func GetInvoice() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		invoiceID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid invoice id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		document, err := database.FindInvoice(ctx, InvoiceCollection, invoiceID)
+		if err != nil || document.User_ID != middleware.UserID(gCtx) {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindInvoice.Error()})
+			return
+		}
+
+		renderInvoice(gCtx, document)
+	}
+}
+
+// RefundOrder godoc
+// @Summary Refund an order
+// @Description Issues a credit note for the refunded units. Every entry of product_ids refunds one unit, shipping refunds the shipping charge, and an empty body refunds whatever is left
+// @Tags Invoices
+// @Accept json
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 201 {object} models.Invoice
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/orders/{id}/refunds [post]
+func RefundOrder() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var body struct {
+			Product_IDs []string `json:"product_ids"`
+			Shipping    bool     `json:"shipping"`
+			Reason      string   `json:"reason"`
+		}
+		if gCtx.Request.ContentLength != 0 {
+			if err := gCtx.BindJSON(&body); err != nil {
+				gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		refund := invoice.Refund{Shipping: body.Shipping, Reason: body.Reason}
+		for _, hex := range body.Product_IDs {
+			productID, err := primitive.ObjectIDFromHex(hex)
+			if err != nil {
+				gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id " + hex})
+				return
+			}
+			refund.Product_IDs = append(refund.Product_IDs, productID)
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		note, err := database.RefundOrder(ctx, UserCollection, InvoiceCollection, orderID, refund)
+		switch err {
+		case nil:
+			recordAudit(ctx, gCtx, orderAudit(models.AuditOrderRefunded, orderID, map[string]string{"credit_note_id": note.Invoice_ID.Hex()}), nil, note)
+			gCtx.IndentedJSON(http.StatusCreated, note)
+		case database.ErrCantFindOrder:
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case invoice.ErrNothingToCredit, invoice.ErrNotInvoiced:
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	generate "github.com/ravelinejunior/golang_ecommerce/tokens"
+)
+
+// JWKS godoc
+// @Summary Public token keys
+// @Description Lists the public keys tokens are signed with, as a JSON Web Key Set, so that other services can verify them. Keys are named by the kid header of the tokens; retired keys stay listed for their grace period
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} tokens.JWKS
+// @Router /.well-known/jwks.json [get]
+func JWKS() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		// short enough for verifiers to pick up a rotation well within the grace period
+		gCtx.Header("Cache-Control", "public, max-age=300")
+		gCtx.JSON(http.StatusOK, generate.Keys.JWKS(time.Now()))
+	}
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/lockout"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var LoginAttemptCollection *mongo.Collection = database.OpenCollection(database.Client, "LoginAttempts")
+
+// AccountLoginPolicy is the backoff for the failed logins to one email.
+var AccountLoginPolicy = lockout.Policy{
+	Free:   envInt("LOGIN_ACCOUNT_FREE_ATTEMPTS", 5),
+	Base:   envDuration("LOGIN_BACKOFF_BASE", time.Second),
+	Max:    envDuration("LOGIN_LOCKOUT", 15*time.Minute),
+	Window: envDuration("LOGIN_FAILURE_WINDOW", 24*time.Hour),
+}
+
+// IPLoginPolicy is the backoff for the failed logins from one address, looser than the account
+// policy since many users may share an address.
+var IPLoginPolicy = lockout.Policy{
+	Free:   envInt("LOGIN_IP_FREE_ATTEMPTS", 20),
+	Base:   envDuration("LOGIN_BACKOFF_BASE", time.Second),
+	Max:    envDuration("LOGIN_LOCKOUT", 15*time.Minute),
+	Window: envDuration("LOGIN_FAILURE_WINDOW", 24*time.Hour),
+}
+
+// dummyPasswordHash is compared against when the email has no account, at the cost of
+// HashPassword, so that a failed login takes as long either way.
+const dummyPasswordHash = "$2a$14$qW6OSSyg4oBpcPrcfWxb/uuyUt3tCsGaT6gpUNvYQPZQuNgOTTWQ6"
+
+// loginKeys returns the attempt keys of a login, the account first and the address second.
+func loginKeys(email, ip string) []string {
+	return []string{database.AccountLoginKey(email), database.IPLoginKey(ip)}
+}
+
+// loginLocked answers 429 with a Retry-After header and reports true while any of the keys is
+// locked. Should the tracking fail, logins go on rather than locking everyone out.
+func loginLocked(ctx context.Context, gCtx *gin.Context, keys []string) bool {
+	now := time.Now()
+	until, err := database.LoginLockedUntil(ctx, LoginAttemptCollection, keys, now)
+	if err != nil || until.IsZero() {
+		return false
+	}
+	wait := int(math.Ceil(until.Sub(now).Seconds()))
+	gCtx.Header("Retry-After", fmt.Sprint(wait))
+	gCtx.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("too many failed logins, try again in %d seconds", wait)})
+	return true
+}
+
+// recordLoginFailure counts a failed login against the account and the address of keys and
+// returns the failures in a row of the account, 0 when they couldn't be counted.
+func recordLoginFailure(ctx context.Context, keys []string) int {
+	now := time.Now()
+	failures, _, err := database.RecordLoginFailure(ctx, LoginAttemptCollection, keys[0], AccountLoginPolicy, now)
+	if err != nil {
+		log.Println("could not record the failed login:", err)
+	}
+	if _, _, err := database.RecordLoginFailure(ctx, LoginAttemptCollection, keys[1], IPLoginPolicy, now); err != nil {
+		log.Println("could not record the failed login:", err)
+	}
+	return failures
+}
+
+// loginFailureAudited tells whether the failed login that made failures in a row for its account
+// goes to the audit log: only the first of the window and the one locking the account out do, so
+// that guessing can't flood the log every entry is chained into. Logins refused while locked out
+// never reach it.
+func loginFailureAudited(failures int) bool {
+	return failures == 1 || failures == AccountLoginPolicy.Free+1
+}
+
+// clearLoginFailures forgets the failures of the account after a successful login. Those of the
+// address are kept, or logging into an account of one's own would reset them.
+func clearLoginFailures(ctx context.Context, keys []string) {
+	if _, err := database.ClearLoginFailures(ctx, LoginAttemptCollection, keys[0]); err != nil {
+		log.Println("could not clear the failed logins:", err)
+	}
+}
+
+// UnlockUser godoc
+// @Summary Unlock an account
+// @Description Forgets the failed logins of a user, lifting any lockout of the account. Lockouts of IP addresses are lifted with ?ip=
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Param ip query string false "Also unlock this address"
+// @Success 200 {object} map[string]bool
+// @Failure 404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/users/{id}/unlock [post]
+func UnlockUser() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findUser(ctx, gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		// deleted accounts have no email, and no failures kept under it
+		unlocked := false
+		if user.Email != nil {
+			unlocked, err = database.ClearLoginFailures(ctx, LoginAttemptCollection, database.AccountLoginKey(*user.Email))
+			if err != nil {
+				gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		result := gin.H{"account_unlocked": unlocked}
+		details := map[string]string{}
+		if ip := gCtx.Query("ip"); ip != "" {
+			cleared, err := database.ClearLoginFailures(ctx, LoginAttemptCollection, database.IPLoginKey(ip))
+			if err != nil {
+				gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			result["ip_unlocked"] = cleared
+			details["ip"] = ip
+		}
+		recordAudit(ctx, gCtx, userAudit(models.AuditUserUnlocked, user.User_ID, details), nil, nil)
+		gCtx.IndentedJSON(http.StatusOK, result)
+	}
+}
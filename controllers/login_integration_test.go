@@ -0,0 +1,218 @@
+//go:build integration
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/totp"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// testIP is the address httptest requests come from.
+const testIP = "192.0.2.1"
+
+// forgetLoginFailures deletes the failed logins of the email and of the test address, now and
+// once the test is over.
+func forgetLoginFailures(t *testing.T, email string) {
+	t.Helper()
+	forget := func() {
+		for _, key := range loginKeys(email, testIP) {
+			if _, err := database.ClearLoginFailures(context.Background(), LoginAttemptCollection, key); err != nil {
+				t.Log(err)
+			}
+		}
+	}
+	forget()
+	t.Cleanup(forget)
+}
+
+// enableMfa turns two-factor authentication on for the user with a fresh secret.
+func enableMfa(t *testing.T, email string) {
+	t.Helper()
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := bson.M{"mfa_enabled": true, "mfa_secret": secret, "mfa_last_step": 0}
+	if _, err := UserCollection.UpdateOne(context.Background(), bson.M{"email": email}, bson.M{"$set": set}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loginRouter serves both steps of a login.
+func loginRouter() http.Handler {
+	router := gin.New()
+	router.POST("/users/login", Login())
+	router.POST("/users/login/mfa", VerifyMfaLogin())
+	return router
+}
+
+// loginWithPassword sends the password of the user and returns the mfa_token of the answer.
+func loginWithPassword(t *testing.T, router http.Handler, email string) string {
+	t.Helper()
+	answer := serve(router, http.MethodPost, "/users/login", fmt.Sprintf(`{"email": %q, "password": "password"}`, email), nil)
+	var started struct {
+		Mfa_Token string `json:"mfa_token"`
+	}
+	if answer.Code != http.StatusOK || json.Unmarshal(answer.Body.Bytes(), &started) != nil || started.Mfa_Token == "" {
+		t.Fatalf("login answered %d: %s, want an mfa_token", answer.Code, answer.Body)
+	}
+	return started.Mfa_Token
+}
+
+// Logging in with the password again doesn't forget the wrong codes sent before.
+func TestMfaCodeGuessesLockTheAccount(t *testing.T) {
+	router := loginRouter()
+	email := uniqueEmail()
+	createUser(t, email, true)
+	enableMfa(t, email)
+	forgetLoginFailures(t, email)
+
+	guess := func(mfaToken string) int {
+		return serve(router, http.MethodPost, "/users/login/mfa", fmt.Sprintf(`{"mfa_token": %q, "code": "000000"}`, mfaToken), nil).Code
+	}
+
+	mfaToken := loginWithPassword(t, router, email)
+	for i := 0; i < AccountLoginPolicy.Free; i++ {
+		if status := guess(mfaToken); status != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d answered %d, want %d", i+1, status, http.StatusUnauthorized)
+		}
+	}
+
+	mfaToken = loginWithPassword(t, router, email)
+	if status := guess(mfaToken); status != http.StatusUnauthorized {
+		t.Fatalf("the first wrong code after the password answered %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := guess(mfaToken); status != http.StatusTooManyRequests {
+		t.Errorf("the second wrong code after the password answered %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
+
+// Only the first wrong password of an email and the one locking it out are audited, and the
+// attempts refused while locked out aren't.
+func TestFailedLoginsAuditedOnce(t *testing.T) {
+	router := loginRouter()
+	email := uniqueEmail()
+	forgetLoginFailures(t, email)
+
+	for i := 0; i < AccountLoginPolicy.Free+3; i++ {
+		serve(router, http.MethodPost, "/users/login", fmt.Sprintf(`{"email": %q, "password": "wrong"}`, email), nil)
+	}
+	var entries []models.AuditEntry
+	cursor, err := AuditCollection.Find(context.Background(), bson.M{"action": models.AuditLoginFailed, "details.account": database.HashToken(database.AccountLoginKey(email))})
+	if err != nil || cursor.All(context.Background(), &entries) != nil {
+		t.Fatal(err)
+	}
+	var failures []string
+	for _, entry := range entries {
+		failures = append(failures, entry.Details["failures"])
+	}
+	if want := []string{"1", fmt.Sprint(AccountLoginPolicy.Free + 1)}; fmt.Sprint(failures) != fmt.Sprint(want) {
+		t.Errorf("audited failures %v, want %v", failures, want)
+	}
+}
+
+// Emails are one account whatever their case and surrounding spaces.
+func TestEmailCase(t *testing.T) {
+	router := gin.New()
+	router.POST("/users/signup", Signup())
+	router.POST("/users/login", Login())
+	email := uniqueEmail()
+	forgetUser(t, email)
+	forgetLoginFailures(t, email)
+
+	signup := func(email string) int {
+		body := fmt.Sprintf(`{"first_name": "Test", "last_name": "User", "email": %q, "password": "password", "phone": "%d"}`, email, time.Now().UnixNano())
+		return serve(router, http.MethodPost, "/users/signup", body, nil).Code
+	}
+	if status := signup(" " + strings.ToUpper(email) + " "); status != http.StatusCreated {
+		t.Fatalf("signup answered %d, want %d", status, http.StatusCreated)
+	}
+	storedUser(t, email)
+	if status := signup(email); status != http.StatusConflict {
+		t.Errorf("signing up again in lower case answered %d, want %d", status, http.StatusConflict)
+	}
+	answer := serve(router, http.MethodPost, "/users/login", fmt.Sprintf(`{"email": %q, "password": "password"}`, strings.ToUpper(email)), nil)
+	if answer.Code != http.StatusOK {
+		t.Errorf("login in upper case answered %d: %s", answer.Code, answer.Body)
+	}
+}
+
+// Wrong current passwords count against the account like failed logins do.
+func TestChangePasswordGuessesLockTheAccount(t *testing.T) {
+	router := gin.New()
+	router.POST("/users/password/change", middleware.Authentication(), ChangePassword())
+	email := uniqueEmail()
+	header := bearer(t, createUser(t, email, true))
+	forgetLoginFailures(t, email)
+
+	change := func(current string) int {
+		body := fmt.Sprintf(`{"current_password": %q, "new_password": "new password"}`, current)
+		return serve(router, http.MethodPost, "/users/password/change", body, header).Code
+	}
+	for i := 0; i < AccountLoginPolicy.Free+1; i++ {
+		if status := change("wrong"); status != http.StatusForbidden {
+			t.Fatalf("wrong password %d answered %d, want %d", i+1, status, http.StatusForbidden)
+		}
+	}
+	if status := change("password"); status != http.StatusTooManyRequests {
+		t.Errorf("the right password while locked out answered %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
+
+// Wrong passwords confirming an account deletion count against the account like failed logins do.
+func TestDeleteAccountGuessesLockTheAccount(t *testing.T) {
+	router := gin.New()
+	router.DELETE("/me", middleware.Authentication(), DeleteAccount())
+	email := uniqueEmail()
+	header := bearer(t, createUser(t, email, true))
+	forgetLoginFailures(t, email)
+
+	deleteAccount := func(password string) int {
+		return serve(router, http.MethodDelete, "/me", fmt.Sprintf(`{"password": %q}`, password), header).Code
+	}
+	for i := 0; i < AccountLoginPolicy.Free+1; i++ {
+		if status := deleteAccount("wrong"); status != http.StatusForbidden {
+			t.Fatalf("wrong password %d answered %d, want %d", i+1, status, http.StatusForbidden)
+		}
+	}
+	if status := deleteAccount("password"); status != http.StatusTooManyRequests {
+		t.Errorf("the right password while locked out answered %d, want %d", status, http.StatusTooManyRequests)
+	}
+	// the account is still there
+	storedUser(t, email)
+}
+
+// Wrong codes sent to turn two-factor authentication off count against the account like failed
+// logins do.
+func TestDisableMfaGuessesLockTheAccount(t *testing.T) {
+	router := gin.New()
+	router.POST("/users/mfa/disable", middleware.Authentication(), DisableMfa())
+	email := uniqueEmail()
+	header := bearer(t, createUser(t, email, true))
+	enableMfa(t, email)
+	forgetLoginFailures(t, email)
+
+	disable := func() int {
+		return serve(router, http.MethodPost, "/users/mfa/disable", `{"password": "password", "code": "000000"}`, header).Code
+	}
+	for i := 0; i < AccountLoginPolicy.Free+1; i++ {
+		if status := disable(); status != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d answered %d, want %d", i+1, status, http.StatusUnauthorized)
+		}
+	}
+	if status := disable(); status != http.StatusTooManyRequests {
+		t.Errorf("a wrong code while locked out answered %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,379 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	generate "github.com/ravelinejunior/golang_ecommerce/tokens"
+	"github.com/ravelinejunior/golang_ecommerce/totp"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MfaIssuer names the store in authenticator apps.
+var MfaIssuer = envOr("MFA_ISSUER", "golang_ecommerce")
+
+// recoveryCodeCount is how many recovery codes a user gets at a time.
+const recoveryCodeCount = 10
+
+// mfaErrorStatus maps the errors of the two-factor functions to a status code.
+func mfaErrorStatus(err error) int {
+	switch err {
+	case database.ErrInvalidMfaCode:
+		return http.StatusUnauthorized
+	case database.ErrMfaAlreadyEnabled, database.ErrMfaNotEnabled, database.ErrMfaNotEnrolling:
+		return http.StatusConflict
+	case database.ErrUserIdsNotValid:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// findUser loads the user with the id.
+func findUser(ctx context.Context, userID string) (*models.User, error) {
+	var user models.User
+	if err := UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user); err != nil {
+		return nil, database.ErrUserIdsNotValid
+	}
+	return &user, nil
+}
+
+// checkMfaCode accepts a code from the authenticator app of the user or one of their recovery
+// codes, either only once.
+func checkMfaCode(ctx context.Context, user *models.User, code string) error {
+	if !user.Mfa_Enabled {
+		return database.ErrMfaNotEnabled
+	}
+	if step, ok := totp.Verify(user.Mfa_Secret, code, time.Now()); ok {
+		return database.UseMfaStep(ctx, UserCollection, user.User_ID, step)
+	}
+	return database.UseRecoveryCode(ctx, UserCollection, user.User_ID, database.HashToken(totp.NormalizeRecoveryCode(code)))
+}
+
+// newRecoveryCodes returns fresh recovery codes to show the user and their hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := totp.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = database.HashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// startMfaLogin answers a login that still needs the second factor with the short lived token
+// to send along with the code, see VerifyMfaLogin.
+func startMfaLogin(gCtx *gin.Context, user *models.User) {
+	if accountDisabled(gCtx, user) {
+		return
+	}
+	mfaToken, err := generate.MfaTokenGenerator(user.User_ID, user.Session_Version)
+	if err != nil {
+		log.Println(err)
+		gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not start the two-factor login"})
+		return
+	}
+	gCtx.IndentedJSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": mfaToken})
+}
+
+// EnrollMfa godoc
+// @Summary Start enabling two-factor authentication
+// @Description Creates a new TOTP secret and returns it with the otpauth URI to show as a QR code. Two-factor authentication is only turned on once a code from the app is confirmed
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /users/mfa/enroll [post]
+func EnrollMfa() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findUser(ctx, middleware.UserID(gCtx))
+		if err != nil {
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		secret, err := totp.NewSecret()
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not create a secret"})
+			return
+		}
+		if err := database.StartMfaEnrollment(ctx, UserCollection, user.User_ID, secret); err != nil {
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, gin.H{
+			"secret":      secret,
+			"otpauth_uri": totp.URI(MfaIssuer, *user.Email, secret),
+		})
+	}
+}
+
+// ConfirmMfa godoc
+// @Summary Turn two-factor authentication on
+// @Description Confirms the enrollment with a code from the authenticator app. Returns the recovery codes, shown only this once, and fresh tokens since every other session is logged out
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 400,401,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /users/mfa/confirm [post]
+func ConfirmMfa() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Code string `json:"code" validate:"required"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findUser(ctx, middleware.UserID(gCtx))
+		if err != nil {
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if user.Mfa_Enabled {
+			gCtx.JSON(http.StatusConflict, gin.H{"error": database.ErrMfaAlreadyEnabled.Error()})
+			return
+		}
+		if user.Mfa_Pending_Secret == "" {
+			gCtx.JSON(http.StatusConflict, gin.H{"error": database.ErrMfaNotEnrolling.Error()})
+			return
+		}
+		step, ok := totp.Verify(user.Mfa_Pending_Secret, body.Code, time.Now())
+		if !ok {
+			gCtx.JSON(http.StatusUnauthorized, gin.H{"error": database.ErrInvalidMfaCode.Error()})
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not create the recovery codes"})
+			return
+		}
+		updated, err := database.EnableMfa(ctx, UserCollection, user.User_ID, user.Mfa_Pending_Secret, step, hashes)
+		if err != nil {
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, userAudit(models.AuditMfaEnabled, user.User_ID, nil), nil, nil)
+
+		// keep this session going, it just proved the second factor
+		token, refreshToken, err := generate.TokenGenerator(*updated.Email, *updated.First_Name, *updated.Last_Name, updated.User_ID, updated.Session_Version)
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not sign new tokens, log in again"})
+			return
+		}
+		generate.UpdateAllTokens(token, refreshToken, updated.User_ID)
+		gCtx.IndentedJSON(http.StatusOK, gin.H{"recovery_codes": codes, "token": token, "refresh_token": refreshToken})
+	}
+}
+
+// DisableMfa godoc
+// @Summary Turn two-factor authentication off
+// @Description Turns two-factor authentication off, given the password, if the account has one, and a code from the app or a recovery code; wrong ones count as failed logins. Admins can't while it is required for them
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {string} string
+// @Failure 400,401,403,409,429 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /users/mfa/disable [post]
+func DisableMfa() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Password string `json:"password"`
+			Code     string `json:"code" validate:"required"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findUser(ctx, middleware.UserID(gCtx))
+		if err != nil {
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if middleware.AdminMfaRequired && user.Role != nil && *user.Role == models.RoleAdmin {
+			gCtx.JSON(http.StatusForbidden, gin.H{"error": "admin accounts can't turn two-factor authentication off"})
+			return
+		}
+		// the password and the code are guessed against the same lockout as logins
+		keys := loginKeys(*user.Email, gCtx.ClientIP())
+		if loginLocked(ctx, gCtx, keys) {
+			return
+		}
+		// accounts created through an identity provider have no password to check
+		if user.Password != nil {
+			if valid, _ := VerifyPassword(body.Password, *user.Password); !valid {
+				recordLoginFailure(ctx, keys)
+				gCtx.JSON(http.StatusForbidden, gin.H{"error": "the password is incorrect"})
+				return
+			}
+		}
+		if err := checkMfaCode(ctx, user, body.Code); err != nil {
+			if err == database.ErrInvalidMfaCode {
+				recordLoginFailure(ctx, keys)
+			}
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		clearLoginFailures(ctx, keys)
+
+		if err := database.DisableMfa(ctx, UserCollection, user.User_ID); err != nil {
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, userAudit(models.AuditMfaDisabled, user.User_ID, nil), nil, nil)
+		gCtx.IndentedJSON(http.StatusOK, "Successfully turned two-factor authentication off")
+	}
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Replace the recovery codes
+// @Description Voids every recovery code of the user and returns new ones, given a code from the app; wrong ones count as failed logins
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string][]string
+// @Failure 400,401,409,429 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /users/mfa/recovery-codes [post]
+func RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Code string `json:"code" validate:"required"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findUser(ctx, middleware.UserID(gCtx))
+		if err != nil {
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		keys := loginKeys(*user.Email, gCtx.ClientIP())
+		if loginLocked(ctx, gCtx, keys) {
+			return
+		}
+		if err := checkMfaCode(ctx, user, body.Code); err != nil {
+			if err == database.ErrInvalidMfaCode {
+				recordLoginFailure(ctx, keys)
+			}
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		clearLoginFailures(ctx, keys)
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not create the recovery codes"})
+			return
+		}
+		if err := database.ReplaceRecoveryCodes(ctx, UserCollection, user.User_ID, hashes); err != nil {
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+// VerifyMfaLogin godoc
+// @Summary Finish a two-factor login
+// @Description Completes a login with the mfa_token returned by the login and a code from the authenticator app or a recovery code
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.LoggedIn
+// @Failure 400,401,429 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /users/login/mfa [post]
+func VerifyMfaLogin() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Mfa_Token string `json:"mfa_token" validate:"required"`
+			Code      string `json:"code" validate:"required"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, msg := generate.ValidateMfaToken(body.Mfa_Token)
+		if msg != "" {
+			gCtx.JSON(http.StatusUnauthorized, gin.H{"error": "the two-factor login has expired, log in again"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// a password change since the first step voids the login
+		user, err := findUser(ctx, claims.Uid)
+		if err != nil || user.Session_Version != claims.Session {
+			gCtx.JSON(http.StatusUnauthorized, gin.H{"error": "the two-factor login has expired, log in again"})
+			return
+		}
+		// codes are guessed against the same lockout as passwords
+		keys := loginKeys(*user.Email, gCtx.ClientIP())
+		if loginLocked(ctx, gCtx, keys) {
+			return
+		}
+		if err := checkMfaCode(ctx, user, body.Code); err != nil {
+			if err == database.ErrInvalidMfaCode {
+				recordLoginFailure(ctx, keys)
+			}
+			gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		clearLoginFailures(ctx, keys)
+
+		completeLogin(ctx, gCtx, user)
+	}
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/oidc"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var OidcLoginCollection *mongo.Collection = database.OpenCollection(database.Client, "OidcLogins")
+
+// OidcProviders are the identity providers users can sign in with, see oidc.FromEnv.
+var OidcProviders = oidc.FromEnv(AppBaseURL + "/auth/oidc")
+
+// oidcLoginLifetime is how long a user has to sign in at the provider.
+const oidcLoginLifetime = 10 * time.Minute
+
+// oidcErrorStatus maps the errors of the social login to a status code.
+func oidcErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidOidcState), errors.Is(err, oidc.ErrExchange), errors.Is(err, oidc.ErrInvalidIDToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, database.ErrOidcEmailUnverified):
+		return http.StatusForbidden
+	case errors.Is(err, database.ErrCantLinkIdentity):
+		return http.StatusConflict
+	case errors.Is(err, oidc.ErrDiscovery):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// StartOidcLogin godoc
+// @Summary Sign in with an identity provider
+// @Description Redirects to the sign in page of the provider, using the authorization code flow with PKCE. The provider sends the user back to the callback
+// @Tags Auth
+// @Param provider path string true "Provider name"
+// @Success 302
+// @Failure 404 {object} models.Error
+// @Failure 502 {object} models.Error
+// @Router /auth/oidc/{provider} [get]
+func StartOidcLogin() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		provider, ok := OidcProviders[gCtx.Param("provider")]
+		if !ok {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": oidc.ErrUnknownProvider.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var secrets [3]string
+		for i := range secrets {
+			value, err := oidc.RandomString()
+			if err != nil {
+				log.Println(err)
+				gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not start the sign in"})
+				return
+			}
+			secrets[i] = value
+		}
+		state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+		address, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(oidcErrorStatus(err), gin.H{"error": oidc.ErrDiscovery.Error()})
+			return
+		}
+		now := time.Now()
+		login := models.OidcLogin{
+			State_Hash: database.HashToken(state),
+			Provider:   provider.Name,
+			Nonce:      nonce,
+			Verifier:   verifier,
+			Created_At: now,
+			Expires_At: now.Add(oidcLoginLifetime),
+		}
+		if err := database.CreateOidcLogin(ctx, OidcLoginCollection, login); err != nil {
+			gCtx.JSON(oidcErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.Redirect(http.StatusFound, address)
+	}
+}
+
+// OidcCallback godoc
+// @Summary Finish signing in with an identity provider
+// @Description Where the provider sends the user back. Links the identity to the account with the same verified email, or creates one, and logs the user in like users/login
+// @Tags Auth
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State of the login"
+// @Success 200 {object} models.LoggedIn
+// @Failure 400,401,403,404,409 {object} models.Error
+// @Failure 500,502 {object} models.Error
+// @Router /auth/oidc/{provider}/callback [get]
+func OidcCallback() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		provider, ok := OidcProviders[gCtx.Param("provider")]
+		if !ok {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": oidc.ErrUnknownProvider.Error()})
+			return
+		}
+		if reason := gCtx.Query("error"); reason != "" {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "the identity provider refused the sign in: " + reason, "description": gCtx.Query("error_description")})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		login, err := database.ConsumeOidcLogin(ctx, OidcLoginCollection, provider.Name, gCtx.Query("state"))
+		if err != nil {
+			gCtx.JSON(oidcErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		idToken, err := provider.Exchange(ctx, gCtx.Query("code"), login.Verifier)
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(oidcErrorStatus(err), gin.H{"error": oidc.ErrExchange.Error()})
+			return
+		}
+		claims, err := provider.VerifyIDToken(ctx, idToken, login.Nonce)
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(oidcErrorStatus(err), gin.H{"error": oidc.ErrInvalidIDToken.Error()})
+			return
+		}
+
+		user, err := database.SignInWithIdentity(ctx, UserCollection, provider.Name, claims)
+		if err != nil {
+			gCtx.JSON(oidcErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		// the provider stands in for the password, not for the second factor
+		if user.Mfa_Enabled {
+			startMfaLogin(gCtx, user)
+			return
+		}
+		completeLogin(ctx, gCtx, user)
+	}
+}
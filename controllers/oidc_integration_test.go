@@ -0,0 +1,190 @@
+//go:build integration
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/oidc"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// setupOidc registers a fake provider as "fake", signing in a user with a fresh subject and
+// email, and returns the router of the social login.
+func setupOidc(t *testing.T) (*oidc.Fake, http.Handler) {
+	t.Helper()
+	fake, err := oidc.NewFake("shop", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Email = uniqueEmail()
+	fake.Subject = "subject-" + fake.Email
+	forgetUser(t, fake.Email)
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	OidcProviders["fake"] = oidc.NewProvider(oidc.Config{
+		Name:          "fake",
+		Issuer:        server.URL,
+		Client_ID:     "shop",
+		Client_Secret: "secret",
+		Redirect_URL:  AppBaseURL + "/auth/oidc/fake/callback",
+	}, server.Client())
+	t.Cleanup(func() { delete(OidcProviders, "fake") })
+
+	router := gin.New()
+	router.GET("/auth/oidc/:provider", StartOidcLogin())
+	router.GET("/auth/oidc/:provider/callback", OidcCallback())
+	return fake, router
+}
+
+// signInAtProvider starts a login, signs in at the provider and returns the query string the
+// provider sends the user back to the callback with.
+func signInAtProvider(t *testing.T, router http.Handler) url.Values {
+	t.Helper()
+	started := serve(router, http.MethodGet, "/auth/oidc/fake", "", nil)
+	if started.Code != http.StatusFound {
+		t.Fatalf("starting the login answered %d: %s", started.Code, started.Body)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(started.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	back, err := url.Parse(response.Header.Get("Location"))
+	if err != nil || response.StatusCode != http.StatusFound {
+		t.Fatalf("the provider answered %s, redirecting to %q", response.Status, response.Header.Get("Location"))
+	}
+	return back.Query()
+}
+
+// callback sends the user back from the provider.
+func callback(router http.Handler, query url.Values) *httptest.ResponseRecorder {
+	return serve(router, http.MethodGet, "/auth/oidc/fake/callback?"+query.Encode(), "", nil)
+}
+
+// tamperLogin changes the pending login of the state as an attacker replaying another login would.
+func tamperLogin(t *testing.T, state string, set bson.M) {
+	t.Helper()
+	result, err := OidcLoginCollection.UpdateOne(context.Background(), bson.M{"state_hash": database.HashToken(state)}, bson.M{"$set": set})
+	if err != nil || result.MatchedCount != 1 {
+		t.Fatalf("no pending login for the state: %v", err)
+	}
+}
+
+func TestOidcCallbackCreatesUser(t *testing.T) {
+	fake, router := setupOidc(t)
+
+	answer := callback(router, signInAtProvider(t, router))
+	if answer.Code != http.StatusOK {
+		t.Fatalf("callback answered %d: %s", answer.Code, answer.Body)
+	}
+	var loggedIn models.LoggedIn
+	if err := json.Unmarshal(answer.Body.Bytes(), &loggedIn); err != nil || loggedIn.Token == "" {
+		t.Fatalf("no token in %s", answer.Body)
+	}
+
+	user := storedUser(t, fake.Email)
+	if !user.Email_Verified || user.Password != nil {
+		t.Errorf("new user: verified %v, password set %v, want a verified user without a password", user.Email_Verified, user.Password != nil)
+	}
+	if len(user.Identities) != 1 || user.Identities[0].Provider != "fake" || user.Identities[0].Subject != fake.Subject {
+		t.Errorf("identities = %+v, want the fake identity", user.Identities)
+	}
+}
+
+func TestOidcCallbackStateReuse(t *testing.T) {
+	_, router := setupOidc(t)
+
+	query := signInAtProvider(t, router)
+	if answer := callback(router, query); answer.Code != http.StatusOK {
+		t.Fatalf("first callback answered %d: %s", answer.Code, answer.Body)
+	}
+	if answer := callback(router, query); answer.Code != http.StatusUnauthorized {
+		t.Errorf("replayed callback answered %d, want %d", answer.Code, http.StatusUnauthorized)
+	}
+
+	query.Set("state", "made-up")
+	if answer := callback(router, query); answer.Code != http.StatusUnauthorized {
+		t.Errorf("callback with an unknown state answered %d, want %d", answer.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOidcCallbackNonceMismatch(t *testing.T) {
+	fake, router := setupOidc(t)
+
+	query := signInAtProvider(t, router)
+	tamperLogin(t, query.Get("state"), bson.M{"nonce": "another-nonce"})
+	if answer := callback(router, query); answer.Code != http.StatusUnauthorized {
+		t.Errorf("callback answered %d, want %d", answer.Code, http.StatusUnauthorized)
+	}
+	if count, _ := UserCollection.CountDocuments(context.Background(), bson.M{"email": fake.Email}); count != 0 {
+		t.Error("a user was created from a token of another login")
+	}
+}
+
+func TestOidcCallbackPKCE(t *testing.T) {
+	_, router := setupOidc(t)
+
+	query := signInAtProvider(t, router)
+	tamperLogin(t, query.Get("state"), bson.M{"verifier": "another-verifier"})
+	if answer := callback(router, query); answer.Code != http.StatusUnauthorized {
+		t.Errorf("callback answered %d, want %d", answer.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOidcCallbackLinksVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		name string
+		// account is whether the existing account verified its email
+		account bool
+		// provider is whether the provider vouches for the email
+		provider bool
+		status   int
+		linked   bool
+		password bool
+	}{
+		{"verified account", true, true, http.StatusOK, true, true},
+		{"unverified account", false, true, http.StatusOK, true, false},
+		{"email not vouched for", true, false, http.StatusForbidden, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, router := setupOidc(t)
+			fake.Email_Verified = test.provider
+			existing := createUser(t, fake.Email, test.account)
+
+			answer := callback(router, signInAtProvider(t, router))
+			if answer.Code != test.status {
+				t.Fatalf("callback answered %d: %s, want %d", answer.Code, answer.Body, test.status)
+			}
+
+			user := storedUser(t, fake.Email)
+			if user.User_ID != existing.User_ID {
+				t.Fatal("another account was created for the email")
+			}
+			if linked := len(user.Identities) == 1; linked != test.linked {
+				t.Errorf("linked = %v, want %v", linked, test.linked)
+			}
+			if password := user.Password != nil; password != test.password {
+				t.Errorf("password kept = %v, want %v", password, test.password)
+			}
+			if test.linked && !user.Email_Verified {
+				t.Error("the linked account's email isn't verified")
+			}
+			if test.linked && !test.account && user.Session_Version != existing.Session_Version+1 {
+				t.Error("the sessions of the unverified account were kept")
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds of the ?per_page= query parameter.
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// pageQuery reads ?page= and ?per_page= from the query string, falling back to the first page
+// of the default size for missing or invalid values
+func pageQuery(gCtx *gin.Context) (page, perPage int64) {
+	page, err := strconv.ParseInt(gCtx.Query("page"), 10, 64)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err = strconv.ParseInt(gCtx.Query("per_page"), 10, 64)
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	return page, perPage
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/mail"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	generate "github.com/ravelinejunior/golang_ecommerce/tokens"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var PasswordResetCollection *mongo.Collection = database.OpenCollection(database.Client, "PasswordResets")
+
+// passwordResetLifetime is how long a reset link stays valid.
+const passwordResetLifetime = time.Hour
+
+// passwordResetCooldown is the minimum time between two reset emails to the same user.
+const passwordResetCooldown = time.Minute
+
+// ForgotPassword godoc
+// @Summary Ask for a password reset
+// @Description Emails a single use link to reset the password, valid for an hour. The answer is the same whether or not the email belongs to an account
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 202 {string} string
+// @Failure 400 {object} models.Error
+// @Router /users/password/forgot [post]
+func ForgotPassword() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		body.Email = database.NormalizeEmail(body.Email)
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// never tell whether the address has an account
+		accepted := "If an account uses this address, a reset link is on its way"
+		user, token, err := database.CreatePasswordReset(ctx, UserCollection, PasswordResetCollection, body.Email, passwordResetLifetime, passwordResetCooldown)
+		if err != nil {
+			if err != database.ErrCantFindUserByEmail && err != database.ErrResetRequestedSoon {
+				log.Println(err)
+			}
+			gCtx.IndentedJSON(http.StatusAccepted, accepted)
+			return
+		}
+
+		if err := sendPasswordResetEmail(ctx, user, token); err != nil {
+			log.Println("could not send the password reset email:", err)
+		}
+		gCtx.IndentedJSON(http.StatusAccepted, accepted)
+	}
+}
+
+// sendPasswordResetEmail emails the user the link to reset their password with the token.
+func sendPasswordResetEmail(ctx context.Context, user *models.User, token string) error {
+	link := AppBaseURL + "/users/password/reset?token=" + url.QueryEscape(token)
+	return Mailer.Send(ctx, mail.Email{
+		To:      *user.Email,
+		Subject: "Reset your password",
+		Text: fmt.Sprintf("Someone asked to reset the password of your account. To choose a new password, open the link below within %d minutes. It works once.\n\n%s\n\nIf it wasn't you, ignore this email, your password stays the same.\n",
+			int(passwordResetLifetime.Minutes()), link),
+	})
+}
+
+// ResetPassword godoc
+// @Summary Reset a forgotten password
+// @Description Sets a new password with the token from the reset email and logs out every session of the account
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {string} string
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /users/password/reset [post]
+func ResetPassword() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Token    string `json:"token" validate:"required"`
+			Password string `json:"password" validate:"required,min=6"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID, err := database.UsePasswordReset(ctx, PasswordResetCollection, body.Token)
+		if err == database.ErrInvalidResetToken {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := database.SetPassword(ctx, UserCollection, userID, HashPassword(body.Password)); err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		reset := userAudit(models.AuditPasswordReset, userID, nil)
+		reset.Actor_ID = userID
+		recordAudit(ctx, gCtx, reset, nil, nil)
+		gCtx.IndentedJSON(http.StatusOK, "Successfully reset the password, log in with the new one")
+	}
+}
+
+// ChangePassword godoc
+// @Summary Change the password
+// @Description Changes the password of the logged in user, who must confirm the current one. Wrong current passwords count as failed logins. Every other session is logged out and fresh tokens are returned for this one
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 400,403,409,429 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /users/password/change [post]
+func ChangePassword() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Current_Password string `json:"current_password" validate:"required"`
+			New_Password     string `json:"new_password" validate:"required,min=6"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"user_id": middleware.UserID(gCtx)}).Decode(&user); err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not find the user"})
+			return
+		}
+		if user.Password == nil {
+			gCtx.JSON(http.StatusConflict, gin.H{"error": "the account has no password yet, set one through /users/password/forgot"})
+			return
+		}
+		// wrong current passwords count as failed logins, or a stolen session could guess it freely
+		keys := loginKeys(*user.Email, gCtx.ClientIP())
+		if loginLocked(ctx, gCtx, keys) {
+			return
+		}
+		if valid, _ := VerifyPassword(body.Current_Password, *user.Password); !valid {
+			recordLoginFailure(ctx, keys)
+			gCtx.JSON(http.StatusForbidden, gin.H{"error": "the current password is incorrect"})
+			return
+		}
+		clearLoginFailures(ctx, keys)
+
+		updated, err := database.SetPassword(ctx, UserCollection, user.User_ID, HashPassword(body.New_Password))
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, userAudit(models.AuditPasswordChanged, user.User_ID, nil), nil, nil)
+
+		// keep this session going with tokens of the new session version
+		token, refreshToken, err := generate.TokenGenerator(*updated.Email, *updated.First_Name, *updated.Last_Name, updated.User_ID, updated.Session_Version)
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not sign new tokens, log in again"})
+			return
+		}
+		generate.UpdateAllTokens(token, refreshToken, updated.User_ID)
+		gCtx.IndentedJSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
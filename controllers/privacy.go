@@ -0,0 +1,459 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/mail"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExportStore keeps the data export archives on disk, under EXPORT_DIR. Unlike the images they
+// are never served as they are, only downloaded by their owner through DownloadDataExport.
+var ExportStore storage.Store = exportStore()
+
+func exportStore() storage.Store {
+	store, err := storage.NewLocal(envOr("EXPORT_DIR", "exports"), "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return store
+}
+
+// Privacy settings, read from the environment.
+var (
+	// DataExportRetention is how long a data export can be downloaded before it is deleted.
+	DataExportRetention = envDuration("DATA_EXPORT_RETENTION", 7*24*time.Hour)
+	// DataExportCleanupInterval is how often the expired data exports are deleted.
+	DataExportCleanupInterval = envDuration("DATA_EXPORT_CLEANUP_INTERVAL", time.Hour)
+)
+
+// privacyJobTimeout bounds how long a data export or an account deletion may run.
+const privacyJobTimeout = 30 * time.Minute
+
+// dataExportCleanupBatch bounds the exports deleted per sweep.
+const dataExportCleanupBatch = 100
+
+// privacyErrorStatus maps the errors of the data exports and account deletions to a status code.
+func privacyErrorStatus(err error) int {
+	switch err {
+	case database.ErrCantFindJob, database.ErrUserIdsNotValid:
+		return http.StatusNotFound
+	case database.ErrAccountDeleted:
+		return http.StatusConflict
+	case storage.ErrNotFound:
+		return http.StatusGone
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// RequestDataExport godoc
+// @Summary Export my data
+// @Description Starts gathering the profile, addresses, cart, orders, invoices, shipments, reviews and wishlists of the logged in user into a JSON archive, in the background. Poll the returned job, then download the archive while it lasts
+// @Tags Users
+// @Produce json
+// @Success 202 {object} models.Job
+// @Failure 409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /me/exports [post]
+func RequestDataExport() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userID := middleware.UserID(gCtx)
+		active, err := database.HasActiveJob(ctx, JobCollection, userID, models.JobDataExport)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if active {
+			gCtx.JSON(http.StatusConflict, gin.H{"error": "an export of your data is already being prepared"})
+			return
+		}
+
+		job := models.Job{
+			Kind:       models.JobDataExport,
+			Format:     "json",
+			File_Name:  fmt.Sprintf("data-export-%s.json", time.Now().Format("20060102")),
+			Created_By: userID,
+		}
+		if err := database.CreateJob(ctx, JobCollection, &job); err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		go runDataExport(job)
+		gCtx.IndentedJSON(http.StatusAccepted, job)
+	}
+}
+
+// runDataExport writes the archive of a user's data to the export store.
+func runDataExport(job models.Job) {
+	var ctx, cancel = context.WithTimeout(context.Background(), privacyJobTimeout)
+	defer cancel()
+
+	fail := func(err error) {
+		log.Println("data export", job.Job_ID.Hex(), "failed:", err)
+		database.FinishJob(context.Background(), JobCollection, job.Job_ID, err.Error())
+	}
+
+	if err := database.StartJob(ctx, JobCollection, job.Job_ID); err != nil {
+		fail(err)
+		return
+	}
+	export, err := database.ExportUserData(ctx, UserCollection, InvoiceCollection, ShipmentCollection, ReviewCollection, WishlistCollection, job.Created_By)
+	if err != nil {
+		fail(err)
+		return
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	key := job.Created_By + "/" + job.Job_ID.Hex() + ".json"
+	if err := ExportStore.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "application/json"); err != nil {
+		fail(err)
+		return
+	}
+	if err := database.SetJobBlob(ctx, JobCollection, job.Job_ID, key, time.Now().Add(DataExportRetention)); err != nil {
+		ExportStore.Delete(context.Background(), key)
+		fail(err)
+		return
+	}
+	database.FinishJob(ctx, JobCollection, job.Job_ID, "")
+}
+
+// ListDataExports godoc
+// @Summary List my data exports
+// @Description Lists the latest data exports of the logged in user, newest first
+// @Tags Users
+// @Produce json
+// @Success 200 {array} models.Job
+// @Failure 500 {object} models.Error
+// @Router /me/exports [get]
+func ListDataExports() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		jobs, err := database.ListUserJobs(ctx, JobCollection, middleware.UserID(gCtx), models.JobDataExport, maxPerPage)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, jobs)
+	}
+}
+
+// GetDataExport godoc
+// @Summary Get a data export
+// @Description Returns the status of a data export of the logged in user, and until when it can be downloaded
+// @Tags Users
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} models.Job
+// @Failure 400,404 {object} models.Error
+// @Router /me/exports/{id} [get]
+func GetDataExport() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		jobID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		job, err := database.FindUserJob(ctx, JobCollection, jobID, middleware.UserID(gCtx), models.JobDataExport)
+		if err != nil {
+			gCtx.JSON(privacyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, job)
+	}
+}
+
+// DownloadDataExport godoc
+// @Summary Download a data export
+// @Description Downloads the JSON archive of a completed data export of the logged in user
+// @Tags Users
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} models.DataExport
+// @Failure 400,404,409,410 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /me/exports/{id}/download [get]
+func DownloadDataExport() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		jobID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		job, err := database.FindUserJob(ctx, JobCollection, jobID, middleware.UserID(gCtx), models.JobDataExport)
+		if err != nil {
+			gCtx.JSON(privacyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if job.Status != models.JobCompleted {
+			gCtx.JSON(http.StatusConflict, gin.H{"error": "the export is " + job.Status + ", it can only be downloaded once completed"})
+			return
+		}
+		if job.Blob_Key == "" || (job.Expires_At != nil && time.Now().After(*job.Expires_At)) {
+			gCtx.JSON(http.StatusGone, gin.H{"error": "the export has expired, ask for a new one"})
+			return
+		}
+
+		archive, err := ExportStore.Get(ctx, job.Blob_Key)
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(privacyErrorStatus(err), gin.H{"error": "the export is no longer available, ask for a new one"})
+			return
+		}
+		defer archive.Close()
+
+		gCtx.Header("Cache-Control", "no-store")
+		gCtx.DataFromReader(http.StatusOK, -1, "application/json", archive, map[string]string{
+			"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, job.File_Name),
+		})
+	}
+}
+
+// DeleteAccount godoc
+// @Summary Delete my account
+// @Description Closes the account of the logged in user at once: it can no longer log in and its email and phone are released. Its personal data is then anonymized in the background; orders are kept for the accounting history without the personal data. Follow the returned job with the status token. Accounts with a password must send it along, and a code when two-factor authentication is on; wrong ones count as failed logins
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Success 202 {object} models.AccountDeletion
+// @Failure 400,401,403,404,409,429 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /me [delete]
+func DeleteAccount() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Password string `json:"password"`
+			Code     string `json:"code"`
+		}
+		if err := gCtx.ShouldBindJSON(&body); err != nil && gCtx.Request.ContentLength != 0 {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findUser(ctx, middleware.UserID(gCtx))
+		if err != nil {
+			gCtx.JSON(privacyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		// the store must keep someone able to run it
+		if user.Role != nil && *user.Role == models.RoleAdmin {
+			gCtx.JSON(http.StatusConflict, gin.H{"error": "admin accounts must lose the admin role before they can be deleted"})
+			return
+		}
+		// the password and the code are guessed against the same lockout as logins
+		keys := loginKeys(*user.Email, gCtx.ClientIP())
+		if loginLocked(ctx, gCtx, keys) {
+			return
+		}
+		// accounts created through an identity provider have no password to check
+		if user.Password != nil {
+			if valid, _ := VerifyPassword(body.Password, *user.Password); !valid {
+				recordLoginFailure(ctx, keys)
+				gCtx.JSON(http.StatusForbidden, gin.H{"error": "the password is incorrect"})
+				return
+			}
+		}
+		if user.Mfa_Enabled {
+			if err := checkMfaCode(ctx, user, body.Code); err != nil {
+				if err == database.ErrInvalidMfaCode {
+					recordLoginFailure(ctx, keys)
+				}
+				gCtx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+		}
+		clearLoginFailures(ctx, keys)
+
+		token, hash, err := database.NewJobToken()
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		job := models.Job{Kind: models.JobAccountDeletion, Created_By: user.User_ID, Token_Hash: hash}
+		if err := database.CreateJob(ctx, JobCollection, &job); err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		closed, err := database.CloseAccount(ctx, UserCollection, user.User_ID)
+		if err != nil {
+			database.FinishJob(ctx, JobCollection, job.Job_ID, err.Error())
+			gCtx.JSON(privacyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		go runAccountDeletion(job)
+		// nothing personal goes to the audit trail, which outlives the account
+		recordAudit(ctx, gCtx, userAudit(models.AuditAccountDeleted, user.User_ID, map[string]string{"job_id": job.Job_ID.Hex()}), nil, nil)
+
+		if closed.Email != nil {
+			if err := sendAccountDeletedEmail(ctx, closed); err != nil {
+				log.Println("could not send the account deletion email:", err)
+			}
+		}
+		gCtx.IndentedJSON(http.StatusAccepted, models.AccountDeletion{Job: &job, Status_Token: token})
+	}
+}
+
+// sendAccountDeletedEmail confirms to the former address of a user that the account is deleted.
+func sendAccountDeletedEmail(ctx context.Context, user *models.User) error {
+	name := ""
+	if user.First_Name != nil {
+		name = " " + *user.First_Name
+	}
+	return Mailer.Send(ctx, mail.Email{
+		To:      *user.Email,
+		Subject: "Your account was deleted",
+		Text: fmt.Sprintf("Hi%s,\n\nYour account is closed and your personal data is being removed. The orders you placed are kept for our accounting, without your personal details.\n\nIf you didn't ask for this, contact us right away.\n",
+			name),
+	})
+}
+
+// runAccountDeletion removes the personal data of a closed account and the data exports of the user.
+func runAccountDeletion(job models.Job) {
+	var ctx, cancel = context.WithTimeout(context.Background(), privacyJobTimeout)
+	defer cancel()
+
+	fail := func(err error) {
+		log.Println("account deletion", job.Job_ID.Hex(), "failed:", err)
+		database.FinishJob(context.Background(), JobCollection, job.Job_ID, err.Error())
+	}
+
+	if err := database.StartJob(ctx, JobCollection, job.Job_ID); err != nil {
+		fail(err)
+		return
+	}
+	exports, err := database.JobsWithBlobs(ctx, JobCollection, job.Created_By, time.Now(), 0)
+	if err != nil {
+		fail(err)
+		return
+	}
+	for _, export := range exports {
+		if err := deleteExportBlob(ctx, export); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if err := database.AnonymizeUser(ctx, UserCollection, ReviewCollection, WishlistCollection, APIKeyCollection, PasswordResetCollection, job.Created_By); err != nil {
+		fail(err)
+		return
+	}
+	database.FinishJob(ctx, JobCollection, job.Job_ID, "")
+}
+
+// ResumeAccountDeletions restarts the account deletions a previous run of the server left
+// unfinished; the accounts are already closed, so their data must still go.
+func ResumeAccountDeletions(ctx context.Context) error {
+	jobs, err := database.InterruptedJobs(ctx, JobCollection, models.JobAccountDeletion)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		go runAccountDeletion(job)
+	}
+	return nil
+}
+
+// AccountDeletionStatus godoc
+// @Summary Follow an account deletion
+// @Description Returns the job anonymizing a deleted account, given the status token returned when the account was deleted
+// @Tags Users
+// @Produce json
+// @Param id path string true "Job ID"
+// @Param token query string true "Status token"
+// @Success 200 {object} models.Job
+// @Failure 400,404 {object} models.Error
+// @Router /account-deletions/{id} [get]
+func AccountDeletionStatus() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		jobID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// a wrong token gets the same answer as a missing job
+		job, err := database.FindJob(ctx, JobCollection, jobID)
+		if err != nil || job.Kind != models.JobAccountDeletion ||
+			subtle.ConstantTimeCompare([]byte(database.HashToken(gCtx.Query("token"))), []byte(job.Token_Hash)) != 1 {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindJob.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, job)
+	}
+}
+
+// StartDataExportCleanup deletes the expired data exports every DataExportCleanupInterval, until
+// the context is cancelled.
+func StartDataExportCleanup(ctx context.Context) {
+	ticker := time.NewTicker(DataExportCleanupInterval)
+	defer ticker.Stop()
+	for {
+		sweepExpiredExports(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepExpiredExports deletes the archives of the data exports past retention.
+func sweepExpiredExports(parent context.Context) {
+	var ctx, cancel = context.WithTimeout(parent, DataExportCleanupInterval)
+	defer cancel()
+
+	jobs, err := database.JobsWithBlobs(ctx, JobCollection, "", time.Now(), dataExportCleanupBatch)
+	if err != nil {
+		log.Println("data exports:", err)
+		return
+	}
+	for _, job := range jobs {
+		if err := deleteExportBlob(ctx, job); err != nil {
+			log.Println("data export", job.Job_ID.Hex()+":", err)
+		}
+	}
+}
+
+// deleteExportBlob deletes the archive of a data export and forgets it on the job.
+func deleteExportBlob(ctx context.Context, job models.Job) error {
+	if err := ExportStore.Delete(ctx, job.Blob_Key); err != nil {
+		return err
+	}
+	return database.ClearJobBlob(ctx, JobCollection, job.Job_ID)
+}
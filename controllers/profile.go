@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/mail"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	generate "github.com/ravelinejunior/golang_ecommerce/tokens"
+)
+
+// emailChangeCooldown is the minimum time between two requests to change the email address.
+const emailChangeCooldown = time.Minute
+
+// userErrorStatus maps the errors of creating and updating users to a status code.
+func userErrorStatus(err error) int {
+	switch err {
+	case database.ErrEmailTaken, database.ErrPhoneTaken, database.ErrSameEmail:
+		return http.StatusConflict
+	case database.ErrNothingToUpdate:
+		return http.StatusBadRequest
+	case database.ErrEmailChangeTooSoon:
+		return http.StatusTooManyRequests
+	case database.ErrUserIdsNotValid:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// sendEmailChangeEmails emails a link confirming the new address to it, and warns the current
+// address that a change was asked for.
+func sendEmailChangeEmails(ctx context.Context, user *models.User, email string) error {
+	token, err := generate.EmailTokenGenerator(user.User_ID, email)
+	if err != nil {
+		return err
+	}
+	link := AppBaseURL + "/users/verify-email?token=" + url.QueryEscape(token)
+
+	name := ""
+	if user.First_Name != nil {
+		name = " " + *user.First_Name
+	}
+	err = Mailer.Send(ctx, mail.Email{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Text: fmt.Sprintf("Hi%s,\n\nPlease confirm you want to use this email address for your account by opening the link below. It expires in %d hours, and you will then log in again with this address.\n\n%s\n\nIf you didn't ask for this, you can ignore this email.\n",
+			name, int(generate.EmailTokenLifetime.Hours()), link),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the current owner of the account hears about it too, in case someone else asked
+	if user.Email == nil {
+		return nil
+	}
+	return Mailer.Send(ctx, mail.Email{
+		To:      *user.Email,
+		Subject: "Your email address is about to change",
+		Text: fmt.Sprintf("Hi%s,\n\nSomeone asked to move your account to %s. Nothing changes until the new address is confirmed.\n\nIf it wasn't you, change your password right away.\n",
+			name, email),
+	})
+}
+
+// GetProfile godoc
+// @Summary Get the profile of the logged in user
+// @Description Returns the logged in user. The password and tokens are never part of it
+// @Tags Users
+// @Produce json
+// @Success 200 {object} models.User
+// @Failure 404 {object} models.Error
+// @Router /me [get]
+func GetProfile() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findUser(ctx, middleware.UserID(gCtx))
+		if err != nil {
+			gCtx.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, user)
+	}
+}
+
+// UpdateProfile godoc
+// @Summary Update the profile of the logged in user
+// @Description Changes the first and last name and the phone number of the logged in user. Fields left out are kept; the email is changed through /me/email
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param profile body models.ProfileUpdate true "Fields to change"
+// @Success 200 {object} models.User
+// @Failure 400,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /me [patch]
+func UpdateProfile() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body models.ProfileUpdate
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Phone != nil {
+			phone := strings.TrimSpace(*body.Phone)
+			body.Phone = &phone
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := database.UpdateProfile(ctx, UserCollection, middleware.UserID(gCtx), body)
+		if err != nil {
+			gCtx.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, user)
+	}
+}
+
+// ChangeEmail godoc
+// @Summary Change the email address
+// @Description Sends a confirmation link to the new address, at most once a minute. The account keeps its current address until the link is opened, then moves to the new one and its sessions end. Accounts with a password must send it along
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param change body models.EmailChange true "New address and current password"
+// @Success 202 {string} string
+// @Failure 400,403,404,409,429 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /me/email [post]
+func ChangeEmail() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body models.EmailChange
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		body.Email = database.NormalizeEmail(body.Email)
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findUser(ctx, middleware.UserID(gCtx))
+		if err != nil {
+			gCtx.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		// accounts created through an identity provider have no password to check
+		if user.Password != nil {
+			if valid, _ := VerifyPassword(body.Password, *user.Password); !valid {
+				gCtx.JSON(http.StatusForbidden, gin.H{"error": "the password is incorrect"})
+				return
+			}
+		}
+
+		user, err = database.RequestEmailChange(ctx, UserCollection, user.User_ID, body.Email, emailChangeCooldown)
+		if err == database.ErrEmailChangeTooSoon {
+			gCtx.Header("Retry-After", fmt.Sprint(int(emailChangeCooldown.Seconds())))
+		}
+		if err != nil {
+			gCtx.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := sendEmailChangeEmails(ctx, user, body.Email); err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not send the confirmation email"})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusAccepted, "Confirmation email sent to the new address")
+	}
+}
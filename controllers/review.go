@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ReviewCollection *mongo.Collection = database.OpenCollection(database.Client, "Reviews")
+
+// reviewErrorStatus maps the errors of the review store to an http status
+func reviewErrorStatus(err error) int {
+	switch err {
+	case database.ErrCantFindReview, database.ErrCantFindProduct:
+		return http.StatusNotFound
+	case database.ErrNotPurchased, database.ErrOwnReview:
+		return http.StatusForbidden
+	case database.ErrAlreadyReviewed:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// AddReview godoc
+// @Summary Review a product
+// @Description Submits a review of a product the user ordered. Reviews are shown once a moderator approves them
+// @Tags Reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param review body models.Review true "Rating, title and body"
+// @Success 201 {object} models.Review
+// @Failure 400,403,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /products/{id}/reviews [post]
+func AddReview() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+			return
+		}
+
+		var review models.Review
+		if err := gCtx.BindJSON(&review); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(review); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		review.Product_ID = productID
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err = database.AddReview(ctx, UserCollection, ProductCollection, ReviewCollection, middleware.UserID(gCtx), &review)
+		if err != nil {
+			gCtx.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusCreated, review)
+	}
+}
+
+// ProductReviews godoc
+// @Summary List the reviews of a product
+// @Description Lists the approved reviews of a product, most recent first or most helpful first with ?sort=helpful. Logged in users also see their own review whatever its status
+// @Tags Reviews
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param sort query string false "recent or helpful"
+// @Param page query int false "Page number"
+// @Param per_page query int false "Reviews per page"
+// @Success 200 {object} models.Page
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /products/{id}/reviews [get]
+func ProductReviews() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+			return
+		}
+
+		sort := bson.D{{Key: "created_at", Value: -1}}
+		if gCtx.Query("sort") == "helpful" {
+			sort = bson.D{{Key: "helpful_votes", Value: -1}, {Key: "created_at", Value: -1}}
+		}
+		page, perPage := pageQuery(gCtx)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"product_id": productID, "status": models.ReviewApproved}
+		// logged in authors also see their own review while it awaits moderation or was rejected
+		if userID := middleware.UserID(gCtx); userID != "" {
+			filter = bson.M{"product_id": productID, "$or": bson.A{
+				bson.M{"status": models.ReviewApproved},
+				bson.M{"user_id": userID},
+			}}
+		}
+		reviews, err := database.ListReviews(ctx, ReviewCollection, filter, sort, page, perPage)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, reviews)
+	}
+}
+
+// VoteReview godoc
+// @Summary Vote on a review
+// @Description Marks an approved review as helpful or not. Voting again replaces the earlier vote
+// @Tags Reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Review ID"
+// @Success 200 {object} models.Review
+// @Failure 400,403,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /reviews/{id}/votes [post]
+func VoteReview() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		reviewID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid review id"})
+			return
+		}
+
+		var vote struct {
+			Helpful *bool `json:"helpful" validate:"required"`
+		}
+		if err := gCtx.BindJSON(&vote); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(vote); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		review, err := database.VoteReview(ctx, ReviewCollection, reviewID, middleware.UserID(gCtx), *vote.Helpful)
+		if err != nil {
+			gCtx.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, review)
+	}
+}
+
+// ReviewQueue godoc
+// @Summary List reviews for moderation
+// @Description Lists the reviews in a moderation state, pending by default, oldest first
+// @Tags Reviews
+// @Produce json
+// @Param status query string false "pending, approved or rejected"
+// @Param page query int false "Page number"
+// @Param per_page query int false "Reviews per page"
+// @Success 200 {object} models.Page
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/reviews [get]
+func ReviewQueue() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		status := gCtx.DefaultQuery("status", models.ReviewPending)
+		if status != models.ReviewPending && status != models.ReviewApproved && status != models.ReviewRejected {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, approved or rejected"})
+			return
+		}
+		page, perPage := pageQuery(gCtx)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		sort := bson.D{{Key: "created_at", Value: 1}}
+		reviews, err := database.ListReviews(ctx, ReviewCollection, bson.M{"status": status}, sort, page, perPage)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, reviews)
+	}
+}
+
+// ModerateReview godoc
+// @Summary Approve or reject a review
+// @Description Moves a review to approved or rejected and refreshes the average rating and review count of its product
+// @Tags Reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Review ID"
+// @Success 200 {object} models.Review
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/reviews/{id}/moderation [post]
+func ModerateReview() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		reviewID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid review id"})
+			return
+		}
+
+		var decision struct {
+			Status string `json:"status" validate:"required,oneof=approved rejected"`
+			Note   string `json:"note" validate:"max=1000"`
+		}
+		if err := gCtx.BindJSON(&decision); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(decision); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		review, err := database.ModerateReview(ctx, ProductCollection, ReviewCollection, reviewID, decision.Status, decision.Note)
+		if err != nil {
+			gCtx.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, review)
+	}
+}
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/shipping"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ShipmentCollection *mongo.Collection = database.OpenCollection(database.Client, "Shipments")
+
+// shipmentErrorStatus maps the errors of the fulfillment flow to an http status
+func shipmentErrorStatus(err error) int {
+	switch err {
+	case database.ErrCantFindOrder, database.ErrCantFindShipment:
+		return http.StatusNotFound
+	case database.ErrShipmentDelivered, database.ErrShipmentConflict:
+		return http.StatusConflict
+	case database.ErrCantSaveShipment, database.ErrCantUpdateOrder:
+		return http.StatusInternalServerError
+	}
+	// item checks return descriptive errors of their own
+	return http.StatusBadRequest
+}
+
+// CreateShipment godoc
+// @Summary Create a shipment for an order
+// @Description Records a shipment holding part or all of the order's items
+// @Tags Fulfillment
+// @Accept json
+// @Produce json
+// @Param id path string true "Order ID"
+// @Param shipment body models.Shipment true "Carrier, tracking number and items"
+// @Success 201 {object} models.Shipment
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/orders/{id}/shipments [post]
+func CreateShipment() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var shipment models.Shipment
+		if err := gCtx.BindJSON(&shipment); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(shipment); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		before := orderState(ctx, orderID)
+		err = database.CreateShipment(ctx, UserCollection, ShipmentCollection, orderID, &shipment)
+		if err != nil {
+			gCtx.JSON(shipmentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, orderAudit(models.AuditShipmentCreated, orderID, map[string]string{"shipment_id": shipment.Shipment_ID.Hex()}), before, orderState(ctx, orderID))
+
+		gCtx.IndentedJSON(http.StatusCreated, shipment)
+	}
+}
+
+// UpdateShipment godoc
+// @Summary Update a shipment
+// @Description Changes the carrier or tracking number of a shipment
+// @Tags Fulfillment
+// @Accept json
+// @Produce json
+// @Param id path string true "Shipment ID"
+// @Param shipment body models.Shipment true "Carrier and tracking number"
+// @Success 200 {object} models.Shipment
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/shipments/{id} [patch]
+func UpdateShipment() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		shipmentID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid shipment id"})
+			return
+		}
+
+		var changes struct {
+			Carrier         string `json:"carrier"`
+			Tracking_Number string `json:"tracking_number"`
+		}
+		if err := gCtx.BindJSON(&changes); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		before := shipmentState(ctx, shipmentID)
+		shipment, err := database.UpdateShipment(ctx, ShipmentCollection, shipmentID, changes.Carrier, changes.Tracking_Number)
+		if err != nil {
+			gCtx.JSON(shipmentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, models.AuditEntry{
+			Action: models.AuditShipmentUpdated, Target_Type: models.AuditTargetShipment, Target_ID: shipmentID.Hex(),
+			Details: map[string]string{"order_id": shipment.Order_ID.Hex()},
+		}, before, shipment)
+
+		gCtx.IndentedJSON(http.StatusOK, shipment)
+	}
+}
+
+// AddShipmentEvent godoc
+// @Summary Add a tracking event to a shipment
+// @Description Appends a tracking event, moves the shipment to its status and advances the order through shipped and delivered
+// @Tags Fulfillment
+// @Accept json
+// @Produce json
+// @Param id path string true "Shipment ID"
+// @Param event body models.ShipmentEvent true "Tracking event"
+// @Success 200 {object} models.Shipment
+// @Failure 400,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/shipments/{id}/events [post]
+func AddShipmentEvent() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		shipmentID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid shipment id"})
+			return
+		}
+
+		var event models.ShipmentEvent
+		if err := gCtx.BindJSON(&event); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(event); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// the event may move the order along, which is what the audit trail records
+		var before *models.Order
+		if previous := shipmentState(ctx, shipmentID); previous != nil {
+			before = orderState(ctx, previous.Order_ID)
+		}
+		shipment, err := database.AddShipmentEvent(ctx, UserCollection, ShipmentCollection, shipmentID, event)
+		if err != nil {
+			gCtx.JSON(shipmentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, orderAudit(models.AuditShipmentEvent, shipment.Order_ID, map[string]string{
+			"shipment_id": shipmentID.Hex(), "status": shipment.Status,
+		}), before, orderState(ctx, shipment.Order_ID))
+
+		gCtx.IndentedJSON(http.StatusOK, shipment)
+	}
+}
+
+// TrackOrder godoc
+// @Summary Track an order
+// @Description Shows the status of one of the user's orders together with its shipments and their tracking events
+// @Tags Fulfillment
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {object} models.OrderTracking
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /orders/{id}/tracking [get]
+func TrackOrder() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// answer not found for other users' orders rather than revealing they exist
+		order, userID, err := database.FindOrder(ctx, UserCollection, orderID)
+		if err != nil || userID != middleware.UserID(gCtx) {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindOrder.Error()})
+			return
+		}
+
+		shipments, err := database.OrderShipments(ctx, ShipmentCollection, orderID)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		status := order.Status
+		if status == "" {
+			// orders placed before fulfillment tracking existed
+			status = shipping.OrderStatus(order, shipments)
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, models.OrderTracking{
+			Order_ID:  order.Order_ID,
+			Status:    status,
+			Shipments: shipments,
+		})
+	}
+}
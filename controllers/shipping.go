@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/shipping"
+	"github.com/ravelinejunior/golang_ecommerce/tax"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ShippingZoneCollection *mongo.Collection = database.OpenCollection(database.Client, "ShippingZones")
+
+// checkoutOptions reads the shipping method and address position from the query string
+func checkoutOptions(gCtx *gin.Context) (database.CheckoutOptions, error) {
+	checkout := database.CheckoutOptions{ShippingMethod: gCtx.Query("method"), Acknowledgement: gCtx.Query("acknowledge")}
+
+	if address := gCtx.Query("address"); address != "" {
+		index, err := strconv.Atoi(address)
+		if err != nil {
+			return checkout, errors.New("address must be the position of a saved address")
+		}
+		checkout.AddressIndex = index
+	}
+	return checkout, nil
+}
+
+// checkoutErrorStatus maps the errors of placing an order to an http status
+func checkoutErrorStatus(err error) int {
+	switch err {
+	case database.ErrUserIdsNotValid, database.ErrCartEmpty, database.ErrNoAddress, database.ErrAddressNoCountry,
+		shipping.ErrNoZone, shipping.ErrNoMethod, shipping.ErrNoTier:
+		return http.StatusBadRequest
+	case database.ErrCantFindProduct:
+		return http.StatusNotFound
+	case catalog.ErrVariantRequired, catalog.ErrUnknownVariant:
+		return http.StatusBadRequest
+	case catalog.ErrOutOfStock, database.ErrCartBusy:
+		return http.StatusConflict
+	case tax.ErrNoJurisdiction:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// ShippingQuote godoc
+// @Summary Quote shipping for the cart
+// @Description Prices every shipping method available for the user's cart, or for a single product when product is set
+// @Tags Shipping
+// @Produce json
+// @Param address query int false "Position of the shipping address, 0 for home and 1 for work"
+// @Param product query string false "Product ID to quote instead of the cart"
+// @Param variant query string false "Variant of the product to quote"
+// @Success 200 {array} models.ShippingQuote
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /shippingquote [get]
+func ShippingQuote() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		userID, err := primitive.ObjectIDFromHex(middleware.UserID(gCtx))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+			return
+		}
+
+		checkout, err := checkoutOptions(gCtx)
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		err = UserCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		items := user.UserCart
+		if productQueryID := gCtx.Query("product"); productQueryID != "" {
+			productID, err := primitive.ObjectIDFromHex(productQueryID)
+			if err != nil {
+				gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+				return
+			}
+
+			variantID, err := variantQuery(gCtx)
+			if err != nil {
+				gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			var product models.Product
+			if err = ProductCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+				gCtx.JSON(http.StatusNotFound, gin.H{"error": database.ErrCantFindProduct.Error()})
+				return
+			}
+
+			item, err := catalog.CartItem(&product, variantID)
+			if err != nil {
+				gCtx.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			items = []models.ProductUser{item}
+		}
+
+		if len(items) == 0 {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": database.ErrCartEmpty.Error()})
+			return
+		}
+
+		address, err := database.ShippingAddress(&user, checkout.AddressIndex)
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		quotes, err := database.ShippingQuotes(ctx, ShippingZoneCollection, address, items)
+		if err != nil {
+			gCtx.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, quotes)
+	}
+}
+
+// AddShippingZone godoc
+// @Summary Add a shipping zone
+// @Description Adds a shipping zone with its methods and rates
+// @Tags Shipping
+// @Accept json
+// @Produce json
+// @Param zone body models.ShippingZone true "Shipping zone"
+// @Success 201 {object} models.ShippingZone
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/shipping/zones [post]
+func AddShippingZone() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var zone models.ShippingZone
+		if err := gCtx.BindJSON(&zone); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(zone); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := shipping.CheckZone(&zone); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.AddShippingZone(ctx, ShippingZoneCollection, &zone); err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusCreated, zone)
+	}
+}
+
+// ListShippingZones godoc
+// @Summary List shipping zones
+// @Description Lists every shipping zone with its methods and rates
+// @Tags Shipping
+// @Produce json
+// @Success 200 {array} models.ShippingZone
+// @Failure 500 {object} models.Error
+// @Router /admin/shipping/zones [get]
+func ListShippingZones() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		zones, err := database.ListShippingZones(ctx, ShippingZoneCollection)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, zones)
+	}
+}
+
+// UpdateShippingZone godoc
+// @Summary Update a shipping zone
+// @Description Replaces a shipping zone with its methods and rates
+// @Tags Shipping
+// @Accept json
+// @Produce json
+// @Param id path string true "Zone ID"
+// @Param zone body models.ShippingZone true "Shipping zone"
+// @Success 200 {object} models.ShippingZone
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/shipping/zones/{id} [put]
+func UpdateShippingZone() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		zoneID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid zone id"})
+			return
+		}
+
+		var zone models.ShippingZone
+		if err := gCtx.BindJSON(&zone); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(zone); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := shipping.CheckZone(&zone); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err = database.UpdateShippingZone(ctx, ShippingZoneCollection, zoneID, &zone)
+		if err == database.ErrCantFindZone {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, zone)
+	}
+}
+
+// DeleteShippingZone godoc
+// @Summary Delete a shipping zone
+// @Description Deletes a shipping zone
+// @Tags Shipping
+// @Produce json
+// @Param id path string true "Zone ID"
+// @Success 200 {string} string "Shipping zone deleted"
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/shipping/zones/{id} [delete]
+func DeleteShippingZone() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		zoneID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid zone id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err = database.DeleteShippingZone(ctx, ShippingZoneCollection, zoneID)
+		if err == database.ErrCantFindZone {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, "Shipping zone deleted")
+	}
+}
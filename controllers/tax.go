@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/tax"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var TaxRateCollection *mongo.Collection = database.OpenCollection(database.Client, "TaxRates")
+
+// TaxProvider computes the taxes of every order. It defaults to the built-in tax table and can be
+// replaced by an external tax service before the server starts.
+var TaxProvider tax.Provider = tax.NewTable(func(ctx context.Context, country string) ([]models.TaxRate, error) {
+	return database.TaxRatesForCountry(ctx, TaxRateCollection, country)
+})
+
+// AddTaxRate godoc
+// @Summary Add a tax rate
+// @Description Adds a rate to the built-in tax table
+// @Tags Tax
+// @Accept json
+// @Produce json
+// @Param rate body models.TaxRate true "Tax rate"
+// @Success 201 {object} models.TaxRate
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/tax/rates [post]
+func AddTaxRate() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var rate models.TaxRate
+		if err := gCtx.BindJSON(&rate); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(rate); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.AddTaxRate(ctx, TaxRateCollection, &rate); err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusCreated, rate)
+	}
+}
+
+// ListTaxRates godoc
+// @Summary List tax rates
+// @Description Lists the built-in tax table
+// @Tags Tax
+// @Produce json
+// @Success 200 {array} models.TaxRate
+// @Failure 500 {object} models.Error
+// @Router /admin/tax/rates [get]
+func ListTaxRates() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		rates, err := database.ListTaxRates(ctx, TaxRateCollection)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, rates)
+	}
+}
+
+// UpdateTaxRate godoc
+// @Summary Update a tax rate
+// @Description Replaces a rate of the built-in tax table
+// @Tags Tax
+// @Accept json
+// @Produce json
+// @Param id path string true "Rate ID"
+// @Param rate body models.TaxRate true "Tax rate"
+// @Success 200 {object} models.TaxRate
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/tax/rates/{id} [put]
+func UpdateTaxRate() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		rateID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid rate id"})
+			return
+		}
+
+		var rate models.TaxRate
+		if err := gCtx.BindJSON(&rate); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(rate); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err = database.UpdateTaxRate(ctx, TaxRateCollection, rateID, &rate)
+		if err == database.ErrCantFindTaxRate {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, rate)
+	}
+}
+
+// DeleteTaxRate godoc
+// @Summary Delete a tax rate
+// @Description Deletes a rate of the built-in tax table
+// @Tags Tax
+// @Produce json
+// @Param id path string true "Rate ID"
+// @Success 200 {string} string "Tax rate deleted"
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/tax/rates/{id} [delete]
+func DeleteTaxRate() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		rateID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid rate id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err = database.DeleteTaxRate(ctx, TaxRateCollection, rateID)
+		if err == database.ErrCantFindTaxRate {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		gCtx.IndentedJSON(http.StatusOK, "Tax rate deleted")
+	}
+}
@@ -0,0 +1,320 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var JobCollection *mongo.Collection = database.OpenCollection(database.Client, "Jobs")
+
+// maxImportBytes bounds the size of an uploaded import file.
+const maxImportBytes = 100 << 20
+
+// importProgressEvery is how many rows an import processes between progress updates.
+const importProgressEvery = 100
+
+// importTimeout bounds how long a single import may run.
+const importTimeout = time.Hour
+
+// transferFormat reads the format from ?format=, falling back to the extension of the file name.
+func transferFormat(gCtx *gin.Context, fileName string) string {
+	if format := strings.ToLower(gCtx.Query("format")); format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return catalog.FormatCSV
+	case ".jsonl", ".ndjson":
+		return catalog.FormatJSONL
+	}
+	return ""
+}
+
+// ImportProducts godoc
+// @Summary Bulk import products
+// @Description Uploads a CSV or JSON Lines file in the multipart field "file" and upserts its products by sku in the background. Poll the returned job for progress and the per-row errors
+// @Tags Products
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV or JSON Lines file"
+// @Param format query string false "csv or jsonl, guessed from the file extension when omitted"
+// @Success 202 {object} models.Job
+// @Failure 400,413 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/products/import [post]
+func ImportProducts() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		gCtx.Request.Body = http.MaxBytesReader(gCtx.Writer, gCtx.Request.Body, maxImportBytes+1<<20)
+		header, err := gCtx.FormFile("file")
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "the file field is required and must be at most " + fmt.Sprint(maxImportBytes) + " bytes"})
+			return
+		}
+		format := transferFormat(gCtx, header.Filename)
+
+		upload, err := header.Open()
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer upload.Close()
+
+		// the job outlives the request, so keep its own copy of the upload
+		temp, err := os.CreateTemp("", "product-import-*")
+		if err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not store the upload"})
+			return
+		}
+		if _, err := io.Copy(temp, upload); err != nil {
+			log.Println(err)
+			temp.Close()
+			os.Remove(temp.Name())
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not store the upload"})
+			return
+		}
+
+		// reject a bad format or header right away rather than in the job report
+		if _, err := temp.Seek(0, io.SeekStart); err == nil {
+			_, err = catalog.NewReader(format, temp)
+		}
+		temp.Close()
+		if err != nil {
+			os.Remove(temp.Name())
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		job := models.Job{
+			Kind:       models.JobProductImport,
+			Format:     format,
+			File_Name:  header.Filename,
+			Created_By: middleware.UserID(gCtx),
+		}
+		if err := database.CreateJob(ctx, JobCollection, &job); err != nil {
+			os.Remove(temp.Name())
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		recordAudit(ctx, gCtx, models.AuditEntry{
+			Action: models.AuditProductsImported, Target_Type: models.AuditTargetJob, Target_ID: job.Job_ID.Hex(),
+			Details: map[string]string{"file_name": header.Filename, "format": format},
+		}, nil, nil)
+		go runProductImport(job, temp.Name())
+		gCtx.IndentedJSON(http.StatusAccepted, job)
+	}
+}
+
+// runProductImport upserts the rows of an uploaded file, saving its progress on the job as it goes.
+func runProductImport(job models.Job, path string) {
+	defer os.Remove(path)
+
+	var ctx, cancel = context.WithTimeout(context.Background(), importTimeout)
+	defer cancel()
+
+	fail := func(err error) {
+		log.Println("product import", job.Job_ID.Hex(), "failed:", err)
+		database.FinishJob(context.Background(), JobCollection, job.Job_ID, err.Error())
+	}
+
+	if err := database.StartJob(ctx, JobCollection, job.Job_ID); err != nil {
+		fail(err)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer file.Close()
+	reader, err := catalog.NewReader(job.Format, file)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	var rowErrors []models.RowError
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			database.SaveJobProgress(ctx, JobCollection, &job, rowErrors)
+			fail(err)
+			return
+		}
+
+		job.Rows++
+		if row.Err == nil {
+			row.Err = Validate.Struct(row.Product)
+		}
+		if row.Err == nil {
+			var created bool
+			created, row.Err = database.UpsertProductBySku(ctx, ProductCollection, row.Product)
+			if created {
+				job.Created++
+			} else if row.Err == nil {
+				job.Updated++
+			}
+		}
+		if row.Err != nil {
+			job.Failed++
+			rowError := models.RowError{Row: row.Number, Error: row.Err.Error()}
+			if row.Product.Sku != nil {
+				rowError.Sku = *row.Product.Sku
+			}
+			rowErrors = append(rowErrors, rowError)
+		}
+
+		if job.Rows%importProgressEvery == 0 {
+			if err := database.SaveJobProgress(ctx, JobCollection, &job, rowErrors); err != nil {
+				fail(err)
+				return
+			}
+			rowErrors = nil
+		}
+	}
+
+	if err := database.SaveJobProgress(ctx, JobCollection, &job, rowErrors); err != nil {
+		fail(err)
+		return
+	}
+	database.FinishJob(ctx, JobCollection, job.Job_ID, "")
+}
+
+// ExportProducts godoc
+// @Summary Bulk export products
+// @Description Streams the whole catalog as CSV or JSON Lines, in the same format the import accepts. Options and variants are only exported as JSON Lines
+// @Tags Products
+// @Produce text/csv,application/x-ndjson
+// @Param format query string false "csv (default) or jsonl"
+// @Success 200 {file} file
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/products/export [get]
+func ExportProducts() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		format := strings.ToLower(gCtx.DefaultQuery("format", catalog.FormatCSV))
+		contentType := map[string]string{catalog.FormatCSV: "text/csv", catalog.FormatJSONL: "application/x-ndjson"}[format]
+		if contentType == "" {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": catalog.ErrUnknownFormat.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), importTimeout)
+		defer cancel()
+
+		cursor, err := database.ExportProducts(ctx, ProductCollection)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer cursor.Close(ctx)
+
+		gCtx.Header("Content-Type", contentType)
+		gCtx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="products-%s.%s"`, time.Now().Format("20060102"), format))
+		writer, err := catalog.NewWriter(format, gCtx.Writer)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		// the status is already sent, a failure can only cut the stream short
+		for rows := 1; cursor.Next(ctx); rows++ {
+			var product models.Product
+			if err := cursor.Decode(&product); err != nil {
+				log.Println(err)
+				return
+			}
+			if err := writer.Write(product); err != nil {
+				log.Println(err)
+				return
+			}
+			if rows%importProgressEvery == 0 {
+				if err := writer.Flush(); err != nil {
+					log.Println(err)
+					return
+				}
+				gCtx.Writer.Flush()
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			log.Println(err)
+		}
+		if err := writer.Flush(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// GetJob godoc
+// @Summary Get a background job
+// @Description Returns the status and counters of a background job, with the errors of the rejected rows
+// @Tags Jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} models.Job
+// @Failure 400,404 {object} models.Error
+// @Router /admin/jobs/{id} [get]
+func GetJob() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		jobID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		job, err := database.FindJob(ctx, JobCollection, jobID)
+		if err != nil {
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, job)
+	}
+}
+
+// ListJobs godoc
+// @Summary List background jobs
+// @Description Lists the latest background jobs, newest first, optionally of one kind
+// @Tags Jobs
+// @Produce json
+// @Param kind query string false "Job kind, such as product_import"
+// @Success 200 {array} models.Job
+// @Failure 500 {object} models.Error
+// @Router /admin/jobs [get]
+func ListJobs() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		jobs, err := database.ListJobs(ctx, JobCollection, gCtx.Query("kind"), maxPerPage)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, jobs)
+	}
+}
@@ -0,0 +1,334 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// userAdminErrorStatus maps the errors of managing users to a status code.
+func userAdminErrorStatus(err error) int {
+	switch err {
+	case database.ErrUserIdsNotValid:
+		return http.StatusNotFound
+	case database.ErrAccountDeleted:
+		return http.StatusConflict
+	case database.ErrResetRequestedSoon:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// notOnSelf refuses, with 409, an action an admin may not take on their own account, lest they
+// lock themselves out. It reports whether the request was refused.
+func notOnSelf(gCtx *gin.Context, action string) bool {
+	if gCtx.Param("id") != middleware.UserID(gCtx) {
+		return false
+	}
+	gCtx.JSON(http.StatusConflict, gin.H{"error": "admins can't " + action + " their own account"})
+	return true
+}
+
+// userAudit is the audit entry of an action on a user.
+func userAudit(action, userID string, details map[string]string) models.AuditEntry {
+	return models.AuditEntry{Action: action, Target_Type: models.AuditTargetUser, Target_ID: userID, Details: details}
+}
+
+// userState is what the audit trail compares of a user before and after an action: the account
+// settings, not the cart, orders and addresses that come along, nor the timestamps every
+// update changes.
+func userState(user *models.User) *models.User {
+	state := *user
+	state.UserCart, state.Order_Status, state.Address_Details = nil, nil, nil
+	state.Updated_At = time.Time{}
+	return &state
+}
+
+// ListUsers godoc
+// @Summary Search users
+// @Description Lists the users, newest first, a page at a time. ?q= matches the id exactly, or part of the email, names or phone number; ?role= and ?status= (active, disabled or deleted) narrow the list. Carts and orders come with a single user
+// @Tags Admin
+// @Produce json
+// @Param q query string false "Search text"
+// @Param role query string false "USER or ADMIN"
+// @Param status query string false "active, disabled or deleted"
+// @Param page query int false "Page, from 1"
+// @Param per_page query int false "Users per page, up to 100"
+// @Success 200 {object} models.Page
+// @Failure 400 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/users [get]
+func ListUsers() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		filter := bson.M{}
+		query := strings.TrimSpace(gCtx.Query("q"))
+		if query != "" {
+			pattern := bson.M{"$regex": regexp.QuoteMeta(query), "$options": "i"}
+			filter["$or"] = bson.A{
+				bson.M{"user_id": query},
+				bson.M{"email": pattern},
+				bson.M{"first_name": pattern},
+				bson.M{"last_name": pattern},
+				bson.M{"phone": pattern},
+			}
+		}
+		switch role := gCtx.Query("role"); role {
+		case "":
+		case models.RoleUser, models.RoleAdmin:
+			filter["role"] = role
+		default:
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "role must be " + models.RoleUser + " or " + models.RoleAdmin})
+			return
+		}
+		switch status := gCtx.Query("status"); status {
+		case "":
+		case "active":
+			filter["disabled_at"] = bson.M{"$exists": false}
+			filter["deleted_at"] = bson.M{"$exists": false}
+		case "disabled":
+			filter["disabled_at"] = bson.M{"$exists": true}
+		case "deleted":
+			filter["deleted_at"] = bson.M{"$exists": true}
+		default:
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "status must be active, disabled or deleted"})
+			return
+		}
+		page, perPage := pageQuery(gCtx)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		users, err := database.SearchUsers(ctx, UserCollection, filter, page, perPage)
+		if err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, models.AuditEntry{Action: models.AuditUsersSearched, Details: map[string]string{
+			"q": query, "role": gCtx.Query("role"), "status": gCtx.Query("status"), "page": fmt.Sprint(page),
+		}}, nil, nil)
+		gCtx.IndentedJSON(http.StatusOK, users)
+	}
+}
+
+// GetUser godoc
+// @Summary Get a user
+// @Description Returns a user with their addresses, cart and orders
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.User
+// @Failure 404 {object} models.Error
+// @Router /admin/users/{id} [get]
+func GetUser() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findUser(ctx, gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(userAdminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, userAudit(models.AuditUserViewed, user.User_ID, nil), nil, nil)
+		gCtx.IndentedJSON(http.StatusOK, user)
+	}
+}
+
+// DisableUser godoc
+// @Summary Disable an account
+// @Description Stops the user from logging in, by any means, and ends their sessions until the account is enabled again
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.User
+// @Failure 400,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/users/{id}/disable [post]
+func DisableUser() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Reason string `json:"reason" validate:"required,max=500"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if notOnSelf(gCtx, "disable") {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		previous, err := findUser(ctx, gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(userAdminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		user, err := database.DisableUser(ctx, UserCollection, previous.User_ID, body.Reason)
+		if err != nil {
+			gCtx.JSON(userAdminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, userAudit(models.AuditUserDisabled, user.User_ID, nil), userState(previous), userState(user))
+		gCtx.IndentedJSON(http.StatusOK, user)
+	}
+}
+
+// EnableUser godoc
+// @Summary Enable an account
+// @Description Lets a disabled user log in again
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.User
+// @Failure 404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/users/{id}/enable [post]
+func EnableUser() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		previous, err := findUser(ctx, gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(userAdminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		user, err := database.EnableUser(ctx, UserCollection, previous.User_ID)
+		if err != nil {
+			gCtx.JSON(userAdminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, userAudit(models.AuditUserEnabled, user.User_ID, nil), userState(previous), userState(user))
+		gCtx.IndentedJSON(http.StatusOK, user)
+	}
+}
+
+// SetUserRole godoc
+// @Summary Change the role of a user
+// @Description Grants or takes away the admin role. It applies at once, and only admins who logged in can change roles
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.User
+// @Failure 400,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/users/{id}/role [put]
+func SetUserRole() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Role string `json:"role" validate:"required,oneof=USER ADMIN"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if notOnSelf(gCtx, "change the role of") {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		previous, err := findUser(ctx, gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(userAdminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		user, err := database.SetUserRole(ctx, UserCollection, previous.User_ID, body.Role)
+		if err != nil {
+			gCtx.JSON(userAdminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, userAudit(models.AuditUserRoleChanged, user.User_ID, nil), userState(previous), userState(user))
+		gCtx.IndentedJSON(http.StatusOK, user)
+	}
+}
+
+// LogoutUser godoc
+// @Summary Log a user out everywhere
+// @Description Ends every session of the user, who must log in again
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {string} string
+// @Failure 404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/users/{id}/logout [post]
+func LogoutUser() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := database.RevokeSessions(ctx, UserCollection, gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(userAdminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(ctx, gCtx, userAudit(models.AuditUserLoggedOut, user.User_ID, nil), nil, nil)
+		gCtx.IndentedJSON(http.StatusOK, "Successfully ended every session of the user")
+	}
+}
+
+// SendUserPasswordReset godoc
+// @Summary Send a password reset to a user
+// @Description Emails the user a single use link to reset their password, as if they had asked for it, at most once a minute
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 202 {string} string
+// @Failure 404,409,429 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/users/{id}/password-reset [post]
+func SendUserPasswordReset() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := findUser(ctx, gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(userAdminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if user.Deleted_At != nil || user.Email == nil {
+			gCtx.JSON(http.StatusConflict, gin.H{"error": database.ErrAccountDeleted.Error()})
+			return
+		}
+
+		user, token, err := database.CreatePasswordReset(ctx, UserCollection, PasswordResetCollection, *user.Email, passwordResetLifetime, passwordResetCooldown)
+		if err == database.ErrResetRequestedSoon {
+			gCtx.Header("Retry-After", fmt.Sprint(int(passwordResetCooldown.Seconds())))
+		}
+		if err != nil {
+			gCtx.JSON(userAdminErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if err := sendPasswordResetEmail(ctx, user, token); err != nil {
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not send the password reset email"})
+			return
+		}
+		recordAudit(ctx, gCtx, userAudit(models.AuditUserPasswordReset, user.User_ID, nil), nil, nil)
+		gCtx.IndentedJSON(http.StatusAccepted, "Password reset email sent")
+	}
+}
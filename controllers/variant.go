@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SetProductVariants godoc
+// @Summary Replace the options and variants of a product
+// @Description Replaces the options (such as size and color) of a product and its variants, each with its own sku, price, stock, images and barcode. Send the _id of existing variants to keep them
+// @Tags Products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {object} models.Product
+// @Failure 400,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /admin/products/{id}/variants [put]
+func SetProductVariants() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+			return
+		}
+
+		var body struct {
+			Options  []models.ProductOption `json:"options" validate:"dive"`
+			Variants []models.Variant       `json:"variants" validate:"dive"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		before := productState(ctx, productID)
+		product, err := database.SetProductVariants(ctx, ProductCollection, productID, body.Options, body.Variants)
+		switch err {
+		case nil:
+			recordAudit(ctx, gCtx, productAudit(models.AuditProductVariants, productID, nil), before, product)
+			gCtx.IndentedJSON(http.StatusOK, product)
+		case database.ErrCantFindProduct:
+			gCtx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case database.ErrSkuTaken:
+			gCtx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case database.ErrCantUpdateProduct:
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		default:
+			// the variants don't match the options
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/mail"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	generate "github.com/ravelinejunior/golang_ecommerce/tokens"
+)
+
+// Mailer sends the emails of the store, see mail.FromEnv.
+var Mailer mail.Sender = mail.FromEnv()
+
+// AppBaseURL is the public address of the API, used in the links sent by email.
+var AppBaseURL = strings.TrimSuffix(envOr("APP_BASE_URL", "http://localhost:8000"), "/")
+
+// verificationResendCooldown is the minimum time between two verification emails.
+const verificationResendCooldown = time.Minute
+
+// sendVerificationEmail emails the user a link to verify their address.
+func sendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := generate.EmailTokenGenerator(user.User_ID, *user.Email)
+	if err != nil {
+		return err
+	}
+	link := AppBaseURL + "/users/verify-email?token=" + url.QueryEscape(token)
+
+	name := ""
+	if user.First_Name != nil {
+		name = " " + *user.First_Name
+	}
+	return Mailer.Send(ctx, mail.Email{
+		To:      *user.Email,
+		Subject: "Verify your email address",
+		Text: fmt.Sprintf("Hi%s,\n\nPlease confirm this is your email address by opening the link below. It expires in %d hours.\n\n%s\n\nIf you didn't create an account, you can ignore this email.\n",
+			name, int(generate.EmailTokenLifetime.Hours()), link),
+	})
+}
+
+// VerifyEmail godoc
+// @Summary Verify an email address
+// @Description Verifies the email address of an account with the token from the verification email, or moves the account to the new address it confirms
+// @Tags Auth
+// @Produce json
+// @Param token query string true "Verification token"
+// @Success 200 {string} string
+// @Failure 400,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /users/verify-email [get]
+func VerifyEmail() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		claims, msg := generate.ValidateEmailToken(gCtx.Query("token"))
+		if msg != "" {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "the verification link is invalid or has expired, ask for a new one"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err := database.MarkEmailVerified(ctx, UserCollection, claims.Uid, claims.Email)
+		if err == database.ErrVerificationStale {
+			// the link may confirm the address the user asked to move to, see ChangeEmail
+			if err = database.ConfirmEmailChange(ctx, UserCollection, claims.Uid, claims.Email); err == nil {
+				gCtx.IndentedJSON(http.StatusOK, "Successfully changed your email address, log in with the new one")
+				return
+			}
+		}
+		switch err {
+		case nil:
+			gCtx.IndentedJSON(http.StatusOK, "Successfully verified your email address")
+		case database.ErrVerificationStale, database.ErrEmailTaken:
+			gCtx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+	}
+}
+
+// ResendVerification godoc
+// @Summary Resend the verification email
+// @Description Sends a new verification link to the email address of the logged in user, at most once a minute
+// @Tags Auth
+// @Produce json
+// @Success 202 {string} string
+// @Failure 409,429 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /users/verify-email/resend [post]
+func ResendVerification() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := database.ClaimVerificationResend(ctx, UserCollection, middleware.UserID(gCtx), verificationResendCooldown)
+		switch err {
+		case nil:
+		case database.ErrAlreadyVerified:
+			gCtx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case database.ErrResendTooSoon:
+			gCtx.Header("Retry-After", fmt.Sprint(int(verificationResendCooldown.Seconds())))
+			gCtx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		default:
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := sendVerificationEmail(ctx, user); err != nil {
+			log.Println(err)
+			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "could not send the verification email"})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusAccepted, "Verification email sent")
+	}
+}
+
+// envOr reads a string from the environment
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,153 @@
+//go:build integration
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// verificationLink finds the token of the link in the email.
+var verificationLink = regexp.MustCompile(`/users/verify-email\?token=(\S+)`)
+
+// verifyRouter serves signing up, verifying and resending, and a checkout stand-in behind
+// middleware.VerifiedEmail.
+func verifyRouter() http.Handler {
+	router := gin.New()
+	router.POST("/users/signup", Signup())
+	router.GET("/users/verify-email", VerifyEmail())
+	authenticated := router.Group("", middleware.Authentication())
+	authenticated.POST("/users/verify-email/resend", ResendVerification())
+	authenticated.GET("/checkout", middleware.VerifiedEmail(), func(gCtx *gin.Context) {
+		gCtx.Status(http.StatusOK)
+	})
+	return router
+}
+
+// emailedToken returns the verification token of the latest email sent to the address.
+func emailedToken(t *testing.T, email string) string {
+	t.Helper()
+	sent, ok := capture.Last(email)
+	if !ok {
+		t.Fatalf("no email was sent to %s", email)
+	}
+	match := verificationLink.FindStringSubmatch(sent.Text)
+	if match == nil {
+		t.Fatalf("no verification link in %q", sent.Text)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// sentTo counts the emails sent to the address.
+func sentTo(email string) int {
+	count := 0
+	for _, sent := range capture.Sent() {
+		if sent.To == email {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSignupVerifyEmail(t *testing.T) {
+	router := verifyRouter()
+	email := uniqueEmail()
+	forgetUser(t, email)
+
+	body := fmt.Sprintf(`{"first_name": "Test", "last_name": "User", "email": %q, "password": "password", "phone": "%d"}`, email, time.Now().UnixNano())
+	if answer := serve(router, http.MethodPost, "/users/signup", body, nil); answer.Code != http.StatusCreated {
+		t.Fatalf("signup answered %d: %s", answer.Code, answer.Body)
+	}
+	user := storedUser(t, email)
+	if user.Email_Verified {
+		t.Fatal("the email is verified before the link was opened")
+	}
+	if answer := serve(router, http.MethodGet, "/checkout", "", bearer(t, user)); answer.Code != http.StatusForbidden {
+		t.Errorf("checkout before verifying answered %d, want %d", answer.Code, http.StatusForbidden)
+	}
+
+	token := emailedToken(t, email)
+	if answer := serve(router, http.MethodGet, "/users/verify-email?token="+url.QueryEscape(token+"x"), "", nil); answer.Code != http.StatusBadRequest {
+		t.Errorf("verifying with a forged token answered %d, want %d", answer.Code, http.StatusBadRequest)
+	}
+	if answer := serve(router, http.MethodGet, "/users/verify-email?token="+url.QueryEscape(token), "", nil); answer.Code != http.StatusOK {
+		t.Fatalf("verifying answered %d: %s", answer.Code, answer.Body)
+	}
+	if !storedUser(t, email).Email_Verified {
+		t.Error("the email isn't verified after the link was opened")
+	}
+	if answer := serve(router, http.MethodGet, "/checkout", "", bearer(t, user)); answer.Code != http.StatusOK {
+		t.Errorf("checkout after verifying answered %d, want %d", answer.Code, http.StatusOK)
+	}
+}
+
+// A link stops working once the account moved to another address.
+func TestVerifyEmailStale(t *testing.T) {
+	router := verifyRouter()
+	email := uniqueEmail()
+	user := createUser(t, email, false)
+	if err := sendVerificationEmail(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	token := emailedToken(t, email)
+
+	moved := uniqueEmail()
+	forgetUser(t, moved)
+	if _, err := UserCollection.UpdateOne(context.Background(), bson.M{"user_id": user.User_ID}, bson.M{"$set": bson.M{"email": moved}}); err != nil {
+		t.Fatal(err)
+	}
+	if answer := serve(router, http.MethodGet, "/users/verify-email?token="+url.QueryEscape(token), "", nil); answer.Code != http.StatusConflict {
+		t.Errorf("verifying the old address answered %d, want %d", answer.Code, http.StatusConflict)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	router := verifyRouter()
+	email := uniqueEmail()
+	user := createUser(t, email, false)
+	header := bearer(t, user)
+
+	// no email was sent yet, so the first one goes at once
+	if answer := serve(router, http.MethodPost, "/users/verify-email/resend", "", header); answer.Code != http.StatusAccepted {
+		t.Fatalf("resending answered %d: %s", answer.Code, answer.Body)
+	}
+	emailedToken(t, email)
+	sent := sentTo(email)
+
+	answer := serve(router, http.MethodPost, "/users/verify-email/resend", "", header)
+	if answer.Code != http.StatusTooManyRequests || answer.Header().Get("Retry-After") == "" {
+		t.Errorf("resending again at once answered %d with Retry-After %q, want %d with a Retry-After", answer.Code, answer.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+	if sentTo(email) != sent {
+		t.Error("an email was sent during the cooldown")
+	}
+
+	// once the cooldown is over
+	earlier := time.Now().Add(-2 * verificationResendCooldown)
+	if _, err := UserCollection.UpdateOne(context.Background(), bson.M{"user_id": user.User_ID}, bson.M{"$set": bson.M{"verification_sent_at": earlier}}); err != nil {
+		t.Fatal(err)
+	}
+	if answer := serve(router, http.MethodPost, "/users/verify-email/resend", "", header); answer.Code != http.StatusAccepted {
+		t.Fatalf("resending after the cooldown answered %d: %s", answer.Code, answer.Body)
+	}
+	if answer := serve(router, http.MethodGet, "/users/verify-email?token="+url.QueryEscape(emailedToken(t, email)), "", nil); answer.Code != http.StatusOK {
+		t.Fatalf("verifying with the resent link answered %d: %s", answer.Code, answer.Body)
+	}
+
+	if answer := serve(router, http.MethodPost, "/users/verify-email/resend", "", header); answer.Code != http.StatusConflict {
+		t.Errorf("resending once verified answered %d, want %d", answer.Code, http.StatusConflict)
+	}
+}
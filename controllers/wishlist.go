@@ -0,0 +1,411 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var WishlistCollection *mongo.Collection = database.OpenCollection(database.Client, "Wishlists")
+
+// wishlistErrorStatus maps the errors of the wishlist store to an http status
+func wishlistErrorStatus(err error) int {
+	switch err {
+	case database.ErrCantFindWishlist, database.ErrCantFindWishlistItem, database.ErrCantFindProduct, database.ErrNotInCart:
+		return http.StatusNotFound
+	case database.ErrWishlistNameTaken:
+		return http.StatusConflict
+	case catalog.ErrVariantRequired, catalog.ErrUnknownVariant:
+		return http.StatusBadRequest
+	case catalog.ErrOutOfStock:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// wishlistItemBody names a product, and optionally one of its variants, in a request body
+type wishlistItemBody struct {
+	Product_ID primitive.ObjectID  `json:"product_id" validate:"required"`
+	Variant_ID *primitive.ObjectID `json:"variant_id"`
+}
+
+// wishlistParam reads the wishlist id from the path, answering 400 when it is invalid
+func wishlistParam(gCtx *gin.Context) (primitive.ObjectID, bool) {
+	wishlistID, err := primitive.ObjectIDFromHex(gCtx.Param("id"))
+	if err != nil {
+		gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid wishlist id"})
+		return wishlistID, false
+	}
+	return wishlistID, true
+}
+
+// respondWishlist answers with the wishlist, its items flagged with their current price and stock
+func respondWishlist(ctx context.Context, gCtx *gin.Context, status int, wishlist *models.Wishlist) {
+	if err := database.FlagWishlist(ctx, ProductCollection, wishlist); err != nil {
+		gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	gCtx.IndentedJSON(status, wishlist)
+}
+
+// CreateWishlist godoc
+// @Summary Create a wishlist
+// @Description Creates an empty, named wishlist for the user
+// @Tags Wishlists
+// @Accept json
+// @Produce json
+// @Param wishlist body models.Wishlist true "Name of the list"
+// @Success 201 {object} models.Wishlist
+// @Failure 400,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /wishlists [post]
+func CreateWishlist() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var body struct {
+			Name string `json:"name" validate:"required,max=100"`
+		}
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.CreateWishlist(ctx, WishlistCollection, middleware.UserID(gCtx), body.Name)
+		if err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusCreated, wishlist)
+	}
+}
+
+// ListWishlists godoc
+// @Summary List the wishlists of the user
+// @Description Lists the wishlists of the user with their items, flagged when they are sold out, unavailable or changed price
+// @Tags Wishlists
+// @Produce json
+// @Success 200 {array} models.Wishlist
+// @Failure 500 {object} models.Error
+// @Router /wishlists [get]
+func ListWishlists() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wishlists, err := database.ListWishlists(ctx, WishlistCollection, middleware.UserID(gCtx))
+		if err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		for i := range wishlists {
+			if err := database.FlagWishlist(ctx, ProductCollection, &wishlists[i]); err != nil {
+				gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		gCtx.IndentedJSON(http.StatusOK, wishlists)
+	}
+}
+
+// GetWishlist godoc
+// @Summary Get a wishlist
+// @Description Returns a wishlist of the user, its items flagged when they are sold out, unavailable or changed price
+// @Tags Wishlists
+// @Produce json
+// @Param id path string true "Wishlist ID"
+// @Success 200 {object} models.Wishlist
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /wishlists/{id} [get]
+func GetWishlist() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		wishlistID, ok := wishlistParam(gCtx)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.FindWishlist(ctx, WishlistCollection, middleware.UserID(gCtx), wishlistID)
+		if err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		respondWishlist(ctx, gCtx, http.StatusOK, wishlist)
+	}
+}
+
+// DeleteWishlist godoc
+// @Summary Delete a wishlist
+// @Description Deletes a wishlist of the user with its items
+// @Tags Wishlists
+// @Produce json
+// @Param id path string true "Wishlist ID"
+// @Success 200 {string} string
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /wishlists/{id} [delete]
+func DeleteWishlist() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		wishlistID, ok := wishlistParam(gCtx)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.DeleteWishlist(ctx, WishlistCollection, middleware.UserID(gCtx), wishlistID); err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, "Successfully deleted the wishlist")
+	}
+}
+
+// AddWishlistItem godoc
+// @Summary Save a product on a wishlist
+// @Description Saves a product, or a variant of it with variant_id, on a wishlist along with its current price. Sold out products can be saved too
+// @Tags Wishlists
+// @Accept json
+// @Produce json
+// @Param id path string true "Wishlist ID"
+// @Success 200 {object} models.Wishlist
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /wishlists/{id}/items [post]
+func AddWishlistItem() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		wishlistID, ok := wishlistParam(gCtx)
+		if !ok {
+			return
+		}
+
+		var body wishlistItemBody
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.AddWishlistItem(ctx, ProductCollection, WishlistCollection, middleware.UserID(gCtx), wishlistID, body.Product_ID, body.Variant_ID)
+		if err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		respondWishlist(ctx, gCtx, http.StatusOK, wishlist)
+	}
+}
+
+// RemoveWishlistItem godoc
+// @Summary Remove an item from a wishlist
+// @Description Takes an item off a wishlist of the user
+// @Tags Wishlists
+// @Produce json
+// @Param id path string true "Wishlist ID"
+// @Param item_id path string true "Item ID"
+// @Success 200 {string} string
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /wishlists/{id}/items/{item_id} [delete]
+func RemoveWishlistItem() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		wishlistID, ok := wishlistParam(gCtx)
+		if !ok {
+			return
+		}
+		itemID, err := primitive.ObjectIDFromHex(gCtx.Param("item_id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.RemoveWishlistItem(ctx, WishlistCollection, middleware.UserID(gCtx), wishlistID, itemID); err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, "Successfully removed the item from the wishlist")
+	}
+}
+
+// MoveCartItemToWishlist godoc
+// @Summary Save a cart item for later
+// @Description Moves a product, or a variant of it with variant_id, out of the cart and onto a wishlist
+// @Tags Wishlists
+// @Accept json
+// @Produce json
+// @Param id path string true "Wishlist ID"
+// @Success 200 {object} models.Wishlist
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /wishlists/{id}/items/from-cart [post]
+func MoveCartItemToWishlist() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		wishlistID, ok := wishlistParam(gCtx)
+		if !ok {
+			return
+		}
+
+		var body wishlistItemBody
+		if err := gCtx.BindJSON(&body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.MoveCartItemToWishlist(ctx, ProductCollection, UserCollection, WishlistCollection, middleware.UserID(gCtx), wishlistID, body.Product_ID, body.Variant_ID)
+		if err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		respondWishlist(ctx, gCtx, http.StatusOK, wishlist)
+	}
+}
+
+// MoveWishlistItemToCart godoc
+// @Summary Move a wishlist item to the cart
+// @Description Puts one unit of a saved item in the cart and takes it off the wishlist. Sold out items stay on the list
+// @Tags Wishlists
+// @Produce json
+// @Param id path string true "Wishlist ID"
+// @Param item_id path string true "Item ID"
+// @Success 200 {string} string
+// @Failure 400,404,409 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /wishlists/{id}/items/{item_id}/move-to-cart [post]
+func MoveWishlistItemToCart() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		wishlistID, ok := wishlistParam(gCtx)
+		if !ok {
+			return
+		}
+		itemID, err := primitive.ObjectIDFromHex(gCtx.Param("item_id"))
+		if err != nil {
+			gCtx.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err = database.MoveWishlistItemToCart(ctx, ProductCollection, UserCollection, WishlistCollection, middleware.UserID(gCtx), wishlistID, itemID)
+		if err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, "Successfully moved the item to the cart")
+	}
+}
+
+// ShareWishlist godoc
+// @Summary Share a wishlist
+// @Description Gives a wishlist a share token. Anyone with the token can view the list read-only at /wishlists/shared/{token}
+// @Tags Wishlists
+// @Produce json
+// @Param id path string true "Wishlist ID"
+// @Success 200 {object} models.Wishlist
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /wishlists/{id}/share [post]
+func ShareWishlist() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		wishlistID, ok := wishlistParam(gCtx)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.ShareWishlist(ctx, WishlistCollection, middleware.UserID(gCtx), wishlistID)
+		if err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		respondWishlist(ctx, gCtx, http.StatusOK, wishlist)
+	}
+}
+
+// UnshareWishlist godoc
+// @Summary Stop sharing a wishlist
+// @Description Revokes the share token of a wishlist so the links handed out stop working
+// @Tags Wishlists
+// @Produce json
+// @Param id path string true "Wishlist ID"
+// @Success 200 {string} string
+// @Failure 400,404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /wishlists/{id}/share [delete]
+func UnshareWishlist() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		wishlistID, ok := wishlistParam(gCtx)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := database.UnshareWishlist(ctx, WishlistCollection, middleware.UserID(gCtx), wishlistID); err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		gCtx.IndentedJSON(http.StatusOK, "Successfully stopped sharing the wishlist")
+	}
+}
+
+// SharedWishlist godoc
+// @Summary View a shared wishlist
+// @Description Returns a wishlist shared by its owner, without the owner or the token
+// @Tags Wishlists
+// @Produce json
+// @Param token path string true "Share token"
+// @Success 200 {object} models.Wishlist
+// @Failure 404 {object} models.Error
+// @Failure 500 {object} models.Error
+// @Router /wishlists/shared/{token} [get]
+func SharedWishlist() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		wishlist, err := database.FindSharedWishlist(ctx, WishlistCollection, gCtx.Param("token"))
+		if err != nil {
+			gCtx.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		wishlist.User_ID = ""
+		wishlist.Share_Token = nil
+		respondWishlist(ctx, gCtx, http.StatusOK, wishlist)
+	}
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindCarts     = errors.New("can't find the abandoned carts")
+	ErrCantSaveReminder  = errors.New("can't save the cart reminder")
+	ErrCantExpireCarts   = errors.New("can't expire the stale carts")
+	ErrCantComputeReport = errors.New("can't compute the cart recovery report")
+)
+
+// EnsureCartReminderIndexes creates the indexes serving the recovery tracking and report.
+func EnsureCartReminderIndexes(ctx context.Context, userCollection, reminderCollection *mongo.Collection) error {
+	_, err := reminderCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "sent_at", Value: -1}}},
+		{Keys: bson.D{{Key: "sent_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = userCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "cart_updated_at", Value: 1}},
+	})
+	return err
+}
+
+// StampCartActivity dates the carts filled before cart activity was tracked, so they are
+// reminded about and expired like the others.
+func StampCartActivity(ctx context.Context, userCollection *mongo.Collection) error {
+	filter := bson.M{"usercart.0": bson.M{"$exists": true}, "cart_updated_at": bson.M{"$exists": false}}
+	if _, err := userCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"cart_updated_at": time.Now()}}); err != nil {
+		log.Println(err)
+		return ErrCantFindCarts
+	}
+	return nil
+}
+
+// FindAbandonedCarts returns up to limit users whose cart has been idle since idleSince and who
+// weren't reminded about it yet.
+func FindAbandonedCarts(ctx context.Context, userCollection *mongo.Collection, idleSince time.Time, limit int64) ([]models.User, error) {
+	filter := bson.M{
+		"usercart.0":       bson.M{"$exists": true},
+		"cart_updated_at":  bson.M{"$lte": idleSince},
+		"cart_reminded_at": bson.M{"$exists": false},
+	}
+	opts := options.Find().SetSort(bson.M{"cart_updated_at": 1}).SetLimit(limit)
+	cursor, err := userCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindCarts
+	}
+	users := make([]models.User, 0)
+	if err := cursor.All(ctx, &users); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindCarts
+	}
+	return users, nil
+}
+
+// ClaimCartReminder marks the cart of a user as reminded, unless it changed or another server
+// already claimed it. It reports whether the claim succeeded.
+func ClaimCartReminder(ctx context.Context, userCollection *mongo.Collection, user *models.User) (bool, error) {
+	filter := bson.M{"_id": user.ID, "cart_updated_at": user.Cart_Updated_At, "cart_reminded_at": bson.M{"$exists": false}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"cart_reminded_at": time.Now()}})
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantSaveReminder
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// UnclaimCartReminder lets the next sweep retry a reminder that couldn't be sent.
+func UnclaimCartReminder(ctx context.Context, userCollection *mongo.Collection, user *models.User) error {
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$unset": bson.M{"cart_reminded_at": ""}}); err != nil {
+		log.Println(err)
+		return ErrCantSaveReminder
+	}
+	return nil
+}
+
+// RecordCartReminder stores a reminder that was sent.
+func RecordCartReminder(ctx context.Context, reminderCollection *mongo.Collection, reminder *models.CartReminder) error {
+	reminder.Reminder_ID = primitive.NewObjectID()
+	if _, err := reminderCollection.InsertOne(ctx, reminder); err != nil {
+		log.Println(err)
+		return ErrCantSaveReminder
+	}
+	return nil
+}
+
+// ExpireStaleCarts empties the carts idle since before the given time and returns how many were emptied.
+func ExpireStaleCarts(ctx context.Context, userCollection *mongo.Collection, idleSince time.Time) (int64, error) {
+	filter := bson.M{"usercart.0": bson.M{"$exists": true}, "cart_updated_at": bson.M{"$lte": idleSince}}
+	update := bson.M{
+		"$set":   bson.M{"usercart": make([]models.ProductUser, 0)},
+		"$unset": bson.M{"cart_updated_at": "", "cart_reminded_at": ""},
+	}
+	result, err := userCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantExpireCarts
+	}
+	return result.ModifiedCount, nil
+}
+
+// TrackCartRecovery credits an order to the latest reminder the user got within the window, if any.
+func TrackCartRecovery(ctx context.Context, reminderCollection *mongo.Collection, userID string, order *models.Order, window time.Duration) error {
+	filter := bson.M{"user_id": userID, "recovered": false, "sent_at": bson.M{"$gte": order.Ordered_At.Add(-window)}}
+	update := bson.M{"$set": bson.M{
+		"recovered":    true,
+		"recovered_at": order.Ordered_At,
+		"order_id":     order.Order_ID,
+		"order_total":  order.Price,
+	}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"sent_at": -1})
+	err := reminderCollection.FindOneAndUpdate(ctx, filter, update, opts).Err()
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return ErrCantSaveReminder
+	}
+	return nil
+}
+
+// CartRecoveryStats sums up the reminders sent since the given time.
+func CartRecoveryStats(ctx context.Context, reminderCollection *mongo.Collection, since time.Time) (*models.RecoveryStats, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"sent_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       nil,
+			"sent":      bson.M{"$sum": 1},
+			"recovered": bson.M{"$sum": bson.M{"$cond": bson.A{"$recovered", 1, 0}}},
+			"revenue":   bson.M{"$sum": "$order_total"},
+		}}},
+	}
+	cursor, err := reminderCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantComputeReport
+	}
+	var totals []struct {
+		Sent      int `bson:"sent"`
+		Recovered int `bson:"recovered"`
+		Revenue   int `bson:"revenue"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		log.Println(err)
+		return nil, ErrCantComputeReport
+	}
+
+	stats := &models.RecoveryStats{Since: since}
+	if len(totals) > 0 {
+		stats.Sent = totals[0].Sent
+		stats.Recovered = totals[0].Recovered
+		stats.Recovered_Revenue = totals[0].Revenue
+	}
+	if stats.Sent > 0 {
+		stats.Recovery_Rate = float64(stats.Recovered) / float64(stats.Sent)
+	}
+	return stats, nil
+}
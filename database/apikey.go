@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindAPIKey   = errors.New("can't find the API key")
+	ErrInvalidAPIKey    = errors.New("the API key is invalid, revoked or expired")
+	ErrCantCreateAPIKey = errors.New("can't create the API key")
+	ErrCantListAPIKeys  = errors.New("can't list the API keys")
+)
+
+// APIKeyPrefix starts every API key, so that leaked keys are easy to search for.
+const APIKeyPrefix = "ek_"
+
+// apiKeyTouchInterval is the least time between two updates of the last use of a key, so that
+// busy integrations don't write on every request.
+const apiKeyTouchInterval = time.Minute
+
+// EnsureAPIKeyIndexes creates the indexes finding a key by its hash and the keys of a user.
+func EnsureAPIKeyIndexes(ctx context.Context, apiKeyCollection *mongo.Collection) error {
+	_, err := apiKeyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// CreateAPIKey creates a key for the user and returns it with the secret key, which is only
+// known at this point.
+func CreateAPIKey(ctx context.Context, apiKeyCollection *mongo.Collection, userID, name string, scopes []string, expiresAt *time.Time) (*models.APIKey, string, error) {
+	token, err := randomToken()
+	if err != nil {
+		log.Println(err)
+		return nil, "", ErrCantCreateAPIKey
+	}
+	secret := APIKeyPrefix + token
+	key := models.APIKey{
+		Key_ID:     primitive.NewObjectID(),
+		User_ID:    userID,
+		Name:       name,
+		Prefix:     secret[:len(APIKeyPrefix)+8],
+		Key_Hash:   HashToken(secret),
+		Scopes:     scopes,
+		Created_At: time.Now(),
+		Expires_At: expiresAt,
+	}
+	if _, err := apiKeyCollection.InsertOne(ctx, key); err != nil {
+		log.Println(err)
+		return nil, "", ErrCantCreateAPIKey
+	}
+	return &key, secret, nil
+}
+
+// ListAPIKeys returns the keys of the user, or of every user when userID is empty, newest first.
+func ListAPIKeys(ctx context.Context, apiKeyCollection *mongo.Collection, userID string) ([]models.APIKey, error) {
+	filter := bson.M{}
+	if userID != "" {
+		filter["user_id"] = userID
+	}
+	cursor, err := apiKeyCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListAPIKeys
+	}
+	keys := make([]models.APIKey, 0)
+	if err := cursor.All(ctx, &keys); err != nil {
+		log.Println(err)
+		return nil, ErrCantListAPIKeys
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey stops the key from working. Revoking a revoked key keeps the first revocation.
+func RevokeAPIKey(ctx context.Context, apiKeyCollection *mongo.Collection, keyID primitive.ObjectID) (*models.APIKey, error) {
+	now := time.Now()
+	filter := bson.M{"_id": keyID, "revoked_at": bson.M{"$exists": false}}
+	var key models.APIKey
+	err := apiKeyCollection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"revoked_at": now}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		if err := apiKeyCollection.FindOne(ctx, bson.M{"_id": keyID}).Decode(&key); err != nil {
+			return nil, ErrCantFindAPIKey
+		}
+		return &key, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindAPIKey
+	}
+	return &key, nil
+}
+
+// UseAPIKey returns the key matching secret while it is neither revoked nor expired, and
+// records that it was used from ip.
+func UseAPIKey(ctx context.Context, apiKeyCollection *mongo.Collection, secret, ip string) (*models.APIKey, error) {
+	now := time.Now()
+	filter := bson.M{
+		"key_hash":   HashToken(secret),
+		"revoked_at": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$exists": false}},
+			bson.M{"expires_at": bson.M{"$gt": now}},
+		},
+	}
+	var key models.APIKey
+	err := apiKeyCollection.FindOne(ctx, filter).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInvalidAPIKey
+	}
+
+	if key.Last_Used_At == nil || now.Sub(*key.Last_Used_At) >= apiKeyTouchInterval {
+		update := bson.M{"$set": bson.M{"last_used_at": now, "last_used_ip": ip}}
+		if _, err := apiKeyCollection.UpdateOne(ctx, bson.M{"_id": key.Key_ID}, update); err != nil {
+			log.Println(err)
+		}
+	}
+	return &key, nil
+}
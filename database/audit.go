@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/audit"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantRecordAudit = errors.New("can't record the action in the audit trail")
+	ErrCantFindAudit   = errors.New("can't find the audit entries")
+)
+
+// auditAttempts is how many times RecordAudit tries to append an entry while other entries are
+// appended at the same time.
+const auditAttempts = 10
+
+// chained matches the entries of the hash chain, leaving out those recorded before it.
+var chained = bson.M{"seq": bson.M{"$exists": true}}
+
+// EnsureAuditIndexes creates the indexes finding the entries about a target, those of an actor
+// and those of a request, and the one keeping the chain from forking: no two entries may share
+// a sequence number.
+func EnsureAuditIndexes(ctx context.Context, auditCollection *mongo.Collection) error {
+	_, err := auditCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(chained)},
+		{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "request_id", Value: 1}}},
+	})
+	return err
+}
+
+// RecordAudit appends the entry to the audit trail, after the last one. When another entry takes
+// its place first the unique sequence number refuses it, and it's chained again after that one.
+// Entries are never updated nor deleted.
+func RecordAudit(ctx context.Context, auditCollection *mongo.Collection, entry *models.AuditEntry) error {
+	for attempt := 0; attempt < auditAttempts; attempt++ {
+		var last models.AuditEntry
+		previous := &last
+		err := auditCollection.FindOne(ctx, chained, options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&last)
+		if err == mongo.ErrNoDocuments {
+			previous = nil
+		} else if err != nil {
+			log.Println(err)
+			return ErrCantRecordAudit
+		}
+
+		entry.Audit_ID = primitive.NewObjectID()
+		entry.Created_At = time.Now().UTC()
+		audit.Chain(entry, previous)
+		_, err = auditCollection.InsertOne(ctx, entry)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			log.Println(err)
+			return ErrCantRecordAudit
+		}
+	}
+	log.Println("gave up appending to the audit trail after", auditAttempts, "attempts")
+	return ErrCantRecordAudit
+}
+
+// SearchAudit returns a page of the audit entries matching the filter, newest first.
+func SearchAudit(ctx context.Context, auditCollection *mongo.Collection, filter bson.M, page, perPage int64) (*models.Page, error) {
+	total, err := auditCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindAudit
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "seq", Value: -1}}).
+		SetSkip((page - 1) * perPage).SetLimit(perPage)
+	cursor, err := auditCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindAudit
+	}
+	defer cursor.Close(ctx)
+
+	entries := make([]models.AuditEntry, 0)
+	if err = cursor.All(ctx, &entries); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindAudit
+	}
+	return &models.Page{Items: entries, Page: page, Per_Page: perPage, Total: total}, nil
+}
+
+// VerifyAuditChain walks the audit trail from its first entry and reports the first one that was
+// changed, or that doesn't follow the one before it, because entries were removed or inserted.
+// Entries removed from the end can't be told apart from entries never written.
+func VerifyAuditChain(ctx context.Context, auditCollection *mongo.Collection) (*models.AuditVerification, error) {
+	cursor, err := auditCollection.Find(ctx, chained, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindAudit
+	}
+	defer cursor.Close(ctx)
+
+	result := models.AuditVerification{Intact: true}
+	var previous *models.AuditEntry
+	for cursor.Next(ctx) {
+		var entry models.AuditEntry
+		if err := cursor.Decode(&entry); err != nil {
+			log.Println(err)
+			return nil, ErrCantFindAudit
+		}
+		result.Checked++
+		if reason := audit.Check(&entry, previous); reason != "" {
+			seq := entry.Seq
+			result.Intact, result.Broken_At, result.Reason = false, &seq, reason
+			return &result, nil
+		}
+		previous = &entry
+	}
+	if err := cursor.Err(); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindAudit
+	}
+	return &result, nil
+}
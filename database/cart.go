@@ -6,7 +6,10 @@ import (
 	"log"
 	"time"
 
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
 	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/shipping"
+	"github.com/ravelinejunior/golang_ecommerce/tax"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -20,23 +23,32 @@ var (
 	ErrCantRemoveCartItem = errors.New("can't remove this item from the cart")
 	ErrCantGetItem        = errors.New("unable to get item from the cart")
 	ErrCantBuyCartItem    = errors.New("can't update the purchase")
+	ErrCartEmpty          = errors.New("the cart is empty")
+	ErrCantCalculateTax   = errors.New("can't calculate the taxes of the order")
+	ErrCartBusy           = errors.New("the cart keeps changing, check out again")
 )
 
-// AddProductToCart adds a product to the cart of a user
-func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+// errCartChanged tells BuyItemFromCart the cart changed while the order was being placed.
+var errCartChanged = errors.New("the cart changed during the checkout")
+
+// cartCheckoutAttempts is how many times BuyItemFromCart reads the cart again when it changes
+// while the order is being placed.
+const cartCheckoutAttempts = 3
+
+// AddProductToCart adds a product to the cart of a user. Products with options are added as the given variant.
+func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, variantID *primitive.ObjectID, userID string) error {
 	// search the product collection for the given product id
-	searchFromDB, err := prodCollection.Find(ctx, bson.M{"_id": productID})
+	var product models.Product
+	err := prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
 	if err != nil {
 		log.Println(err)
 		return ErrCantFindProduct
 	}
 
-	// decode the products into a slice of ProductUser structs
-	var productCart []models.ProductUser
-	err = searchFromDB.All(ctx, &productCart)
+	// build the cart entry from the product or its variant
+	item, err := catalog.CartItem(&product, variantID)
 	if err != nil {
-		log.Println(err)
-		return ErrCantDecodeProducts
+		return err
 	}
 
 	// convert the user id to a primitive.ObjectID
@@ -49,10 +61,14 @@ func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo
 	// create a filter to search for the given user id
 	filter := bson.D{primitive.E{Key: "_id", Value: id}}
 
-	// create an update to add the products to the user's cart
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: bson.D{{Key: "$each", Value: productCart}}}}}}
+	// create an update to add the product to the user's cart, dating the cart activity
+	update := bson.D{
+		{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: item}}},
+		{Key: "$set", Value: bson.D{primitive.E{Key: "cart_updated_at", Value: time.Now()}}},
+		{Key: "$unset", Value: bson.D{primitive.E{Key: "cart_reminded_at", Value: ""}}},
+	}
 
-	// update the user document with the new cart items
+	// update the user document with the new cart item
 	_, err = userCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return ErrCantUpdateUser
@@ -60,8 +76,8 @@ func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo
 	return nil
 }
 
-// RemoveCartItem removes an item from the cart of a user
-func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+// RemoveCartItem removes an item from the cart of a user. When variantID is set only that variant of the product is removed.
+func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, variantID *primitive.ObjectID, userID string) error {
 	// convert the user id to a primitive.ObjectID
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
@@ -74,10 +90,19 @@ func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.C
 	filter := bson.D{primitive.E{Key: "_id", Value: id}}
 
 	// create an update to remove the given product id from the user's cart
-	update := bson.M{"$pull": bson.M{"usercart": bson.M{"_id": productID}}}
+	item := bson.M{"_id": productID}
+	if variantID != nil {
+		item["variant_id"] = *variantID
+	}
+	update := bson.M{"$pull": bson.M{"usercart": item}}
 
-	// update the user document with the new cart items
-	_, err = userCollection.UpdateMany(ctx, filter, update)
+	// update the user document with the new cart items, dating the cart activity
+	userUpdate := bson.M{
+		"$pull":  bson.M{"usercart": item},
+		"$set":   bson.M{"cart_updated_at": time.Now()},
+		"$unset": bson.M{"cart_reminded_at": ""},
+	}
+	_, err = userCollection.UpdateMany(ctx, filter, userUpdate)
 	if err != nil {
 		// log the error and return that the cart item could not be removed
 		log.Println(err)
@@ -94,194 +119,204 @@ func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.C
 	return nil
 }
 
-// BuyItemFromCart fetches the cart of the user, finds the cart total, creates an order with the items, adds the order to the user collection, adds the items in the cart to the order list, and empties the cart.
-func BuyItemFromCart(ctx context.Context, userCollection *mongo.Collection, userID string) error {
+// CheckoutOptions carries the choices a shopper makes when placing an order.
+type CheckoutOptions struct {
+	// ShippingMethod is the code of the shipping method, standard by default.
+	ShippingMethod string
+	// AddressIndex picks the shipping address, 0 for home and 1 for work.
+	AddressIndex int
+	// Acknowledgement confirms the shopper saw the cart issues it fingerprints.
+	Acknowledgement string
+	// Coupon is a coupon already claimed for this order, if any.
+	Coupon *models.Coupon
+}
+
+// BuyItemFromCart fetches the cart of the user, prices it together with the chosen shipping method and its taxes, adds the order to the user's orders and empties the cart.
+// When the cart changes meanwhile the checkout starts over with the new cart, so that nothing added to it is lost.
+func BuyItemFromCart(ctx context.Context, prodCollection, userCollection, zoneCollection *mongo.Collection, taxes tax.Provider, userID string, checkout CheckoutOptions) (*models.Order, error) {
 	// Convert the user ID to a primitive.ObjectID.
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
-		return ErrUserIdsNotValid
+		return nil, ErrUserIdsNotValid
 	}
 
-	// Initialize variables to hold the retrieved cart items and the order.
-	var getCartItems models.User
-	var orderCart models.Order
-
-	// Set the order ID, ordered at time, and initialize the order cart.
-	orderCart.Order_ID = primitive.NewObjectID()
-	orderCart.Ordered_At = time.Now()
-	orderCart.Order_Cart = make([]models.ProductUser, 0)
-	orderCart.Payment_Method.COD = true
-
-	// Unwind the user cart and group by the user ID to find the cart total.
-	unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-	grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
+	for attempt := 0; attempt < cartCheckoutAttempts; attempt++ {
+		order, err := buyCart(ctx, prodCollection, userCollection, zoneCollection, taxes, id, checkout)
+		if err != errCartChanged {
+			return order, err
+		}
+	}
+	return nil, ErrCartBusy
+}
 
-	// Run the aggregation pipeline to retrieve the cart items and total.
-	currentResults, err := userCollection.Aggregate(ctx, mongo.Pipeline{unwind, grouping})
-	ctx.Done()
+// buyCart places the order of the cart as it is now. It fails with errCartChanged when the cart
+// changed before the order was added, releasing the stock it took.
+func buyCart(ctx context.Context, prodCollection, userCollection, zoneCollection *mongo.Collection, taxes tax.Provider, id primitive.ObjectID, checkout CheckoutOptions) (*models.Order, error) {
+	// Find the user document holding the cart.
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&user)
 	if err != nil {
-		panic(err)
+		log.Println(err)
+		return nil, ErrCantGetItem
 	}
 
-	// Initialize a slice to hold the retrieved cart items.
-	var getUserCart []bson.M
-	if err = currentResults.All(ctx, &getUserCart); err != nil {
-		panic(err)
+	if len(user.UserCart) == 0 {
+		return nil, ErrCartEmpty
 	}
 
-	// Initialize a variable to hold the total cart price.
-	var totalPrice int32
-
-	// Iterate through the retrieved cart items to find the total price.
-	for _, userItem := range getUserCart {
-		price := userItem["total"]
-		totalPrice = price.(int32)
-	}
-
-	// Set the order price to the total cart price.
-	orderCart.Price = int(totalPrice)
-
-	// Create a filter to search for the given user ID.
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-
-	// Create an update to add the order to the user's orders.
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orderCart}}}}
-
-	// Update the user document with the new order.
-	_, err = userCollection.UpdateMany(ctx, filter, update)
+	// Charge today's prices, once the shopper acknowledged what changed since the items were added.
+	review, err := RevalidateCart(ctx, prodCollection, user.UserCart)
 	if err != nil {
-		log.Println(err)
+		return nil, err
+	}
+	if len(review.Issues) > 0 && review.Acknowledgement != checkout.Acknowledgement {
+		return nil, &CartChangedError{Review: review}
+	}
+	if len(review.Items) == 0 {
+		return nil, ErrCartEmpty
 	}
 
-	// Find the user document with the given user ID.
-	userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getCartItems)
+	// Build the order from the cart items and the shipping quote.
+	order, err := newOrder(ctx, zoneCollection, taxes, &user, review.Items, checkout)
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
 
-	// Create a filter to search for the given user ID again.
-	secondFilter := bson.D{primitive.E{Key: "_id", Value: id}}
-
-	// Create an update to add the cart items to the order list.
-	secondUpdate := bson.M{"$push": bson.M{"orders.$[].order_list": bson.M{"$each": getCartItems.UserCart}}}
+	// Take the ordered units out of stock.
+	if err = ReserveStock(ctx, prodCollection, order.Order_Cart); err != nil {
+		return nil, err
+	}
 
-	// Update the user document with the new order list.
-	_, err = userCollection.UpdateOne(ctx, secondFilter, secondUpdate)
+	// Add the order and empty the cart in a single update, as long as the cart is the one the
+	// order was built from: every change to the cart dates it, see AddProductToCart.
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "cart_updated_at", Value: user.Cart_Updated_At}}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: order}}},
+		{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: make([]models.ProductUser, 0)}}},
+		{Key: "$unset", Value: bson.D{{Key: "cart_updated_at", Value: ""}, {Key: "cart_reminded_at", Value: ""}}},
+	}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		log.Println(err)
+		ReleaseStock(ctx, prodCollection, order.Order_Cart)
+		return nil, ErrCantBuyCartItem
 	}
-
-	// Initialize an empty slice for the user cart.
-	userCartEmpty := make([]models.ProductUser, 0)
-
-	// Create a filter to search for the given user ID a third time.
-	thirdFilter := bson.D{primitive.E{Key: "_id", Value: id}}
-
-	// Create an update to set the user cart to the empty slice.
-	thirdUpdate := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: userCartEmpty}}}}
-
-	// Update the user document with the empty cart.
-	_, err = userCollection.UpdateOne(ctx, thirdFilter, thirdUpdate)
-	if err != nil {
-		return ErrCantBuyCartItem
+	if result.MatchedCount == 0 {
+		ReleaseStock(ctx, prodCollection, order.Order_Cart)
+		return nil, errCartChanged
 	}
 
-	return nil
+	return order, nil
 }
 
-// InstantBuyer adds a product to the cart of a user
-func InstantBuyer(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+// InstantBuyer places an order for a single product, or one of its variants, without touching the cart of the user
+func InstantBuyer(ctx context.Context, prodCollection, userCollection, zoneCollection *mongo.Collection, taxes tax.Provider, productID primitive.ObjectID, variantID *primitive.ObjectID, userID string, checkout CheckoutOptions) (*models.Order, error) {
 	// Convert the user ID to a primitive.ObjectID.
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
-		return ErrUserIdsNotValid
+		return nil, ErrUserIdsNotValid
 	}
 
-	// Initialize variables to hold the retrieved cart items and the order.
-	var getCartItems models.User
-	var orderCart models.Order
-
-	// Set the order ID, ordered at time, and initialize the order cart.
-	orderCart.Order_ID = primitive.NewObjectID()
-	orderCart.Ordered_At = time.Now()
-	orderCart.Order_Cart = make([]models.ProductUser, 0)
-	orderCart.Payment_Method.COD = true
-
-	// Unwind the user cart and group by the user ID to find the cart total.
-	unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-	grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
-
-	// Run the aggregation pipeline to retrieve the cart items and total.
-	currentResults, err := userCollection.Aggregate(ctx, mongo.Pipeline{unwind, grouping})
-	ctx.Done()
+	// Find the product being bought.
+	var product models.Product
+	err = prodCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(&product)
 	if err != nil {
-		panic(err)
+		log.Println(err)
+		return nil, ErrCantFindProduct
 	}
 
-	// Initialize a slice to hold the retrieved cart items.
-	var getUserCart []bson.M
-	if err = currentResults.All(ctx, &getUserCart); err != nil {
-		panic(err)
+	// Build the order line from the product or its variant.
+	item, err := catalog.CartItem(&product, variantID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Initialize a variable to hold the total cart price.
-	var totalPrice int32
+	// Find the user placing the order.
+	var user models.User
+	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&user)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrUserIdsNotValid
+	}
 
-	// Iterate through the retrieved cart items to find the total price.
-	for _, userItem := range getUserCart {
-		price := userItem["total"]
-		totalPrice = price.(int32)
+	// Build the order from the product and the shipping quote.
+	order, err := newOrder(ctx, zoneCollection, taxes, &user, []models.ProductUser{item}, checkout)
+	if err != nil {
+		return nil, err
 	}
 
-	// Set the order price to the total cart price.
-	orderCart.Price = int(totalPrice)
+	// Take the ordered unit out of stock.
+	if err = ReserveStock(ctx, prodCollection, order.Order_Cart); err != nil {
+		return nil, err
+	}
 
-	// Create a filter to search for the given user ID.
+	// Add the order to the user's orders.
 	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: order}}}}
+	_, err = userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		ReleaseStock(ctx, prodCollection, order.Order_Cart)
+		return nil, ErrCantBuyCartItem
+	}
+
+	return order, nil
+}
 
-	// Create an update to add the order to the user's orders.
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orderCart}}}}
+// newOrder builds an order for the items, pricing shipping to the address chosen at checkout and taxing both.
+func newOrder(ctx context.Context, zoneCollection *mongo.Collection, taxes tax.Provider, user *models.User, items []models.ProductUser, checkout CheckoutOptions) (*models.Order, error) {
+	method := checkout.ShippingMethod
+	if method == "" {
+		method = models.ShippingStandard
+	}
 
-	// Update the user document with the new order.
-	_, err = userCollection.UpdateMany(ctx, filter, update)
+	address, err := ShippingAddress(user, checkout.AddressIndex)
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
 
-	// Find the user document with the given user ID.
-	userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getCartItems)
+	zone, err := FindShippingZone(ctx, zoneCollection, address)
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
 
-	// Create a filter to search for the given user ID again.
-	secondFilter := bson.D{primitive.E{Key: "_id", Value: id}}
+	// take the coupon off the items before shipping and taxes are worked out
+	var discount *int
+	if checkout.Coupon != nil {
+		amount := catalog.Discount(checkout.Coupon, shipping.CartSubtotal(items))
+		items = catalog.ApplyDiscount(items, amount)
+		discount = &amount
+	}
 
-	// Create an update to add the cart items to the order list.
-	secondUpdate := bson.M{"$push": bson.M{"orders.$[].order_list": bson.M{"$each": getCartItems.UserCart}}}
+	quote, err := shipping.QuoteMethod(zone, method, items)
+	if err != nil {
+		return nil, err
+	}
 
-	// Update the user document with the new order list.
-	_, err = userCollection.UpdateOne(ctx, secondFilter, secondUpdate)
+	// tax the items and the shipping charge for the shipping address
+	summary, err := taxes.Calculate(ctx, tax.NewRequest(address, items, quote.Price))
 	if err != nil {
 		log.Println(err)
+		return nil, ErrCantCalculateTax
 	}
 
-	// Initialize an empty slice for the user cart.
-	userCartEmpty := make([]models.ProductUser, 0)
-
-	// Create a filter to search for the given user ID a third time.
-	thirdFilter := bson.D{primitive.E{Key: "_id", Value: id}}
-
-	// Create an update to set the user cart to the empty slice.
-	thirdUpdate := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: userCartEmpty}}}}
-
-	// Update the user document with the empty cart.
-	_, err = userCollection.UpdateOne(ctx, thirdFilter, thirdUpdate)
-	if err != nil {
-		return ErrCantBuyCartItem
+	order := &models.Order{
+		Order_ID:   primitive.NewObjectID(),
+		Ordered_At: time.Now(),
+		Order_Cart: items,
+		Discount:   discount,
+		Subtotal:   shipping.CartSubtotal(items),
+		Shipping:   quote,
+		Ship_To:    address,
+		Tax:        summary,
+		Status:     models.OrderPlaced,
+		Updated_At: time.Now(),
 	}
+	order.Payment_Method.COD = true
+	// the gross of the summary already holds the shipping and, when prices exclude it, the tax
+	order.Price = summary.Gross
 
-	return nil
+	return order, nil
 }
@@ -0,0 +1,317 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindCategory    = errors.New("can't find the category")
+	ErrCantSaveCategory    = errors.New("can't save the category")
+	ErrCantDeleteCategory  = errors.New("can't delete the category")
+	ErrCategorySlugTaken   = errors.New("another category already uses this slug")
+	ErrCategoryCycle       = errors.New("a category can't be moved under itself or one of its descendants")
+	ErrCategoryHasChildren = errors.New("move or delete the subcategories first")
+	ErrCantUpdateProduct   = errors.New("can't update the product")
+)
+
+var slugUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a category name into its url slug, such as "Men's Shoes" into "men-s-shoes".
+func Slugify(name string) string {
+	return strings.Trim(slugUnsafe.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// EnsureCategoryIndexes creates the indexes keeping slugs unique and subtree lookups fast.
+func EnsureCategoryIndexes(ctx context.Context, categoryCollection *mongo.Collection) error {
+	_, err := categoryCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "ancestors", Value: 1}}},
+	})
+	return err
+}
+
+// AddCategory stores a new category under its parent, or as a root when it has none.
+func AddCategory(ctx context.Context, categoryCollection *mongo.Collection, category *models.Category) error {
+	category.Category_ID = primitive.NewObjectID()
+	if category.Slug == "" {
+		category.Slug = Slugify(*category.Name)
+	}
+
+	ancestors, err := ancestorsOf(ctx, categoryCollection, category.Parent_ID)
+	if err != nil {
+		return err
+	}
+	category.Ancestors = ancestors
+	category.Created_At = time.Now()
+	category.Updated_At = category.Created_At
+
+	_, err = categoryCollection.InsertOne(ctx, category)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrCategorySlugTaken
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveCategory
+	}
+	return nil
+}
+
+// FindCategory returns the category with the given id.
+func FindCategory(ctx context.Context, categoryCollection *mongo.Collection, categoryID primitive.ObjectID) (*models.Category, error) {
+	return findCategory(ctx, categoryCollection, bson.M{"_id": categoryID})
+}
+
+// FindCategoryBySlug returns the category with the given slug.
+func FindCategoryBySlug(ctx context.Context, categoryCollection *mongo.Collection, slug string) (*models.Category, error) {
+	return findCategory(ctx, categoryCollection, bson.M{"slug": slug})
+}
+
+// ListCategories returns every category.
+func ListCategories(ctx context.Context, categoryCollection *mongo.Collection) ([]models.Category, error) {
+	cursor, err := categoryCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindCategory
+	}
+	defer cursor.Close(ctx)
+
+	categories := make([]models.Category, 0)
+	if err = cursor.All(ctx, &categories); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindCategory
+	}
+	return categories, nil
+}
+
+// CategoryTree nests the categories under their parents and returns the roots.
+func CategoryTree(categories []models.Category) []*models.CategoryNode {
+	nodes := make(map[primitive.ObjectID]*models.CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.Category_ID] = &models.CategoryNode{Category: category, Children: make([]*models.CategoryNode, 0)}
+	}
+
+	roots := make([]*models.CategoryNode, 0)
+	for _, category := range categories {
+		node := nodes[category.Category_ID]
+		if category.Parent_ID != nil {
+			if parent, ok := nodes[*category.Parent_ID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots
+}
+
+// UpdateCategory renames or moves a category. Moving rewrites the ancestors of the whole
+// subtree, and a category can't be moved below itself.
+func UpdateCategory(ctx context.Context, categoryCollection *mongo.Collection, categoryID primitive.ObjectID, changes *models.Category) (*models.Category, error) {
+	current, err := FindCategory(ctx, categoryCollection, categoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestors, err := ancestorsOf(ctx, categoryCollection, changes.Parent_ID)
+	if err != nil {
+		return nil, err
+	}
+	if changes.Parent_ID != nil && *changes.Parent_ID == categoryID {
+		return nil, ErrCategoryCycle
+	}
+	for _, ancestor := range ancestors {
+		if ancestor == categoryID {
+			return nil, ErrCategoryCycle
+		}
+	}
+
+	slug := changes.Slug
+	if slug == "" {
+		slug = current.Slug
+	}
+
+	set := bson.M{"name": changes.Name, "slug": slug, "parent_id": changes.Parent_ID, "ancestors": ancestors, "updated_at": time.Now()}
+	_, err = categoryCollection.UpdateOne(ctx, bson.M{"_id": categoryID}, bson.M{"$set": set})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrCategorySlugTaken
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveCategory
+	}
+
+	// rewrite the path of every descendant when the category moved
+	if !sameParent(current.Parent_ID, changes.Parent_ID) {
+		if err = moveSubtree(ctx, categoryCollection, categoryID, append(ancestors, categoryID)); err != nil {
+			return nil, err
+		}
+	}
+
+	return FindCategory(ctx, categoryCollection, categoryID)
+}
+
+// DeleteCategory removes a leaf category and takes it off every product.
+func DeleteCategory(ctx context.Context, categoryCollection, prodCollection *mongo.Collection, categoryID primitive.ObjectID) error {
+	count, err := categoryCollection.CountDocuments(ctx, bson.M{"parent_id": categoryID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantDeleteCategory
+	}
+	if count > 0 {
+		return ErrCategoryHasChildren
+	}
+
+	result, err := categoryCollection.DeleteOne(ctx, bson.M{"_id": categoryID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantDeleteCategory
+	}
+	if result.DeletedCount == 0 {
+		return ErrCantFindCategory
+	}
+
+	_, err = prodCollection.UpdateMany(ctx, bson.M{"category_ids": categoryID}, bson.M{"$pull": bson.M{"category_ids": categoryID}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateProduct
+	}
+	return nil
+}
+
+// SetProductCategories replaces the categories a product is listed in.
+func SetProductCategories(ctx context.Context, categoryCollection, prodCollection *mongo.Collection, productID primitive.ObjectID, categoryIDs []primitive.ObjectID) error {
+	count, err := categoryCollection.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": categoryIDs}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantFindCategory
+	}
+	if int(count) != len(uniqueIDs(categoryIDs)) {
+		return ErrCantFindCategory
+	}
+
+	result, err := prodCollection.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$set": bson.M{"category_ids": uniqueIDs(categoryIDs)}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateProduct
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindProduct
+	}
+	return nil
+}
+
+// CategoryProducts returns the products listed in the category or any of its descendants.
+func CategoryProducts(ctx context.Context, categoryCollection, prodCollection *mongo.Collection, categoryID primitive.ObjectID) ([]models.Product, error) {
+	subtree := []primitive.ObjectID{categoryID}
+	cursor, err := categoryCollection.Find(ctx, bson.M{"ancestors": categoryID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindCategory
+	}
+	var descendants []models.Category
+	if err = cursor.All(ctx, &descendants); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindCategory
+	}
+	for _, descendant := range descendants {
+		subtree = append(subtree, descendant.Category_ID)
+	}
+
+	productCursor, err := prodCollection.Find(ctx, bson.M{"category_ids": bson.M{"$in": subtree}})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindProduct
+	}
+	products := make([]models.Product, 0)
+	if err = productCursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return nil, ErrCantDecodeProducts
+	}
+	return products, nil
+}
+
+func findCategory(ctx context.Context, categoryCollection *mongo.Collection, filter bson.M) (*models.Category, error) {
+	var category models.Category
+	err := categoryCollection.FindOne(ctx, filter).Decode(&category)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println(err)
+		}
+		return nil, ErrCantFindCategory
+	}
+	return &category, nil
+}
+
+// ancestorsOf returns the path of a category placed under parentID.
+func ancestorsOf(ctx context.Context, categoryCollection *mongo.Collection, parentID *primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if parentID == nil {
+		return make([]primitive.ObjectID, 0), nil
+	}
+	parent, err := FindCategory(ctx, categoryCollection, *parentID)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(make([]primitive.ObjectID, 0, len(parent.Ancestors)+1), parent.Ancestors...), parent.Category_ID), nil
+}
+
+// moveSubtree gives every descendant of the category its new path, keeping the part below the category.
+func moveSubtree(ctx context.Context, categoryCollection *mongo.Collection, categoryID primitive.ObjectID, path []primitive.ObjectID) error {
+	cursor, err := categoryCollection.Find(ctx, bson.M{"ancestors": categoryID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveCategory
+	}
+	var descendants []models.Category
+	if err = cursor.All(ctx, &descendants); err != nil {
+		log.Println(err)
+		return ErrCantSaveCategory
+	}
+
+	for _, descendant := range descendants {
+		below := make([]primitive.ObjectID, 0)
+		for i, ancestor := range descendant.Ancestors {
+			if ancestor == categoryID {
+				below = descendant.Ancestors[i+1:]
+				break
+			}
+		}
+		ancestors := append(append(make([]primitive.ObjectID, 0, len(path)+len(below)), path...), below...)
+
+		_, err = categoryCollection.UpdateOne(ctx, bson.M{"_id": descendant.Category_ID}, bson.M{"$set": bson.M{"ancestors": ancestors}})
+		if err != nil {
+			log.Println(err)
+			return ErrCantSaveCategory
+		}
+	}
+	return nil
+}
+
+func sameParent(a, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func uniqueIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	unique := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidCoupon    = errors.New("this coupon doesn't exist, has expired or was already used")
+	ErrCantSaveCoupon   = errors.New("can't save the coupon")
+	ErrCantRedeemCoupon = errors.New("can't redeem the coupon")
+)
+
+// EnsureCouponIndexes creates the index keeping coupon codes unique.
+func EnsureCouponIndexes(ctx context.Context, couponCollection *mongo.Collection) error {
+	_, err := couponCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// CreateCoupon stores a single use coupon under a fresh random code.
+func CreateCoupon(ctx context.Context, couponCollection *mongo.Collection, coupon *models.Coupon) error {
+	code, err := couponCode()
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveCoupon
+	}
+	coupon.Coupon_ID = primitive.NewObjectID()
+	coupon.Code = code
+	coupon.Created_At = time.Now()
+	if _, err := couponCollection.InsertOne(ctx, coupon); err != nil {
+		log.Println(err)
+		return ErrCantSaveCoupon
+	}
+	return nil
+}
+
+// DeleteCoupon removes a coupon that was never handed out.
+func DeleteCoupon(ctx context.Context, couponCollection *mongo.Collection, couponID primitive.ObjectID) error {
+	if _, err := couponCollection.DeleteOne(ctx, bson.M{"_id": couponID, "used_at": nil}); err != nil {
+		log.Println(err)
+		return ErrCantSaveCoupon
+	}
+	return nil
+}
+
+// ClaimCoupon marks a coupon as used by the user, so two checkouts can't both redeem it. Release
+// it with ReleaseCoupon when the checkout fails.
+func ClaimCoupon(ctx context.Context, couponCollection *mongo.Collection, code, userID string) (*models.Coupon, error) {
+	now := time.Now()
+	filter := bson.M{
+		"code":       strings.ToUpper(strings.TrimSpace(code)),
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+		"user_id":    bson.M{"$in": bson.A{"", userID}},
+	}
+	update := bson.M{"$set": bson.M{"used_at": now}}
+
+	var coupon models.Coupon
+	err := couponCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&coupon)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidCoupon
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantRedeemCoupon
+	}
+	return &coupon, nil
+}
+
+// ReleaseCoupon makes a claimed coupon usable again.
+func ReleaseCoupon(ctx context.Context, couponCollection *mongo.Collection, couponID primitive.ObjectID) error {
+	_, err := couponCollection.UpdateOne(ctx, bson.M{"_id": couponID, "order_id": nil}, bson.M{"$set": bson.M{"used_at": nil}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantRedeemCoupon
+	}
+	return nil
+}
+
+// SetCouponOrder records the order a claimed coupon was redeemed on.
+func SetCouponOrder(ctx context.Context, couponCollection *mongo.Collection, couponID, orderID primitive.ObjectID) error {
+	_, err := couponCollection.UpdateOne(ctx, bson.M{"_id": couponID}, bson.M{"$set": bson.M{"order_id": orderID}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantRedeemCoupon
+	}
+	return nil
+}
+
+// couponCode returns a random code that is easy to type, such as CART-7Q2MZK4D.
+func couponCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "CART-" + base32.StdEncoding.EncodeToString(buf), nil
+}
@@ -51,3 +51,8 @@ func ProductData(client *mongo.Client, collectionName string) *mongo.Collection
 	var productCollection *mongo.Collection = client.Database("EcommerceDB").Collection(collectionName)
 	return productCollection
 }
+
+// OpenCollection returns a collection of the ecommerce database
+func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
+	return client.Database("EcommerceDB").Collection(collectionName)
+}
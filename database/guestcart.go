@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindGuestCart = errors.New("can't find the guest cart")
+	ErrCantSaveGuestCart = errors.New("can't save the guest cart")
+	ErrCantMergeCart     = errors.New("can't merge the guest cart")
+)
+
+// EnsureGuestCartIndexes expires guest carts left untouched for longer than their token lasts.
+func EnsureGuestCartIndexes(ctx context.Context, guestCollection *mongo.Collection, lifetime time.Duration) error {
+	_, err := guestCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "updated_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(lifetime.Seconds())),
+	})
+	return err
+}
+
+// CreateGuestCart creates an empty guest cart.
+func CreateGuestCart(ctx context.Context, guestCollection *mongo.Collection) (*models.GuestCart, error) {
+	now := time.Now()
+	cart := models.GuestCart{
+		Cart_ID:    primitive.NewObjectID(),
+		Items:      make([]models.ProductUser, 0),
+		Created_At: now,
+		Updated_At: now,
+	}
+	if _, err := guestCollection.InsertOne(ctx, cart); err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveGuestCart
+	}
+	return &cart, nil
+}
+
+// FindGuestCart returns a guest cart by id.
+func FindGuestCart(ctx context.Context, guestCollection *mongo.Collection, cartID primitive.ObjectID) (*models.GuestCart, error) {
+	var cart models.GuestCart
+	err := guestCollection.FindOne(ctx, bson.M{"_id": cartID}).Decode(&cart)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindGuestCart
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindGuestCart
+	}
+	return &cart, nil
+}
+
+// AddGuestCartItem puts quantity units of a product, or of one of its variants, in a guest cart.
+func AddGuestCartItem(ctx context.Context, prodCollection, guestCollection *mongo.Collection, cartID, productID primitive.ObjectID, variantID *primitive.ObjectID, quantity int) (*models.GuestCart, error) {
+	var product models.Product
+	err := prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindProduct
+	}
+	item, err := catalog.CartItem(&product, variantID)
+	if err != nil {
+		return nil, err
+	}
+
+	units := make(bson.A, quantity)
+	for i := range units {
+		units[i] = item
+	}
+	update := bson.M{"$push": bson.M{"items": bson.M{"$each": units}}, "$set": bson.M{"updated_at": time.Now()}}
+	result, err := guestCollection.UpdateOne(ctx, bson.M{"_id": cartID}, update)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveGuestCart
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrCantFindGuestCart
+	}
+	return FindGuestCart(ctx, guestCollection, cartID)
+}
+
+// RemoveGuestCartItem takes every unit of a product, or of one of its variants, out of a guest cart.
+func RemoveGuestCartItem(ctx context.Context, guestCollection *mongo.Collection, cartID, productID primitive.ObjectID, variantID *primitive.ObjectID) (*models.GuestCart, error) {
+	item := bson.M{"_id": productID}
+	if variantID != nil {
+		item["variant_id"] = *variantID
+	}
+	update := bson.M{"$pull": bson.M{"items": item}, "$set": bson.M{"updated_at": time.Now()}}
+	result, err := guestCollection.UpdateOne(ctx, bson.M{"_id": cartID}, update)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveGuestCart
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrCantFindGuestCart
+	}
+	return FindGuestCart(ctx, guestCollection, cartID)
+}
+
+// MergeGuestCart moves a guest cart into the cart of a user who just logged in or signed up,
+// combining quantities and refreshing prices, then deletes the guest cart. It returns the
+// user's new cart and how many guest units were dropped for lack of stock.
+func MergeGuestCart(ctx context.Context, prodCollection, userCollection, guestCollection *mongo.Collection, cartID primitive.ObjectID, userID string) ([]models.ProductUser, int, error) {
+	guest, err := FindGuestCart(ctx, guestCollection, cartID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user); err != nil {
+		log.Println(err)
+		return nil, 0, ErrUserIdsNotValid
+	}
+
+	productIDs := make([]primitive.ObjectID, 0, len(guest.Items))
+	for _, item := range guest.Items {
+		productIDs = append(productIDs, item.Product_ID)
+	}
+	byID, err := productsByID(ctx, prodCollection, productIDs)
+	if err != nil {
+		return nil, 0, ErrCantMergeCart
+	}
+
+	cart, dropped := catalog.MergeCart(user.UserCart, guest.Items, byID)
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"usercart": cart, "cart_updated_at": time.Now()}, "$unset": bson.M{"cart_reminded_at": ""}}); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantMergeCart
+	}
+	if _, err := guestCollection.DeleteOne(ctx, bson.M{"_id": cartID}); err != nil {
+		// the cart was merged, a leftover guest cart only expires later
+		log.Println(err)
+	}
+	return cart, dropped, nil
+}
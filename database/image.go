@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrCantFindImage = errors.New("can't find the image")
+	ErrImageOrder    = errors.New("the new order must list every image of the product exactly once")
+)
+
+// AddProductImage inserts an image at the given position of the product's images, or last when
+// the position is out of range.
+func AddProductImage(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, image models.ProductImage, position int) error {
+	push := bson.M{"$each": bson.A{image}}
+	if position >= 0 {
+		push["$position"] = position
+	}
+
+	result, err := prodCollection.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$push": bson.M{"images": push}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateProduct
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindProduct
+	}
+	return syncMainImage(ctx, prodCollection, productID)
+}
+
+// RemoveProductImage takes an image off the product and returns it so its blobs can be deleted.
+func RemoveProductImage(ctx context.Context, prodCollection *mongo.Collection, productID, imageID primitive.ObjectID) (*models.ProductImage, error) {
+	var product models.Product
+	err := prodCollection.FindOne(ctx, bson.M{"_id": productID, "images._id": imageID}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindImage
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindProduct
+	}
+
+	var removed *models.ProductImage
+	for i := range product.Images {
+		if product.Images[i].Image_ID == imageID {
+			removed = &product.Images[i]
+		}
+	}
+
+	_, err = prodCollection.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$pull": bson.M{"images": bson.M{"_id": imageID}}})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateProduct
+	}
+	return removed, syncMainImage(ctx, prodCollection, productID)
+}
+
+// ReorderProductImages puts the product's images in the order of the given ids.
+func ReorderProductImages(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, imageIDs []primitive.ObjectID) ([]models.ProductImage, error) {
+	var product models.Product
+	err := prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindProduct
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindProduct
+	}
+
+	if len(imageIDs) != len(product.Images) {
+		return nil, ErrImageOrder
+	}
+	byID := make(map[primitive.ObjectID]models.ProductImage, len(product.Images))
+	for _, image := range product.Images {
+		byID[image.Image_ID] = image
+	}
+	images := make([]models.ProductImage, 0, len(imageIDs))
+	for _, imageID := range imageIDs {
+		image, ok := byID[imageID]
+		if !ok {
+			return nil, ErrImageOrder
+		}
+		delete(byID, imageID)
+		images = append(images, image)
+	}
+
+	// only reorder when the images didn't change meanwhile
+	filter := bson.M{"_id": productID, "images._id": bson.M{"$all": imageIDs}, "images": bson.M{"$size": len(imageIDs)}}
+	result, err := prodCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"images": images}})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateProduct
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrImageOrder
+	}
+	return images, syncMainImage(ctx, prodCollection, productID)
+}
+
+// syncMainImage points Product.Image, which carts and orders copy, at the first image.
+func syncMainImage(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID) error {
+	var product models.Product
+	if err := prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+		log.Println(err)
+		return ErrCantUpdateProduct
+	}
+	if len(product.Images) == 0 {
+		// keep a hand-entered image url when no image was uploaded
+		return nil
+	}
+
+	_, err := prodCollection.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$set": bson.M{"image": product.Images[0].URL}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateProduct
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/ravelinejunior/golang_ecommerce/invoice"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindInvoice  = errors.New("can't find the invoice")
+	ErrCantIssueInvoice = errors.New("can't issue the invoice")
+)
+
+// issueAttempts bounds how often issuing retries after losing the race for a number.
+const issueAttempts = 10
+
+// refundAttempts bounds how often a refund is worked out again after another credit note
+// of the invoice was issued meanwhile.
+const refundAttempts = 5
+
+// EnsureInvoiceIndexes creates the unique indexes that keep invoice numbers gap-free,
+// give every order a single invoice and credit notes of an invoice a single place each.
+func EnsureInvoiceIndexes(ctx context.Context, invoiceCollection *mongo.Collection) error {
+	_, err := invoiceCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "store_id", Value: 1}, {Key: "kind", Value: 1}, {Key: "number", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "order_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"kind": models.InvoiceKind}),
+		},
+		{
+			Keys: bson.D{{Key: "credits", Value: 1}, {Key: "credit_seq", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"kind":       models.CreditNoteKind,
+				"credit_seq": bson.M{"$exists": true},
+			}),
+		},
+	})
+	return err
+}
+
+// IssueInvoice numbers the invoice or credit note and stores it. The number follows
+// the highest one issued in the store's series for that kind, and the unique index
+// on the series turns concurrent issues into retries, so no number is ever skipped.
+func IssueInvoice(ctx context.Context, invoiceCollection *mongo.Collection, document *models.Invoice) error {
+	for attempt := 0; attempt < issueAttempts; attempt++ {
+		var last models.Invoice
+		filter := bson.M{"store_id": document.Store_ID, "kind": document.Kind}
+		opts := options.FindOne().SetSort(bson.M{"number": -1}).SetProjection(bson.M{"number": 1})
+		err := invoiceCollection.FindOne(ctx, filter, opts).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			log.Println(err)
+			return ErrCantIssueInvoice
+		}
+
+		document.Number = last.Number + 1
+		document.Reference = invoice.Reference(document.Kind, document.Store_ID, document.Number)
+
+		_, err = invoiceCollection.InsertOne(ctx, document)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			log.Println(err)
+			return ErrCantIssueInvoice
+		}
+		// another invoice took the number, or this order was invoiced or credited meanwhile
+		if document.Kind == models.InvoiceKind {
+			if count, _ := invoiceCollection.CountDocuments(ctx, bson.M{"order_id": document.Order_ID, "kind": models.InvoiceKind}); count > 0 {
+				return err
+			}
+		}
+		if document.Kind == models.CreditNoteKind && document.Credit_Seq > 0 {
+			filter := bson.M{"credits": document.Credits, "credit_seq": document.Credit_Seq, "kind": models.CreditNoteKind}
+			if count, _ := invoiceCollection.CountDocuments(ctx, filter); count > 0 {
+				return err
+			}
+		}
+	}
+	return ErrCantIssueInvoice
+}
+
+// FindInvoice returns the invoice or credit note with the given id.
+func FindInvoice(ctx context.Context, invoiceCollection *mongo.Collection, invoiceID primitive.ObjectID) (*models.Invoice, error) {
+	var document models.Invoice
+	err := invoiceCollection.FindOne(ctx, bson.M{"_id": invoiceID}).Decode(&document)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println(err)
+		}
+		return nil, ErrCantFindInvoice
+	}
+	return &document, nil
+}
+
+// InvoiceForOrder returns the invoice of the order, issuing it the first time it is asked for.
+func InvoiceForOrder(ctx context.Context, userCollection, invoiceCollection *mongo.Collection, orderID primitive.ObjectID) (*models.Invoice, error) {
+	var document models.Invoice
+	err := invoiceCollection.FindOne(ctx, bson.M{"order_id": orderID, "kind": models.InvoiceKind}).Decode(&document)
+	if err == nil {
+		return &document, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return nil, ErrCantFindInvoice
+	}
+
+	order, userID, err := FindOrder(ctx, userCollection, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err = userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user); err != nil {
+		log.Println(err)
+		return nil, ErrUserIdsNotValid
+	}
+
+	issued := invoice.Build(order, &user)
+	err = IssueInvoice(ctx, invoiceCollection, issued)
+	if mongo.IsDuplicateKeyError(err) {
+		// issued concurrently by another request
+		err = invoiceCollection.FindOne(ctx, bson.M{"order_id": orderID, "kind": models.InvoiceKind}).Decode(&document)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrCantFindInvoice
+		}
+		return &document, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+// CreditNotes returns the credit notes issued against an invoice, oldest first.
+func CreditNotes(ctx context.Context, invoiceCollection *mongo.Collection, invoiceID primitive.ObjectID) ([]models.Invoice, error) {
+	opts := options.Find().SetSort(bson.M{"number": 1})
+	cursor, err := invoiceCollection.Find(ctx, bson.M{"credits": invoiceID, "kind": models.CreditNoteKind}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindInvoice
+	}
+	defer cursor.Close(ctx)
+
+	notes := make([]models.Invoice, 0)
+	if err = cursor.All(ctx, &notes); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindInvoice
+	}
+	return notes, nil
+}
+
+// RefundOrder issues a credit note for the refund and marks the order refunded once
+// the whole invoice has been credited. Every credit note takes the next place after the
+// notes it was worked out from, and the unique index on the places refuses a second note
+// in the same one, so concurrent refunds can't credit more than was invoiced: the refund
+// that loses is worked out again from the notes issued meanwhile.
+func RefundOrder(ctx context.Context, userCollection, invoiceCollection *mongo.Collection, orderID primitive.ObjectID, refund invoice.Refund) (*models.Invoice, error) {
+	issued, err := InvoiceForOrder(ctx, userCollection, invoiceCollection, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < refundAttempts; attempt++ {
+		previous, err := CreditNotes(ctx, invoiceCollection, issued.Invoice_ID)
+		if err != nil {
+			return nil, err
+		}
+
+		note, err := invoice.CreditNote(issued, previous, refund)
+		if err != nil {
+			return nil, err
+		}
+		note.Credit_Seq = len(previous) + 1
+
+		err = IssueInvoice(ctx, invoiceCollection, note)
+		if mongo.IsDuplicateKeyError(err) {
+			// another credit note of the invoice was issued meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if invoice.FullyCredited(issued, append(previous, *note)) {
+			if err = SetOrderStatus(ctx, userCollection, orderID, models.OrderRefunded); err != nil {
+				return nil, err
+			}
+		}
+		return note, nil
+	}
+	return nil, ErrCantIssueInvoice
+}
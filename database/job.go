@@ -0,0 +1,241 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindJob = errors.New("can't find the job")
+	ErrCantSaveJob = errors.New("can't save the job")
+)
+
+// MaxJobErrors bounds the row errors kept on a job; Failed still counts them all.
+const MaxJobErrors = 1000
+
+// EnsureJobIndexes creates the indexes listing the jobs of a user and finding the files to expire.
+func EnsureJobIndexes(ctx context.Context, jobCollection *mongo.Collection) error {
+	_, err := jobCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_by", Value: 1}, {Key: "kind", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	return err
+}
+
+// CreateJob stores a new pending job.
+func CreateJob(ctx context.Context, jobCollection *mongo.Collection, job *models.Job) error {
+	job.Job_ID = primitive.NewObjectID()
+	job.Status = models.JobPending
+	job.Created_At = time.Now()
+	if job.Errors == nil {
+		job.Errors = make([]models.RowError, 0)
+	}
+	if _, err := jobCollection.InsertOne(ctx, job); err != nil {
+		log.Println(err)
+		return ErrCantSaveJob
+	}
+	return nil
+}
+
+// NewJobToken returns a token to follow a job without logging in, and the hash to keep on the
+// job, see models.Job.Token_Hash.
+func NewJobToken() (string, string, error) {
+	token, err := randomToken()
+	if err != nil {
+		log.Println(err)
+		return "", "", ErrCantSaveJob
+	}
+	return token, HashToken(token), nil
+}
+
+// FindJob returns a job by id.
+func FindJob(ctx context.Context, jobCollection *mongo.Collection, jobID primitive.ObjectID) (*models.Job, error) {
+	var job models.Job
+	err := jobCollection.FindOne(ctx, bson.M{"_id": jobID}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindJob
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindJob
+	}
+	return &job, nil
+}
+
+// FindUserJob returns a job of a kind started by the user.
+func FindUserJob(ctx context.Context, jobCollection *mongo.Collection, jobID primitive.ObjectID, userID, kind string) (*models.Job, error) {
+	var job models.Job
+	err := jobCollection.FindOne(ctx, bson.M{"_id": jobID, "created_by": userID, "kind": kind}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindJob
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindJob
+	}
+	return &job, nil
+}
+
+// ListUserJobs returns the latest jobs of a kind started by the user, newest first.
+func ListUserJobs(ctx context.Context, jobCollection *mongo.Collection, userID, kind string, limit int64) ([]models.Job, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+	cursor, err := jobCollection.Find(ctx, bson.M{"created_by": userID, "kind": kind}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindJob
+	}
+	jobs := make([]models.Job, 0)
+	if err := cursor.All(ctx, &jobs); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindJob
+	}
+	return jobs, nil
+}
+
+// HasActiveJob tells whether a job of a kind started by the user is still pending or running.
+func HasActiveJob(ctx context.Context, jobCollection *mongo.Collection, userID, kind string) (bool, error) {
+	filter := bson.M{"created_by": userID, "kind": kind, "status": bson.M{"$in": bson.A{models.JobPending, models.JobRunning}}}
+	count, err := jobCollection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantFindJob
+	}
+	return count > 0, nil
+}
+
+// ListJobs returns the latest jobs of a kind, newest first.
+func ListJobs(ctx context.Context, jobCollection *mongo.Collection, kind string, limit int64) ([]models.Job, error) {
+	filter := bson.M{}
+	if kind != "" {
+		filter["kind"] = kind
+	}
+	// the row errors are only returned with a single job
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(limit).SetProjection(bson.M{"errors": 0})
+	cursor, err := jobCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindJob
+	}
+	jobs := make([]models.Job, 0)
+	if err := cursor.All(ctx, &jobs); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindJob
+	}
+	return jobs, nil
+}
+
+// StartJob marks a pending job as running.
+func StartJob(ctx context.Context, jobCollection *mongo.Collection, jobID primitive.ObjectID) error {
+	now := time.Now()
+	_, err := jobCollection.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{"$set": bson.M{"status": models.JobRunning, "started_at": now}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveJob
+	}
+	return nil
+}
+
+// SaveJobProgress records the counters of a running job and appends its new row errors.
+func SaveJobProgress(ctx context.Context, jobCollection *mongo.Collection, job *models.Job, rowErrors []models.RowError) error {
+	update := bson.M{"$set": bson.M{"rows": job.Rows, "created": job.Created, "updated": job.Updated, "failed": job.Failed}}
+	if len(rowErrors) > 0 {
+		update["$push"] = bson.M{"errors": bson.M{"$each": rowErrors, "$slice": MaxJobErrors}}
+	}
+	if _, err := jobCollection.UpdateOne(ctx, bson.M{"_id": job.Job_ID}, update); err != nil {
+		log.Println(err)
+		return ErrCantSaveJob
+	}
+	return nil
+}
+
+// FinishJob marks a job as completed, or failed when failure is not empty.
+func FinishJob(ctx context.Context, jobCollection *mongo.Collection, jobID primitive.ObjectID, failure string) error {
+	set := bson.M{"status": models.JobCompleted, "finished_at": time.Now()}
+	if failure != "" {
+		set["status"] = models.JobFailed
+		set["error"] = failure
+	}
+	if _, err := jobCollection.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{"$set": set}); err != nil {
+		log.Println(err)
+		return ErrCantSaveJob
+	}
+	return nil
+}
+
+// SetJobBlob records where the file a job produced is kept, and until when.
+func SetJobBlob(ctx context.Context, jobCollection *mongo.Collection, jobID primitive.ObjectID, key string, expiresAt time.Time) error {
+	_, err := jobCollection.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{"$set": bson.M{"blob_key": key, "expires_at": expiresAt}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveJob
+	}
+	return nil
+}
+
+// ClearJobBlob forgets the file of a job once it is deleted.
+func ClearJobBlob(ctx context.Context, jobCollection *mongo.Collection, jobID primitive.ObjectID) error {
+	_, err := jobCollection.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{"$unset": bson.M{"blob_key": ""}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveJob
+	}
+	return nil
+}
+
+// JobsWithBlobs returns the jobs whose file is still kept, those of the user when userID is set,
+// or else those expired at now.
+func JobsWithBlobs(ctx context.Context, jobCollection *mongo.Collection, userID string, now time.Time, limit int64) ([]models.Job, error) {
+	filter := bson.M{"blob_key": bson.M{"$exists": true}}
+	if userID != "" {
+		filter["created_by"] = userID
+	} else {
+		filter["expires_at"] = bson.M{"$lte": now}
+	}
+	cursor, err := jobCollection.Find(ctx, filter, options.Find().SetLimit(limit))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindJob
+	}
+	jobs := make([]models.Job, 0)
+	if err := cursor.All(ctx, &jobs); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindJob
+	}
+	return jobs, nil
+}
+
+// InterruptedJobs returns the jobs of a kind a previous run of the server left unfinished.
+func InterruptedJobs(ctx context.Context, jobCollection *mongo.Collection, kind string) ([]models.Job, error) {
+	filter := bson.M{"kind": kind, "status": bson.M{"$in": bson.A{models.JobPending, models.JobRunning}}}
+	cursor, err := jobCollection.Find(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindJob
+	}
+	jobs := make([]models.Job, 0)
+	if err := cursor.All(ctx, &jobs); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindJob
+	}
+	return jobs, nil
+}
+
+// FailInterruptedJobs fails the jobs a previous run of the server left unfinished. Account
+// deletions are left to be resumed instead, since the account is already closed.
+func FailInterruptedJobs(ctx context.Context, jobCollection *mongo.Collection) error {
+	filter := bson.M{"kind": bson.M{"$ne": models.JobAccountDeletion}, "status": bson.M{"$in": bson.A{models.JobPending, models.JobRunning}}}
+	update := bson.M{"$set": bson.M{"status": models.JobFailed, "error": "interrupted by a server restart", "finished_at": time.Now()}}
+	if _, err := jobCollection.UpdateMany(ctx, filter, update); err != nil {
+		log.Println(err)
+		return ErrCantSaveJob
+	}
+	return nil
+}
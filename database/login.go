@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/lockout"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantTrackLogins = errors.New("can't track the failed logins")
+)
+
+// AccountLoginKey is the key of the failed logins for an email, whether or not an account
+// uses it, so that a lockout doesn't tell which emails are registered.
+func AccountLoginKey(email string) string {
+	return "account:" + NormalizeEmail(email)
+}
+
+// IPLoginKey is the key of the failed logins from an address.
+func IPLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// EnsureLoginAttemptIndexes creates the indexes finding the attempts of a key and forgetting
+// them once their window is over.
+func EnsureLoginAttemptIndexes(ctx context.Context, attemptCollection *mongo.Collection) error {
+	_, err := attemptCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// LoginLockedUntil returns the latest time any of the keys is locked until, or the zero time
+// when none is locked at now.
+func LoginLockedUntil(ctx context.Context, attemptCollection *mongo.Collection, keys []string, now time.Time) (time.Time, error) {
+	filter := bson.M{"key": bson.M{"$in": keys}, "locked_until": bson.M{"$gt": now}}
+	cursor, err := attemptCollection.Find(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return time.Time{}, ErrCantTrackLogins
+	}
+	var attempts []models.LoginAttempts
+	if err := cursor.All(ctx, &attempts); err != nil {
+		log.Println(err)
+		return time.Time{}, ErrCantTrackLogins
+	}
+
+	var until time.Time
+	for _, attempt := range attempts {
+		if attempt.Locked_Until != nil && attempt.Locked_Until.After(until) {
+			until = *attempt.Locked_Until
+		}
+	}
+	return until, nil
+}
+
+// RecordLoginFailure counts a failed login for the key and locks it for as long as the policy
+// says, returning the failures in a row of the key and the time it is locked until, or the zero
+// time when it isn't. Failures older than the window of the policy start over.
+func RecordLoginFailure(ctx context.Context, attemptCollection *mongo.Collection, key string, policy lockout.Policy, now time.Time) (int, time.Time, error) {
+	// the failures of a key whose window is over are forgotten even before the TTL index runs
+	if _, err := attemptCollection.DeleteOne(ctx, bson.M{"key": key, "expires_at": bson.M{"$lte": now}}); err != nil {
+		log.Println(err)
+		return 0, time.Time{}, ErrCantTrackLogins
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"last_failure_at": now, "expires_at": now.Add(policy.Window)},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var attempts models.LoginAttempts
+	if err := attemptCollection.FindOneAndUpdate(ctx, bson.M{"key": key}, update, opts).Decode(&attempts); err != nil {
+		log.Println(err)
+		return 0, time.Time{}, ErrCantTrackLogins
+	}
+
+	delay := policy.Delay(attempts.Failures)
+	if delay == 0 {
+		return attempts.Failures, time.Time{}, nil
+	}
+	until := now.Add(delay)
+	// never shorten a lock set by a concurrent failure
+	filter := bson.M{"key": key, "locked_until": bson.M{"$not": bson.M{"$gte": until}}}
+	if _, err := attemptCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"locked_until": until}}); err != nil {
+		log.Println(err)
+		return 0, time.Time{}, ErrCantTrackLogins
+	}
+	return attempts.Failures, until, nil
+}
+
+// ClearLoginFailures forgets the failed logins of the key, after a successful login or when an
+// admin unlocks it. It reports whether there was anything to forget.
+func ClearLoginFailures(ctx context.Context, attemptCollection *mongo.Collection, key string) (bool, error) {
+	result, err := attemptCollection.DeleteOne(ctx, bson.M{"key": key})
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantTrackLogins
+	}
+	return result.DeletedCount > 0, nil
+}
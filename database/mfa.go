@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrMfaAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMfaNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrMfaNotEnrolling   = errors.New("start the two-factor enrollment first")
+	ErrInvalidMfaCode    = errors.New("the code is invalid or was already used")
+	ErrCantUpdateMfa     = errors.New("can't update the two-factor authentication of the user")
+)
+
+// StartMfaEnrollment keeps a new secret for the user until they confirm it with a code,
+// replacing any enrollment left unfinished.
+func StartMfaEnrollment(ctx context.Context, userCollection *mongo.Collection, userID, secret string) error {
+	filter := bson.M{"user_id": userID, "mfa_enabled": bson.M{"$ne": true}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa_pending_secret": secret}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateMfa
+	}
+	if result.MatchedCount == 0 {
+		return ErrMfaAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableMfa turns two-factor authentication on with the pending secret, once the user proved
+// their app has it with the code of step. Every other session is revoked since none of them
+// went through the second factor; the returned user carries the new session version.
+func EnableMfa(ctx context.Context, userCollection *mongo.Collection, userID, secret string, step int64, recoveryHashes []string) (*models.User, error) {
+	now := time.Now()
+	filter := bson.M{"user_id": userID, "mfa_enabled": bson.M{"$ne": true}, "mfa_pending_secret": secret}
+	update := bson.M{
+		"$set": bson.M{
+			"mfa_enabled":        true,
+			"mfa_enabled_at":     now,
+			"mfa_secret":         secret,
+			"mfa_last_step":      step,
+			"mfa_recovery_codes": recoveryHashes,
+			"updated_at":         now,
+		},
+		"$unset": bson.M{"mfa_pending_secret": ""},
+		"$inc":   bson.M{"session_version": 1},
+	}
+	var user models.User
+	err := userCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrMfaNotEnrolling
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateMfa
+	}
+	return &user, nil
+}
+
+// DisableMfa turns two-factor authentication off and forgets the secret and recovery codes.
+func DisableMfa(ctx context.Context, userCollection *mongo.Collection, userID string) error {
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"mfa_enabled": false, "mfa_last_step": 0, "updated_at": now},
+		"$unset": bson.M{"mfa_secret": "", "mfa_pending_secret": "", "mfa_recovery_codes": "", "mfa_enabled_at": ""},
+	}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID, "mfa_enabled": true}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateMfa
+	}
+	if result.MatchedCount == 0 {
+		return ErrMfaNotEnabled
+	}
+	return nil
+}
+
+// UseMfaStep records that the code of step was used, refusing steps at or before the last one
+// so that a code can't be replayed.
+func UseMfaStep(ctx context.Context, userCollection *mongo.Collection, userID string, step int64) error {
+	// a missing mfa_last_step must match too, hence $not rather than $lt
+	filter := bson.M{"user_id": userID, "mfa_enabled": true, "mfa_last_step": bson.M{"$not": bson.M{"$gte": step}}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa_last_step": step}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateMfa
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidMfaCode
+	}
+	return nil
+}
+
+// UseRecoveryCode consumes the recovery code with the hash, which then stops working.
+func UseRecoveryCode(ctx context.Context, userCollection *mongo.Collection, userID, codeHash string) error {
+	filter := bson.M{"user_id": userID, "mfa_enabled": true, "mfa_recovery_codes": codeHash}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"mfa_recovery_codes": codeHash}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateMfa
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidMfaCode
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes voids every recovery code of the user in favor of new ones.
+func ReplaceRecoveryCodes(ctx context.Context, userCollection *mongo.Collection, userID string, recoveryHashes []string) error {
+	filter := bson.M{"user_id": userID, "mfa_enabled": true}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa_recovery_codes": recoveryHashes}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateMfa
+	}
+	if result.MatchedCount == 0 {
+		return ErrMfaNotEnabled
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/oidc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidOidcState    = errors.New("the sign in link is invalid, was already used or has expired, start again")
+	ErrOidcEmailUnverified = errors.New("the identity provider doesn't vouch for the email of this account, sign in another way")
+	ErrCantLinkIdentity    = errors.New("can't sign in with the identity provider")
+)
+
+// EnsureOidcLoginIndexes creates the indexes finding a pending login by state and dropping
+// abandoned ones.
+func EnsureOidcLoginIndexes(ctx context.Context, oidcLoginCollection *mongo.Collection) error {
+	_, err := oidcLoginCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "state_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// CreateOidcLogin keeps a login started with a provider until the user comes back.
+func CreateOidcLogin(ctx context.Context, oidcLoginCollection *mongo.Collection, login models.OidcLogin) error {
+	if _, err := oidcLoginCollection.InsertOne(ctx, login); err != nil {
+		log.Println(err)
+		return ErrCantLinkIdentity
+	}
+	return nil
+}
+
+// ConsumeOidcLogin returns the pending login of the provider with the state, which then stops
+// working.
+func ConsumeOidcLogin(ctx context.Context, oidcLoginCollection *mongo.Collection, provider, state string) (*models.OidcLogin, error) {
+	filter := bson.M{"state_hash": HashToken(state), "provider": provider, "expires_at": bson.M{"$gt": time.Now()}}
+	var login models.OidcLogin
+	err := oidcLoginCollection.FindOneAndDelete(ctx, filter).Decode(&login)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidOidcState
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantLinkIdentity
+	}
+	return &login, nil
+}
+
+// SignInWithIdentity returns the user behind an identity at the provider. An identity seen for
+// the first time is linked to the user with the same email, or a new user is created, but only
+// when the provider vouches for the email. Linking to an account whose email was never verified
+// verifies it and revokes its password and sessions, since whoever set them up couldn't prove
+// they own the address.
+func SignInWithIdentity(ctx context.Context, userCollection *mongo.Collection, provider string, claims *oidc.Claims) (*models.User, error) {
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": claims.Subject}}}).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return nil, ErrCantLinkIdentity
+	}
+
+	email := NormalizeEmail(claims.Email)
+	if !claims.Email_Verified || email == "" {
+		return nil, ErrOidcEmailUnverified
+	}
+	now := time.Now()
+	identity := models.Identity{Provider: provider, Subject: claims.Subject, Email: email, Linked_At: now}
+
+	err = userCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		update := bson.M{"$push": bson.M{"identities": identity}, "$set": bson.M{"updated_at": now}}
+		if !user.Email_Verified {
+			update["$set"] = bson.M{"updated_at": now, "email_verified": true, "email_verified_at": now}
+			update["$unset"] = bson.M{"password": "", "token": "", "refresh_token": "", "verification_sent_at": ""}
+			update["$inc"] = bson.M{"session_version": 1}
+		}
+		filter := bson.M{"user_id": user.User_ID, "identities": bson.M{"$not": bson.M{"$elemMatch": bson.M{"provider": provider}}}}
+		err := userCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			// the account is already linked to another identity at this provider
+			return nil, ErrCantLinkIdentity
+		}
+		if err != nil {
+			log.Println(err)
+			return nil, ErrCantLinkIdentity
+		}
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return nil, ErrCantLinkIdentity
+	}
+
+	first, last := claims.Given_Name, claims.Family_Name
+	if first == "" {
+		first, last, _ = strings.Cut(claims.Name, " ")
+	}
+	if first == "" {
+		first, _, _ = strings.Cut(email, "@")
+	}
+	role := models.RoleUser
+	user = models.User{
+		ID:                primitive.NewObjectID(),
+		First_Name:        &first,
+		Last_Name:         &last,
+		Email:             &email,
+		Email_Verified:    true,
+		Email_Verified_At: &now,
+		Role:              &role,
+		Identities:        []models.Identity{identity},
+		Created_At:        now,
+		Updated_At:        now,
+		UserCart:          make([]models.ProductUser, 0),
+		Address_Details:   make([]models.Address, 0),
+		Order_Status:      make([]models.Order, 0),
+	}
+	user.User_ID = user.ID.Hex()
+	if _, err := userCollection.InsertOne(ctx, user); err != nil {
+		log.Println(err)
+		return nil, ErrCantLinkIdentity
+	}
+	return &user, nil
+}
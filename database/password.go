@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidResetToken   = errors.New("the reset link is invalid, was already used or has expired")
+	ErrResetRequestedSoon  = errors.New("a reset email was sent recently")
+	ErrCantResetPassword   = errors.New("can't reset the password")
+	ErrCantChangePassword  = errors.New("can't change the password")
+	ErrCantFindUserByEmail = errors.New("can't find a user with this email")
+)
+
+// EnsurePasswordResetIndexes creates the indexes finding a reset by token and dropping expired ones.
+func EnsurePasswordResetIndexes(ctx context.Context, resetCollection *mongo.Collection) error {
+	_, err := resetCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// CreatePasswordReset starts a password reset for the user with the email, replacing any reset
+// still pending, and returns the user and the token to mail them. A new reset is refused while
+// the previous one is younger than cooldown.
+func CreatePasswordReset(ctx context.Context, userCollection, resetCollection *mongo.Collection, email string, lifetime, cooldown time.Duration) (*models.User, string, error) {
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, "", ErrCantFindUserByEmail
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, "", ErrCantResetPassword
+	}
+
+	now := time.Now()
+	recent, err := resetCollection.CountDocuments(ctx, bson.M{"user_id": user.User_ID, "used_at": nil, "created_at": bson.M{"$gt": now.Add(-cooldown)}})
+	if err != nil {
+		log.Println(err)
+		return nil, "", ErrCantResetPassword
+	}
+	if recent > 0 {
+		return nil, "", ErrResetRequestedSoon
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		log.Println(err)
+		return nil, "", ErrCantResetPassword
+	}
+
+	// only the latest link works
+	if _, err := resetCollection.DeleteMany(ctx, bson.M{"user_id": user.User_ID, "used_at": nil}); err != nil {
+		log.Println(err)
+		return nil, "", ErrCantResetPassword
+	}
+	reset := models.PasswordReset{
+		Reset_ID:   primitive.NewObjectID(),
+		User_ID:    user.User_ID,
+		Token_Hash: HashToken(token),
+		Created_At: now,
+		Expires_At: now.Add(lifetime),
+	}
+	if _, err := resetCollection.InsertOne(ctx, reset); err != nil {
+		log.Println(err)
+		return nil, "", ErrCantResetPassword
+	}
+	return &user, token, nil
+}
+
+// UsePasswordReset consumes a reset token and returns the id of its user. A token works once.
+func UsePasswordReset(ctx context.Context, resetCollection *mongo.Collection, token string) (string, error) {
+	now := time.Now()
+	filter := bson.M{"token_hash": HashToken(token), "used_at": nil, "expires_at": bson.M{"$gt": now}}
+	var reset models.PasswordReset
+	err := resetCollection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"used_at": now}}).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrInvalidResetToken
+	}
+	if err != nil {
+		log.Println(err)
+		return "", ErrCantResetPassword
+	}
+	return reset.User_ID, nil
+}
+
+// SetPassword stores a new password hash for the user and revokes every session. The returned
+// user carries the new session version to sign fresh tokens with.
+func SetPassword(ctx context.Context, userCollection *mongo.Collection, userID, passwordHash string) (*models.User, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"password": passwordHash, "password_changed_at": now, "updated_at": now},
+		"$inc":   bson.M{"session_version": 1},
+		"$unset": bson.M{"token": "", "refresh_token": ""},
+	}
+	var user models.User
+	err := userCollection.FindOneAndUpdate(ctx, bson.M{"user_id": userID}, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserIdsNotValid
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantChangePassword
+	}
+	return &user, nil
+}
+
+// HashToken returns the hex sha256 of a secret token, which is what gets stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns an unguessable url safe token.
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
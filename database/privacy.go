@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrAccountDeleted    = errors.New("the account is deleted")
+	ErrCantExportData    = errors.New("can't export the data of the user")
+	ErrCantDeleteAccount = errors.New("can't delete the account")
+)
+
+// findAllOfUser decodes every document of the user in the collection into results, oldest first.
+func findAllOfUser(ctx context.Context, collection *mongo.Collection, userID, sortBy string, results interface{}) error {
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: sortBy, Value: 1}}))
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+// ExportUserData gathers everything kept about the user: the profile, addresses, cart and orders
+// of the user document, and the invoices, shipments, reviews and wishlists of the user.
+func ExportUserData(ctx context.Context, userCollection, invoiceCollection, shipmentCollection, reviewCollection, wishlistCollection *mongo.Collection, userID string) (*models.DataExport, error) {
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserIdsNotValid
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantExportData
+	}
+
+	export := models.DataExport{
+		Exported_At: time.Now(),
+		Addresses:   user.Address_Details,
+		Cart:        user.UserCart,
+		Orders:      user.Order_Status,
+		Invoices:    make([]models.Invoice, 0),
+		Shipments:   make([]models.Shipment, 0),
+		Reviews:     make([]models.Review, 0),
+		Wishlists:   make([]models.Wishlist, 0),
+	}
+	// each list has its own section rather than repeating it in the profile
+	user.Address_Details, user.UserCart, user.Order_Status = nil, nil, nil
+	export.Profile = user
+
+	for _, part := range []struct {
+		collection *mongo.Collection
+		sortBy     string
+		results    interface{}
+	}{
+		{invoiceCollection, "issued_at", &export.Invoices},
+		{shipmentCollection, "created_at", &export.Shipments},
+		{reviewCollection, "created_at", &export.Reviews},
+		{wishlistCollection, "created_at", &export.Wishlists},
+	} {
+		if err := findAllOfUser(ctx, part.collection, userID, part.sortBy, part.results); err != nil {
+			log.Println(err)
+			return nil, ErrCantExportData
+		}
+	}
+	return &export, nil
+}
+
+// CloseAccount is the part of deleting an account that takes effect at once: the user can no
+// longer log in, by password, identity provider or an open session, and the email and phone are
+// released. It returns the user as it was, the rest of the personal data being removed by
+// AnonymizeUser.
+func CloseAccount(ctx context.Context, userCollection *mongo.Collection, userID string) (*models.User, error) {
+	now := time.Now()
+	filter := bson.M{"user_id": userID, "deleted_at": bson.M{"$exists": false}}
+	update := bson.M{
+		"$set": bson.M{"deleted_at": now, "updated_at": now, "mfa_enabled": false, "usercart": bson.A{}},
+		"$unset": bson.M{
+			"password": "", "token": "", "refresh_token": "", "email": "", "pending_email": "", "phone": "",
+			"identities": "", "mfa_secret": "", "mfa_pending_secret": "", "mfa_recovery_codes": "",
+			"verification_sent_at": "", "email_change_sent_at": "", "cart_updated_at": "", "cart_reminded_at": "",
+		},
+		"$inc": bson.M{"session_version": 1},
+	}
+
+	var user models.User
+	err := userCollection.FindOneAndUpdate(ctx, filter, update).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return nil, ErrCantDeleteAccount
+	}
+	if count, _ := userCollection.CountDocuments(ctx, bson.M{"user_id": userID}); count > 0 {
+		return nil, ErrAccountDeleted
+	}
+	return nil, ErrUserIdsNotValid
+}
+
+// AnonymizeUser removes the personal data left on a closed account. Orders are kept for the
+// accounting history, shipped to the country alone; reviews are kept under an anonymous author;
+// wishlists and password resets are deleted and API keys revoked. Issued invoices are left as
+// they are, since the law requires keeping them. Running it again is harmless.
+func AnonymizeUser(ctx context.Context, userCollection, reviewCollection, wishlistCollection, apiKeyCollection, resetCollection *mongo.Collection, userID string) error {
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.M{"user_id": userID, "deleted_at": bson.M{"$exists": true}}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return ErrUserIdsNotValid
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantDeleteAccount
+	}
+
+	for i := range user.Order_Status {
+		if address := user.Order_Status[i].Ship_To; address != nil {
+			user.Order_Status[i].Ship_To = &models.Address{Address_ID: address.Address_ID, Country: address.Country}
+		}
+	}
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"first_name":     models.AnonymizedFirstName,
+			"last_name":      models.AnonymizedLastName,
+			"email_verified": false,
+			"address":        bson.A{},
+			"orders":         user.Order_Status,
+			"anonymized_at":  now,
+			"updated_at":     now,
+		},
+		"$unset": bson.M{"email_verified_at": "", "password_changed_at": "", "mfa_enabled_at": "", "mfa_last_step": ""},
+	}
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update); err != nil {
+		log.Println(err)
+		return ErrCantDeleteAccount
+	}
+
+	if _, err := reviewCollection.UpdateMany(ctx, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"author": models.AnonymizedName}}); err != nil {
+		log.Println(err)
+		return ErrCantDeleteAccount
+	}
+	if _, err := wishlistCollection.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		log.Println(err)
+		return ErrCantDeleteAccount
+	}
+	if _, err := apiKeyCollection.UpdateMany(ctx, bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"revoked_at": now}}); err != nil {
+		log.Println(err)
+		return ErrCantDeleteAccount
+	}
+	if _, err := resetCollection.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		log.Println(err)
+		return ErrCantDeleteAccount
+	}
+	return nil
+}
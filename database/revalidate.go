@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CartChangedError stops a checkout whose cart no longer matches the catalog until the
+// shopper acknowledges the changes.
+type CartChangedError struct {
+	Review models.CartReview
+}
+
+func (e *CartChangedError) Error() string {
+	return "the cart changed since it was filled, review the issues and pass their acknowledgement to check out"
+}
+
+// RevalidateCart checks a cart against the current products.
+func RevalidateCart(ctx context.Context, prodCollection *mongo.Collection, cart []models.ProductUser) (models.CartReview, error) {
+	productIDs := make([]primitive.ObjectID, 0, len(cart))
+	for _, item := range cart {
+		productIDs = append(productIDs, item.Product_ID)
+	}
+	products, err := productsByID(ctx, prodCollection, productIDs)
+	if err != nil {
+		return models.CartReview{}, err
+	}
+	return catalog.RevalidateCart(cart, products), nil
+}
+
+// productsByID loads the given products, keyed by id. Deleted products are missing from the map.
+func productsByID(ctx context.Context, prodCollection *mongo.Collection, productIDs []primitive.ObjectID) (map[primitive.ObjectID]*models.Product, error) {
+	cursor, err := prodCollection.Find(ctx, bson.M{"_id": bson.M{"$in": uniqueIDs(productIDs)}})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindProduct
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return nil, ErrCantDecodeProducts
+	}
+
+	byID := make(map[primitive.ObjectID]*models.Product, len(products))
+	for i := range products {
+		byID[products[i].Product_ID] = &products[i]
+	}
+	return byID, nil
+}
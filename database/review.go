@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindReview   = errors.New("can't find the review")
+	ErrCantSaveReview   = errors.New("can't save the review")
+	ErrNotPurchased     = errors.New("only customers who ordered this product can review it")
+	ErrAlreadyReviewed  = errors.New("you already reviewed this product")
+	ErrOwnReview        = errors.New("you can't vote on your own review")
+	ErrCantUpdateRating = errors.New("can't update the product rating")
+)
+
+// EnsureReviewIndexes creates the indexes allowing one review per customer and product
+// and serving the listings.
+func EnsureReviewIndexes(ctx context.Context, reviewCollection *mongo.Collection) error {
+	_, err := reviewCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+	return err
+}
+
+// AddReview stores a review in the moderation queue. The user must have ordered the product.
+func AddReview(ctx context.Context, userCollection, prodCollection, reviewCollection *mongo.Collection, userID string, review *models.Review) error {
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.M{"user_id": userID, "orders.order_list._id": review.Product_ID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotPurchased
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveReview
+	}
+
+	count, err := prodCollection.CountDocuments(ctx, bson.M{"_id": review.Product_ID})
+	if err != nil || count == 0 {
+		return ErrCantFindProduct
+	}
+
+	review.Review_ID = primitive.NewObjectID()
+	review.User_ID = userID
+	review.Author = ""
+	if user.First_Name != nil {
+		review.Author = *user.First_Name
+	}
+	review.Status = models.ReviewPending
+	review.Helpful_Votes, review.Unhelpful_Votes = 0, 0
+	review.Votes = make([]models.ReviewVote, 0)
+	review.Created_At = time.Now()
+	review.Moderated_At = nil
+
+	_, err = reviewCollection.InsertOne(ctx, review)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyReviewed
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveReview
+	}
+	return nil
+}
+
+// ListReviews returns a page of the reviews matching the filter.
+func ListReviews(ctx context.Context, reviewCollection *mongo.Collection, filter bson.M, sort bson.D, page, perPage int64) (*models.Page, error) {
+	total, err := reviewCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindReview
+	}
+
+	opts := options.Find().SetSort(sort).SetSkip((page - 1) * perPage).SetLimit(perPage)
+	cursor, err := reviewCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindReview
+	}
+	defer cursor.Close(ctx)
+
+	reviews := make([]models.Review, 0)
+	if err = cursor.All(ctx, &reviews); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindReview
+	}
+	return &models.Page{Items: reviews, Page: page, Per_Page: perPage, Total: total}, nil
+}
+
+// ModerateReview approves or rejects a review and refreshes the rating of its product.
+func ModerateReview(ctx context.Context, prodCollection, reviewCollection *mongo.Collection, reviewID primitive.ObjectID, status, note string) (*models.Review, error) {
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"status": status, "moderation_note": note, "moderated_at": now}}
+
+	var review models.Review
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := reviewCollection.FindOneAndUpdate(ctx, bson.M{"_id": reviewID}, update, opts).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindReview
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveReview
+	}
+
+	if err = RefreshProductRating(ctx, prodCollection, reviewCollection, review.Product_ID); err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// VoteReview records whether the user found an approved review helpful. Voting again replaces
+// the earlier vote.
+func VoteReview(ctx context.Context, reviewCollection *mongo.Collection, reviewID primitive.ObjectID, userID string, helpful bool) (*models.Review, error) {
+	var review models.Review
+	err := reviewCollection.FindOne(ctx, bson.M{"_id": reviewID, "status": models.ReviewApproved}).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindReview
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveReview
+	}
+	if review.User_ID == userID {
+		return nil, ErrOwnReview
+	}
+
+	// swap the user's vote and recount in a single update
+	vote := bson.M{"user_id": userID, "helpful": helpful}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"votes": bson.M{"$concatArrays": bson.A{
+			bson.M{"$filter": bson.M{"input": bson.M{"$ifNull": bson.A{"$votes", bson.A{}}}, "cond": bson.M{"$ne": bson.A{"$$this.user_id", userID}}}},
+			bson.A{vote},
+		}}}}},
+		{{Key: "$set", Value: bson.M{
+			"helpful_votes":   bson.M{"$size": bson.M{"$filter": bson.M{"input": "$votes", "cond": "$$this.helpful"}}},
+			"unhelpful_votes": bson.M{"$size": bson.M{"$filter": bson.M{"input": "$votes", "cond": bson.M{"$not": bson.A{"$$this.helpful"}}}}},
+		}}},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = reviewCollection.FindOneAndUpdate(ctx, bson.M{"_id": reviewID}, pipeline, opts).Decode(&review)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveReview
+	}
+	return &review, nil
+}
+
+// RefreshProductRating recomputes the average rating and review count of a product from its
+// approved reviews.
+func RefreshProductRating(ctx context.Context, prodCollection, reviewCollection *mongo.Collection, productID primitive.ObjectID) error {
+	match := bson.D{{Key: "$match", Value: bson.M{"product_id": productID, "status": models.ReviewApproved}}}
+	group := bson.D{{Key: "$group", Value: bson.M{"_id": "$product_id", "average": bson.M{"$avg": "$rating"}, "count": bson.M{"$sum": 1}}}}
+
+	cursor, err := reviewCollection.Aggregate(ctx, mongo.Pipeline{match, group})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateRating
+	}
+	var results []struct {
+		Average float64 `bson:"average"`
+		Count   int     `bson:"count"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		log.Println(err)
+		return ErrCantUpdateRating
+	}
+
+	set := bson.M{"rating_average": 0.0, "rating_count": 0, "rating": nil}
+	if len(results) > 0 {
+		average := math.Round(results[0].Average*100) / 100
+		set = bson.M{"rating_average": average, "rating_count": results[0].Count, "rating": uint8(math.Round(average))}
+	}
+
+	_, err = prodCollection.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$set": set})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateRating
+	}
+	return nil
+}
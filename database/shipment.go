@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/shipping"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindOrder     = errors.New("can't find the order")
+	ErrCantUpdateOrder   = errors.New("can't update the order")
+	ErrCantFindShipment  = errors.New("can't find the shipment")
+	ErrCantSaveShipment  = errors.New("can't save the shipment")
+	ErrShipmentDelivered = errors.New("the shipment was already delivered")
+	ErrShipmentConflict  = errors.New("other shipments keep being created for the order, try again")
+)
+
+// shipmentAttempts is how many times CreateShipment checks the items again when another
+// shipment of the order is created at the same time.
+const shipmentAttempts = 5
+
+// FindOrder returns the order with the given id together with the id of the user who placed it.
+func FindOrder(ctx context.Context, userCollection *mongo.Collection, orderID primitive.ObjectID) (*models.Order, string, error) {
+	// only project the matching order out of the user's orders
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"user_id": 1, "orders.$": 1})
+	err := userCollection.FindOne(ctx, bson.M{"orders._id": orderID}, opts).Decode(&user)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println(err)
+		}
+		return nil, "", ErrCantFindOrder
+	}
+
+	if len(user.Order_Status) == 0 {
+		return nil, "", ErrCantFindOrder
+	}
+	return &user.Order_Status[0], user.User_ID, nil
+}
+
+// SetOrderStatus changes the status of the order with the given id.
+func SetOrderStatus(ctx context.Context, userCollection *mongo.Collection, orderID primitive.ObjectID, status string) error {
+	filter := bson.M{"orders._id": orderID}
+	update := bson.M{"$set": bson.M{"orders.$.status": status, "orders.$.updated_at": time.Now()}}
+	_, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateOrder
+	}
+	return nil
+}
+
+// OrderShipments returns every shipment of the order, oldest first.
+func OrderShipments(ctx context.Context, shipmentCollection *mongo.Collection, orderID primitive.ObjectID) ([]models.Shipment, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := shipmentCollection.Find(ctx, bson.M{"order_id": orderID}, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindShipment
+	}
+	defer cursor.Close(ctx)
+
+	shipments := make([]models.Shipment, 0)
+	if err = cursor.All(ctx, &shipments); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindShipment
+	}
+	return shipments, nil
+}
+
+// CreateShipment records a shipment for part or all of the order's items and advances the order.
+// Shipments of the same order are created one at a time: the shipment is inserted, then takes
+// the next number of the order's shipment sequence. When another shipment took it first, the
+// items may no longer fit, so the shipment is removed and checked again against the others.
+func CreateShipment(ctx context.Context, userCollection, shipmentCollection *mongo.Collection, orderID primitive.ObjectID, shipment *models.Shipment) error {
+	for attempt := 0; attempt < shipmentAttempts; attempt++ {
+		order, userID, err := FindOrder(ctx, userCollection, orderID)
+		if err != nil {
+			return err
+		}
+
+		// the items must still be waiting to be shipped
+		others, err := OrderShipments(ctx, shipmentCollection, orderID)
+		if err != nil {
+			return err
+		}
+		if err = shipping.CheckShipmentItems(order, others, shipment.Items); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		shipment.Shipment_ID = primitive.NewObjectID()
+		shipment.Order_ID = orderID
+		shipment.User_ID = userID
+		shipment.Status = models.ShipmentLabelCreated
+		shipment.Events = []models.ShipmentEvent{{Status: models.ShipmentLabelCreated, Description: "Shipping label created", Occurred_At: now}}
+		shipment.Created_At = now
+		shipment.Updated_At = now
+
+		if _, err = shipmentCollection.InsertOne(ctx, shipment); err != nil {
+			log.Println(err)
+			return ErrCantSaveShipment
+		}
+
+		claimed, err := claimShipmentSeq(ctx, userCollection, order)
+		if err != nil || !claimed {
+			if _, err := shipmentCollection.DeleteOne(ctx, bson.M{"_id": shipment.Shipment_ID}); err != nil {
+				log.Println(err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if claimed {
+			return syncOrderStatus(ctx, userCollection, order, append(others, *shipment))
+		}
+	}
+	return ErrShipmentConflict
+}
+
+// claimShipmentSeq moves the shipment sequence of the order on from the number it was read with.
+// It reports false when another shipment moved it on first.
+func claimShipmentSeq(ctx context.Context, userCollection *mongo.Collection, order *models.Order) (bool, error) {
+	var seq interface{} = order.Shipment_Seq
+	if order.Shipment_Seq == 0 {
+		// orders start without a sequence
+		seq = bson.M{"$exists": false}
+	}
+	filter := bson.M{"orders": bson.M{"$elemMatch": bson.M{"_id": order.Order_ID, "shipment_seq": seq}}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"orders.$.shipment_seq": 1}})
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantSaveShipment
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// UpdateShipment changes the carrier and tracking number of a shipment.
+func UpdateShipment(ctx context.Context, shipmentCollection *mongo.Collection, shipmentID primitive.ObjectID, carrier, trackingNumber string) (*models.Shipment, error) {
+	set := bson.M{"updated_at": time.Now()}
+	if carrier != "" {
+		set["carrier"] = carrier
+	}
+	if trackingNumber != "" {
+		set["tracking_number"] = trackingNumber
+	}
+
+	var shipment models.Shipment
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := shipmentCollection.FindOneAndUpdate(ctx, bson.M{"_id": shipmentID}, bson.M{"$set": set}, opts).Decode(&shipment)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindShipment
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveShipment
+	}
+	return &shipment, nil
+}
+
+// AddShipmentEvent appends a tracking event to the shipment, moves the shipment to the event's
+// status and advances the order accordingly.
+func AddShipmentEvent(ctx context.Context, userCollection, shipmentCollection *mongo.Collection, shipmentID primitive.ObjectID, event models.ShipmentEvent) (*models.Shipment, error) {
+	if event.Occurred_At.IsZero() {
+		event.Occurred_At = time.Now()
+	}
+
+	// delivered shipments are final
+	filter := bson.M{"_id": shipmentID, "status": bson.M{"$ne": models.ShipmentDelivered}}
+	update := bson.M{
+		"$push": bson.M{"events": event},
+		"$set":  bson.M{"status": event.Status, "updated_at": time.Now()},
+	}
+
+	var shipment models.Shipment
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := shipmentCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&shipment)
+	if err == mongo.ErrNoDocuments {
+		if count, _ := shipmentCollection.CountDocuments(ctx, bson.M{"_id": shipmentID}); count > 0 {
+			return nil, ErrShipmentDelivered
+		}
+		return nil, ErrCantFindShipment
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveShipment
+	}
+
+	order, _, err := FindOrder(ctx, userCollection, shipment.Order_ID)
+	if err != nil {
+		return nil, err
+	}
+	shipments, err := OrderShipments(ctx, shipmentCollection, shipment.Order_ID)
+	if err != nil {
+		return nil, err
+	}
+	if err = syncOrderStatus(ctx, userCollection, order, shipments); err != nil {
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+// syncOrderStatus stores the status derived from the order's shipments when it changed. An
+// order refunded since it was read keeps its status.
+func syncOrderStatus(ctx context.Context, userCollection *mongo.Collection, order *models.Order, shipments []models.Shipment) error {
+	status := shipping.OrderStatus(order, shipments)
+	if status == order.Status {
+		return nil
+	}
+	filter := bson.M{"orders": bson.M{"$elemMatch": bson.M{"_id": order.Order_ID, "status": bson.M{"$ne": models.OrderRefunded}}}}
+	update := bson.M{"$set": bson.M{"orders.$.status": status, "orders.$.updated_at": time.Now()}}
+	if _, err := userCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateOrder
+	}
+	return nil
+}
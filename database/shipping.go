@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"github.com/ravelinejunior/golang_ecommerce/shipping"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrCantFindZone     = errors.New("can't find the shipping zone")
+	ErrCantSaveZone     = errors.New("can't save the shipping zone")
+	ErrCantDeleteZone   = errors.New("can't delete the shipping zone")
+	ErrNoAddress        = errors.New("there is no shipping address at this position")
+	ErrAddressNoCountry = errors.New("the shipping address has no country")
+)
+
+// AddShippingZone stores a new shipping zone.
+func AddShippingZone(ctx context.Context, zoneCollection *mongo.Collection, zone *models.ShippingZone) error {
+	zone.Zone_ID = primitive.NewObjectID()
+	_, err := zoneCollection.InsertOne(ctx, zone)
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveZone
+	}
+	return nil
+}
+
+// ListShippingZones returns every configured shipping zone.
+func ListShippingZones(ctx context.Context, zoneCollection *mongo.Collection) ([]models.ShippingZone, error) {
+	cursor, err := zoneCollection.Find(ctx, bson.D{})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindZone
+	}
+	defer cursor.Close(ctx)
+
+	zones := make([]models.ShippingZone, 0)
+	if err = cursor.All(ctx, &zones); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindZone
+	}
+	return zones, nil
+}
+
+// UpdateShippingZone replaces the zone with the given id.
+func UpdateShippingZone(ctx context.Context, zoneCollection *mongo.Collection, zoneID primitive.ObjectID, zone *models.ShippingZone) error {
+	zone.Zone_ID = zoneID
+	result, err := zoneCollection.ReplaceOne(ctx, bson.M{"_id": zoneID}, zone)
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveZone
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindZone
+	}
+	return nil
+}
+
+// DeleteShippingZone removes the zone with the given id.
+func DeleteShippingZone(ctx context.Context, zoneCollection *mongo.Collection, zoneID primitive.ObjectID) error {
+	result, err := zoneCollection.DeleteOne(ctx, bson.M{"_id": zoneID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantDeleteZone
+	}
+	if result.DeletedCount == 0 {
+		return ErrCantFindZone
+	}
+	return nil
+}
+
+// FindShippingZone returns the zone serving the given address.
+func FindShippingZone(ctx context.Context, zoneCollection *mongo.Collection, address *models.Address) (*models.ShippingZone, error) {
+	if address.Country == nil || *address.Country == "" {
+		return nil, ErrAddressNoCountry
+	}
+
+	// countries are not normalised in storage, so narrow down in Go
+	zones, err := ListShippingZones(ctx, zoneCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	postal := ""
+	if address.PinCode != nil {
+		postal = *address.PinCode
+	}
+	return shipping.MatchZone(zones, *address.Country, postal)
+}
+
+// ShippingAddress returns the user's address at the given position, where 0 is
+// the home address and 1 the work address.
+func ShippingAddress(user *models.User, index int) (*models.Address, error) {
+	if index < 0 || index >= len(user.Address_Details) {
+		return nil, ErrNoAddress
+	}
+	return &user.Address_Details[index], nil
+}
+
+// ShippingQuotes prices every shipping method available for delivering the
+// items to the address.
+func ShippingQuotes(ctx context.Context, zoneCollection *mongo.Collection, address *models.Address, items []models.ProductUser) ([]models.ShippingQuote, error) {
+	zone, err := FindShippingZone(ctx, zoneCollection, address)
+	if err != nil {
+		return nil, err
+	}
+	return shipping.Quote(zone, items)
+}
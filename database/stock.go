@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// stockKey identifies what stock an item is drawn from: the variant, or the product itself.
+type stockKey struct {
+	product primitive.ObjectID
+	variant primitive.ObjectID
+}
+
+// ReserveStock takes the units of the items out of stock. Products and variants whose stock
+// isn't tracked are left alone. Either every unit is reserved or none is.
+func ReserveStock(ctx context.Context, prodCollection *mongo.Collection, items []models.ProductUser) error {
+	quantities := countStock(items)
+
+	reserved := make(map[stockKey]int, len(quantities))
+	for key, quantity := range quantities {
+		filter, update := stockUpdate(key, quantity, -quantity)
+		result, err := prodCollection.UpdateOne(ctx, filter, update)
+		if err == nil && result.MatchedCount > 0 {
+			reserved[key] = quantity
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+		}
+
+		// a miss is fine when the stock isn't tracked, otherwise there isn't enough of it
+		tracked, checkErr := stockTracked(ctx, prodCollection, key)
+		if err == nil && checkErr == nil && !tracked {
+			continue
+		}
+
+		releaseStock(ctx, prodCollection, reserved)
+		if err != nil || checkErr != nil {
+			return ErrCantBuyCartItem
+		}
+		return catalog.ErrOutOfStock
+	}
+	return nil
+}
+
+// ReleaseStock puts the units of the items back in stock.
+func ReleaseStock(ctx context.Context, prodCollection *mongo.Collection, items []models.ProductUser) {
+	releaseStock(ctx, prodCollection, countStock(items))
+}
+
+func releaseStock(ctx context.Context, prodCollection *mongo.Collection, quantities map[stockKey]int) {
+	for key, quantity := range quantities {
+		filter, update := stockUpdate(key, 0, quantity)
+		if _, err := prodCollection.UpdateOne(ctx, filter, update); err != nil {
+			log.Println("could not release stock of", key.product.Hex(), err)
+		}
+	}
+}
+
+func countStock(items []models.ProductUser) map[stockKey]int {
+	quantities := make(map[stockKey]int)
+	for _, item := range items {
+		key := stockKey{product: item.Product_ID}
+		if item.Variant_ID != nil {
+			key.variant = *item.Variant_ID
+		}
+		quantities[key]++
+	}
+	return quantities
+}
+
+// stockUpdate builds the update moving the stock of the key by delta, only matching when at least
+// minimum units are available.
+func stockUpdate(key stockKey, minimum, delta int) (bson.M, bson.M) {
+	if key.variant.IsZero() {
+		filter := bson.M{"_id": key.product, "stock": bson.M{"$gte": minimum}}
+		return filter, bson.M{"$inc": bson.M{"stock": delta}}
+	}
+	filter := bson.M{"_id": key.product, "variants": bson.M{"$elemMatch": bson.M{"_id": key.variant, "stock": bson.M{"$gte": minimum}}}}
+	return filter, bson.M{"$inc": bson.M{"variants.$.stock": delta}}
+}
+
+// stockTracked tells whether the product or variant keeps a stock count.
+func stockTracked(ctx context.Context, prodCollection *mongo.Collection, key stockKey) (bool, error) {
+	var filter bson.M
+	if key.variant.IsZero() {
+		filter = bson.M{"_id": key.product, "stock": bson.M{"$type": "number"}}
+	} else {
+		filter = bson.M{"_id": key.product, "variants": bson.M{"$elemMatch": bson.M{"_id": key.variant, "stock": bson.M{"$type": "number"}}}}
+	}
+	count, err := prodCollection.CountDocuments(ctx, filter)
+	return count > 0, err
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrCantFindTaxRate   = errors.New("can't find the tax rate")
+	ErrCantSaveTaxRate   = errors.New("can't save the tax rate")
+	ErrCantDeleteTaxRate = errors.New("can't delete the tax rate")
+)
+
+// AddTaxRate stores a new rate in the tax table.
+func AddTaxRate(ctx context.Context, taxCollection *mongo.Collection, rate *models.TaxRate) error {
+	rate.Rate_ID = primitive.NewObjectID()
+	normaliseTaxRate(rate)
+	_, err := taxCollection.InsertOne(ctx, rate)
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveTaxRate
+	}
+	return nil
+}
+
+// ListTaxRates returns the whole tax table.
+func ListTaxRates(ctx context.Context, taxCollection *mongo.Collection) ([]models.TaxRate, error) {
+	return findTaxRates(ctx, taxCollection, bson.M{})
+}
+
+// TaxRatesForCountry returns the rates of the tax table for one country.
+func TaxRatesForCountry(ctx context.Context, taxCollection *mongo.Collection, country string) ([]models.TaxRate, error) {
+	return findTaxRates(ctx, taxCollection, bson.M{"country": strings.ToUpper(country)})
+}
+
+// UpdateTaxRate replaces the rate with the given id.
+func UpdateTaxRate(ctx context.Context, taxCollection *mongo.Collection, rateID primitive.ObjectID, rate *models.TaxRate) error {
+	rate.Rate_ID = rateID
+	normaliseTaxRate(rate)
+	result, err := taxCollection.ReplaceOne(ctx, bson.M{"_id": rateID}, rate)
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveTaxRate
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindTaxRate
+	}
+	return nil
+}
+
+// DeleteTaxRate removes the rate with the given id.
+func DeleteTaxRate(ctx context.Context, taxCollection *mongo.Collection, rateID primitive.ObjectID) error {
+	result, err := taxCollection.DeleteOne(ctx, bson.M{"_id": rateID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantDeleteTaxRate
+	}
+	if result.DeletedCount == 0 {
+		return ErrCantFindTaxRate
+	}
+	return nil
+}
+
+func findTaxRates(ctx context.Context, taxCollection *mongo.Collection, filter bson.M) ([]models.TaxRate, error) {
+	cursor, err := taxCollection.Find(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindTaxRate
+	}
+	defer cursor.Close(ctx)
+
+	rates := make([]models.TaxRate, 0)
+	if err = cursor.All(ctx, &rates); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindTaxRate
+	}
+	return rates, nil
+}
+
+// normaliseTaxRate stores countries in upper case so they can be looked up exactly
+func normaliseTaxRate(rate *models.TaxRate) {
+	country := strings.ToUpper(strings.TrimSpace(*rate.Country))
+	rate.Country = &country
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrCantUpsertRow = errors.New("can't save the product")
+
+// UpsertProductBySku creates the product, or updates the product with the same sku. Only the
+// fields set on the product are written, so a csv without a stock column leaves stock alone.
+// It reports whether the product was created.
+func UpsertProductBySku(ctx context.Context, prodCollection *mongo.Collection, product models.Product) (bool, error) {
+	set := bson.M{"sku": *product.Sku}
+	if product.Product_Name != nil {
+		set["product_name"] = *product.Product_Name
+	}
+	if product.Price != nil {
+		set["price"] = *product.Price
+	}
+	if product.Image != nil {
+		set["image"] = *product.Image
+	}
+	if product.Weight != nil {
+		set["weight"] = *product.Weight
+	}
+	if product.Dimensions != nil {
+		set["dimensions"] = product.Dimensions
+	}
+	if product.Tax_Class != nil {
+		set["tax_class"] = *product.Tax_Class
+	}
+	if product.Stock != nil {
+		set["stock"] = *product.Stock
+	}
+	if product.Category_IDs != nil {
+		set["category_ids"] = uniqueIDs(product.Category_IDs)
+	}
+	if product.Options != nil || product.Variants != nil {
+		set["options"] = product.Options
+		set["variants"] = product.Variants
+	}
+
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "rating_average": 0, "rating_count": 0},
+	}
+	result, err := prodCollection.UpdateOne(ctx, bson.M{"sku": *product.Sku}, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, ErrSkuTaken
+	}
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantUpsertRow
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+// ExportProducts returns a cursor over the whole catalog, sorted by sku.
+func ExportProducts(ctx context.Context, prodCollection *mongo.Collection) (*mongo.Cursor, error) {
+	cursor, err := prodCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"sku": 1}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindProduct
+	}
+	return cursor, nil
+}
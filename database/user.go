@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrEmailTaken         = errors.New("this email address is already in use")
+	ErrPhoneTaken         = errors.New("this phone number is already in use")
+	ErrSameEmail          = errors.New("this is already the email address of the account")
+	ErrEmailChangeTooSoon = errors.New("a confirmation email was sent recently, check your inbox or try again later")
+	ErrCantCreateUser     = errors.New("could not create the user")
+	ErrCantUpdateProfile  = errors.New("can't update the profile")
+	ErrCantChangeEmail    = errors.New("can't change the email address")
+	ErrNothingToUpdate    = errors.New("nothing to update")
+)
+
+// names of the unique user indexes, telling which one a duplicate key error is about
+const (
+	userEmailIndex = "email_unique"
+	userPhoneIndex = "phone_unique"
+)
+
+// EnsureUserIndexes creates the unique indexes on the id, email and phone of users. Users
+// created through an identity provider have no phone, so only phones that are set must be unique.
+func EnsureUserIndexes(ctx context.Context, userCollection *mongo.Collection) error {
+	_, err := userCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetName(userEmailIndex).SetUnique(true).
+			SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string"}})},
+		{Keys: bson.D{{Key: "phone", Value: 1}}, Options: options.Index().SetName(userPhoneIndex).SetUnique(true).
+			SetPartialFilterExpression(bson.M{"phone": bson.M{"$type": "string"}})},
+	})
+	return err
+}
+
+// NormalizeEmail returns the email lowercased and trimmed, the form emails are stored and looked
+// up in, so that an address is a single account whatever its case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeUserEmails rewrites the emails and pending emails stored before emails were normalized.
+// It stops at the first email another account already has once normalized: those accounts are
+// the same address and must be merged or changed by hand.
+func NormalizeUserEmails(ctx context.Context, userCollection *mongo.Collection) error {
+	for _, field := range []string{"email", "pending_email"} {
+		filter := bson.M{field: bson.M{"$type": "string", "$regex": `[A-Z]|^\s|\s$`}}
+		cursor, err := userCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"user_id": 1, field: 1}))
+		if err != nil {
+			return err
+		}
+		var users []bson.M
+		if err := cursor.All(ctx, &users); err != nil {
+			return err
+		}
+		for _, user := range users {
+			email, _ := user[field].(string)
+			_, err := userCollection.UpdateOne(ctx, bson.M{"_id": user["_id"]}, bson.M{"$set": bson.M{field: NormalizeEmail(email)}})
+			if mongo.IsDuplicateKeyError(err) {
+				return fmt.Errorf("user %v: another account has the email %s", user["user_id"], NormalizeEmail(email))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// duplicateUserError tells which unique field of a user a write collided on, or returns nil when
+// err isn't a duplicate key error.
+func duplicateUserError(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if strings.Contains(err.Error(), userPhoneIndex) {
+		return ErrPhoneTaken
+	}
+	return ErrEmailTaken
+}
+
+// CreateUser inserts the user, refusing an email or phone another user already has.
+func CreateUser(ctx context.Context, userCollection *mongo.Collection, user *models.User) error {
+	_, err := userCollection.InsertOne(ctx, user)
+	if err == nil {
+		return nil
+	}
+	if dup := duplicateUserError(err); dup != nil {
+		return dup
+	}
+	log.Println(err)
+	return ErrCantCreateUser
+}
+
+// UpdateProfile sets the names and phone of the user given in update, leaving the others as
+// they are, and returns the updated user.
+func UpdateProfile(ctx context.Context, userCollection *mongo.Collection, userID string, update models.ProfileUpdate) (*models.User, error) {
+	set := bson.M{}
+	if update.First_Name != nil {
+		set["first_name"] = *update.First_Name
+	}
+	if update.Last_Name != nil {
+		set["last_name"] = *update.Last_Name
+	}
+	if update.Phone != nil {
+		set["phone"] = *update.Phone
+	}
+	if len(set) == 0 {
+		return nil, ErrNothingToUpdate
+	}
+	set["updated_at"] = time.Now()
+
+	var user models.User
+	err := userCollection.FindOneAndUpdate(ctx, bson.M{"user_id": userID}, bson.M{"$set": set}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserIdsNotValid
+	}
+	if err != nil {
+		if dup := duplicateUserError(err); dup != nil {
+			return nil, dup
+		}
+		log.Println(err)
+		return nil, ErrCantUpdateProfile
+	}
+	return &user, nil
+}
+
+// RequestEmailChange records the address the user wants to move to, replacing any change still
+// pending, at most once per cooldown. The email only changes once the new address is verified,
+// see ConfirmEmailChange.
+func RequestEmailChange(ctx context.Context, userCollection *mongo.Collection, userID, email string, cooldown time.Duration) (*models.User, error) {
+	now := time.Now()
+	filter := bson.M{
+		"user_id": userID,
+		"email":   bson.M{"$ne": email},
+		"$or": bson.A{
+			bson.M{"email_change_sent_at": bson.M{"$exists": false}},
+			bson.M{"email_change_sent_at": bson.M{"$lte": now.Add(-cooldown)}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"pending_email": email, "email_change_sent_at": now, "updated_at": now}}
+
+	var user models.User
+	err := userCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return nil, ErrCantChangeEmail
+	}
+
+	// tell apart an unchanged address from a request made too soon
+	if err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user); err != nil {
+		return nil, ErrUserIdsNotValid
+	}
+	if user.Email != nil && *user.Email == email {
+		return nil, ErrSameEmail
+	}
+	return nil, ErrEmailChangeTooSoon
+}
+
+// ConfirmEmailChange moves the user to the pending address the verification was sent to, marking
+// it verified. Sessions are revoked since they were opened under the former address. It fails
+// with ErrVerificationStale once the user asked for another address, and ErrEmailTaken when
+// someone else took the address in the meantime.
+func ConfirmEmailChange(ctx context.Context, userCollection *mongo.Collection, userID, email string) error {
+	now := time.Now()
+	filter := bson.M{"user_id": userID, "pending_email": email}
+	update := bson.M{
+		"$set":   bson.M{"email": email, "email_verified": true, "email_verified_at": now, "updated_at": now},
+		"$unset": bson.M{"pending_email": "", "email_change_sent_at": "", "verification_sent_at": "", "token": "", "refresh_token": ""},
+		"$inc":   bson.M{"session_version": 1},
+	}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		if dup := duplicateUserError(err); dup != nil {
+			return dup
+		}
+		log.Println(err)
+		return ErrCantChangeEmail
+	}
+	if result.MatchedCount == 0 {
+		return ErrVerificationStale
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindUsers  = errors.New("can't find the users")
+	ErrCantManageUser = errors.New("can't update the account")
+)
+
+// SearchUsers returns a page of the users matching the filter, newest first. The carts and
+// orders are left out, they come with a single user.
+func SearchUsers(ctx context.Context, userCollection *mongo.Collection, filter bson.M, page, perPage int64) (*models.Page, error) {
+	total, err := userCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindUsers
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip((page - 1) * perPage).SetLimit(perPage).SetProjection(bson.M{"usercart": 0, "orders": 0})
+	cursor, err := userCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindUsers
+	}
+	defer cursor.Close(ctx)
+
+	users := make([]models.User, 0)
+	if err = cursor.All(ctx, &users); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindUsers
+	}
+	return &models.Page{Items: users, Page: page, Per_Page: perPage, Total: total}, nil
+}
+
+// updateLiveUser applies the update to a user whose account isn't deleted and returns the user
+// after it.
+func updateLiveUser(ctx context.Context, userCollection *mongo.Collection, userID string, update interface{}) (*models.User, error) {
+	filter := bson.M{"user_id": userID, "deleted_at": bson.M{"$exists": false}}
+	var user models.User
+	err := userCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return nil, ErrCantManageUser
+	}
+	if count, _ := userCollection.CountDocuments(ctx, bson.M{"user_id": userID}); count > 0 {
+		return nil, ErrAccountDeleted
+	}
+	return nil, ErrUserIdsNotValid
+}
+
+// DisableUser stops the user from logging in and ends their sessions. Disabling a disabled user
+// keeps when it was first disabled and only changes the reason.
+func DisableUser(ctx context.Context, userCollection *mongo.Collection, userID, reason string) (*models.User, error) {
+	now := time.Now()
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"disabled_at":     bson.M{"$ifNull": bson.A{"$disabled_at", now}},
+			"disabled_reason": reason,
+			"updated_at":      now,
+			"session_version": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$session_version", 0}}, 1}},
+		}},
+		bson.M{"$unset": bson.A{"token", "refresh_token"}},
+	}
+	return updateLiveUser(ctx, userCollection, userID, update)
+}
+
+// EnableUser lets a disabled user log in again.
+func EnableUser(ctx context.Context, userCollection *mongo.Collection, userID string) (*models.User, error) {
+	update := bson.M{"$unset": bson.M{"disabled_at": "", "disabled_reason": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	return updateLiveUser(ctx, userCollection, userID, update)
+}
+
+// SetUserRole gives the user the role. The admin middleware reads the role on every request, so
+// the change applies at once.
+func SetUserRole(ctx context.Context, userCollection *mongo.Collection, userID, role string) (*models.User, error) {
+	update := bson.M{"$set": bson.M{"role": role, "updated_at": time.Now()}}
+	return updateLiveUser(ctx, userCollection, userID, update)
+}
+
+// RevokeSessions ends every session of the user, who must log in again.
+func RevokeSessions(ctx context.Context, userCollection *mongo.Collection, userID string) (*models.User, error) {
+	update := bson.M{"$inc": bson.M{"session_version": 1}, "$unset": bson.M{"token": "", "refresh_token": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	return updateLiveUser(ctx, userCollection, userID, update)
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSkuTaken = errors.New("another product already uses this sku")
+
+// EnsureProductIndexes creates the indexes keeping product and variant SKUs unique.
+func EnsureProductIndexes(ctx context.Context, prodCollection *mongo.Collection) error {
+	_, err := prodCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "sku", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"sku": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys:    bson.D{{Key: "variants.sku", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"variants.sku": bson.M{"$type": "string"}}),
+		},
+	})
+	return err
+}
+
+// SetProductVariants replaces the options and variants of a product. Variants keep their
+// ids when they are sent back with them, so carts and orders still point at them.
+func SetProductVariants(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, productOptions []models.ProductOption, variants []models.Variant) (*models.Product, error) {
+	product := &models.Product{Product_ID: productID, Options: productOptions, Variants: variants}
+	if err := catalog.PrepareVariants(product); err != nil {
+		return nil, err
+	}
+
+	update := bson.M{"$set": bson.M{"options": product.Options, "variants": product.Variants}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := prodCollection.FindOneAndUpdate(ctx, bson.M{"_id": productID}, update, opts).Decode(product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindProduct
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrSkuTaken
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateProduct
+	}
+	return product, nil
+}
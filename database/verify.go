@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrVerificationStale = errors.New("this verification link is for an address the account no longer uses")
+	ErrAlreadyVerified   = errors.New("the email address is already verified")
+	ErrResendTooSoon     = errors.New("a verification email was sent recently, check your inbox or try again later")
+	ErrCantVerifyEmail   = errors.New("can't verify the email address")
+)
+
+// MarkEmailVerified marks the address of the user as verified, provided it is still the
+// address the verification was sent to.
+func MarkEmailVerified(ctx context.Context, userCollection *mongo.Collection, userID, email string) error {
+	now := time.Now()
+	filter := bson.M{"user_id": userID, "email": email}
+	update := bson.M{"$set": bson.M{"email_verified": true, "email_verified_at": now}, "$unset": bson.M{"verification_sent_at": ""}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantVerifyEmail
+	}
+	if result.MatchedCount == 0 {
+		return ErrVerificationStale
+	}
+	return nil
+}
+
+// ClaimVerificationResend records that a new verification email is about to be sent to the
+// user, at most once per cooldown, and returns the user to send it to.
+func ClaimVerificationResend(ctx context.Context, userCollection *mongo.Collection, userID string, cooldown time.Duration) (*models.User, error) {
+	now := time.Now()
+	filter := bson.M{
+		"user_id":        userID,
+		"email_verified": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{"verification_sent_at": bson.M{"$exists": false}},
+			bson.M{"verification_sent_at": bson.M{"$lte": now.Add(-cooldown)}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"verification_sent_at": now}}
+
+	var user models.User
+	err := userCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return nil, ErrCantVerifyEmail
+	}
+
+	// tell apart a verified user from one who asked too soon
+	if err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user); err != nil {
+		return nil, ErrUserIdsNotValid
+	}
+	if user.Email_Verified {
+		return nil, ErrAlreadyVerified
+	}
+	return nil, ErrResendTooSoon
+}
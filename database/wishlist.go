@@ -0,0 +1,267 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/catalog"
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindWishlist     = errors.New("can't find the wishlist")
+	ErrCantSaveWishlist     = errors.New("can't save the wishlist")
+	ErrWishlistNameTaken    = errors.New("you already have a wishlist with this name")
+	ErrCantFindWishlistItem = errors.New("can't find the item on the wishlist")
+	ErrNotInCart            = errors.New("this product is not in the cart")
+)
+
+// EnsureWishlistIndexes creates the indexes keeping list names unique per user and
+// looking up shared lists by token.
+func EnsureWishlistIndexes(ctx context.Context, wishlistCollection *mongo.Collection) error {
+	_, err := wishlistCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "share_token", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	})
+	return err
+}
+
+// CreateWishlist creates an empty wishlist for the user.
+func CreateWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID, name string) (*models.Wishlist, error) {
+	now := time.Now()
+	wishlist := models.Wishlist{
+		Wishlist_ID: primitive.NewObjectID(),
+		User_ID:     userID,
+		Name:        name,
+		Items:       make([]models.WishlistItem, 0),
+		Created_At:  now,
+		Updated_At:  now,
+	}
+	_, err := wishlistCollection.InsertOne(ctx, wishlist)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrWishlistNameTaken
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveWishlist
+	}
+	return &wishlist, nil
+}
+
+// ListWishlists returns the wishlists of a user, oldest first.
+func ListWishlists(ctx context.Context, wishlistCollection *mongo.Collection, userID string) ([]models.Wishlist, error) {
+	cursor, err := wishlistCollection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindWishlist
+	}
+	wishlists := make([]models.Wishlist, 0)
+	if err := cursor.All(ctx, &wishlists); err != nil {
+		log.Println(err)
+		return nil, ErrCantFindWishlist
+	}
+	return wishlists, nil
+}
+
+// FindWishlist returns a wishlist of the user.
+func FindWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) (*models.Wishlist, error) {
+	return findWishlist(ctx, wishlistCollection, bson.M{"_id": wishlistID, "user_id": userID})
+}
+
+// FindSharedWishlist returns the wishlist shared under the token.
+func FindSharedWishlist(ctx context.Context, wishlistCollection *mongo.Collection, token string) (*models.Wishlist, error) {
+	if token == "" {
+		return nil, ErrCantFindWishlist
+	}
+	return findWishlist(ctx, wishlistCollection, bson.M{"share_token": token})
+}
+
+func findWishlist(ctx context.Context, wishlistCollection *mongo.Collection, filter bson.M) (*models.Wishlist, error) {
+	var wishlist models.Wishlist
+	err := wishlistCollection.FindOne(ctx, filter).Decode(&wishlist)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindWishlist
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindWishlist
+	}
+	return &wishlist, nil
+}
+
+// DeleteWishlist deletes a wishlist of the user.
+func DeleteWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) error {
+	result, err := wishlistCollection.DeleteOne(ctx, bson.M{"_id": wishlistID, "user_id": userID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveWishlist
+	}
+	if result.DeletedCount == 0 {
+		return ErrCantFindWishlist
+	}
+	return nil
+}
+
+// AddWishlistItem saves a product, or one of its variants, on a wishlist at its current price.
+// Sold out products can be saved; saving the same product twice keeps the first entry.
+func AddWishlistItem(ctx context.Context, prodCollection, wishlistCollection *mongo.Collection, userID string, wishlistID, productID primitive.ObjectID, variantID *primitive.ObjectID) (*models.Wishlist, error) {
+	var product models.Product
+	err := prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindProduct
+	}
+	entry, err := catalog.CartItem(&product, variantID)
+	if err != nil && err != catalog.ErrOutOfStock {
+		return nil, err
+	}
+
+	item := models.WishlistItem{
+		Item_ID:     primitive.NewObjectID(),
+		Product_ID:  productID,
+		Variant_ID:  variantID,
+		Price_Added: entry.Price,
+		Added_At:    time.Now(),
+	}
+	filter := bson.M{
+		"_id":     wishlistID,
+		"user_id": userID,
+		"items":   bson.M{"$not": bson.M{"$elemMatch": bson.M{"product_id": productID, "variant_id": variantID}}},
+	}
+	update := bson.M{"$push": bson.M{"items": item}, "$set": bson.M{"updated_at": item.Added_At}}
+	if _, err := wishlistCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveWishlist
+	}
+	return FindWishlist(ctx, wishlistCollection, userID, wishlistID)
+}
+
+// RemoveWishlistItem takes an item off a wishlist.
+func RemoveWishlistItem(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID, itemID primitive.ObjectID) error {
+	filter := bson.M{"_id": wishlistID, "user_id": userID, "items._id": itemID}
+	update := bson.M{"$pull": bson.M{"items": bson.M{"_id": itemID}}, "$set": bson.M{"updated_at": time.Now()}}
+	result, err := wishlistCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveWishlist
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindWishlistItem
+	}
+	return nil
+}
+
+// MoveCartItemToWishlist saves a product of the cart on a wishlist and takes it out of the cart.
+func MoveCartItemToWishlist(ctx context.Context, prodCollection, userCollection, wishlistCollection *mongo.Collection, userID string, wishlistID, productID primitive.ObjectID, variantID *primitive.ObjectID) (*models.Wishlist, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrUserIdsNotValid
+	}
+	inCart := bson.M{"_id": productID}
+	if variantID != nil {
+		inCart["variant_id"] = *variantID
+	}
+	count, err := userCollection.CountDocuments(ctx, bson.M{"_id": id, "usercart": bson.M{"$elemMatch": inCart}})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	if count == 0 {
+		return nil, ErrNotInCart
+	}
+	if _, err := FindWishlist(ctx, wishlistCollection, userID, wishlistID); err != nil {
+		return nil, err
+	}
+
+	wishlist, err := AddWishlistItem(ctx, prodCollection, wishlistCollection, userID, wishlistID, productID, variantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := RemoveCartItem(ctx, prodCollection, userCollection, productID, variantID, userID); err != nil {
+		return nil, err
+	}
+	return wishlist, nil
+}
+
+// MoveWishlistItemToCart puts one unit of a saved item in the cart and takes it off the wishlist.
+func MoveWishlistItemToCart(ctx context.Context, prodCollection, userCollection, wishlistCollection *mongo.Collection, userID string, wishlistID, itemID primitive.ObjectID) error {
+	wishlist, err := FindWishlist(ctx, wishlistCollection, userID, wishlistID)
+	if err != nil {
+		return err
+	}
+	var item *models.WishlistItem
+	for i := range wishlist.Items {
+		if wishlist.Items[i].Item_ID == itemID {
+			item = &wishlist.Items[i]
+		}
+	}
+	if item == nil {
+		return ErrCantFindWishlistItem
+	}
+
+	if err := AddProductToCart(ctx, prodCollection, userCollection, item.Product_ID, item.Variant_ID, userID); err != nil {
+		return err
+	}
+	return RemoveWishlistItem(ctx, wishlistCollection, userID, wishlistID, itemID)
+}
+
+// ShareWishlist gives a wishlist a share token, keeping the existing one if it was already shared.
+func ShareWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) (*models.Wishlist, error) {
+	token, err := newShareToken()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveWishlist
+	}
+	filter := bson.M{"_id": wishlistID, "user_id": userID, "share_token": bson.M{"$exists": false}}
+	if _, err := wishlistCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"share_token": token}}); err != nil {
+		log.Println(err)
+		return nil, ErrCantSaveWishlist
+	}
+	return FindWishlist(ctx, wishlistCollection, userID, wishlistID)
+}
+
+// UnshareWishlist revokes the share token of a wishlist, breaking the links handed out.
+func UnshareWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) error {
+	result, err := wishlistCollection.UpdateOne(ctx, bson.M{"_id": wishlistID, "user_id": userID}, bson.M{"$unset": bson.M{"share_token": ""}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveWishlist
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindWishlist
+	}
+	return nil
+}
+
+// FlagWishlist fills in the current name, image, price and stock of the items of a wishlist.
+func FlagWishlist(ctx context.Context, prodCollection *mongo.Collection, wishlist *models.Wishlist) error {
+	productIDs := make([]primitive.ObjectID, 0, len(wishlist.Items))
+	for _, item := range wishlist.Items {
+		productIDs = append(productIDs, item.Product_ID)
+	}
+	byID, err := productsByID(ctx, prodCollection, productIDs)
+	if err != nil {
+		return err
+	}
+	for i := range wishlist.Items {
+		catalog.FlagWishlistItem(&wishlist.Items[i], byID[wishlist.Items[i].Product_ID])
+	}
+	return nil
+}
+
+// newShareToken returns an unguessable url safe token.
+func newShareToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
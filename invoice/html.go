@@ -0,0 +1,71 @@
+package invoice
+
+import (
+	"html/template"
+	"io"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+var htmlTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"money":   Money,
+	"percent": Percent,
+	"title":   Title,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{title .}} {{.Reference}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 13px; color: #222; margin: 40px; }
+h1 { font-size: 22px; margin: 0 0 4px; }
+.parties { display: flex; justify-content: space-between; margin: 24px 0; }
+.parties div { width: 45%; }
+table { width: 100%; border-collapse: collapse; margin-top: 16px; }
+th, td { padding: 6px 8px; border-bottom: 1px solid #ddd; text-align: left; }
+td.num, th.num { text-align: right; }
+.totals td { border: none; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<h1>{{title .}} {{.Reference}}</h1>
+<div class="muted">Issued {{.Issued_At.Format "2006-01-02"}} &middot; Order {{.Order_ID.Hex}} placed {{.Ordered_At.Format "2006-01-02"}}</div>
+{{if .Reason}}<p>Reason: {{.Reason}}</p>{{end}}
+<div class="parties">
+<div><strong>Seller</strong><br>{{.Seller.Name}}<br>{{range .Seller.Address}}{{.}}<br>{{end}}{{if .Seller.Email}}{{.Seller.Email}}<br>{{end}}{{if .Seller.Tax_ID}}Tax ID: {{.Seller.Tax_ID}}{{end}}</div>
+<div><strong>Bill to</strong><br>{{.Buyer.Name}}<br>{{range .Buyer.Address}}{{.}}<br>{{end}}{{if .Buyer.Email}}{{.Buyer.Email}}<br>{{end}}{{if .Buyer.Phone}}{{.Buyer.Phone}}{{end}}</div>
+</div>
+<table>
+<thead><tr><th>Description</th><th class="num">Qty</th><th class="num">Tax rate</th><th class="num">Net</th><th class="num">Tax</th><th class="num">Total</th></tr></thead>
+<tbody>
+{{range .Lines}}<tr><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{percent .Basis_Points}}</td><td class="num">{{money .Net}}</td><td class="num">{{money .Tax}}</td><td class="num">{{money .Gross}}</td></tr>
+{{end}}</tbody>
+</table>
+<table>
+<thead><tr><th>Tax</th><th class="num">Rate</th><th class="num">Taxable</th><th class="num">Tax</th></tr></thead>
+<tbody>
+{{range .Tax_Totals}}<tr><td>{{if .Jurisdiction}}{{.Jurisdiction}}{{else}}No tax{{end}}</td><td class="num">{{percent .Basis_Points}}</td><td class="num">{{money .Net}}</td><td class="num">{{money .Tax}}</td></tr>
+{{end}}</tbody>
+</table>
+<table class="totals">
+<tr><td class="num">Net</td><td class="num">{{money .Net}}</td></tr>
+<tr><td class="num">Tax</td><td class="num">{{money .Tax}}</td></tr>
+<tr><td class="num"><strong>Total</strong></td><td class="num"><strong>{{money .Gross}}</strong></td></tr>
+</table>
+</body>
+</html>
+`))
+
+// Title names the kind of document, for headings and file names.
+func Title(invoice *models.Invoice) string {
+	if invoice.Kind == models.CreditNoteKind {
+		return "Credit Note"
+	}
+	return "Invoice"
+}
+
+// RenderHTML writes the invoice as a standalone HTML page.
+func RenderHTML(w io.Writer, invoice *models.Invoice) error {
+	return htmlTemplate.Execute(w, invoice)
+}
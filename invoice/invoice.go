@@ -0,0 +1,314 @@
+package invoice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNothingToCredit = errors.New("everything on this invoice was already credited")
+	ErrNotInvoiced     = errors.New("the refund holds a product that is not left on the invoice")
+)
+
+// StoreID identifies the store the invoices are numbered for. Every store keeps
+// its own gap-free series of invoice and credit note numbers.
+var StoreID = envOr("STORE_ID", "default")
+
+// Seller returns the store details printed on every invoice.
+func Seller() models.Party {
+	party := models.Party{
+		Name:   envOr("STORE_NAME", "Golang E-Commerce"),
+		Email:  os.Getenv("STORE_EMAIL"),
+		Phone:  os.Getenv("STORE_PHONE"),
+		Tax_ID: os.Getenv("STORE_TAX_ID"),
+	}
+	// address lines are separated by | so they fit in a single variable
+	for _, line := range strings.Split(os.Getenv("STORE_ADDRESS"), "|") {
+		if line = strings.TrimSpace(line); line != "" {
+			party.Address = append(party.Address, line)
+		}
+	}
+	return party
+}
+
+// Reference formats the printed number of an invoice, such as INV-default-000042.
+func Reference(kind, storeID string, number int64) string {
+	prefix := "INV"
+	if kind == models.CreditNoteKind {
+		prefix = "CN"
+	}
+	return fmt.Sprintf("%s-%s-%06d", prefix, storeID, number)
+}
+
+// Money formats an amount held in minor units, such as 1999 as 19.99.
+func Money(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// Percent formats a rate in basis points, such as 2000 as 20%.
+func Percent(basisPoints int) string {
+	if basisPoints%100 == 0 {
+		return fmt.Sprintf("%d%%", basisPoints/100)
+	}
+	return strings.TrimRight(fmt.Sprintf("%.2f", float64(basisPoints)/100), "0") + "%"
+}
+
+// Build prepares the invoice of an order. The number is assigned when the
+// invoice is issued.
+func Build(order *models.Order, user *models.User) *models.Invoice {
+	invoice := &models.Invoice{
+		Invoice_ID: primitive.NewObjectID(),
+		Kind:       models.InvoiceKind,
+		Store_ID:   StoreID,
+		Order_ID:   order.Order_ID,
+		User_ID:    user.User_ID,
+		Issued_At:  time.Now(),
+		Ordered_At: order.Ordered_At,
+		Seller:     Seller(),
+		Buyer:      buyer(order, user),
+		Lines:      orderLines(order),
+	}
+	if order.Tax != nil {
+		invoice.Include_Tax = order.Tax.Prices_Include_Tax
+	}
+	total(invoice)
+	return invoice
+}
+
+// Refund selects what a credit note gives back. Every entry of Product_IDs
+// refunds one unit; an empty selection refunds whatever is left on the invoice.
+type Refund struct {
+	Product_IDs []primitive.ObjectID
+	Shipping    bool
+	Reason      string
+}
+
+// CreditNote prepares a credit note for the refund, taking the credit notes
+// already issued against the invoice into account.
+func CreditNote(invoice *models.Invoice, previous []models.Invoice, refund Refund) (*models.Invoice, error) {
+	// what is left of every line once earlier credit notes are deducted
+	remaining := make([]models.InvoiceLine, len(invoice.Lines))
+	copy(remaining, invoice.Lines)
+	for _, note := range previous {
+		for _, credited := range note.Lines {
+			for i := range remaining {
+				if sameLine(remaining[i], credited) {
+					remaining[i].Quantity += credited.Quantity
+					remaining[i].Net += credited.Net
+					remaining[i].Tax += credited.Tax
+					remaining[i].Gross += credited.Gross
+					break
+				}
+			}
+		}
+	}
+
+	// how many units of every line are refunded
+	units := make([]int, len(remaining))
+	if len(refund.Product_IDs) == 0 && !refund.Shipping {
+		for i := range remaining {
+			units[i] = remaining[i].Quantity
+		}
+	}
+	for _, productID := range refund.Product_IDs {
+		i := lineOf(remaining, units, productID)
+		if i < 0 {
+			return nil, ErrNotInvoiced
+		}
+		units[i]++
+	}
+	if refund.Shipping {
+		i := lineOf(remaining, units, primitive.NilObjectID)
+		if i < 0 {
+			return nil, ErrNotInvoiced
+		}
+		units[i] = remaining[i].Quantity
+	}
+
+	note := &models.Invoice{
+		Invoice_ID:  primitive.NewObjectID(),
+		Kind:        models.CreditNoteKind,
+		Store_ID:    invoice.Store_ID,
+		Order_ID:    invoice.Order_ID,
+		User_ID:     invoice.User_ID,
+		Credits:     &invoice.Invoice_ID,
+		Reason:      refund.Reason,
+		Issued_At:   time.Now(),
+		Ordered_At:  invoice.Ordered_At,
+		Seller:      Seller(),
+		Buyer:       invoice.Buyer,
+		Include_Tax: invoice.Include_Tax,
+		Lines:       make([]models.InvoiceLine, 0),
+	}
+	for i, line := range remaining {
+		if units[i] == 0 || line.Quantity == 0 {
+			continue
+		}
+		credited := line
+		credited.Quantity = units[i]
+		// the last units take whatever is left so rounding never leaves a remainder
+		if units[i] < line.Quantity {
+			credited.Net = line.Net * units[i] / line.Quantity
+			credited.Tax = line.Tax * units[i] / line.Quantity
+			credited.Gross = credited.Net + credited.Tax
+		}
+		credited.Quantity, credited.Net, credited.Tax, credited.Gross = -credited.Quantity, -credited.Net, -credited.Tax, -credited.Gross
+		note.Lines = append(note.Lines, credited)
+	}
+
+	if len(note.Lines) == 0 {
+		return nil, ErrNothingToCredit
+	}
+	total(note)
+	return note, nil
+}
+
+// FullyCredited tells whether the credit notes give back the whole invoice.
+func FullyCredited(invoice *models.Invoice, notes []models.Invoice) bool {
+	gross := invoice.Gross
+	for _, note := range notes {
+		gross += note.Gross
+	}
+	return gross <= 0
+}
+
+func buyer(order *models.Order, user *models.User) models.Party {
+	party := models.Party{}
+	if user.First_Name != nil && user.Last_Name != nil {
+		party.Name = *user.First_Name + " " + *user.Last_Name
+	}
+	if user.Email != nil {
+		party.Email = *user.Email
+	}
+	if user.Phone != nil {
+		party.Phone = *user.Phone
+	}
+
+	if address := order.Ship_To; address != nil {
+		for _, field := range []*string{address.House, address.Street, address.City, address.PinCode, address.Country} {
+			if field != nil && *field != "" {
+				party.Address = append(party.Address, *field)
+			}
+		}
+	}
+	return party
+}
+
+// orderLines groups the order's units into invoice lines. Orders placed before
+// taxes were calculated are invoiced without tax.
+func orderLines(order *models.Order) []models.InvoiceLine {
+	var taxLines []models.TaxLine
+	if order.Tax != nil {
+		taxLines = order.Tax.Lines
+	} else {
+		for _, item := range order.Order_Cart {
+			description := ""
+			if item.Product_Name != nil {
+				description = *item.Product_Name
+			}
+			taxLines = append(taxLines, models.TaxLine{Product_ID: item.Product_ID, Description: description, Tax_Class: models.TaxStandard, Net: item.Price, Gross: item.Price})
+		}
+		if order.Shipping != nil && order.Shipping.Price > 0 {
+			taxLines = append(taxLines, models.TaxLine{Description: "Shipping", Tax_Class: models.TaxStandard, Net: order.Shipping.Price, Gross: order.Shipping.Price})
+		}
+	}
+
+	lines := make([]models.InvoiceLine, 0, len(taxLines))
+	for _, taxLine := range taxLines {
+		line := models.InvoiceLine{
+			Product_ID:   taxLine.Product_ID,
+			Description:  taxLine.Description,
+			Quantity:     1,
+			Tax_Class:    taxLine.Tax_Class,
+			Jurisdiction: strings.Join(taxLine.Jurisdiction, " + "),
+			Basis_Points: taxLine.Basis_Points,
+			Net:          taxLine.Net,
+			Tax:          taxLine.Tax,
+			Gross:        taxLine.Gross,
+		}
+
+		merged := false
+		for i := range lines {
+			if sameLine(lines[i], line) {
+				lines[i].Quantity++
+				lines[i].Net += line.Net
+				lines[i].Tax += line.Tax
+				lines[i].Gross += line.Gross
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// total sums the lines of the invoice and its breakdown per tax rate.
+func total(invoice *models.Invoice) {
+	invoice.Net, invoice.Tax, invoice.Gross = 0, 0, 0
+	type rateKey struct {
+		basisPoints  int
+		jurisdiction string
+	}
+	byRate := make(map[rateKey]*models.InvoiceTaxTotal)
+	for _, line := range invoice.Lines {
+		invoice.Net += line.Net
+		invoice.Tax += line.Tax
+		invoice.Gross += line.Gross
+
+		key := rateKey{line.Basis_Points, line.Jurisdiction}
+		rate, ok := byRate[key]
+		if !ok {
+			rate = &models.InvoiceTaxTotal{Basis_Points: line.Basis_Points, Jurisdiction: line.Jurisdiction}
+			byRate[key] = rate
+		}
+		rate.Net += line.Net
+		rate.Tax += line.Tax
+	}
+
+	invoice.Tax_Totals = make([]models.InvoiceTaxTotal, 0, len(byRate))
+	for _, rate := range byRate {
+		invoice.Tax_Totals = append(invoice.Tax_Totals, *rate)
+	}
+	sort.Slice(invoice.Tax_Totals, func(i, j int) bool {
+		if invoice.Tax_Totals[i].Basis_Points != invoice.Tax_Totals[j].Basis_Points {
+			return invoice.Tax_Totals[i].Basis_Points > invoice.Tax_Totals[j].Basis_Points
+		}
+		return invoice.Tax_Totals[i].Jurisdiction < invoice.Tax_Totals[j].Jurisdiction
+	})
+}
+
+// lineOf finds a line of the product that still has units left to refund.
+func lineOf(lines []models.InvoiceLine, units []int, productID primitive.ObjectID) int {
+	for i, line := range lines {
+		if line.Product_ID == productID && units[i] < line.Quantity {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameLine(a, b models.InvoiceLine) bool {
+	return a.Product_ID == b.Product_ID && a.Description == b.Description && a.Tax_Class == b.Tax_Class &&
+		a.Jurisdiction == b.Jurisdiction && a.Basis_Points == b.Basis_Points
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,257 @@
+package invoice
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	mug   = primitive.NewObjectID()
+	shirt = primitive.NewObjectID()
+)
+
+// testInvoice is an invoice of 3 mugs at 10.00 + 20% tax, a shirt at 25.00 + 5% tax and
+// shipping at 4.99 + 20% tax.
+func testInvoice() *models.Invoice {
+	invoice := &models.Invoice{
+		Invoice_ID: primitive.NewObjectID(),
+		Kind:       models.InvoiceKind,
+		Lines: []models.InvoiceLine{
+			{Product_ID: mug, Description: "Mug", Quantity: 3, Basis_Points: 2000, Jurisdiction: "GB", Net: 3000, Tax: 600, Gross: 3600},
+			{Product_ID: shirt, Description: "Shirt", Quantity: 1, Basis_Points: 500, Jurisdiction: "GB", Net: 2500, Tax: 125, Gross: 2625},
+			{Description: "Shipping", Quantity: 1, Basis_Points: 2000, Jurisdiction: "GB", Net: 499, Tax: 100, Gross: 599},
+		},
+	}
+	total(invoice)
+	return invoice
+}
+
+// credits issues the refunds one after the other, failing the test at the first refused one.
+func credits(t *testing.T, invoice *models.Invoice, refunds ...Refund) []models.Invoice {
+	t.Helper()
+	var notes []models.Invoice
+	for _, refund := range refunds {
+		note, err := CreditNote(invoice, notes, refund)
+		if err != nil {
+			t.Fatalf("refund %+v: %v", refund, err)
+		}
+		notes = append(notes, *note)
+	}
+	return notes
+}
+
+func TestCreditNote(t *testing.T) {
+	tests := []struct {
+		name   string
+		refund Refund
+		lines  []models.InvoiceLine
+	}{
+		{
+			"one unit",
+			Refund{Product_IDs: []primitive.ObjectID{mug}},
+			[]models.InvoiceLine{{Product_ID: mug, Description: "Mug", Quantity: -1, Basis_Points: 2000, Jurisdiction: "GB", Net: -1000, Tax: -200, Gross: -1200}},
+		},
+		{
+			"units of two lines and the shipping",
+			Refund{Product_IDs: []primitive.ObjectID{mug, shirt, mug}, Shipping: true},
+			[]models.InvoiceLine{
+				{Product_ID: mug, Description: "Mug", Quantity: -2, Basis_Points: 2000, Jurisdiction: "GB", Net: -2000, Tax: -400, Gross: -2400},
+				{Product_ID: shirt, Description: "Shirt", Quantity: -1, Basis_Points: 500, Jurisdiction: "GB", Net: -2500, Tax: -125, Gross: -2625},
+				{Description: "Shipping", Quantity: -1, Basis_Points: 2000, Jurisdiction: "GB", Net: -499, Tax: -100, Gross: -599},
+			},
+		},
+		{
+			"everything",
+			Refund{},
+			[]models.InvoiceLine{
+				{Product_ID: mug, Description: "Mug", Quantity: -3, Basis_Points: 2000, Jurisdiction: "GB", Net: -3000, Tax: -600, Gross: -3600},
+				{Product_ID: shirt, Description: "Shirt", Quantity: -1, Basis_Points: 500, Jurisdiction: "GB", Net: -2500, Tax: -125, Gross: -2625},
+				{Description: "Shipping", Quantity: -1, Basis_Points: 2000, Jurisdiction: "GB", Net: -499, Tax: -100, Gross: -599},
+			},
+		},
+	}
+	for _, test := range tests {
+		invoice := testInvoice()
+		note, err := CreditNote(invoice, nil, test.refund)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(note.Lines, test.lines) {
+			t.Errorf("%s: lines = %+v, want %+v", test.name, note.Lines, test.lines)
+		}
+		if note.Kind != models.CreditNoteKind || note.Credits == nil || *note.Credits != invoice.Invoice_ID {
+			t.Errorf("%s: the note is a %s crediting %v, want a credit note of the invoice", test.name, note.Kind, note.Credits)
+		}
+	}
+}
+
+// Refunding the units of a line one at a time credits exactly the line, the last unit taking
+// what rounding left over.
+func TestCreditNoteRounding(t *testing.T) {
+	invoice := &models.Invoice{Invoice_ID: primitive.NewObjectID(), Lines: []models.InvoiceLine{
+		{Product_ID: mug, Description: "Mug", Quantity: 3, Basis_Points: 2000, Net: 1000, Tax: 200, Gross: 1200},
+	}}
+	total(invoice)
+
+	one := Refund{Product_IDs: []primitive.ObjectID{mug}}
+	notes := credits(t, invoice, one, one, one)
+	var net, tax []int
+	for _, note := range notes {
+		net, tax = append(net, -note.Net), append(tax, -note.Tax)
+		if note.Gross != note.Net+note.Tax {
+			t.Errorf("gross %d isn't net %d + tax %d", note.Gross, note.Net, note.Tax)
+		}
+	}
+	if want := []int{333, 333, 334}; !reflect.DeepEqual(net, want) {
+		t.Errorf("credited net %v, want %v", net, want)
+	}
+	if want := []int{66, 67, 67}; !reflect.DeepEqual(tax, want) {
+		t.Errorf("credited tax %v, want %v", tax, want)
+	}
+	if !FullyCredited(invoice, notes) {
+		t.Error("the invoice isn't fully credited")
+	}
+}
+
+func TestCreditNoteRefused(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous []Refund
+		refund   Refund
+		err      error
+	}{
+		{"more units than invoiced", nil, Refund{Product_IDs: []primitive.ObjectID{shirt, shirt}}, ErrNotInvoiced},
+		{"a product not invoiced", nil, Refund{Product_IDs: []primitive.ObjectID{primitive.NewObjectID()}}, ErrNotInvoiced},
+		{"units already credited", []Refund{{Product_IDs: []primitive.ObjectID{mug, mug}}}, Refund{Product_IDs: []primitive.ObjectID{mug, mug}}, ErrNotInvoiced},
+		{"shipping already credited", []Refund{{Shipping: true}}, Refund{Shipping: true}, ErrNotInvoiced},
+		{"everything already credited", []Refund{{Product_IDs: []primitive.ObjectID{mug}}, {}}, Refund{}, ErrNothingToCredit},
+	}
+	for _, test := range tests {
+		invoice := testInvoice()
+		notes := credits(t, invoice, test.previous...)
+		if _, err := CreditNote(invoice, notes, test.refund); !errors.Is(err, test.err) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+// What is left after a partial refund is what a refund of everything credits.
+func TestCreditNoteRemainder(t *testing.T) {
+	invoice := testInvoice()
+	notes := credits(t, invoice, Refund{Product_IDs: []primitive.ObjectID{mug}, Shipping: true})
+	if FullyCredited(invoice, notes) {
+		t.Fatal("a partial refund fully credits the invoice")
+	}
+
+	rest, err := CreditNote(invoice, notes, Refund{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.InvoiceLine{
+		{Product_ID: mug, Description: "Mug", Quantity: -2, Basis_Points: 2000, Jurisdiction: "GB", Net: -2000, Tax: -400, Gross: -2400},
+		{Product_ID: shirt, Description: "Shirt", Quantity: -1, Basis_Points: 500, Jurisdiction: "GB", Net: -2500, Tax: -125, Gross: -2625},
+	}
+	if !reflect.DeepEqual(rest.Lines, want) {
+		t.Errorf("lines = %+v, want %+v", rest.Lines, want)
+	}
+	if !FullyCredited(invoice, append(notes, *rest)) {
+		t.Error("the invoice isn't fully credited")
+	}
+	if rest.Gross+notes[0].Gross != -invoice.Gross || rest.Tax+notes[0].Tax != -invoice.Tax {
+		t.Errorf("credited %d with %d tax, want the invoice's %d with %d tax", -rest.Gross-notes[0].Gross, -rest.Tax-notes[0].Tax, invoice.Gross, invoice.Tax)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	name := "Mug"
+	first, last, email := "Ana", "Silva", "ana@example.com"
+	street, city := "Main Street", "Springfield"
+	user := &models.User{User_ID: "user", First_Name: &first, Last_Name: &last, Email: &email}
+
+	tests := []struct {
+		name   string
+		order  *models.Order
+		lines  []models.InvoiceLine
+		totals []models.InvoiceTaxTotal
+	}{
+		{
+			"taxed order",
+			&models.Order{Tax: &models.TaxSummary{Prices_Include_Tax: true, Lines: []models.TaxLine{
+				{Product_ID: mug, Description: "Mug", Tax_Class: models.TaxStandard, Jurisdiction: []string{"US-CA", "US-CA-LA"}, Basis_Points: 950, Net: 1000, Tax: 95, Gross: 1095},
+				{Product_ID: mug, Description: "Mug", Tax_Class: models.TaxStandard, Jurisdiction: []string{"US-CA", "US-CA-LA"}, Basis_Points: 950, Net: 1000, Tax: 95, Gross: 1095},
+				{Product_ID: shirt, Description: "Shirt", Tax_Class: models.TaxStandard, Jurisdiction: []string{"US-CA"}, Basis_Points: 725, Net: 2000, Tax: 145, Gross: 2145},
+				{Description: "Shipping", Tax_Class: models.TaxStandard, Net: 500, Gross: 500},
+			}}},
+			[]models.InvoiceLine{
+				{Product_ID: mug, Description: "Mug", Quantity: 2, Tax_Class: models.TaxStandard, Jurisdiction: "US-CA + US-CA-LA", Basis_Points: 950, Net: 2000, Tax: 190, Gross: 2190},
+				{Product_ID: shirt, Description: "Shirt", Quantity: 1, Tax_Class: models.TaxStandard, Jurisdiction: "US-CA", Basis_Points: 725, Net: 2000, Tax: 145, Gross: 2145},
+				{Description: "Shipping", Quantity: 1, Tax_Class: models.TaxStandard, Net: 500, Gross: 500},
+			},
+			[]models.InvoiceTaxTotal{
+				{Basis_Points: 950, Jurisdiction: "US-CA + US-CA-LA", Net: 2000, Tax: 190},
+				{Basis_Points: 725, Jurisdiction: "US-CA", Net: 2000, Tax: 145},
+				{Basis_Points: 0, Jurisdiction: "", Net: 500, Tax: 0},
+			},
+		},
+		{
+			"order placed before taxes",
+			&models.Order{
+				Order_Cart: []models.ProductUser{{Product_ID: mug, Product_Name: &name, Price: 1000}, {Product_ID: mug, Product_Name: &name, Price: 1000}},
+				Shipping:   &models.ShippingQuote{Price: 499},
+				Ship_To:    &models.Address{Street: &street, City: &city},
+			},
+			[]models.InvoiceLine{
+				{Product_ID: mug, Description: "Mug", Quantity: 2, Tax_Class: models.TaxStandard, Net: 2000, Gross: 2000},
+				{Description: "Shipping", Quantity: 1, Tax_Class: models.TaxStandard, Net: 499, Gross: 499},
+			},
+			[]models.InvoiceTaxTotal{{Net: 2499}},
+		},
+	}
+	for _, test := range tests {
+		invoice := Build(test.order, user)
+		if !reflect.DeepEqual(invoice.Lines, test.lines) {
+			t.Errorf("%s: lines = %+v, want %+v", test.name, invoice.Lines, test.lines)
+		}
+		if !reflect.DeepEqual(invoice.Tax_Totals, test.totals) {
+			t.Errorf("%s: tax totals = %+v, want %+v", test.name, invoice.Tax_Totals, test.totals)
+		}
+		net, tax := 0, 0
+		for _, line := range test.lines {
+			net, tax = net+line.Net, tax+line.Tax
+		}
+		if invoice.Net != net || invoice.Tax != tax || invoice.Gross != net+tax {
+			t.Errorf("%s: totals %d + %d = %d, want %d + %d", test.name, invoice.Net, invoice.Tax, invoice.Gross, net, tax)
+		}
+		if invoice.Include_Tax != (test.order.Tax != nil) {
+			t.Errorf("%s: prices include tax = %v", test.name, invoice.Include_Tax)
+		}
+	}
+
+	invoice := Build(tests[1].order, user)
+	if want := (models.Party{Name: "Ana Silva", Email: email, Address: []string{street, city}}); !reflect.DeepEqual(invoice.Buyer, want) {
+		t.Errorf("buyer = %+v, want %+v", invoice.Buyer, want)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct{ got, want string }{
+		{Reference(models.InvoiceKind, "eu", 42), "INV-eu-000042"},
+		{Reference(models.CreditNoteKind, "eu", 1234567), "CN-eu-1234567"},
+		{Money(1999), "19.99"},
+		{Money(5), "0.05"},
+		{Money(-1205), "-12.05"},
+		{Percent(2000), "20%"},
+		{Percent(725), "7.25%"},
+		{Percent(950), "9.5%"},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("got %s, want %s", test.got, test.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+// A4 page size and margins in points.
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	margin     = 50.0
+)
+
+// Fonts are the standard Helvetica faces every PDF reader ships, so nothing has to be embedded.
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+)
+
+// helveticaWidths holds the advance widths of the printable ASCII characters in
+// thousandths of the font size, from the Helvetica AFM metrics.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// winAnsi maps the characters outside Latin-1 that WinAnsiEncoding can still print.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfWriter lays out text and rules on A4 pages and serialises them as a PDF.
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64
+}
+
+func newPDFWriter() *pdfWriter {
+	p := &pdfWriter{}
+	p.newPage()
+	return p
+}
+
+func (p *pdfWriter) newPage() {
+	p.page = &bytes.Buffer{}
+	p.pages = append(p.pages, p.page)
+	p.y = pageHeight - margin
+}
+
+// ensure starts a new page when fewer than height points are left on this one.
+func (p *pdfWriter) ensure(height float64) {
+	if p.y-height < margin {
+		p.newPage()
+	}
+}
+
+func (p *pdfWriter) text(x, y float64, font string, size float64, s string) {
+	fmt.Fprintf(p.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escapePDF(s))
+}
+
+func (p *pdfWriter) textRight(right, y float64, font string, size float64, s string) {
+	p.text(right-textWidth(s, size), y, font, size, s)
+}
+
+func (p *pdfWriter) rule(y float64) {
+	fmt.Fprintf(p.page, "0.8 G 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n", margin, y, pageWidth-margin, y)
+}
+
+// writeTo serialises the pages with the object table readers need to find them.
+func (p *pdfWriter) writeTo(w io.Writer) error {
+	var out bytes.Buffer
+	offsets := make([]int, 0)
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// objects 1 to 4 are the catalog, the page tree and the two fonts; each page then
+	// takes a page object followed by its content stream
+	kids := ""
+	for i := range p.pages {
+		kids += fmt.Sprintf("%d 0 R ", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, len(p.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range p.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, fontRegular, fontBold, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// RenderPDF writes the invoice as a PDF document.
+func RenderPDF(w io.Writer, invoice *models.Invoice) error {
+	p := newPDFWriter()
+
+	// heading
+	p.text(margin, p.y, fontBold, 20, Title(invoice)+" "+invoice.Reference)
+	p.y -= 18
+	p.text(margin, p.y, fontRegular, 9, fmt.Sprintf("Issued %s - Order %s placed %s",
+		invoice.Issued_At.Format("2006-01-02"), invoice.Order_ID.Hex(), invoice.Ordered_At.Format("2006-01-02")))
+	if invoice.Reason != "" {
+		p.y -= 12
+		p.text(margin, p.y, fontRegular, 9, "Reason: "+invoice.Reason)
+	}
+	p.y -= 30
+
+	// seller and buyer side by side
+	seller := partyLines(invoice.Seller, "Tax ID: "+invoice.Seller.Tax_ID)
+	buyer := partyLines(invoice.Buyer, invoice.Buyer.Phone)
+	p.text(margin, p.y, fontBold, 10, "Seller")
+	p.text(pageWidth/2, p.y, fontBold, 10, "Bill to")
+	for i := 0; i < len(seller) || i < len(buyer); i++ {
+		p.y -= 13
+		if i < len(seller) {
+			p.text(margin, p.y, fontRegular, 10, seller[i])
+		}
+		if i < len(buyer) {
+			p.text(pageWidth/2, p.y, fontRegular, 10, buyer[i])
+		}
+	}
+	p.y -= 30
+
+	// line items
+	columns := []float64{330, 385, 445, 495, pageWidth - margin}
+	header := func() {
+		p.text(margin, p.y, fontBold, 10, "Description")
+		for i, title := range []string{"Qty", "Tax rate", "Net", "Tax", "Total"} {
+			p.textRight(columns[i], p.y, fontBold, 10, title)
+		}
+		p.y -= 6
+		p.rule(p.y)
+		p.y -= 14
+	}
+	header()
+	for _, line := range invoice.Lines {
+		if p.y-14 < margin {
+			p.newPage()
+			header()
+		}
+		p.text(margin, p.y, fontRegular, 10, truncate(line.Description, columns[0]-margin-40, 10))
+		for i, value := range []string{fmt.Sprint(line.Quantity), Percent(line.Basis_Points), Money(line.Net), Money(line.Tax), Money(line.Gross)} {
+			p.textRight(columns[i], p.y, fontRegular, 10, value)
+		}
+		p.y -= 14
+	}
+	p.rule(p.y + 8)
+	p.y -= 16
+
+	// tax breakdown
+	p.ensure(30 + 14*float64(len(invoice.Tax_Totals)))
+	p.text(margin, p.y, fontBold, 10, "Tax")
+	for i, title := range []string{"Rate", "Taxable", "Tax"} {
+		p.textRight(columns[i+1], p.y, fontBold, 10, title)
+	}
+	p.y -= 14
+	for _, rate := range invoice.Tax_Totals {
+		jurisdiction := rate.Jurisdiction
+		if jurisdiction == "" {
+			jurisdiction = "No tax"
+		}
+		p.text(margin, p.y, fontRegular, 10, truncate(jurisdiction, columns[0]-margin, 10))
+		for i, value := range []string{Percent(rate.Basis_Points), Money(rate.Net), Money(rate.Tax)} {
+			p.textRight(columns[i+1], p.y, fontRegular, 10, value)
+		}
+		p.y -= 14
+	}
+	p.y -= 16
+
+	// totals
+	p.ensure(50)
+	for _, total := range []struct {
+		label string
+		value int
+		font  string
+	}{{"Net", invoice.Net, fontRegular}, {"Tax", invoice.Tax, fontRegular}, {"Total", invoice.Gross, fontBold}} {
+		p.textRight(columns[3], p.y, total.font, 11, total.label)
+		p.textRight(columns[4], p.y, total.font, 11, Money(total.value))
+		p.y -= 16
+	}
+
+	return p.writeTo(w)
+}
+
+func partyLines(party models.Party, extra string) []string {
+	lines := append([]string{party.Name}, party.Address...)
+	if party.Email != "" {
+		lines = append(lines, party.Email)
+	}
+	if extra != "" && extra != "Tax ID: " {
+		lines = append(lines, extra)
+	}
+	return lines
+}
+
+// textWidth measures a string set in Helvetica at the given size.
+func textWidth(s string, size float64) float64 {
+	width := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			width += helveticaWidths[r-32]
+		} else {
+			width += 556
+		}
+	}
+	return float64(width) * size / 1000
+}
+
+// truncate shortens a string with an ellipsis so it fits in width points.
+func truncate(s string, width, size float64) string {
+	if textWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && textWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// escapePDF encodes a string as the body of a WinAnsi PDF string literal.
+func escapePDF(s string) string {
+	var out bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteByte(byte(r))
+		case r >= 32 && r <= 126:
+			out.WriteByte(byte(r))
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&out, "\\%03o", r)
+		default:
+			if b, ok := winAnsi[r]; ok {
+				fmt.Fprintf(&out, "\\%03o", b)
+			} else {
+				out.WriteByte('?')
+			}
+		}
+	}
+	return out.String()
+}
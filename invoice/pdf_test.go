@@ -0,0 +1,103 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+// parsePDF checks the cross-reference table and the streams of a document and returns its
+// page count.
+func parsePDF(t *testing.T, data []byte) int {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("the document doesn't start with a PDF header or end with an end-of-file marker")
+	}
+
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if match == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point to the xref table", xref)
+	}
+	var size int
+	if _, err := fmt.Sscanf(string(data[xref:]), "xref\n0 %d\n", &size); err != nil {
+		t.Fatal(err)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(data[xref:], -1)
+	if len(entries) != size-1 {
+		t.Fatalf("the xref table lists %d objects, want %d", len(entries), size-1)
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("the xref offset of object %d points to %q", i+1, data[offset:offset+10])
+		}
+	}
+	if !bytes.Contains(data, []byte(fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R >>", size))) {
+		t.Errorf("the trailer doesn't give the size %d", size)
+	}
+
+	streams := regexp.MustCompile(`<< /Length (\d+) >>\nstream\n`).FindAllSubmatchIndex(data, -1)
+	for _, stream := range streams {
+		length, _ := strconv.Atoi(string(data[stream[2]:stream[3]]))
+		if end := stream[1] + length; !bytes.HasPrefix(data[end:], []byte("endstream")) {
+			t.Errorf("a stream of length %d doesn't end with endstream", length)
+		}
+	}
+
+	count := regexp.MustCompile(`/Type /Pages /Kids \[[^\]]*\] /Count (\d+)`).FindSubmatch(data)
+	if count == nil {
+		t.Fatal("no page tree")
+	}
+	pages, _ := strconv.Atoi(string(count[1]))
+	if len(streams) != pages {
+		t.Errorf("%d content streams for %d pages", len(streams), pages)
+	}
+	return pages
+}
+
+func TestRenderPDF(t *testing.T) {
+	invoice := testInvoice()
+	invoice.Reference = "INV-eu-000001"
+	invoice.Issued_At = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	invoice.Seller = models.Party{Name: "Shop (Europe) Ltd", Address: []string{"1 Rue de l'Été"}}
+	invoice.Lines[0].Description = "Mug (blue) 5€ off"
+
+	var out bytes.Buffer
+	if err := RenderPDF(&out, invoice); err != nil {
+		t.Fatal(err)
+	}
+	if pages := parsePDF(t, out.Bytes()); pages != 1 {
+		t.Errorf("%d pages, want 1", pages)
+	}
+	for _, want := range []string{`(Shop \(Europe\) Ltd)`, `Mug \(blue\) 5\200 off`, `Rue de l'\311t\351`} {
+		if !bytes.Contains(out.Bytes(), []byte(want)) {
+			t.Errorf("the document doesn't contain %s", want)
+		}
+	}
+}
+
+func TestRenderPDFPages(t *testing.T) {
+	invoice := testInvoice()
+	for i := 0; i < 120; i++ {
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{Description: strings.Repeat("Item ", i%10+1), Quantity: 1, Net: 100, Gross: 100})
+	}
+	total(invoice)
+
+	var out bytes.Buffer
+	if err := RenderPDF(&out, invoice); err != nil {
+		t.Fatal(err)
+	}
+	if pages := parsePDF(t, out.Bytes()); pages < 2 {
+		t.Errorf("%d pages for %d lines, want more than one", pages, len(invoice.Lines))
+	}
+}
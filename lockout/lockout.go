@@ -0,0 +1,36 @@
+// Package lockout decides how long to refuse logins after repeated failures: a few failures
+// are free, then each one doubles the wait until it reaches a lockout.
+package lockout
+
+import "time"
+
+// Policy is the backoff applied to one kind of key, an account or an IP address.
+type Policy struct {
+	// Free is how many failures in a row are allowed without any wait.
+	Free int
+	// Base is the wait after the first failure over Free; every further failure doubles it.
+	Base time.Duration
+	// Max caps the wait, it is the longest an account or address stays locked.
+	Max time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// Delay returns how long to refuse logins after the given number of failures in a row.
+func (p Policy) Delay(failures int) time.Duration {
+	over := failures - p.Free
+	if over <= 0 {
+		return 0
+	}
+	delay := p.Base
+	for i := 1; i < over; i++ {
+		delay *= 2
+		if delay >= p.Max {
+			return p.Max
+		}
+	}
+	if delay > p.Max {
+		return p.Max
+	}
+	return delay
+}
@@ -0,0 +1,36 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	policy := Policy{Free: 3, Base: time.Second, Max: 10 * time.Second}
+	tests := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{1000, 10 * time.Second},
+	}
+	for _, test := range tests {
+		if delay := policy.Delay(test.failures); delay != test.delay {
+			t.Errorf("Delay(%d) = %v, want %v", test.failures, delay, test.delay)
+		}
+	}
+}
+
+func TestDelayBaseOverMax(t *testing.T) {
+	policy := Policy{Base: time.Minute, Max: time.Second}
+	if delay := policy.Delay(1); delay != time.Second {
+		t.Errorf("Delay(1) = %v, want the maximum %v", delay, time.Second)
+	}
+}
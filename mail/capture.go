@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Capture keeps the emails it is given instead of sending them, so they can be read back
+// in development and tests.
+type Capture struct {
+	mu     sync.Mutex
+	sent   []Email
+	logged bool
+}
+
+// NewCapture returns an empty capture, which also logs every email when logged is set.
+func NewCapture(logged bool) *Capture {
+	return &Capture{logged: logged}
+}
+
+func (c *Capture) Send(ctx context.Context, email Email) error {
+	if err := headerSafe(email.To, email.Subject); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, email)
+	if c.logged {
+		log.Printf("mail to %s: %s\n%s", email.To, email.Subject, email.Text)
+	}
+	return nil
+}
+
+// Sent returns the emails captured so far, oldest first.
+func (c *Capture) Sent() []Email {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Email(nil), c.sent...)
+}
+
+// Last returns the latest email captured for the recipient.
+func (c *Capture) Last(to string) (Email, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.sent) - 1; i >= 0; i-- {
+		if c.sent[i].To == to {
+			return c.sent[i], true
+		}
+	}
+	return Email{}, false
+}
@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTP sends emails through an SMTP server, upgrading to TLS when the server offers
+// STARTTLS. Credentials are only sent when a username is set.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTP) Send(ctx context.Context, email Email) error {
+	if err := headerSafe(email.To, email.Subject); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	address := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+
+	// smtp.SendMail can't be cancelled, so give up waiting on it when the context ends
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(address, auth, s.From, []string{email.To}, message(s.From, email, time.Now()))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
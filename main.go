@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/gin-gonic/gin"
@@ -12,8 +17,11 @@ import (
 	_ "github.com/ravelinejunior/golang_ecommerce/database"
 	"github.com/ravelinejunior/golang_ecommerce/middleware"
 	_ "github.com/ravelinejunior/golang_ecommerce/middleware"
+	"github.com/ravelinejunior/golang_ecommerce/models"
 	"github.com/ravelinejunior/golang_ecommerce/routes"
 	_ "github.com/ravelinejunior/golang_ecommerce/routes"
+	"github.com/ravelinejunior/golang_ecommerce/storage"
+	generate "github.com/ravelinejunior/golang_ecommerce/tokens"
 )
 
 // main is the entry point of the application
@@ -24,16 +32,105 @@ func main() {
 		port = "8000"
 	}
 
+	// refuse to start without a key to sign the tokens with
+	if err := generate.LoadKeys(); err != nil {
+		log.Fatal("could not load the token signing keys: ", err)
+	}
+	// rotate the keys on SIGHUP, keeping the replaced key valid for its grace period
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := generate.LoadKeys(); err != nil {
+				log.Println("could not reload the token signing keys, keeping the current ones:", err)
+				continue
+			}
+			log.Println("reloaded the token signing keys")
+		}
+	}()
+
 	// create a new application instance
 	app := controllers.NewApplication(database.ProductData(database.Client, "Products"), database.UserData(database.Client, "Users"))
 
+	// create the indexes keeping invoice numbers gap-free and slugs, skus and reviews unique. The
+	// unique indexes are all that enforces those rules, so the application doesn't start without
+	// them; the others only speed up lookups or expire documents
+	indexCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// emails are unique once lowercased, so the ones stored before that must be lowercased first
+	if err := database.NormalizeUserEmails(indexCtx, controllers.UserCollection); err != nil {
+		log.Fatal("could not normalize the user emails: ", err)
+	}
+	if err := database.EnsureUserIndexes(indexCtx, controllers.UserCollection); err != nil {
+		log.Fatal("could not create the user indexes: ", err)
+	}
+	if err := database.EnsureInvoiceIndexes(indexCtx, controllers.InvoiceCollection); err != nil {
+		log.Fatal("could not create the invoice indexes: ", err)
+	}
+	if err := database.EnsureCategoryIndexes(indexCtx, controllers.CategoryCollection); err != nil {
+		log.Fatal("could not create the category indexes: ", err)
+	}
+	if err := database.EnsureProductIndexes(indexCtx, controllers.ProductCollection); err != nil {
+		log.Fatal("could not create the product indexes: ", err)
+	}
+	if err := database.EnsureReviewIndexes(indexCtx, controllers.ReviewCollection); err != nil {
+		log.Fatal("could not create the review indexes: ", err)
+	}
+	if err := database.EnsureWishlistIndexes(indexCtx, controllers.WishlistCollection); err != nil {
+		log.Fatal("could not create the wishlist indexes: ", err)
+	}
+	if err := database.EnsureGuestCartIndexes(indexCtx, controllers.GuestCartCollection, generate.CartTokenLifetime); err != nil {
+		log.Println("could not create the guest cart indexes:", err)
+	}
+	if err := database.EnsureCouponIndexes(indexCtx, controllers.CouponCollection); err != nil {
+		log.Fatal("could not create the coupon indexes: ", err)
+	}
+	if err := database.EnsureCartReminderIndexes(indexCtx, controllers.UserCollection, controllers.CartReminderCollection); err != nil {
+		log.Println("could not create the cart reminder indexes:", err)
+	}
+	if err := database.EnsurePasswordResetIndexes(indexCtx, controllers.PasswordResetCollection); err != nil {
+		log.Fatal("could not create the password reset indexes: ", err)
+	}
+	if err := database.EnsureLoginAttemptIndexes(indexCtx, controllers.LoginAttemptCollection); err != nil {
+		log.Fatal("could not create the login attempt indexes: ", err)
+	}
+	if err := database.EnsureOidcLoginIndexes(indexCtx, controllers.OidcLoginCollection); err != nil {
+		log.Fatal("could not create the oidc login indexes: ", err)
+	}
+	if err := database.EnsureAPIKeyIndexes(indexCtx, controllers.APIKeyCollection); err != nil {
+		log.Fatal("could not create the api key indexes: ", err)
+	}
+	if err := database.EnsureAuditIndexes(indexCtx, controllers.AuditCollection); err != nil {
+		log.Fatal("could not create the audit indexes: ", err)
+	}
+	if err := database.EnsureJobIndexes(indexCtx, controllers.JobCollection); err != nil {
+		log.Println("could not create the job indexes:", err)
+	}
+	if err := database.FailInterruptedJobs(indexCtx, controllers.JobCollection); err != nil {
+		log.Println("could not fail the interrupted jobs:", err)
+	}
+	if err := controllers.ResumeAccountDeletions(indexCtx); err != nil {
+		log.Println("could not resume the account deletions:", err)
+	}
+	cancel()
+
+	// remind the owners of abandoned carts in the background
+	go controllers.StartCartRecovery(context.Background())
+	// delete the data exports past retention in the background
+	go controllers.StartDataExportCleanup(context.Background())
+
 	// create a new gin router
 	router := gin.New()
 	// use the gin logger middleware
 	router.Use(gin.Logger())
+	// give every request an id, kept in the audit log
+	router.Use(middleware.RequestID())
 
 	// register user routes
 	routes.UserRoutes(router)
+	// serve the uploaded images when they are kept on disk
+	if local, ok := controllers.ImageStore.(*storage.Local); ok && strings.HasPrefix(local.BaseURL, "/") {
+		router.Static(local.BaseURL, local.Dir)
+	}
 	// use the authentication middleware
 	router.Use(middleware.Authentication())
 
@@ -42,14 +139,110 @@ func main() {
 	// register remove item route
 	router.GET("/removeitem", app.RemoveItem())
 	// register cart checkout route
-	router.GET("/cartcheckout", app.BuyFromCart())
-	// register instant buy route
-	router.GET("/instantbuy", app.InstantBuy())
+	router.GET("/cartcheckout", middleware.VerifiedEmail(), app.BuyFromCart())
+	// register instant buy route, both checkouts accept ?method= and ?address=
+	router.GET("/instantbuy", middleware.VerifiedEmail(), app.InstantBuy())
 	router.GET("/listcart", controllers.GetItemFromCart())
 	router.POST("/addaddress", controllers.AddAddress())
 	router.PUT("/edithomeaddress", controllers.EditHomeAddress())
 	router.PUT("/editworkaddress", controllers.EditWorkAddress())
 	router.GET("/deleteaddresses", controllers.DeleteAddress())
+	router.GET("/shippingquote", controllers.ShippingQuote())
+	router.GET("/me", controllers.GetProfile())
+	router.PATCH("/me", controllers.UpdateProfile())
+	router.POST("/me/email", controllers.ChangeEmail())
+	router.DELETE("/me", controllers.DeleteAccount())
+	router.POST("/me/exports", controllers.RequestDataExport())
+	router.GET("/me/exports", controllers.ListDataExports())
+	router.GET("/me/exports/:id", controllers.GetDataExport())
+	router.GET("/me/exports/:id/download", controllers.DownloadDataExport())
+	router.POST("/users/verify-email/resend", controllers.ResendVerification())
+	router.POST("/users/password/change", controllers.ChangePassword())
+	router.POST("/users/mfa/enroll", controllers.EnrollMfa())
+	router.POST("/users/mfa/confirm", controllers.ConfirmMfa())
+	router.POST("/users/mfa/disable", controllers.DisableMfa())
+	router.POST("/users/mfa/recovery-codes", controllers.RegenerateRecoveryCodes())
+	router.GET("/orders/:id/tracking", controllers.TrackOrder())
+	router.GET("/orders/:id/invoice", controllers.OrderInvoice())
+	router.GET("/orders/:id/credit-notes", controllers.OrderCreditNotes())
+	router.GET("/invoices/:id", controllers.GetInvoice())
+	router.POST("/products/:id/reviews", controllers.AddReview())
+	router.POST("/reviews/:id/votes", controllers.VoteReview())
+	router.GET("/wishlists", controllers.ListWishlists())
+	router.POST("/wishlists", controllers.CreateWishlist())
+	router.GET("/wishlists/:id", controllers.GetWishlist())
+	router.DELETE("/wishlists/:id", controllers.DeleteWishlist())
+	router.POST("/wishlists/:id/items", controllers.AddWishlistItem())
+	router.POST("/wishlists/:id/items/from-cart", controllers.MoveCartItemToWishlist())
+	router.DELETE("/wishlists/:id/items/:item_id", controllers.RemoveWishlistItem())
+	router.POST("/wishlists/:id/items/:item_id/move-to-cart", controllers.MoveWishlistItemToCart())
+	router.POST("/wishlists/:id/share", controllers.ShareWishlist())
+	router.DELETE("/wishlists/:id/share", controllers.UnshareWishlist())
+
+	// register admin routes, only reachable by users holding the admin role, and by their API
+	// keys holding the scope of the group
+	admin := router.Group("/admin", middleware.Admin())
+
+	shipping := admin.Group("", middleware.Scope(models.ScopeShipping))
+	shipping.POST("/shipping/zones", controllers.AddShippingZone())
+	shipping.GET("/shipping/zones", controllers.ListShippingZones())
+	shipping.PUT("/shipping/zones/:id", controllers.UpdateShippingZone())
+	shipping.DELETE("/shipping/zones/:id", controllers.DeleteShippingZone())
+
+	tax := admin.Group("", middleware.Scope(models.ScopeTax))
+	tax.GET("/tax/rates", controllers.ListTaxRates())
+	tax.POST("/tax/rates", controllers.AddTaxRate())
+	tax.PUT("/tax/rates/:id", controllers.UpdateTaxRate())
+	tax.DELETE("/tax/rates/:id", controllers.DeleteTaxRate())
+
+	catalog := admin.Group("", middleware.Scope(models.ScopeCatalog))
+	catalog.POST("/add_product", controllers.ProductViewerAdmin())
+	catalog.POST("/categories", controllers.AddCategory())
+	catalog.PUT("/categories/:id", controllers.UpdateCategory())
+	catalog.DELETE("/categories/:id", controllers.DeleteCategory())
+	catalog.PUT("/products/:id/categories", controllers.SetProductCategories())
+	catalog.PUT("/products/:id/variants", controllers.SetProductVariants())
+	catalog.POST("/products/import", controllers.ImportProducts())
+	catalog.GET("/products/export", controllers.ExportProducts())
+	catalog.GET("/jobs", controllers.ListJobs())
+	catalog.GET("/jobs/:id", controllers.GetJob())
+	catalog.POST("/products/:id/images", controllers.UploadProductImage())
+	catalog.PUT("/products/:id/images/order", controllers.ReorderProductImages())
+	catalog.DELETE("/products/:id/images/:image_id", controllers.DeleteProductImage())
+
+	reports := admin.Group("", middleware.Scope(models.ScopeReports))
+	reports.GET("/cart-recovery", controllers.CartRecoveryReport())
+
+	users := admin.Group("", middleware.Scope(models.ScopeUsers))
+	users.GET("/users", controllers.ListUsers())
+	users.GET("/users/:id", controllers.GetUser())
+	users.POST("/users/:id/unlock", controllers.UnlockUser())
+	users.POST("/users/:id/disable", controllers.DisableUser())
+	users.POST("/users/:id/enable", controllers.EnableUser())
+	users.POST("/users/:id/logout", controllers.LogoutUser())
+	users.POST("/users/:id/password-reset", controllers.SendUserPasswordReset())
+	// roles are only changed by admins who logged in, never by API keys
+	users.PUT("/users/:id/role", middleware.NoAPIKey(), controllers.SetUserRole())
+
+	auditLog := admin.Group("", middleware.Scope(models.ScopeAudit))
+	auditLog.GET("/audit", controllers.SearchAuditLog())
+	auditLog.GET("/audit/verify", controllers.VerifyAuditLog())
+
+	reviews := admin.Group("", middleware.Scope(models.ScopeReviews))
+	reviews.GET("/reviews", controllers.ReviewQueue())
+	reviews.POST("/reviews/:id/moderation", controllers.ModerateReview())
+
+	orders := admin.Group("", middleware.Scope(models.ScopeOrders))
+	orders.POST("/orders/:id/shipments", controllers.CreateShipment())
+	orders.POST("/orders/:id/refunds", controllers.RefundOrder())
+	orders.PATCH("/shipments/:id", controllers.UpdateShipment())
+	orders.POST("/shipments/:id/events", controllers.AddShipmentEvent())
+
+	// API keys are only managed by admins who logged in, never by other keys
+	apiKeys := admin.Group("/api-keys", middleware.NoAPIKey())
+	apiKeys.POST("", controllers.CreateAPIKey())
+	apiKeys.GET("", controllers.ListAPIKeys())
+	apiKeys.DELETE("/:id", controllers.RevokeAPIKey())
 
 	// start the server and log any errors
 	log.Fatal(router.Run(":" + port))
@@ -0,0 +1,169 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the gif decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+var (
+	ErrUnsupportedType = errors.New("only jpeg, png and gif images are accepted")
+	ErrTooLarge        = errors.New("the image is too large")
+	ErrTooManyPixels   = errors.New("the image has too many pixels")
+)
+
+// Extensions maps the accepted content types to the extension their files are stored with.
+var Extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// MaxBytes bounds the size of an uploaded image, 5 MiB unless MAX_IMAGE_BYTES says otherwise.
+var MaxBytes = envInt("MAX_IMAGE_BYTES", 5<<20)
+
+// maxPixels guards against images that are small on disk but huge once decoded.
+const maxPixels = 40_000_000
+
+// ThumbnailSize is a thumbnail generated for every image, fitting in Max pixels on its longest side.
+type ThumbnailSize struct {
+	Name string
+	Max  int
+}
+
+// ThumbnailSizes are the thumbnails generated for every uploaded image.
+var ThumbnailSizes = []ThumbnailSize{{"small", 150}, {"medium", 400}, {"large", 800}}
+
+// Image is a decoded upload, with its content type sniffed from the bytes rather than trusted from the client.
+type Image struct {
+	Content_Type string
+	Width        int
+	Height       int
+	Data         []byte
+	decoded      image.Image
+}
+
+// Read reads and checks an upload: at most MaxBytes of an accepted image type.
+func Read(r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(MaxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxBytes {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if _, ok := Extensions[contentType]; !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	// check the dimensions before decoding the pixels
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	return &Image{Content_Type: contentType, Width: config.Width, Height: config.Height, Data: data, decoded: decoded}, nil
+}
+
+// Thumbnail scales the image down to fit in max pixels on its longest side and encodes it.
+// PNGs stay PNGs to keep their transparency, everything else becomes a JPEG.
+func (img *Image) Thumbnail(max int) (data []byte, contentType string, err error) {
+	scaled := resize(img.decoded, max)
+
+	var out bytes.Buffer
+	if img.Content_Type == "image/png" {
+		err = png.Encode(&out, scaled)
+		contentType = "image/png"
+	} else {
+		err = jpeg.Encode(&out, scaled, &jpeg.Options{Quality: 85})
+		contentType = "image/jpeg"
+	}
+	return out.Bytes(), contentType, err
+}
+
+// resize shrinks the image by averaging the source pixels covered by each target pixel.
+// Images already small enough are only copied.
+func resize(src image.Image, max int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	targetWidth, targetHeight := width, height
+	if width > max || height > max {
+		if width >= height {
+			targetWidth, targetHeight = max, height*max/width
+		} else {
+			targetWidth, targetHeight = width*max/height, max
+		}
+	}
+	if targetWidth < 1 {
+		targetWidth = 1
+	}
+	if targetHeight < 1 {
+		targetHeight = 1
+	}
+
+	// work on premultiplied pixels so transparent areas don't bleed into the average
+	source := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(source, source.Bounds(), src, bounds.Min, draw.Src)
+	if targetWidth == width && targetHeight == height {
+		return source
+	}
+
+	target := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		y0, y1 := y*height/targetHeight, (y+1)*height/targetHeight
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < targetWidth; x++ {
+			x0, x1 := x*width/targetWidth, (x+1)*width/targetWidth
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := source.Pix[sy*source.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					pixel := row[sx*4 : sx*4+4]
+					r += int(pixel[0])
+					g += int(pixel[1])
+					b += int(pixel[2])
+					a += int(pixel[3])
+					n++
+				}
+			}
+
+			offset := y*target.Stride + x*4
+			target.Pix[offset] = uint8(r / n)
+			target.Pix[offset+1] = uint8(g / n)
+			target.Pix[offset+2] = uint8(b / n)
+			target.Pix[offset+3] = uint8(a / n)
+		}
+	}
+	return target
+}
+
+func envInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
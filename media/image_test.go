@@ -0,0 +1,128 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"testing"
+)
+
+// encode draws a width by height image and encodes it in the format.
+func encode(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var out bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&out, img)
+	case "jpeg":
+		err = jpeg.Encode(&out, img, nil)
+	case "gif":
+		err = gif.Encode(&out, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		err         error
+	}{
+		{"png", encode(t, "png", 30, 20), "image/png", nil},
+		{"jpeg", encode(t, "jpeg", 30, 20), "image/jpeg", nil},
+		{"gif", encode(t, "gif", 30, 20), "image/gif", nil},
+		{"text", []byte("not an image"), "", ErrUnsupportedType},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), "", ErrUnsupportedType},
+		{"truncated png", encode(t, "png", 30, 20)[:40], "", ErrUnsupportedType},
+	}
+	for _, test := range tests {
+		img, err := Read(bytes.NewReader(test.data))
+		if err != test.err {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && (img.Content_Type != test.contentType || img.Width != 30 || img.Height != 20) {
+			t.Errorf("%s: read %s of %dx%d, want %s of 30x20", test.name, img.Content_Type, img.Width, img.Height, test.contentType)
+		}
+	}
+}
+
+func TestReadTooLarge(t *testing.T) {
+	data := encode(t, "png", 30, 20)
+	defer func(max int) { MaxBytes = max }(MaxBytes)
+
+	MaxBytes = len(data)
+	if _, err := Read(bytes.NewReader(data)); err != nil {
+		t.Errorf("an image of MaxBytes: err = %v, want nil", err)
+	}
+	MaxBytes = len(data) - 1
+	if _, err := Read(bytes.NewReader(data)); err != ErrTooLarge {
+		t.Errorf("an image over MaxBytes: err = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+// The header of a PNG claiming more pixels than maxPixels is refused before decoding them.
+func TestReadTooManyPixels(t *testing.T) {
+	data := encode(t, "png", 1, 1)
+	// the width and height follow the signature and the IHDR chunk header, the checksum of
+	// the chunk follows its 13 bytes of data
+	copy(data[16:24], []byte{0, 0, 0x27, 0x10, 0, 0, 0x27, 0x10})
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	if _, err := Read(bytes.NewReader(data)); err != ErrTooManyPixels {
+		t.Errorf("err = %v, want %v", err, ErrTooManyPixels)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		format      string
+		width       int
+		height      int
+		contentType string
+		// sizes are the dimensions of the thumbnail of each of ThumbnailSizes
+		sizes [][2]int
+	}{
+		{"png", 1000, 500, "image/png", [][2]int{{150, 75}, {400, 200}, {800, 400}}},
+		{"jpeg", 300, 600, "image/jpeg", [][2]int{{75, 150}, {200, 400}, {300, 600}}},
+		{"gif", 100, 100, "image/jpeg", [][2]int{{100, 100}, {100, 100}, {100, 100}}},
+	}
+	for _, test := range tests {
+		img, err := Read(bytes.NewReader(encode(t, test.format, test.width, test.height)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, size := range ThumbnailSizes {
+			data, contentType, err := img.Thumbnail(size.Max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType != test.contentType || http.DetectContentType(data) != contentType {
+				t.Errorf("%s %s: content type %s, sniffed %s, want %s", test.format, size.Name, contentType, http.DetectContentType(data), test.contentType)
+			}
+			config, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := [2]int{config.Width, config.Height}; got != test.sizes[i] {
+				t.Errorf("%s %s of %dx%d: %dx%d, want %dx%d", test.format, size.Name, test.width, test.height, got[0], got[1], test.sizes[i][0], test.sizes[i][1])
+			}
+		}
+	}
+}
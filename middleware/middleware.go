@@ -1,36 +1,239 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ravelinejunior/golang_ecommerce/database"
+	"github.com/ravelinejunior/golang_ecommerce/models"
 	token "github.com/ravelinejunior/golang_ecommerce/tokens"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Authentication is a middleware function that verifies the JWT token sent in the request header
-// and sets the user's email and ID in the context. If the token is invalid, the request is aborted
-// with an error.
+var UserCollection *mongo.Collection = database.UserData(database.Client, "Users")
+var APIKeyCollection *mongo.Collection = database.OpenCollection(database.Client, "APIKeys")
+
+// APIKeyHeader carries the API keys of other systems, which may also be sent as bearer tokens.
+const APIKeyHeader = "X-API-Key"
+
+// AdminMfaRequired keeps admins without two-factor authentication out of the admin routes.
+var AdminMfaRequired = os.Getenv("ADMIN_MFA_REQUIRED") == "true"
+
+// realm is the start of every WWW-Authenticate challenge, RFC 6750.
+const realm = `Bearer realm="golang_ecommerce"`
+
+// Authentication is a middleware function that verifies the JWT token, or API key, sent in the
+// Authorization header as a bearer token, or in the legacy token header, and keeps who the request
+// acts for, see CurrentPrincipal. Requests without valid credentials are aborted with 401.
 func Authentication() gin.HandlerFunc {
 	return func(gCtx *gin.Context) {
-		// Get the JWT token from the request header
-		clientToken := gCtx.Request.Header.Get("token")
-		if clientToken == "" {
-			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": "No authorization token header"})
-			gCtx.Abort()
+		if !hasCredentials(gCtx.Request) {
+			unauthorized(gCtx, "", "log in to use this route, then send the token in an Authorization: Bearer header")
 			return
 		}
+		if authenticate(gCtx) {
+			// Continue processing the request
+			gCtx.Next()
+		}
+	}
+}
 
-		// Validate the JWT token
-		claims, err := token.ValidateToken(clientToken)
-		if err != "" {
-			gCtx.JSON(http.StatusInternalServerError, gin.H{"error": err})
-			gCtx.Abort()
+// OptionalAuthentication is a middleware function for public routes that serve logged in users
+// differently. Requests without credentials go through anonymously; requests with credentials are
+// authenticated as by Authentication, and aborted when the credentials are invalid.
+func OptionalAuthentication() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		if hasCredentials(gCtx.Request) && !authenticate(gCtx) {
+			return
+		}
+
+		// Continue processing the request
+		gCtx.Next()
+	}
+}
+
+// credentials returns the bearer token or the API key of the request. API keys sent as bearer
+// tokens are told apart by their prefix.
+func credentials(request *http.Request) (bearer string, apiKey string) {
+	if key := request.Header.Get(APIKeyHeader); key != "" {
+		return "", key
+	}
+	if scheme, value, ok := strings.Cut(request.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, database.APIKeyPrefix) {
+			return "", value
+		}
+		return value, ""
+	}
+	return request.Header.Get("token"), ""
+}
+
+// hasCredentials reports whether the request carries a token or an API key.
+func hasCredentials(request *http.Request) bool {
+	bearer, apiKey := credentials(request)
+	return bearer != "" || apiKey != ""
+}
+
+// authenticate checks the credentials of the request and keeps its principal, reporting true,
+// or answers 401 or 403 and aborts the request.
+func authenticate(gCtx *gin.Context) bool {
+	bearer, apiKey := credentials(gCtx.Request)
+	if apiKey != "" {
+		return authenticateAPIKey(gCtx, apiKey)
+	}
+
+	// Validate the JWT token
+	claims, msg := token.ValidateToken(bearer)
+	if msg != "" {
+		unauthorized(gCtx, "invalid_token", "the token is invalid or has expired, log in again")
+		return false
+	}
+
+	// Reject tokens issued before the user's sessions were revoked, by a password change for instance
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var user models.User
+	projection := options.FindOne().SetProjection(bson.M{"session_version": 1})
+	if err := UserCollection.FindOne(ctx, bson.M{"user_id": claims.Uid}, projection).Decode(&user); err != nil || user.Session_Version != claims.Session {
+		unauthorized(gCtx, "invalid_token", "the session has ended, log in again")
+		return false
+	}
+
+	gCtx.Set(principalKey, &Principal{User_ID: claims.Uid, Email: claims.Email, Session: claims.Session})
+	return true
+}
+
+// authenticateAPIKey accepts an API key as its owner, only on the admin API, where Scope checks
+// the key may use the route.
+func authenticateAPIKey(gCtx *gin.Context, secret string) bool {
+	if !strings.HasPrefix(gCtx.FullPath(), "/admin/") {
+		forbidden(gCtx, "", "API keys only work on the admin API")
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	key, err := database.UseAPIKey(ctx, APIKeyCollection, secret, gCtx.ClientIP())
+	if err != nil {
+		unauthorized(gCtx, "invalid_token", err.Error())
+		return false
+	}
+
+	gCtx.Set(principalKey, &Principal{User_ID: key.User_ID, API_Key_ID: key.Key_ID.Hex(), Scopes: key.Scopes})
+	return true
+}
+
+// unauthorized aborts the request with 401 and a challenge naming the error, if any.
+func unauthorized(gCtx *gin.Context, code, message string) {
+	challenge := realm
+	if code != "" {
+		challenge += fmt.Sprintf(`, error="%s", error_description="%s"`, code, message)
+	}
+	gCtx.Header("WWW-Authenticate", challenge)
+	gCtx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+}
+
+// forbidden aborts the request with 403 and an insufficient_scope challenge, naming the scope
+// missing, if any.
+func forbidden(gCtx *gin.Context, scope, message string) {
+	challenge := realm + fmt.Sprintf(`, error="insufficient_scope", error_description="%s"`, message)
+	if scope != "" {
+		challenge += fmt.Sprintf(`, scope="%s"`, scope)
+	}
+	gCtx.Header("WWW-Authenticate", challenge)
+	gCtx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": message})
+}
+
+// Scope is a middleware function that only lets API keys holding the scope through. Users who
+// logged in are let through, their role decides. It must run after Authentication.
+func Scope(scope string) gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		if principal, ok := CurrentPrincipal(gCtx); ok && principal.IsAPIKey() && !principal.HasScope(scope) {
+			forbidden(gCtx, scope, "the API key lacks the "+scope+" scope")
 			return
 		}
 
-		// Set the user's email and ID in the context
-		gCtx.Set("email", claims.Email)
-		gCtx.Set("uid", claims.Uid)
+		// Continue processing the request
+		gCtx.Next()
+	}
+}
+
+// NoAPIKey is a middleware function that keeps API keys out, for routes only a user who logged
+// in may use. It must run after Authentication.
+func NoAPIKey() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		if principal, ok := CurrentPrincipal(gCtx); ok && principal.IsAPIKey() {
+			forbidden(gCtx, "", "log in to use this route, API keys can't")
+			return
+		}
+
+		// Continue processing the request
+		gCtx.Next()
+	}
+}
+
+// Admin is a middleware function that only lets users holding the admin role through. It must run
+// after Authentication. The role is read from the database so that role changes apply immediately.
+// With AdminMfaRequired, admins must also have two-factor authentication on; enabling it revokes
+// every session that didn't go through it.
+func Admin() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Look the user up by the id carried in the token
+		var user models.User
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": UserID(gCtx)}).Decode(&user)
+		if err != nil || user.Role == nil || *user.Role != models.RoleAdmin {
+			forbidden(gCtx, "", "admin access required")
+			return
+		}
+		// the keys of a disabled admin stop working with the account
+		if user.Disabled_At != nil {
+			forbidden(gCtx, "", "the account is disabled")
+			return
+		}
+		if AdminMfaRequired && !user.Mfa_Enabled {
+			forbidden(gCtx, "", "admin accounts need two-factor authentication, enable it at /users/mfa/enroll")
+			return
+		}
+
+		// Continue processing the request
+		gCtx.Next()
+	}
+}
+
+// VerifiedEmail is a middleware function that only lets users who verified their email address
+// through. It must run after Authentication. Accounts created before addresses were verified
+// don't have the field at all and are let through, their address was never asked to be.
+func VerifiedEmail() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Look the user up by the id carried in the token
+		var user struct {
+			Email_Verified *bool `bson:"email_verified"`
+		}
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": UserID(gCtx)}, options.FindOne().SetProjection(bson.M{"email_verified": 1})).Decode(&user)
+		if err != nil && err != mongo.ErrNoDocuments {
+			log.Println(err)
+			gCtx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "couldn't look the user up"})
+			return
+		}
+		if err == mongo.ErrNoDocuments || (user.Email_Verified != nil && !*user.Email_Verified) {
+			gCtx.JSON(http.StatusForbidden, gin.H{"error": "verify your email address before checking out"})
+			gCtx.Abort()
+			return
+		}
 
 		// Continue processing the request
 		gCtx.Next()
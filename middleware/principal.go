@@ -0,0 +1,53 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+// principalKey is where Authentication keeps the Principal of a request.
+const principalKey = "principal"
+
+// Principal is who an authenticated request acts for: a user who logged in, or the admin who
+// owns the API key it was sent with.
+type Principal struct {
+	User_ID string
+	// Email is the address in the token, empty for API keys.
+	Email string
+	// Session is the session version of the token, see tokens.SignedDetails.
+	Session int
+	// API_Key_ID and Scopes are only set for requests sent with an API key.
+	API_Key_ID string
+	Scopes     []string
+}
+
+// IsAPIKey reports whether the request was sent with an API key rather than a user token.
+func (p *Principal) IsAPIKey() bool {
+	return p.API_Key_ID != ""
+}
+
+// HasScope reports whether the API key of the request holds the scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, held := range p.Scopes {
+		if held == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrentPrincipal returns who the request is authenticated as. It reports false when nobody
+// is, which only happens on routes without authentication or with OptionalAuthentication.
+func CurrentPrincipal(gCtx *gin.Context) (*Principal, bool) {
+	value, ok := gCtx.Get(principalKey)
+	if !ok {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+// UserID returns the id of the user the request is authenticated as, or "" when nobody is.
+func UserID(gCtx *gin.Context) string {
+	if principal, ok := CurrentPrincipal(gCtx); ok {
+		return principal.User_ID
+	}
+	return ""
+}
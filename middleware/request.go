@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the id of a request, from the client or a proxy, and back in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is where RequestID keeps the id of a request.
+const requestIDKey = "request_id"
+
+// validRequestID is what an id sent by the client may look like; anything else is replaced.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID is a middleware function that gives every request an id, the one sent in the
+// X-Request-ID header when it's sensible, or a new one, and sends it back in the response, so
+// that the logs and the audit trail of a request can be matched with what the client saw.
+func RequestID() gin.HandlerFunc {
+	return func(gCtx *gin.Context) {
+		id := gCtx.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			buf := make([]byte, 16)
+			if _, err := rand.Read(buf); err == nil {
+				id = hex.EncodeToString(buf)
+			} else {
+				id = ""
+			}
+		}
+		if id != "" {
+			gCtx.Set(requestIDKey, id)
+			gCtx.Header(RequestIDHeader, id)
+		}
+		gCtx.Next()
+	}
+}
+
+// CurrentRequestID returns the id RequestID gave the request, or "" when it has none.
+func CurrentRequestID(gCtx *gin.Context) string {
+	return gCtx.GetString(requestIDKey)
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scopes an API key can be granted, each opening one group of admin routes.
+const (
+	ScopeCatalog  = "catalog"  // products, categories, variants, images, imports and exports
+	ScopeOrders   = "orders"   // shipments and refunds
+	ScopeShipping = "shipping" // shipping zones
+	ScopeTax      = "tax"      // tax rates
+	ScopeReviews  = "reviews"  // review moderation
+	ScopeReports  = "reports"  // cart recovery report
+	ScopeUsers    = "users"    // user accounts
+	ScopeAudit    = "audit"    // audit log
+)
+
+// APIScopes lists every scope an API key can be granted.
+var APIScopes = []string{ScopeCatalog, ScopeOrders, ScopeShipping, ScopeTax, ScopeReviews, ScopeReports, ScopeUsers, ScopeAudit}
+
+// APIKey lets another system call the admin API on behalf of the admin who created it, limited
+// to its scopes. Only the hash of the key is kept; Prefix is shown to tell keys apart.
+type APIKey struct {
+	Key_ID       primitive.ObjectID `json:"_id" bson:"_id"`
+	User_ID      string             `json:"user_id" bson:"user_id"`
+	Name         string             `json:"name" bson:"name"`
+	Prefix       string             `json:"prefix" bson:"prefix"`
+	Key_Hash     string             `json:"-" bson:"key_hash"`
+	Scopes       []string           `json:"scopes" bson:"scopes"`
+	Created_At   time.Time          `json:"created_at" bson:"created_at"`
+	Expires_At   *time.Time         `json:"expires_at" bson:"expires_at,omitempty"`
+	Last_Used_At *time.Time         `json:"last_used_at" bson:"last_used_at,omitempty"`
+	Last_Used_IP string             `json:"last_used_ip,omitempty" bson:"last_used_ip,omitempty"`
+	Revoked_At   *time.Time         `json:"revoked_at" bson:"revoked_at,omitempty"`
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditLoginSucceeded      = "auth.login_succeeded"
+	AuditLoginFailed         = "auth.login_failed"
+	AuditPasswordChanged     = "auth.password_changed"
+	AuditPasswordReset       = "auth.password_reset"
+	AuditMfaEnabled          = "auth.mfa_enabled"
+	AuditMfaDisabled         = "auth.mfa_disabled"
+	AuditAccountDeleted      = "auth.account_deleted"
+	AuditUsersSearched       = "users.searched"
+	AuditUserViewed          = "user.viewed"
+	AuditUserDisabled        = "user.disabled"
+	AuditUserEnabled         = "user.enabled"
+	AuditUserRoleChanged     = "user.role_changed"
+	AuditUserLoggedOut       = "user.logged_out"
+	AuditUserPasswordReset   = "user.password_reset_sent"
+	AuditUserUnlocked        = "user.unlocked"
+	AuditAPIKeyCreated       = "api_key.created"
+	AuditAPIKeyRevoked       = "api_key.revoked"
+	AuditProductCreated      = "product.created"
+	AuditProductCategorized  = "product.categories_changed"
+	AuditProductVariants     = "product.variants_changed"
+	AuditProductImageAdded   = "product.image_added"
+	AuditProductImageDeleted = "product.image_deleted"
+	AuditProductImagesSorted = "product.images_reordered"
+	AuditProductsImported    = "products.import_started"
+	AuditShipmentCreated     = "order.shipment_created"
+	AuditShipmentUpdated     = "order.shipment_updated"
+	AuditShipmentEvent       = "order.shipment_event_added"
+	AuditOrderRefunded       = "order.refunded"
+)
+
+// Kinds of things an audit entry acts on.
+const (
+	AuditTargetUser     = "user"
+	AuditTargetAPIKey   = "api_key"
+	AuditTargetProduct  = "product"
+	AuditTargetOrder    = "order"
+	AuditTargetShipment = "shipment"
+	AuditTargetJob      = "job"
+)
+
+// AuditEntry records who did what to what. Entries are only ever appended: each one carries
+// the hash of the one before it, so that changing or removing one breaks the chain, see
+// package audit.
+type AuditEntry struct {
+	Audit_ID    primitive.ObjectID `json:"_id" bson:"_id"`
+	Seq         int64              `json:"seq" bson:"seq"`
+	Action      string             `json:"action" bson:"action"`
+	Actor_ID    string             `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	API_Key_ID  string             `json:"api_key_id,omitempty" bson:"api_key_id,omitempty"`
+	Target_Type string             `json:"target_type,omitempty" bson:"target_type,omitempty"`
+	Target_ID   string             `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	Changes     []AuditChange      `json:"changes,omitempty" bson:"changes,omitempty"`
+	Details     map[string]string  `json:"details,omitempty" bson:"details,omitempty"`
+	IP          string             `json:"ip" bson:"ip"`
+	Request_ID  string             `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
+	Prev_Hash   string             `json:"prev_hash" bson:"prev_hash"`
+	Hash        string             `json:"hash" bson:"hash"`
+}
+
+// AuditChange is a field an action changed, with its JSON value before and after; an empty
+// value means the field wasn't there.
+type AuditChange struct {
+	Field  string `json:"field" bson:"field"`
+	Before string `json:"before,omitempty" bson:"before,omitempty"`
+	After  string `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// AuditVerification reports whether the audit log is intact, and otherwise the first entry
+// breaking the chain.
+type AuditVerification struct {
+	Checked   int64  `json:"checked"`
+	Intact    bool   `json:"intact"`
+	Broken_At *int64 `json:"broken_at,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
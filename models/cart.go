@@ -0,0 +1,33 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Kinds of cart issues.
+const (
+	CartPriceChanged      = "price_changed"
+	CartItemRemoved       = "removed"
+	CartOutOfStock        = "out_of_stock"
+	CartInsufficientStock = "insufficient_stock"
+)
+
+// CartIssue reports a cart line that no longer matches the catalog: its price changed,
+// its product or variant is gone, or there isn't enough stock left.
+type CartIssue struct {
+	Product_ID   primitive.ObjectID  `json:"product_id"`
+	Variant_ID   *primitive.ObjectID `json:"variant_id,omitempty"`
+	Product_Name *string             `json:"product_name,omitempty"`
+	Kind         string              `json:"kind"`
+	Old_Price    int                 `json:"old_price,omitempty"`
+	New_Price    int                 `json:"new_price,omitempty"`
+	Requested    int                 `json:"requested,omitempty"`
+	Available    int                 `json:"available,omitempty"`
+}
+
+// CartReview is a cart checked against the catalog. Items hold the lines as they would be
+// ordered now, and Acknowledgement must be passed to checkout when there are Issues.
+type CartReview struct {
+	Items           []ProductUser `json:"items"`
+	Total           int           `json:"total"`
+	Issues          []CartIssue   `json:"issues"`
+	Acknowledgement string        `json:"acknowledgement,omitempty"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Category is a node of the product taxonomy. Ancestors lists every category
+// above it, root first, so a whole subtree can be found with a single query.
+type Category struct {
+	Category_ID primitive.ObjectID   `json:"_id" bson:"_id"`
+	Name        *string              `json:"name" bson:"name" validate:"required,min=1,max=100"`
+	Slug        string               `json:"slug" bson:"slug" validate:"omitempty,max=100"`
+	Parent_ID   *primitive.ObjectID  `json:"parent_id" bson:"parent_id"`
+	Ancestors   []primitive.ObjectID `json:"ancestors" bson:"ancestors"`
+	Created_At  time.Time            `json:"created_at" bson:"created_at"`
+	Updated_At  time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// CategoryNode is a category with its children, as returned by the category tree.
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode `json:"children"`
+}
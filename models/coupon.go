@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Coupon sources.
+const (
+	CouponAbandonedCart = "abandoned_cart"
+)
+
+// Coupon takes Percent_Off percent or Amount_Off minor units off the items of one order.
+// A coupon bound to a user can only be redeemed by them.
+type Coupon struct {
+	Coupon_ID   primitive.ObjectID  `json:"_id" bson:"_id"`
+	Code        string              `json:"code" bson:"code"`
+	Percent_Off int                 `json:"percent_off,omitempty" bson:"percent_off"`
+	Amount_Off  int                 `json:"amount_off,omitempty" bson:"amount_off"`
+	User_ID     string              `json:"user_id,omitempty" bson:"user_id"`
+	Source      string              `json:"source" bson:"source"`
+	Created_At  time.Time           `json:"created_at" bson:"created_at"`
+	Expires_At  time.Time           `json:"expires_at" bson:"expires_at"`
+	Used_At     *time.Time          `json:"used_at,omitempty" bson:"used_at"`
+	Order_ID    *primitive.ObjectID `json:"order_id,omitempty" bson:"order_id"`
+}
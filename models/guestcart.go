@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GuestCart is the cart of a visitor who hasn't logged in, found through a signed cart
+// token. Like UserCart it holds one entry per unit.
+type GuestCart struct {
+	Cart_ID    primitive.ObjectID `json:"_id" bson:"_id"`
+	Items      []ProductUser      `json:"items" bson:"items"`
+	Created_At time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At time.Time          `json:"updated_at" bson:"updated_at"`
+}
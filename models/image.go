@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductImage is an uploaded product picture. The images of a product are
+// kept in display order, and the first one doubles as Product.Image.
+type ProductImage struct {
+	Image_ID     primitive.ObjectID `json:"_id" bson:"_id"`
+	Key          string             `json:"key" bson:"key"`
+	URL          string             `json:"url" bson:"url"`
+	Content_Type string             `json:"content_type" bson:"content_type"`
+	Width        int                `json:"width" bson:"width"`
+	Height       int                `json:"height" bson:"height"`
+	Size         int                `json:"size" bson:"size"`
+	Thumbnails   []Thumbnail        `json:"thumbnails" bson:"thumbnails"`
+	Uploaded_At  time.Time          `json:"uploaded_at" bson:"uploaded_at"`
+}
+
+// Thumbnail is a scaled down copy of a product image.
+type Thumbnail struct {
+	Size string `json:"size" bson:"size"`
+	Key  string `json:"key" bson:"key"`
+	URL  string `json:"url" bson:"url"`
+}
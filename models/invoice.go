@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of invoice documents. Each kind is numbered in its own series.
+const (
+	InvoiceKind    = "invoice"
+	CreditNoteKind = "credit_note"
+)
+
+// Invoice is an issued invoice or credit note. Once issued it is never changed;
+// refunds are recorded as credit notes pointing at the invoice they credit.
+type Invoice struct {
+	Invoice_ID  primitive.ObjectID  `json:"_id" bson:"_id"`
+	Kind        string              `json:"kind" bson:"kind"`
+	Store_ID    string              `json:"store_id" bson:"store_id"`
+	Number      int64               `json:"number" bson:"number"`
+	Reference   string              `json:"reference" bson:"reference"`
+	Order_ID    primitive.ObjectID  `json:"order_id" bson:"order_id"`
+	User_ID     string              `json:"user_id" bson:"user_id"`
+	Credits     *primitive.ObjectID `json:"credits,omitempty" bson:"credits,omitempty"`
+	Reason      string              `json:"reason,omitempty" bson:"reason,omitempty"`
+	Issued_At   time.Time           `json:"issued_at" bson:"issued_at"`
+	Ordered_At  time.Time           `json:"ordered_at" bson:"ordered_at"`
+	Seller      Party               `json:"seller" bson:"seller"`
+	Buyer       Party               `json:"buyer" bson:"buyer"`
+	Lines       []InvoiceLine       `json:"lines" bson:"lines"`
+	Tax_Totals  []InvoiceTaxTotal   `json:"tax_totals" bson:"tax_totals"`
+	Net         int                 `json:"net" bson:"net"`
+	Tax         int                 `json:"tax" bson:"tax"`
+	Gross       int                 `json:"gross" bson:"gross"`
+	Include_Tax bool                `json:"prices_include_tax" bson:"prices_include_tax"`
+	Credit_Seq  int                 `json:"-" bson:"credit_seq,omitempty"`
+}
+
+// Party is the seller or the buyer named on an invoice.
+type Party struct {
+	Name    string   `json:"name" bson:"name"`
+	Address []string `json:"address" bson:"address"`
+	Email   string   `json:"email,omitempty" bson:"email,omitempty"`
+	Phone   string   `json:"phone,omitempty" bson:"phone,omitempty"`
+	Tax_ID  string   `json:"tax_id,omitempty" bson:"tax_id,omitempty"`
+}
+
+// InvoiceLine is one line of an invoice. Credit note lines carry negative amounts.
+type InvoiceLine struct {
+	Product_ID   primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Description  string             `json:"description" bson:"description"`
+	Quantity     int                `json:"quantity" bson:"quantity"`
+	Tax_Class    string             `json:"tax_class" bson:"tax_class"`
+	Jurisdiction string             `json:"jurisdiction" bson:"jurisdiction"`
+	Basis_Points int                `json:"basis_points" bson:"basis_points"`
+	Net          int                `json:"net" bson:"net"`
+	Tax          int                `json:"tax" bson:"tax"`
+	Gross        int                `json:"gross" bson:"gross"`
+}
+
+// InvoiceTaxTotal sums the lines taxed at the same rate in the same jurisdiction.
+type InvoiceTaxTotal struct {
+	Basis_Points int    `json:"basis_points" bson:"basis_points"`
+	Jurisdiction string `json:"jurisdiction" bson:"jurisdiction"`
+	Net          int    `json:"net" bson:"net"`
+	Tax          int    `json:"tax" bson:"tax"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Job kinds.
+const (
+	JobProductImport   = "product_import"
+	JobDataExport      = "data_export"
+	JobAccountDeletion = "account_deletion"
+)
+
+// Job statuses.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Job is a long running task, such as a bulk import, started by a request and
+// reported on once it is done.
+type Job struct {
+	Job_ID      primitive.ObjectID `json:"_id" bson:"_id"`
+	Kind        string             `json:"kind" bson:"kind"`
+	Status      string             `json:"status" bson:"status"`
+	Format      string             `json:"format,omitempty" bson:"format,omitempty"`
+	File_Name   string             `json:"file_name,omitempty" bson:"file_name,omitempty"`
+	Rows        int                `json:"rows" bson:"rows"`
+	Created     int                `json:"created" bson:"created"`
+	Updated     int                `json:"updated" bson:"updated"`
+	Failed      int                `json:"failed" bson:"failed"`
+	Errors      []RowError         `json:"errors" bson:"errors"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	Created_By  string             `json:"created_by" bson:"created_by"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
+	Started_At  *time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	Finished_At *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	// Blob_Key is where the file the job produced is kept, until Expires_At.
+	Blob_Key   string     `json:"-" bson:"blob_key,omitempty"`
+	Expires_At *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	// Token_Hash lets whoever holds the token follow the job without logging in.
+	Token_Hash string `json:"-" bson:"token_hash,omitempty"`
+}
+
+// RowError reports why a row of an import was rejected.
+type RowError struct {
+	Row   int    `json:"row" bson:"row"`
+	Sku   string `json:"sku,omitempty" bson:"sku,omitempty"`
+	Error string `json:"error" bson:"error"`
+}
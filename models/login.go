@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LoginAttempts counts the failed logins in a row for an account or an IP address. Key is
+// "account:<email>" or "ip:<address>".
+type LoginAttempts struct {
+	Key             string     `json:"key" bson:"key"`
+	Failures        int        `json:"failures" bson:"failures"`
+	Last_Failure_At time.Time  `json:"last_failure_at" bson:"last_failure_at"`
+	Locked_Until    *time.Time `json:"locked_until" bson:"locked_until,omitempty"`
+	Expires_At      time.Time  `json:"expires_at" bson:"expires_at"`
+}
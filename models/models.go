@@ -7,36 +7,101 @@ import (
 )
 
 type User struct {
-	ID              primitive.ObjectID `json:"_id" bson:"_id"`
-	First_Name      *string            `json:"first_name" validate:"required,min=2,max=30"`
-	Last_Name       *string            `json:"last_name" validate:"required,min=2,max=30"`
-	Password        *string            `json:"password" validate:"required,min=6"`
-	Email           *string            `json:"email" validate:"email,required"`
-	Phone           *string            `json:"phone" validate:"required"`
-	Token           *string            `json:"token"`
-	Refresh_Token   *string            `json:"refresh_token"`
-	Created_At      time.Time          `json:"created_at"`
-	Updated_At      time.Time          `json:"updated_at"`
-	User_ID         string             `json:"user_id"`
-	UserCart        []ProductUser      `json:"usercart" bson:"usercart"`
-	Address_Details []Address          `json:"address" bson:"address"`
-	Order_Status    []Order            `json:"orders" bson:"orders"`
+	ID                   primitive.ObjectID `json:"_id" bson:"_id"`
+	First_Name           *string            `json:"first_name" validate:"required,min=2,max=30"`
+	Last_Name            *string            `json:"last_name" validate:"required,min=2,max=30"`
+	Password             *string            `json:"-" validate:"required,min=6"`
+	Email                *string            `json:"email" validate:"email,required"`
+	Pending_Email        *string            `json:"pending_email" bson:"pending_email,omitempty"`
+	Email_Change_Sent_At *time.Time         `json:"-" bson:"email_change_sent_at,omitempty"`
+	Email_Verified       bool               `json:"email_verified" bson:"email_verified"`
+	Email_Verified_At    *time.Time         `json:"email_verified_at" bson:"email_verified_at,omitempty"`
+	Verification_Sent_At *time.Time         `json:"-" bson:"verification_sent_at,omitempty"`
+	Phone                *string            `json:"phone" validate:"required"`
+	Token                *string            `json:"-"`
+	Refresh_Token        *string            `json:"-"`
+	Session_Version      int                `json:"-" bson:"session_version"`
+	Password_Changed_At  *time.Time         `json:"password_changed_at" bson:"password_changed_at,omitempty"`
+	Mfa_Enabled          bool               `json:"mfa_enabled" bson:"mfa_enabled"`
+	Mfa_Enabled_At       *time.Time         `json:"mfa_enabled_at" bson:"mfa_enabled_at,omitempty"`
+	Mfa_Secret           string             `json:"-" bson:"mfa_secret,omitempty"`
+	Mfa_Pending_Secret   string             `json:"-" bson:"mfa_pending_secret,omitempty"`
+	Mfa_Last_Step        int64              `json:"-" bson:"mfa_last_step"`
+	Mfa_Recovery_Codes   []string           `json:"-" bson:"mfa_recovery_codes,omitempty"`
+	Identities           []Identity         `json:"identities" bson:"identities,omitempty"`
+	Created_At           time.Time          `json:"created_at"`
+	Updated_At           time.Time          `json:"updated_at"`
+	User_ID              string             `json:"user_id"`
+	Disabled_At          *time.Time         `json:"disabled_at,omitempty" bson:"disabled_at,omitempty"`
+	Disabled_Reason      string             `json:"disabled_reason,omitempty" bson:"disabled_reason,omitempty"`
+	Deleted_At           *time.Time         `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	Anonymized_At        *time.Time         `json:"anonymized_at,omitempty" bson:"anonymized_at,omitempty"`
+	Role                 *string            `json:"role" bson:"role"`
+	UserCart             []ProductUser      `json:"usercart" bson:"usercart"`
+	Cart_Updated_At      *time.Time         `json:"cart_updated_at" bson:"cart_updated_at,omitempty"`
+	Cart_Reminded_At     *time.Time         `json:"cart_reminded_at" bson:"cart_reminded_at,omitempty"`
+	Address_Details      []Address          `json:"address" bson:"address"`
+	Order_Status         []Order            `json:"orders" bson:"orders"`
 }
 
 type Product struct {
-	Product_ID   primitive.ObjectID `bson:"_id"`
-	Product_Name *string            `json:"product_name"`
-	Price        *uint64            `json:"price"`
-	Rating       *uint8             `json:"rating"`
-	Image        *string            `json:"image"`
+	Product_ID     primitive.ObjectID   `bson:"_id"`
+	Product_Name   *string              `json:"product_name"`
+	Price          *uint64              `json:"price"`
+	Rating         *uint8               `json:"rating"`
+	Image          *string              `json:"image"`
+	Images         []ProductImage       `json:"images"`
+	Weight         *uint64              `json:"weight"`
+	Dimensions     *Dimensions          `json:"dimensions"`
+	Tax_Class      *string              `json:"tax_class"`
+	Category_IDs   []primitive.ObjectID `json:"category_ids"`
+	Sku            *string              `json:"sku" validate:"omitempty,max=64"`
+	Stock          *int                 `json:"stock" validate:"omitempty,min=0"`
+	Options        []ProductOption      `json:"options" validate:"dive"`
+	Variants       []Variant            `json:"variants" validate:"dive"`
+	Rating_Average float64              `json:"rating_average"`
+	Rating_Count   int                  `json:"rating_count"`
+}
+
+// ProductOption is an attribute a product is sold in, such as size or color,
+// with the values it can take.
+type ProductOption struct {
+	Name   string   `json:"name" bson:"name" validate:"required"`
+	Values []string `json:"values" bson:"values" validate:"required,min=1"`
+}
+
+// Variant is one sellable combination of a product's options. Its own price,
+// weight and images replace the product's when set. A nil Stock means stock
+// isn't tracked.
+type Variant struct {
+	Variant_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	Sku        *string            `json:"sku" bson:"sku" validate:"required,max=64"`
+	Attributes map[string]string  `json:"attributes" bson:"attributes" validate:"required"`
+	Price      *uint64            `json:"price" bson:"price"`
+	Stock      *int               `json:"stock" bson:"stock" validate:"omitempty,min=0"`
+	Images     []string           `json:"images" bson:"images"`
+	Barcode    string             `json:"barcode" bson:"barcode" validate:"omitempty,numeric,min=8,max=14"`
+	Weight     *uint64            `json:"weight" bson:"weight"`
+}
+
+// Dimensions holds the packed size of a product in millimetres.
+type Dimensions struct {
+	Length uint64 `json:"length" bson:"length"`
+	Width  uint64 `json:"width" bson:"width"`
+	Height uint64 `json:"height" bson:"height"`
 }
 
 type ProductUser struct {
-	Product_ID   primitive.ObjectID `bson:"_id"`
-	Product_Name *string            `json:"product_name" bson:"product_name"`
-	Price        int                `json:"price" bson:"price"`
-	Rating       *uint8             `json:"rating" bson:"rating"`
-	Image        *string            `json:"image" bson:"image"`
+	Product_ID   primitive.ObjectID  `bson:"_id"`
+	Product_Name *string             `json:"product_name" bson:"product_name"`
+	Price        int                 `json:"price" bson:"price"`
+	Rating       *uint8              `json:"rating" bson:"rating"`
+	Image        *string             `json:"image" bson:"image"`
+	Weight       *uint64             `json:"weight" bson:"weight"`
+	Tax_Class    *string             `json:"tax_class" bson:"tax_class"`
+	Variant_ID   *primitive.ObjectID `json:"variant_id" bson:"variant_id"`
+	Sku          *string             `json:"sku" bson:"sku"`
+	Attributes   map[string]string   `json:"attributes" bson:"attributes"`
 }
 
 type Address struct {
@@ -45,6 +110,7 @@ type Address struct {
 	Street     *string            `json:"street_name" bson:"street_name"`
 	City       *string            `json:"city_name" bson:"city_name"`
 	PinCode    *string            `json:"pin_code" bson:"pin_code"`
+	Country    *string            `json:"country" bson:"country"`
 }
 
 type Order struct {
@@ -54,8 +120,32 @@ type Order struct {
 	Price          int                `json:"total_price" bson:"total_price"`
 	Payment_Method Payment            `json:"payment_method" bson:"payment_method"`
 	Discount       *int               `json:"discount" bson:"discount"`
+	Subtotal       int                `json:"subtotal" bson:"subtotal"`
+	Shipping       *ShippingQuote     `json:"shipping" bson:"shipping"`
+	Ship_To        *Address           `json:"ship_to" bson:"ship_to"`
+	Tax            *TaxSummary        `json:"tax" bson:"tax"`
+	Status         string             `json:"status" bson:"status"`
+	Updated_At     time.Time          `json:"updated_at" bson:"updated_at"`
+	// Shipment_Seq counts the shipments created for the order, so that two can't be created
+	// from the same reading of it, see database.CreateShipment.
+	Shipment_Seq int `json:"-" bson:"shipment_seq,omitempty"`
 }
 
+// Statuses an order moves through as it is fulfilled.
+const (
+	OrderPlaced           = "placed"
+	OrderPartiallyShipped = "partially_shipped"
+	OrderShipped          = "shipped"
+	OrderDelivered        = "delivered"
+	OrderRefunded         = "refunded"
+)
+
+// Roles a user can hold. Users created through Signup are always RoleUser.
+const (
+	RoleUser  = "USER"
+	RoleAdmin = "ADMIN"
+)
+
 type Payment struct {
 	Digital bool
 	COD     bool
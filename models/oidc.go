@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Identity links a user to their account at an external identity provider.
+type Identity struct {
+	Provider  string    `json:"provider" bson:"provider"`
+	Subject   string    `json:"subject" bson:"subject"`
+	Email     string    `json:"email" bson:"email"`
+	Linked_At time.Time `json:"linked_at" bson:"linked_at"`
+}
+
+// OidcLogin is a login started with an identity provider, waiting for the user to come back.
+// It is found by the hash of the state sent along, and used once.
+type OidcLogin struct {
+	State_Hash string    `bson:"state_hash"`
+	Provider   string    `bson:"provider"`
+	Nonce      string    `bson:"nonce"`
+	Verifier   string    `bson:"verifier"`
+	Created_At time.Time `bson:"created_at"`
+	Expires_At time.Time `bson:"expires_at"`
+}
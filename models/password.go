@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordReset is a pending forgot-password request. Only the hash of the token mailed to
+// the user is kept, and it can be used once.
+type PasswordReset struct {
+	Reset_ID   primitive.ObjectID `json:"_id" bson:"_id"`
+	User_ID    string             `json:"user_id" bson:"user_id"`
+	Token_Hash string             `json:"-" bson:"token_hash"`
+	Created_At time.Time          `json:"created_at" bson:"created_at"`
+	Expires_At time.Time          `json:"expires_at" bson:"expires_at"`
+	Used_At    *time.Time         `json:"used_at,omitempty" bson:"used_at"`
+}
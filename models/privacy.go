@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// DataExport is the archive of everything the store keeps about a user, as downloaded from
+// a data export job.
+type DataExport struct {
+	Exported_At time.Time     `json:"exported_at"`
+	Profile     User          `json:"profile"`
+	Addresses   []Address     `json:"addresses"`
+	Cart        []ProductUser `json:"cart"`
+	Orders      []Order       `json:"orders"`
+	Invoices    []Invoice     `json:"invoices"`
+	Shipments   []Shipment    `json:"shipments"`
+	Reviews     []Review      `json:"reviews"`
+	Wishlists   []Wishlist    `json:"wishlists"`
+}
+
+// AccountDeletion answers a request to delete an account with the job anonymizing it and the
+// token to follow the job with, since the account can't log in anymore.
+type AccountDeletion struct {
+	Job          *Job   `json:"job"`
+	Status_Token string `json:"status_token"`
+}
+
+// Names standing in for those of a deleted user, on the orders, invoices and reviews kept.
+const (
+	AnonymizedFirstName = "Deleted"
+	AnonymizedLastName  = "user"
+	AnonymizedName      = AnonymizedFirstName + " " + AnonymizedLastName
+)
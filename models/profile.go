@@ -0,0 +1,37 @@
+package models
+
+// Signup is the body of users/signup.
+type Signup struct {
+	First_Name *string `json:"first_name" validate:"required,min=2,max=30"`
+	Last_Name  *string `json:"last_name" validate:"required,min=2,max=30"`
+	Password   *string `json:"password" validate:"required,min=6"`
+	Email      *string `json:"email" validate:"email,required"`
+	Phone      *string `json:"phone" validate:"required"`
+}
+
+// Credentials is the body of users/login.
+type Credentials struct {
+	Email    *string `json:"email" validate:"required,email"`
+	Password *string `json:"password" validate:"required"`
+}
+
+// LoggedIn answers a successful login: the user, whose password and tokens are never
+// serialized, next to the tokens just issued.
+type LoggedIn struct {
+	*User
+	Token         string `json:"token"`
+	Refresh_Token string `json:"refresh_token"`
+}
+
+// ProfileUpdate is the body of PATCH /me; fields left out are kept.
+type ProfileUpdate struct {
+	First_Name *string `json:"first_name" validate:"omitempty,min=2,max=30"`
+	Last_Name  *string `json:"last_name" validate:"omitempty,min=2,max=30"`
+	Phone      *string `json:"phone" validate:"omitempty,min=1"`
+}
+
+// EmailChange is the body of POST /me/email. Accounts with a password confirm the change with it.
+type EmailChange struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CartReminder records a reminder sent about an abandoned cart, and whether the user came
+// back and ordered within the recovery window.
+type CartReminder struct {
+	Reminder_ID  primitive.ObjectID  `json:"_id" bson:"_id"`
+	User_ID      string              `json:"user_id" bson:"user_id"`
+	Channel      string              `json:"channel" bson:"channel"`
+	Items        int                 `json:"items" bson:"items"`
+	Cart_Total   int                 `json:"cart_total" bson:"cart_total"`
+	Coupon_Code  string              `json:"coupon_code,omitempty" bson:"coupon_code,omitempty"`
+	Sent_At      time.Time           `json:"sent_at" bson:"sent_at"`
+	Recovered    bool                `json:"recovered" bson:"recovered"`
+	Recovered_At *time.Time          `json:"recovered_at,omitempty" bson:"recovered_at,omitempty"`
+	Order_ID     *primitive.ObjectID `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	Order_Total  int                 `json:"order_total,omitempty" bson:"order_total,omitempty"`
+}
+
+// RecoveryStats sums up the reminders sent since a date.
+type RecoveryStats struct {
+	Since             time.Time `json:"since"`
+	Sent              int       `json:"sent"`
+	Recovered         int       `json:"recovered"`
+	Recovery_Rate     float64   `json:"recovery_rate"`
+	Recovered_Revenue int       `json:"recovered_revenue"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Moderation states of a review. Only approved reviews are shown and counted
+// in the product rating.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// Review is a customer's rating of a product they ordered.
+type Review struct {
+	Review_ID       primitive.ObjectID `json:"_id" bson:"_id"`
+	Product_ID      primitive.ObjectID `json:"product_id" bson:"product_id"`
+	User_ID         string             `json:"user_id" bson:"user_id"`
+	Author          string             `json:"author" bson:"author"`
+	Rating          int                `json:"rating" bson:"rating" validate:"required,min=1,max=5"`
+	Title           *string            `json:"title" bson:"title" validate:"required,min=2,max=120"`
+	Body            *string            `json:"body" bson:"body" validate:"required,min=10,max=5000"`
+	Status          string             `json:"status" bson:"status"`
+	Moderation_Note string             `json:"moderation_note,omitempty" bson:"moderation_note,omitempty"`
+	Helpful_Votes   int                `json:"helpful_votes" bson:"helpful_votes"`
+	Unhelpful_Votes int                `json:"unhelpful_votes" bson:"unhelpful_votes"`
+	Votes           []ReviewVote       `json:"-" bson:"votes"`
+	Created_At      time.Time          `json:"created_at" bson:"created_at"`
+	Moderated_At    *time.Time         `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+}
+
+// ReviewVote records whether a user found a review helpful.
+type ReviewVote struct {
+	User_ID string `bson:"user_id"`
+	Helpful bool   `bson:"helpful"`
+}
+
+// Page is one page of a paginated listing.
+type Page struct {
+	Items    interface{} `json:"items"`
+	Page     int64       `json:"page"`
+	Per_Page int64       `json:"per_page"`
+	Total    int64       `json:"total"`
+}
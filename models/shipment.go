@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Statuses a shipment reports through its tracking events.
+const (
+	ShipmentLabelCreated   = "label_created"
+	ShipmentInTransit      = "in_transit"
+	ShipmentOutForDelivery = "out_for_delivery"
+	ShipmentDelivered      = "delivered"
+	ShipmentException      = "exception"
+)
+
+// Shipment is one parcel sent for an order. An order can be split across
+// several shipments, each carrying part of its items.
+type Shipment struct {
+	Shipment_ID     primitive.ObjectID `json:"_id" bson:"_id"`
+	Order_ID        primitive.ObjectID `json:"order_id" bson:"order_id"`
+	User_ID         string             `json:"user_id" bson:"user_id"`
+	Carrier         *string            `json:"carrier" bson:"carrier" validate:"required"`
+	Tracking_Number *string            `json:"tracking_number" bson:"tracking_number" validate:"required"`
+	Items           []ShipmentItem     `json:"items" bson:"items" validate:"required,min=1,dive"`
+	Status          string             `json:"status" bson:"status"`
+	Events          []ShipmentEvent    `json:"events" bson:"events"`
+	Created_At      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// ShipmentItem is a quantity of one ordered product packed in a shipment.
+type ShipmentItem struct {
+	Product_ID primitive.ObjectID `json:"product_id" bson:"product_id" validate:"required"`
+	Quantity   int                `json:"quantity" bson:"quantity" validate:"required,min=1"`
+}
+
+// ShipmentEvent is a carrier scan or status change of a shipment.
+type ShipmentEvent struct {
+	Status      string    `json:"status" bson:"status" validate:"required,oneof=label_created in_transit out_for_delivery delivered exception"`
+	Location    string    `json:"location" bson:"location"`
+	Description string    `json:"description" bson:"description"`
+	Occurred_At time.Time `json:"occurred_at" bson:"occurred_at"`
+}
+
+// OrderTracking is what a customer sees when following an order.
+type OrderTracking struct {
+	Order_ID  primitive.ObjectID `json:"order_id"`
+	Status    string             `json:"status"`
+	Shipments []Shipment         `json:"shipments"`
+}
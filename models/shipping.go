@@ -0,0 +1,54 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Shipping method codes offered at checkout.
+const (
+	ShippingStandard = "standard"
+	ShippingExpress  = "express"
+	ShippingPickup   = "pickup"
+)
+
+// Rate types a shipping method can be priced by.
+const (
+	RateFlat     = "flat"
+	RateWeight   = "weight"
+	RateSubtotal = "subtotal"
+)
+
+// ShippingZone groups the destinations that share the same shipping methods.
+// A zone matches an address when its country is listed and, if any postal
+// prefixes are set, the address pin code starts with one of them.
+type ShippingZone struct {
+	Zone_ID         primitive.ObjectID `json:"_id" bson:"_id"`
+	Name            *string            `json:"name" bson:"name" validate:"required"`
+	Countries       []string           `json:"countries" bson:"countries" validate:"required,min=1"`
+	Postal_Prefixes []string           `json:"postal_prefixes" bson:"postal_prefixes"`
+	Methods         []ShippingMethod   `json:"methods" bson:"methods" validate:"required,min=1,dive"`
+}
+
+// ShippingMethod describes how a zone charges for one delivery option.
+// Flat_Fee is used by flat rates; Tiers by weight (grams) and subtotal rates.
+type ShippingMethod struct {
+	Code     string     `json:"code" bson:"code" validate:"required,oneof=standard express pickup"`
+	Name     string     `json:"name" bson:"name"`
+	Rate     string     `json:"rate" bson:"rate" validate:"required,oneof=flat weight subtotal"`
+	Flat_Fee int        `json:"flat_fee" bson:"flat_fee" validate:"min=0"`
+	Tiers    []RateTier `json:"tiers" bson:"tiers" validate:"dive"`
+}
+
+// RateTier charges Price for anything up to and including Up_To. An Up_To of
+// zero means the tier has no upper bound.
+type RateTier struct {
+	Up_To uint64 `json:"up_to" bson:"up_to"`
+	Price int    `json:"price" bson:"price" validate:"min=0"`
+}
+
+// ShippingQuote is the price of delivering a cart with one method.
+type ShippingQuote struct {
+	Zone_ID primitive.ObjectID `json:"zone_id" bson:"zone_id"`
+	Method  string             `json:"method" bson:"method"`
+	Name    string             `json:"name" bson:"name"`
+	Weight  uint64             `json:"weight" bson:"weight"`
+	Price   int                `json:"price" bson:"price"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Tax classes products can belong to. Products without a class are taxed as
+// TaxStandard, and so is shipping.
+const (
+	TaxStandard = "standard"
+	TaxReduced  = "reduced"
+	TaxZero     = "zero"
+)
+
+// TaxRate is one rate of the built-in tax table. Rates with different names
+// stack, so a state and a city tax can both apply to the same line. Among
+// rates with the same name, the longest matching postal prefix wins.
+type TaxRate struct {
+	Rate_ID       primitive.ObjectID `json:"_id" bson:"_id"`
+	Name          *string            `json:"name" bson:"name" validate:"required"`
+	Country       *string            `json:"country" bson:"country" validate:"required"`
+	Postal_Prefix string             `json:"postal_prefix" bson:"postal_prefix"`
+	Tax_Class     *string            `json:"tax_class" bson:"tax_class" validate:"required"`
+	Basis_Points  int                `json:"basis_points" bson:"basis_points" validate:"min=0,max=10000"`
+}
+
+// TaxLine is the tax charged on one order line. Amounts are in the same unit
+// as prices, and Basis_Points is the combined rate (2000 is 20%).
+type TaxLine struct {
+	Product_ID   primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Description  string             `json:"description" bson:"description"`
+	Tax_Class    string             `json:"tax_class" bson:"tax_class"`
+	Jurisdiction []string           `json:"jurisdiction" bson:"jurisdiction"`
+	Basis_Points int                `json:"basis_points" bson:"basis_points"`
+	Net          int                `json:"net" bson:"net"`
+	Tax          int                `json:"tax" bson:"tax"`
+	Gross        int                `json:"gross" bson:"gross"`
+}
+
+// TaxSummary is the tax breakdown stored on an order for invoicing.
+type TaxSummary struct {
+	Provider           string    `json:"provider" bson:"provider"`
+	Prices_Include_Tax bool      `json:"prices_include_tax" bson:"prices_include_tax"`
+	Lines              []TaxLine `json:"lines" bson:"lines"`
+	Net                int       `json:"net" bson:"net"`
+	Tax                int       `json:"tax" bson:"tax"`
+	Gross              int       `json:"gross" bson:"gross"`
+}
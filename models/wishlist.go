@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Wishlist is a named list of products a user saved for later. It can be shared
+// read-only through its Share_Token.
+type Wishlist struct {
+	Wishlist_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	User_ID     string             `json:"user_id,omitempty" bson:"user_id"`
+	Name        string             `json:"name" bson:"name" validate:"required,max=100"`
+	Items       []WishlistItem     `json:"items" bson:"items"`
+	Share_Token *string            `json:"share_token,omitempty" bson:"share_token,omitempty"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At  time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// WishlistItem is a product, or a variant of it, saved on a wishlist. The product
+// details and flags are filled in from the catalog whenever the list is viewed.
+type WishlistItem struct {
+	Item_ID     primitive.ObjectID  `json:"_id" bson:"_id"`
+	Product_ID  primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Variant_ID  *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id"`
+	Price_Added int                 `json:"price_added" bson:"price_added"`
+	Added_At    time.Time           `json:"added_at" bson:"added_at"`
+
+	Product_Name  *string           `json:"product_name,omitempty" bson:"-"`
+	Image         *string           `json:"image,omitempty" bson:"-"`
+	Attributes    map[string]string `json:"attributes,omitempty" bson:"-"`
+	Price         int               `json:"price" bson:"-"`
+	Available     bool              `json:"available" bson:"-"`
+	In_Stock      bool              `json:"in_stock" bson:"-"`
+	Price_Changed bool              `json:"price_changed" bson:"-"`
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is a notification for a single user.
+type Message struct {
+	Kind    string                 `json:"kind"`
+	User_ID string                 `json:"user_id"`
+	Email   string                 `json:"email"`
+	Name    string                 `json:"name"`
+	Subject string                 `json:"subject"`
+	Body    string                 `json:"body"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// Notifier delivers messages to users. Implementations decide the channel.
+type Notifier interface {
+	// Name identifies the channel, it is recorded with what was sent.
+	Name() string
+	Notify(ctx context.Context, message Message) error
+}
+
+// FromEnv builds the notifier selected by NOTIFIER: "webhook" posts every message as JSON to
+// NOTIFY_WEBHOOK_URL, anything else only logs them.
+func FromEnv() Notifier {
+	if strings.EqualFold(os.Getenv("NOTIFIER"), "webhook") {
+		return &Webhook{URL: os.Getenv("NOTIFY_WEBHOOK_URL"), Client: &http.Client{Timeout: 10 * time.Second}}
+	}
+	return Log{}
+}
+
+// Log writes messages to the server log. It is the default for development.
+type Log struct{}
+
+func (Log) Name() string {
+	return "log"
+}
+
+func (Log) Notify(ctx context.Context, message Message) error {
+	log.Printf("notify %s to %s <%s>: %s", message.Kind, message.Name, message.Email, message.Subject)
+	return nil
+}
+
+// Webhook posts messages as JSON to a URL, leaving delivery to another service.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Notify(ctx context.Context, message Message) error {
+	if w.URL == "" {
+		return fmt.Errorf("notify: no webhook url configured")
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := w.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("notify: webhook answered %s", response.Status)
+	}
+	return nil
+}
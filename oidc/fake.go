@@ -0,0 +1,210 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+// fakeKeyID names the only signing key of a Fake.
+const fakeKeyID = "fake"
+
+// Fake is an OpenID provider for development and tests. It signs in the user it is set up with
+// without asking anything, but otherwise checks what a real provider checks: the client, the
+// redirect URL, PKCE and single use codes. Serve it over HTTP, with httptest or cmd/fakeoidc,
+// and point a provider's issuer at it.
+type Fake struct {
+	// Issuer is the address the fake is served at; when empty it is taken from each request.
+	Issuer        string
+	Client_ID     string
+	Client_Secret string
+	// the user signing in
+	Subject        string
+	Email          string
+	Email_Verified bool
+	Given_Name     string
+	Family_Name    string
+
+	key   *ecdsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]fakeCode
+}
+
+// fakeCode is an authorization code waiting to be exchanged.
+type fakeCode struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	expiresAt   time.Time
+}
+
+// NewFake returns a fake provider for the client, signing in a verified test user.
+func NewFake(clientID, clientSecret string) (*Fake, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Fake{
+		Client_ID:      clientID,
+		Client_Secret:  clientSecret,
+		Subject:        "fake-user",
+		Email:          "fake.user@example.com",
+		Email_Verified: true,
+		Given_Name:     "Fake",
+		Family_Name:    "User",
+		key:            key,
+		codes:          map[string]fakeCode{},
+	}, nil
+}
+
+// ServeHTTP serves discovery, the authorization and token endpoints and the keys.
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		issuer := f.issuer(r)
+		writeJSON(w, http.StatusOK, Discovery{
+			Issuer:                 issuer,
+			Authorization_Endpoint: issuer + "/authorize",
+			Token_Endpoint:         issuer + "/token",
+			Jwks_URI:               issuer + "/jwks",
+		})
+	case "/authorize":
+		f.authorize(w, r)
+	case "/token":
+		f.token(w, r)
+	case "/jwks":
+		x := base64.RawURLEncoding.EncodeToString(f.key.X.FillBytes(make([]byte, 32)))
+		y := base64.RawURLEncoding.EncodeToString(f.key.Y.FillBytes(make([]byte, 32)))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{
+			{"kty": "EC", "use": "sig", "alg": "ES256", "kid": fakeKeyID, "crv": "P-256", "x": x, "y": y},
+		}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// issuer returns the configured issuer or the address the request was sent to.
+func (f *Fake) issuer(r *http.Request) string {
+	if f.Issuer != "" {
+		return strings.TrimSuffix(f.Issuer, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// authorize signs the user in at once and sends them back with a code.
+func (f *Fake) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if query.Get("client_id") != f.Client_ID || redirectURI == "" {
+		http.Error(w, "unknown client or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "only the code flow with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+
+	code, err := RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.mu.Lock()
+	f.codes[code] = fakeCode{
+		redirectURI: redirectURI,
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	f.mu.Unlock()
+
+	back, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	values := back.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	back.RawQuery = values.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+// token exchanges a code for an ID token, once, given the PKCE verifier.
+func (f *Fake) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != f.Client_ID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(f.Client_Secret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	f.mu.Lock()
+	code, found := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	f.mu.Unlock()
+	if r.PostForm.Get("grant_type") != "authorization_code" || !found || time.Now().After(code.expiresAt) ||
+		code.redirectURI != r.PostForm.Get("redirect_uri") || Challenge(r.PostForm.Get("code_verifier")) != code.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            f.issuer(r),
+		"sub":            f.Subject,
+		"aud":            f.Client_ID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          code.nonce,
+		"email":          f.Email,
+		"email_verified": f.Email_Verified,
+		"given_name":     f.Given_Name,
+		"family_name":    f.Family_Name,
+		"name":           strings.TrimSpace(f.Given_Name + " " + f.Family_Name),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = fakeKeyID
+	signed, err := token.SignedString(f.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "fake-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+// writeJSON answers with the value as JSON.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,383 @@
+// Package oidc is the relying party side of OpenID Connect: it sends users to a provider with
+// the authorization code flow and PKCE, then exchanges the code and verifies the ID token.
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrDiscovery       = errors.New("can't read the configuration of the identity provider")
+	ErrExchange        = errors.New("the identity provider refused the authorization code")
+	ErrInvalidIDToken  = errors.New("the ID token of the identity provider is invalid")
+	ErrUnknownProvider = errors.New("unknown identity provider")
+)
+
+// keyRefreshInterval is the least time between two downloads of the keys of a provider, when
+// a token names a key it doesn't know.
+const keyRefreshInterval = time.Minute
+
+// Config describes a provider as registered with it.
+type Config struct {
+	// Name identifies the provider in our routes and in the identities linked to users.
+	Name          string
+	Issuer        string
+	Client_ID     string
+	Client_Secret string
+	Redirect_URL  string
+	Scopes        []string
+}
+
+// Discovery is the part of the provider metadata we use, from
+// <issuer>/.well-known/openid-configuration.
+type Discovery struct {
+	Issuer                 string `json:"issuer"`
+	Authorization_Endpoint string `json:"authorization_endpoint"`
+	Token_Endpoint         string `json:"token_endpoint"`
+	Jwks_URI               string `json:"jwks_uri"`
+}
+
+// Claims is what we learn about the user from a verified ID token.
+type Claims struct {
+	Subject        string
+	Email          string
+	Email_Verified bool
+	Name           string
+	Given_Name     string
+	Family_Name    string
+}
+
+// Provider talks to one identity provider. Its metadata and keys are fetched on first use and
+// kept.
+type Provider struct {
+	Config
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *Discovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// NewProvider returns a provider using client, or a client with a timeout when nil.
+func NewProvider(config Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{Config: config, client: client}
+}
+
+// FromEnv builds the providers named in OIDC_PROVIDERS, separated by commas. Each provider
+// <NAME> is configured by OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET
+// and optionally OIDC_<NAME>_SCOPES, separated by spaces. Its redirect URL is
+// <callbackBase>/<name>/callback.
+func FromEnv(callbackBase string) map[string]*Provider {
+	providers := map[string]*Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		providers[name] = NewProvider(Config{
+			Name:          name,
+			Issuer:        strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			Client_ID:     os.Getenv(prefix + "CLIENT_ID"),
+			Client_Secret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Redirect_URL:  callbackBase + "/" + name + "/callback",
+			Scopes:        strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}, nil)
+	}
+	return providers
+}
+
+// RandomString returns an unguessable url safe string, for states, nonces and PKCE verifiers.
+func RandomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Challenge returns the S256 PKCE challenge of a verifier, RFC 7636.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Discover returns the metadata of the provider, fetching it the first time.
+func (p *Provider) Discover(ctx context.Context) (*Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery Discovery
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	// the metadata must be the issuer's own, OpenID Connect Discovery section 4.3
+	if discovery.Issuer != p.Issuer || discovery.Authorization_Endpoint == "" || discovery.Token_Endpoint == "" || discovery.Jwks_URI == "" {
+		return nil, ErrDiscovery
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// AuthCodeURL returns where to send the user to sign in, bound to the state, the nonce and the
+// PKCE verifier kept for the callback.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Client_ID},
+		"redirect_uri":          {p.Redirect_URL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.Authorization_Endpoint, "?") {
+		separator = "&"
+	}
+	return discovery.Authorization_Endpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades the authorization code for the ID token of the user.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Redirect_URL},
+		"code_verifier": {verifier},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.Token_Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(p.Client_ID), url.QueryEscape(p.Client_Secret))
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s %s", ErrExchange, response.Status, body)
+	}
+
+	var tokens struct {
+		ID_Token string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.ID_Token == "" {
+		return "", fmt.Errorf("%w: no id_token in the answer", ErrExchange)
+	}
+	return tokens.ID_Token, nil
+}
+
+// VerifyIDToken checks the signature of the ID token against the keys of the provider, that
+// it was issued by the provider for us, is current and carries the nonce of the login, and
+// returns what it says about the user.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.Alg() {
+		case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg():
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, discovery.Jwks_URI, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	// exp, iat and nbf were checked while parsing
+	if !claims.VerifyIssuer(discovery.Issuer, true) || !claims.VerifyAudience(p.Client_ID, true) {
+		return nil, fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidIDToken)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("%w: the nonce doesn't match the login", ErrInvalidIDToken)
+	}
+
+	result := &Claims{
+		Subject:     stringClaim(claims, "sub"),
+		Email:       stringClaim(claims, "email"),
+		Name:        stringClaim(claims, "name"),
+		Given_Name:  stringClaim(claims, "given_name"),
+		Family_Name: stringClaim(claims, "family_name"),
+	}
+	// some providers send the flag as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.Email_Verified = verified
+	case string:
+		result.Email_Verified = verified == "true"
+	}
+	if result.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	return result, nil
+}
+
+// stringClaim returns the claim when it is a string.
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// key returns the public key with the id, downloading the keys of the provider again when it
+// is unknown, as happens after the provider rotates them.
+func (p *Provider) key(ctx context.Context, jwksURI, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]interface{}{}
+	for _, raw := range set.Keys {
+		id, key, err := parseJWK(raw)
+		if err != nil {
+			// keys of other kinds or uses don't concern us
+			continue
+		}
+		keys[id] = key
+	}
+	p.keys, p.keysFetchedAt = keys, time.Now()
+
+	// a provider with a single key may leave out the kid
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// parseJWK reads an RSA or P-256 signing key of a JSON Web Key Set.
+func parseJWK(raw json.RawMessage) (string, interface{}, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return "", nil, errors.New("not a signing key")
+	}
+
+	decode := func(value string) (*big.Int, error) {
+		bytes, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(bytes), nil
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return "", nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return "", nil, errors.New("invalid RSA exponent")
+		}
+		return jwk.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return "", nil, errors.New("unsupported curve")
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return "", nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return "", nil, errors.New("the point is not on the curve")
+		}
+		return jwk.Kid, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return "", nil, errors.New("unsupported key type")
+}
+
+// getJSON decodes the JSON document at the address into value.
+func (p *Provider) getJSON(ctx context.Context, address string, value interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", address, response.Status)
+	}
+	return json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(value)
+}
@@ -0,0 +1,184 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// setup serves a fake provider and returns a provider pointed at it.
+func setup(t *testing.T) (*Fake, *Provider) {
+	t.Helper()
+	fake, err := NewFake("shop", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	provider := NewProvider(Config{
+		Name:          "fake",
+		Issuer:        server.URL,
+		Client_ID:     "shop",
+		Client_Secret: "secret",
+		Redirect_URL:  "https://shop.example.com/auth/oidc/fake/callback",
+	}, server.Client())
+	return fake, provider
+}
+
+// authorize signs in at the provider and returns the code and state it sends back.
+func authorize(t *testing.T, provider *Provider, state, nonce, verifier string) (string, string) {
+	t.Helper()
+	address, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		t.Fatalf("authorize answered %s", response.Status)
+	}
+	back, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Scheme+"://"+back.Host+back.Path != provider.Redirect_URL {
+		t.Fatalf("redirected to %s, want %s", back, provider.Redirect_URL)
+	}
+	return back.Query().Get("code"), back.Query().Get("state")
+}
+
+func TestFlow(t *testing.T) {
+	fake, provider := setup(t)
+	ctx := context.Background()
+
+	code, state := authorize(t, provider, "the-state", "the-nonce", "the-verifier")
+	if state != "the-state" {
+		t.Errorf("state = %q, want the one sent", state)
+	}
+	idToken, err := provider.Exchange(ctx, code, "the-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := provider.VerifyIDToken(ctx, idToken, "the-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{
+		Subject:        fake.Subject,
+		Email:          fake.Email,
+		Email_Verified: true,
+		Name:           "Fake User",
+		Given_Name:     "Fake",
+		Family_Name:    "User",
+	}
+	if *claims != want {
+		t.Errorf("claims = %+v, want %+v", *claims, want)
+	}
+}
+
+func TestUnverifiedEmail(t *testing.T) {
+	fake, provider := setup(t)
+	fake.Email_Verified = false
+	ctx := context.Background()
+
+	code, _ := authorize(t, provider, "state", "nonce", "verifier")
+	idToken, err := provider.Exchange(ctx, code, "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := provider.VerifyIDToken(ctx, idToken, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email_Verified {
+		t.Error("the email shows as verified")
+	}
+}
+
+func TestExchangeRefused(t *testing.T) {
+	tests := []struct {
+		name string
+		// exchange trades the code the provider sent back
+		exchange func(ctx context.Context, provider *Provider, code string) error
+	}{
+		{"wrong PKCE verifier", func(ctx context.Context, provider *Provider, code string) error {
+			_, err := provider.Exchange(ctx, code, "another-verifier")
+			return err
+		}},
+		{"code used twice", func(ctx context.Context, provider *Provider, code string) error {
+			if _, err := provider.Exchange(ctx, code, "verifier"); err != nil {
+				return nil
+			}
+			_, err := provider.Exchange(ctx, code, "verifier")
+			return err
+		}},
+		{"unknown code", func(ctx context.Context, provider *Provider, code string) error {
+			_, err := provider.Exchange(ctx, code+"x", "verifier")
+			return err
+		}},
+		{"wrong client secret", func(ctx context.Context, provider *Provider, code string) error {
+			provider.Client_Secret = "guess"
+			_, err := provider.Exchange(ctx, code, "verifier")
+			return err
+		}},
+		{"other redirect URL", func(ctx context.Context, provider *Provider, code string) error {
+			provider.Redirect_URL = "https://elsewhere.example.com/callback"
+			_, err := provider.Exchange(ctx, code, "verifier")
+			return err
+		}},
+	}
+	for _, test := range tests {
+		_, provider := setup(t)
+		code, _ := authorize(t, provider, "state", "nonce", "verifier")
+		if err := test.exchange(context.Background(), provider, code); !errors.Is(err, ErrExchange) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, ErrExchange)
+		}
+	}
+}
+
+func TestVerifyIDTokenRefused(t *testing.T) {
+	_, provider := setup(t)
+	ctx := context.Background()
+	code, _ := authorize(t, provider, "state", "nonce", "verifier")
+	idToken, err := provider.Exchange(ctx, code, "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, idToken, "another-nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("nonce mismatch: err = %v, want %v", err, ErrInvalidIDToken)
+	}
+	if _, err := provider.VerifyIDToken(ctx, idToken[:len(idToken)-4]+"AAAA", "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("forged signature: err = %v, want %v", err, ErrInvalidIDToken)
+	}
+
+	// a token the provider issued to another client
+	other := NewProvider(provider.Config, provider.client)
+	other.Client_ID = "another-shop"
+	if _, err := other.VerifyIDToken(ctx, idToken, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("other audience: err = %v, want %v", err, ErrInvalidIDToken)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	fake, provider := setup(t)
+	fake.Issuer = "https://impostor.example.com"
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); !errors.Is(err, ErrDiscovery) {
+		t.Errorf("err = %v, want %v", err, ErrDiscovery)
+	}
+}
+
+// The S256 challenge of the verifier of RFC 7636 Appendix B.
+func TestChallenge(t *testing.T) {
+	if got := Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Challenge = %s", got)
+	}
+}
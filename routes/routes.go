@@ -3,12 +3,28 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/ravelinejunior/golang_ecommerce/controllers"
+	"github.com/ravelinejunior/golang_ecommerce/middleware"
 )
 
 func UserRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.POST("users/signup", controllers.Signup())
 	incomingRoutes.POST("users/login", controllers.Login())
-	incomingRoutes.POST("/admin/add_product", controllers.ProductViewerAdmin())
+	incomingRoutes.GET("/users/verify-email", controllers.VerifyEmail())
+	incomingRoutes.POST("/users/login/mfa", controllers.VerifyMfaLogin())
+	incomingRoutes.GET("/.well-known/jwks.json", controllers.JWKS())
+	incomingRoutes.GET("/auth/oidc/:provider", controllers.StartOidcLogin())
+	incomingRoutes.GET("/auth/oidc/:provider/callback", controllers.OidcCallback())
+	incomingRoutes.POST("/users/password/forgot", controllers.ForgotPassword())
+	incomingRoutes.POST("/users/password/reset", controllers.ResetPassword())
+	incomingRoutes.GET("/account-deletions/:id", controllers.AccountDeletionStatus())
 	incomingRoutes.GET("/users/product_view", controllers.SearchProduct())
 	incomingRoutes.GET("/users/search", controllers.SearchProductByQuery())
+	incomingRoutes.GET("/categories", controllers.ListCategories())
+	incomingRoutes.GET("/categories/:slug/products", controllers.CategoryProducts())
+	incomingRoutes.GET("/products/:id/reviews", middleware.OptionalAuthentication(), controllers.ProductReviews())
+	incomingRoutes.GET("/wishlists/shared/:token", controllers.SharedWishlist())
+	incomingRoutes.POST("/guest/cart", controllers.CreateGuestCart())
+	incomingRoutes.GET("/guest/cart", controllers.GetGuestCart())
+	incomingRoutes.POST("/guest/cart/items", controllers.AddGuestCartItem())
+	incomingRoutes.DELETE("/guest/cart/items/:product_id", controllers.RemoveGuestCartItem())
 }
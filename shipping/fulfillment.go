@@ -0,0 +1,92 @@
+package shipping
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrNotInOrder = errors.New("the shipment holds a product that is not in the order")
+
+// OrderedQuantities counts the units of each product in the order. The cart
+// stores one entry per unit, so repeated products add up.
+func OrderedQuantities(order *models.Order) map[primitive.ObjectID]int {
+	quantities := make(map[primitive.ObjectID]int)
+	for _, item := range order.Order_Cart {
+		quantities[item.Product_ID]++
+	}
+	return quantities
+}
+
+// CheckShipmentItems makes sure the items fit in what is left to ship of the
+// order once the other shipments are accounted for.
+func CheckShipmentItems(order *models.Order, others []models.Shipment, items []models.ShipmentItem) error {
+	remaining := OrderedQuantities(order)
+	for _, shipment := range others {
+		for _, item := range shipment.Items {
+			remaining[item.Product_ID] -= item.Quantity
+		}
+	}
+
+	for _, item := range items {
+		left, ok := remaining[item.Product_ID]
+		if !ok {
+			return ErrNotInOrder
+		}
+		if item.Quantity > left {
+			return fmt.Errorf("only %d unit(s) of product %s are left to ship", left, item.Product_ID.Hex())
+		}
+		remaining[item.Product_ID] -= item.Quantity
+	}
+	return nil
+}
+
+// OrderStatus derives the status of the order from its shipments. Units only
+// count as shipped once their shipment has left the warehouse; a shipment held
+// up by an exception has left it all the same. Refunds are final, so a refunded
+// order keeps its status whatever its shipments do.
+func OrderStatus(order *models.Order, shipments []models.Shipment) string {
+	if order.Status == models.OrderRefunded {
+		return order.Status
+	}
+	ordered := OrderedQuantities(order)
+	shipped := make(map[primitive.ObjectID]int)
+	delivered := make(map[primitive.ObjectID]int)
+
+	for _, shipment := range shipments {
+		for _, item := range shipment.Items {
+			switch shipment.Status {
+			case models.ShipmentDelivered:
+				delivered[item.Product_ID] += item.Quantity
+				shipped[item.Product_ID] += item.Quantity
+			case models.ShipmentInTransit, models.ShipmentOutForDelivery, models.ShipmentException:
+				shipped[item.Product_ID] += item.Quantity
+			}
+		}
+	}
+
+	if len(ordered) == 0 {
+		return order.Status
+	}
+	if covers(delivered, ordered) {
+		return models.OrderDelivered
+	}
+	if covers(shipped, ordered) {
+		return models.OrderShipped
+	}
+	if len(shipped) > 0 {
+		return models.OrderPartiallyShipped
+	}
+	return models.OrderPlaced
+}
+
+func covers(have, want map[primitive.ObjectID]int) bool {
+	for product, quantity := range want {
+		if have[product] < quantity {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package shipping
+
+import (
+	"testing"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOrderStatus(t *testing.T) {
+	mug, shirt := primitive.NewObjectID(), primitive.NewObjectID()
+	// two mugs and a shirt, one cart entry per unit
+	cart := []models.ProductUser{{Product_ID: mug}, {Product_ID: mug}, {Product_ID: shirt}}
+	shipment := func(status string, items ...models.ShipmentItem) models.Shipment {
+		return models.Shipment{Status: status, Items: items}
+	}
+	mugs := func(quantity int) models.ShipmentItem {
+		return models.ShipmentItem{Product_ID: mug, Quantity: quantity}
+	}
+	shirts := models.ShipmentItem{Product_ID: shirt, Quantity: 1}
+
+	tests := []struct {
+		name      string
+		status    string
+		cart      []models.ProductUser
+		shipments []models.Shipment
+		want      string
+	}{
+		{"no shipments", models.OrderPlaced, cart, nil, models.OrderPlaced},
+		{"label created", models.OrderPlaced, cart, []models.Shipment{shipment(models.ShipmentLabelCreated, mugs(2), shirts)}, models.OrderPlaced},
+		{"some units in transit", models.OrderPlaced, cart, []models.Shipment{shipment(models.ShipmentInTransit, mugs(1))}, models.OrderPartiallyShipped},
+		{"some units delivered", models.OrderPlaced, cart, []models.Shipment{shipment(models.ShipmentDelivered, shirts)}, models.OrderPartiallyShipped},
+		{"every unit shipped", models.OrderPlaced, cart, []models.Shipment{shipment(models.ShipmentInTransit, mugs(2)), shipment(models.ShipmentOutForDelivery, shirts)}, models.OrderShipped},
+		{"every unit shipped, some delivered", models.OrderPlaced, cart, []models.Shipment{shipment(models.ShipmentDelivered, mugs(2)), shipment(models.ShipmentInTransit, shirts)}, models.OrderShipped},
+		{"every unit delivered", models.OrderShipped, cart, []models.Shipment{shipment(models.ShipmentDelivered, mugs(1)), shipment(models.ShipmentDelivered, mugs(1), shirts)}, models.OrderDelivered},
+		{"exception before leaving the warehouse", models.OrderPlaced, cart, []models.Shipment{shipment(models.ShipmentException, mugs(1))}, models.OrderPartiallyShipped},
+		{"exception on the only shipment", models.OrderPlaced, cart, []models.Shipment{shipment(models.ShipmentException, mugs(2), shirts)}, models.OrderShipped},
+		{"exception with the rest delivered", models.OrderPlaced, cart, []models.Shipment{shipment(models.ShipmentDelivered, mugs(2)), shipment(models.ShipmentException, shirts)}, models.OrderShipped},
+		{"refunded before shipping", models.OrderRefunded, cart, nil, models.OrderRefunded},
+		{"refunded after delivery", models.OrderRefunded, cart, []models.Shipment{shipment(models.ShipmentDelivered, mugs(2), shirts)}, models.OrderRefunded},
+		{"empty cart", models.OrderShipped, nil, []models.Shipment{shipment(models.ShipmentDelivered, mugs(2))}, models.OrderShipped},
+	}
+	for _, test := range tests {
+		order := &models.Order{Status: test.status, Order_Cart: test.cart}
+		if status := OrderStatus(order, test.shipments); status != test.want {
+			t.Errorf("%s: status = %s, want %s", test.name, status, test.want)
+		}
+	}
+}
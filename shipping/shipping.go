@@ -0,0 +1,170 @@
+package shipping
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+var (
+	ErrNoZone          = errors.New("we don't ship to this address")
+	ErrNoMethod        = errors.New("this shipping method is not available for the address")
+	ErrNoTier          = errors.New("the cart is outside every rate tier of this shipping method")
+	ErrUnknownRateType = errors.New("unknown shipping rate type")
+	ErrNoTiers         = errors.New("weight and subtotal rates need at least one tier")
+)
+
+// CheckZone makes sure every tiered method of the zone has a tier to price
+// carts with; a method without any would never be offered.
+func CheckZone(zone *models.ShippingZone) error {
+	for _, method := range zone.Methods {
+		if (method.Rate == models.RateWeight || method.Rate == models.RateSubtotal) && len(method.Tiers) == 0 {
+			return ErrNoTiers
+		}
+	}
+	return nil
+}
+
+// MatchZone returns the zone serving the given country and postal code. When
+// several zones cover the country, the one with the longest matching postal
+// prefix wins, and zones without prefixes act as the country-wide fallback.
+func MatchZone(zones []models.ShippingZone, country, postal string) (*models.ShippingZone, error) {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	postal = strings.ToUpper(strings.ReplaceAll(postal, " ", ""))
+
+	var best *models.ShippingZone
+	bestLen := -1
+	for i := range zones {
+		zone := &zones[i]
+		if !containsCountry(zone.Countries, country) {
+			continue
+		}
+
+		// a zone without prefixes covers the whole country
+		matched := -1
+		if len(zone.Postal_Prefixes) == 0 {
+			matched = 0
+		}
+		for _, prefix := range zone.Postal_Prefixes {
+			prefix = strings.ToUpper(strings.ReplaceAll(prefix, " ", ""))
+			if strings.HasPrefix(postal, prefix) && len(prefix) > matched {
+				matched = len(prefix)
+			}
+		}
+
+		if matched > bestLen {
+			best, bestLen = zone, matched
+		}
+	}
+
+	if best == nil {
+		return nil, ErrNoZone
+	}
+	return best, nil
+}
+
+// Rate prices a single method for a cart of the given weight and subtotal.
+func Rate(method models.ShippingMethod, weight uint64, subtotal int) (int, error) {
+	switch method.Rate {
+	case models.RateFlat:
+		return method.Flat_Fee, nil
+	case models.RateWeight:
+		return tierPrice(method.Tiers, weight)
+	case models.RateSubtotal:
+		if subtotal < 0 {
+			subtotal = 0
+		}
+		return tierPrice(method.Tiers, uint64(subtotal))
+	}
+	return 0, ErrUnknownRateType
+}
+
+// Quote prices every method of the zone for the given items.
+func Quote(zone *models.ShippingZone, items []models.ProductUser) ([]models.ShippingQuote, error) {
+	weight, subtotal := CartWeight(items), CartSubtotal(items)
+
+	quotes := make([]models.ShippingQuote, 0, len(zone.Methods))
+	for _, method := range zone.Methods {
+		price, err := Rate(method, weight, subtotal)
+		if err == ErrNoTier {
+			// the method simply doesn't apply to this cart
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, models.ShippingQuote{
+			Zone_ID: zone.Zone_ID,
+			Method:  method.Code,
+			Name:    method.Name,
+			Weight:  weight,
+			Price:   price,
+		})
+	}
+	return quotes, nil
+}
+
+// QuoteMethod prices a single method of the zone for the given items.
+func QuoteMethod(zone *models.ShippingZone, code string, items []models.ProductUser) (*models.ShippingQuote, error) {
+	quotes, err := Quote(zone, items)
+	if err != nil {
+		return nil, err
+	}
+	for i := range quotes {
+		if quotes[i].Method == code {
+			return &quotes[i], nil
+		}
+	}
+	return nil, ErrNoMethod
+}
+
+// CartWeight sums the weight of the items in grams. Items without a weight
+// count as weightless.
+func CartWeight(items []models.ProductUser) uint64 {
+	var total uint64
+	for _, item := range items {
+		if item.Weight != nil {
+			total += *item.Weight
+		}
+	}
+	return total
+}
+
+// CartSubtotal sums the price of the items.
+func CartSubtotal(items []models.ProductUser) int {
+	total := 0
+	for _, item := range items {
+		total += item.Price
+	}
+	return total
+}
+
+func tierPrice(tiers []models.RateTier, value uint64) (int, error) {
+	// bounded tiers first, smallest first; the unbounded tier last
+	sorted := make([]models.RateTier, len(tiers))
+	copy(sorted, tiers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Up_To == 0 || sorted[j].Up_To == 0 {
+			return sorted[j].Up_To == 0 && sorted[i].Up_To != 0
+		}
+		return sorted[i].Up_To < sorted[j].Up_To
+	})
+
+	for _, tier := range sorted {
+		if tier.Up_To == 0 || value <= tier.Up_To {
+			return tier.Price, nil
+		}
+	}
+	return 0, ErrNoTier
+}
+
+func containsCountry(countries []string, country string) bool {
+	for _, c := range countries {
+		if strings.ToUpper(strings.TrimSpace(c)) == country {
+			return true
+		}
+	}
+	return false
+}
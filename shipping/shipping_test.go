@@ -0,0 +1,106 @@
+package shipping
+
+import (
+	"testing"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+func zone(name string, countries []string, prefixes ...string) models.ShippingZone {
+	return models.ShippingZone{Name: &name, Countries: countries, Postal_Prefixes: prefixes}
+}
+
+func TestMatchZone(t *testing.T) {
+	zones := []models.ShippingZone{
+		zone("US", []string{"us"}),
+		zone("California", []string{"US"}, "9"),
+		zone("Los Angeles", []string{"US"}, "900", "901"),
+		zone("London", []string{"GB"}, "E1 ", "EC"),
+		zone("Europe", []string{"FR", "DE"}),
+	}
+
+	tests := []struct {
+		name    string
+		country string
+		postal  string
+		zone    string
+	}{
+		{"country-wide fallback", "US", "10001", "US"},
+		{"prefix over the fallback", "US", "94105", "California"},
+		{"longest prefix", "US", "90012", "Los Angeles"},
+		{"second prefix of a zone", "US", "90155", "Los Angeles"},
+		{"country case and spaces", " us ", "90012", "Los Angeles"},
+		{"postal code case and spaces", "GB", "e1 6an", "London"},
+		{"prefix without a fallback", "GB", "EC1A 1BB", "London"},
+		{"zone of several countries", "DE", "10115", "Europe"},
+		{"no prefix matching and no fallback", "GB", "SW1A 1AA", ""},
+		{"country without a zone", "BR", "01310", ""},
+	}
+	for _, test := range tests {
+		matched, err := MatchZone(zones, test.country, test.postal)
+		if test.zone == "" {
+			if err != ErrNoZone {
+				t.Errorf("%s: err = %v, want %v", test.name, err, ErrNoZone)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if *matched.Name != test.zone {
+			t.Errorf("%s: zone = %s, want %s", test.name, *matched.Name, test.zone)
+		}
+	}
+}
+
+func TestTierPrice(t *testing.T) {
+	// listed out of order, the unbounded tier first
+	tiers := []models.RateTier{{Up_To: 0, Price: 3000}, {Up_To: 5000, Price: 1500}, {Up_To: 1000, Price: 500}}
+	bounded := tiers[1:]
+
+	tests := []struct {
+		name  string
+		tiers []models.RateTier
+		value uint64
+		price int
+		err   error
+	}{
+		{"nothing", tiers, 0, 500, nil},
+		{"up to the first bound", tiers, 1000, 500, nil},
+		{"just over the first bound", tiers, 1001, 1500, nil},
+		{"up to the second bound", tiers, 5000, 1500, nil},
+		{"over every bound", tiers, 5001, 3000, nil},
+		{"up to the last bound without an unbounded tier", bounded, 5000, 1500, nil},
+		{"over the last bound without an unbounded tier", bounded, 5001, 0, ErrNoTier},
+		{"no tiers", nil, 1, 0, ErrNoTier},
+	}
+	for _, test := range tests {
+		price, err := tierPrice(test.tiers, test.value)
+		if err != test.err || price != test.price {
+			t.Errorf("%s: tierPrice(%d) = %d, %v, want %d, %v", test.name, test.value, price, err, test.price, test.err)
+		}
+	}
+}
+
+func TestCheckZone(t *testing.T) {
+	tiers := []models.RateTier{{Up_To: 0, Price: 500}}
+	tests := []struct {
+		name   string
+		method models.ShippingMethod
+		err    error
+	}{
+		{"flat rate", models.ShippingMethod{Code: models.ShippingStandard, Rate: models.RateFlat, Flat_Fee: 500}, nil},
+		{"weight rate with a tier", models.ShippingMethod{Code: models.ShippingStandard, Rate: models.RateWeight, Tiers: tiers}, nil},
+		{"subtotal rate with a tier", models.ShippingMethod{Code: models.ShippingStandard, Rate: models.RateSubtotal, Tiers: tiers}, nil},
+		{"weight rate without tiers", models.ShippingMethod{Code: models.ShippingStandard, Rate: models.RateWeight}, ErrNoTiers},
+		{"subtotal rate with an empty tier list", models.ShippingMethod{Code: models.ShippingStandard, Rate: models.RateSubtotal, Tiers: []models.RateTier{}}, ErrNoTiers},
+	}
+	for _, test := range tests {
+		zone := zone("zone", []string{"US"})
+		zone.Methods = []models.ShippingMethod{{Code: models.ShippingPickup, Rate: models.RateFlat}, test.method}
+		if err := CheckZone(&zone); err != test.err {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
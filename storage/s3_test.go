@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bucket is an S3 stand-in keeping the objects of one bucket in memory. It refuses requests
+// whose signature doesn't match the one it computes with its own copy of the secret.
+type bucket struct {
+	t      *testing.T
+	signer *S3
+	mu     sync.Mutex
+	// objects maps the escaped request paths to their content
+	objects map[string]string
+	// paths records the escaped path of every request
+	paths []string
+}
+
+func (b *bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	path := r.URL.EscapedPath()
+
+	if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+		http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+		return
+	}
+	at, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	resigned, _ := http.NewRequest(r.Method, "http://"+r.Host+path, nil)
+	resigned.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	b.signer.sign(resigned, path, body, at)
+	if r.Header.Get("Authorization") != resigned.Header.Get("Authorization") {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paths = append(b.paths, path)
+	switch r.Method {
+	case http.MethodPut:
+		b.objects[path] = string(body)
+	case http.MethodGet:
+		content, ok := b.objects[path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, content)
+	case http.MethodDelete:
+		delete(b.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+var testConfig = S3Config{Region: "eu-west-1", Bucket: "shop", AccessKey: "AKID", SecretKey: "secret"}
+
+// setupS3 serves a bucket checking signatures with the secret and returns a store using the
+// test config against it.
+func setupS3(t *testing.T, secret string) (*bucket, *S3) {
+	t.Helper()
+	b := &bucket{t: t, objects: map[string]string{}}
+	server := httptest.NewServer(b)
+	t.Cleanup(server.Close)
+
+	config := testConfig
+	config.Endpoint = server.URL + "/"
+	store, err := NewS3(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SecretKey = secret
+	b.signer, _ = NewS3(config)
+	return b, store
+}
+
+func TestS3(t *testing.T) {
+	b, store := setupS3(t, testConfig.SecretKey)
+	ctx := context.Background()
+	key := "products/42/front view+1.jpg"
+
+	if err := store.Put(ctx, key, strings.NewReader("jpeg bytes"), 10, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/shop/products/42/front%20view%2B1.jpg"; len(b.paths) != 1 || b.paths[0] != want {
+		t.Errorf("requested paths = %v, want [%s]", b.paths, want)
+	}
+
+	content, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(content)
+	content.Close()
+	if string(data) != "jpeg bytes" {
+		t.Errorf("Get = %q, want the content put", data)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, key); err != ErrNotFound {
+		t.Errorf("Get after Delete: err = %v, want %v", err, ErrNotFound)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("deleting a missing object: err = %v, want nil", err)
+	}
+}
+
+func TestS3WrongSecret(t *testing.T) {
+	_, store := setupS3(t, "another-secret")
+	err := store.Put(context.Background(), "a.jpg", strings.NewReader("jpeg bytes"), 10, "image/jpeg")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("err = %v, want the signature refused", err)
+	}
+}
+
+func TestS3InvalidKey(t *testing.T) {
+	b, store := setupS3(t, testConfig.SecretKey)
+	for _, key := range []string{"", "/", "products//a.jpg", "products/./a.jpg", "../a.jpg"} {
+		if err := store.Put(context.Background(), key, strings.NewReader("x"), 1, ""); err == nil {
+			t.Errorf("Put(%q) was accepted", key)
+		}
+	}
+	if len(b.paths) != 0 {
+		t.Errorf("invalid keys were requested: %v", b.paths)
+	}
+}
+
+func TestS3URL(t *testing.T) {
+	tests := []struct {
+		public string
+		key    string
+		url    string
+	}{
+		{"", "products/1/a b.jpg", "https://s3.example.com/shop/products/1/a%20b.jpg"},
+		{"https://cdn.example.com/", "/products/1/a.jpg", "https://cdn.example.com/products/1/a.jpg"},
+	}
+	for _, test := range tests {
+		config := testConfig
+		config.Endpoint = "https://s3.example.com"
+		config.PublicURL = test.public
+		store, err := NewS3(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url := store.URL(test.key); url != test.url {
+			t.Errorf("URL(%q) with public URL %q = %s, want %s", test.key, test.public, url, test.url)
+		}
+	}
+}
+
+func TestNewS3Incomplete(t *testing.T) {
+	if _, err := NewS3(S3Config{Endpoint: "https://s3.example.com", Bucket: "shop"}); err == nil {
+		t.Error("a config without credentials was accepted")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	store, err := NewLocal(t.TempDir(), "/media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	key := "products/42/a.png"
+
+	if err := store.Put(ctx, key, strings.NewReader("png bytes"), 9, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(content)
+	content.Close()
+	if string(data) != "png bytes" {
+		t.Errorf("Get = %q, want the content put", data)
+	}
+	if url := store.URL(key); url != "/media/products/42/a.png" {
+		t.Errorf("URL = %s", url)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want %v", err, ErrNotFound)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("deleting a missing blob: err = %v, want nil", err)
+	}
+	if err := store.Put(ctx, "../escaped.png", strings.NewReader("x"), 1, ""); err == nil {
+		t.Error("a key leaving the directory was accepted")
+	}
+}
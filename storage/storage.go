@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrNotFound = errors.New("the blob does not exist")
+
+// Store keeps uploaded files. Keys are slash separated paths such as
+// products/<id>/<image>.jpg.
+type Store interface {
+	// Put stores the content under the key, replacing any previous blob.
+	Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error
+	// Get opens the blob stored under the key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the public address of the blob.
+	URL(key string) string
+}
+
+// FromEnv builds the store selected by STORAGE_BACKEND: "s3" for an S3-compatible
+// service, anything else for the local filesystem.
+func FromEnv() (Store, error) {
+	if strings.EqualFold(os.Getenv("STORAGE_BACKEND"), "s3") {
+		return NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    envOr("S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicURL: os.Getenv("S3_PUBLIC_URL"),
+		})
+	}
+	return NewLocal(envOr("STORAGE_DIR", "uploads"), envOr("STORAGE_BASE_URL", "/media"))
+}
+
+// cleanKey rejects keys that could escape the store's root.
+func cleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(key, "/")
+	if key == "" {
+		return "", errors.New("empty blob key")
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", errors.New("invalid blob key " + key)
+		}
+	}
+	return key, nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
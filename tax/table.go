@@ -0,0 +1,113 @@
+package tax
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+// RateLookup returns the rates configured for the country of an address.
+type RateLookup func(ctx context.Context, country string) ([]models.TaxRate, error)
+
+// Table is the built-in provider, taxing orders from a table of rates.
+type Table struct {
+	Lookup RateLookup
+}
+
+// NewTable creates a table provider reading its rates through lookup.
+func NewTable(lookup RateLookup) *Table {
+	return &Table{Lookup: lookup}
+}
+
+// Name identifies the provider on the stored tax summary.
+func (t *Table) Name() string {
+	return "table"
+}
+
+// Calculate taxes every line at the rates matching the address and its tax class.
+func (t *Table) Calculate(ctx context.Context, request Request) (*models.TaxSummary, error) {
+	if request.Address == nil || request.Address.Country == nil || *request.Address.Country == "" {
+		return nil, ErrNoJurisdiction
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(*request.Address.Country))
+	rates, err := t.Lookup(ctx, country)
+	if err != nil {
+		return nil, err
+	}
+
+	postal := ""
+	if request.Address.PinCode != nil {
+		postal = strings.ToUpper(strings.ReplaceAll(*request.Address.PinCode, " ", ""))
+	}
+
+	summary := &models.TaxSummary{
+		Provider:           t.Name(),
+		Prices_Include_Tax: request.PricesIncludeTax,
+		Lines:              make([]models.TaxLine, 0, len(request.Lines)),
+	}
+	for _, line := range request.Lines {
+		applied := matchRates(rates, country, postal, line.Tax_Class)
+
+		basisPoints := 0
+		jurisdiction := make([]string, 0, len(applied))
+		for _, rate := range applied {
+			basisPoints += rate.Basis_Points
+			jurisdiction = append(jurisdiction, *rate.Name)
+		}
+
+		net, tax := Split(line.Amount, basisPoints, request.PricesIncludeTax)
+		summary.Lines = append(summary.Lines, models.TaxLine{
+			Product_ID:   line.Product_ID,
+			Description:  line.Description,
+			Tax_Class:    line.Tax_Class,
+			Jurisdiction: jurisdiction,
+			Basis_Points: basisPoints,
+			Net:          net,
+			Tax:          tax,
+			Gross:        net + tax,
+		})
+		summary.Net += net
+		summary.Tax += tax
+		summary.Gross += net + tax
+	}
+
+	return summary, nil
+}
+
+// matchRates picks, for every rate name, the rate of the class whose postal prefix
+// matches the postal code most specifically.
+func matchRates(rates []models.TaxRate, country, postal, class string) []models.TaxRate {
+	best := make(map[string]models.TaxRate)
+	bestLen := make(map[string]int)
+	order := make([]string, 0)
+	for _, rate := range rates {
+		if rate.Name == nil || rate.Country == nil || rate.Tax_Class == nil {
+			continue
+		}
+		if strings.ToUpper(*rate.Country) != country || *rate.Tax_Class != class {
+			continue
+		}
+
+		prefix := strings.ToUpper(strings.ReplaceAll(rate.Postal_Prefix, " ", ""))
+		if !strings.HasPrefix(postal, prefix) {
+			continue
+		}
+
+		_, seen := best[*rate.Name]
+		if !seen {
+			order = append(order, *rate.Name)
+		}
+		if !seen || len(prefix) > bestLen[*rate.Name] {
+			best[*rate.Name] = rate
+			bestLen[*rate.Name] = len(prefix)
+		}
+	}
+
+	matched := make([]models.TaxRate, 0, len(order))
+	for _, name := range order {
+		matched = append(matched, best[name])
+	}
+	return matched
+}
@@ -0,0 +1,73 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrNoJurisdiction = errors.New("the address has no country to derive taxes from")
+
+// PricesIncludeTax tells whether catalog prices already contain tax (as is common
+// for VAT) or whether tax is added on top of them (as with US sales tax).
+var PricesIncludeTax = os.Getenv("PRICES_INCLUDE_TAX") == "true"
+
+// Line is one taxable line of an order.
+type Line struct {
+	Product_ID  primitive.ObjectID
+	Description string
+	Tax_Class   string
+	Amount      int
+}
+
+// Request is everything a provider needs to tax an order.
+type Request struct {
+	Address          *models.Address
+	Lines            []Line
+	PricesIncludeTax bool
+}
+
+// Provider computes the taxes of an order. The built-in Table provider works from
+// configured rates; an external tax service can be plugged in by implementing it.
+type Provider interface {
+	Name() string
+	Calculate(ctx context.Context, request Request) (*models.TaxSummary, error)
+}
+
+// NewRequest builds the request for taxing the items and the shipping charge.
+func NewRequest(address *models.Address, items []models.ProductUser, shippingPrice int) Request {
+	lines := make([]Line, 0, len(items)+1)
+	for _, item := range items {
+		class := models.TaxStandard
+		if item.Tax_Class != nil && *item.Tax_Class != "" {
+			class = *item.Tax_Class
+		}
+
+		description := ""
+		if item.Product_Name != nil {
+			description = *item.Product_Name
+		}
+
+		lines = append(lines, Line{Product_ID: item.Product_ID, Description: description, Tax_Class: class, Amount: item.Price})
+	}
+
+	if shippingPrice > 0 {
+		lines = append(lines, Line{Description: "Shipping", Tax_Class: models.TaxStandard, Amount: shippingPrice})
+	}
+
+	return Request{Address: address, Lines: lines, PricesIncludeTax: PricesIncludeTax}
+}
+
+// Split divides an amount into its net and tax parts at the given rate in basis
+// points, rounding the tax half up.
+func Split(amount, basisPoints int, inclusive bool) (net, tax int) {
+	if inclusive {
+		tax = (amount*basisPoints + (10000+basisPoints)/2) / (10000 + basisPoints)
+		return amount - tax, tax
+	}
+	tax = (amount*basisPoints + 5000) / 10000
+	return amount, tax
+}
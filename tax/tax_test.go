@@ -0,0 +1,84 @@
+package tax
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ravelinejunior/golang_ecommerce/models"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      int
+		basisPoints int
+		inclusive   bool
+		net, tax    int
+	}{
+		{"exclusive", 1000, 2000, false, 1000, 200},
+		{"exclusive rounds a half up", 1, 5000, false, 1, 1},
+		{"exclusive rounds below a half down", 3, 1500, false, 3, 0},
+		{"exclusive rounds above a half up", 7, 1000, false, 7, 1},
+		{"exclusive without a rate", 999, 0, false, 999, 0},
+		{"inclusive", 1200, 2000, true, 1000, 200},
+		{"inclusive rounds down", 1999, 2000, true, 1666, 333},
+		{"inclusive rounds up", 1000, 2000, true, 833, 167},
+		{"inclusive rounds a half up", 1, 10000, true, 0, 1},
+		{"inclusive without a rate", 999, 0, true, 999, 0},
+		{"nothing", 0, 2000, true, 0, 0},
+	}
+	for _, test := range tests {
+		net, tax := Split(test.amount, test.basisPoints, test.inclusive)
+		if net != test.net || tax != test.tax {
+			t.Errorf("%s: Split(%d, %d, %v) = %d, %d, want %d, %d", test.name, test.amount, test.basisPoints, test.inclusive, net, tax, test.net, test.tax)
+		}
+	}
+}
+
+func rate(name, country, prefix, class string, basisPoints int) models.TaxRate {
+	return models.TaxRate{Name: &name, Country: &country, Postal_Prefix: prefix, Tax_Class: &class, Basis_Points: basisPoints}
+}
+
+func TestTableCalculate(t *testing.T) {
+	rates := []models.TaxRate{
+		rate("VAT", "DE", "", models.TaxStandard, 1900),
+		rate("State", "US", "", models.TaxStandard, 600),
+		rate("State", "US", "100", models.TaxStandard, 400),
+		rate("City", "US", "10001", models.TaxStandard, 450),
+	}
+	table := NewTable(func(ctx context.Context, country string) ([]models.TaxRate, error) {
+		return rates, nil
+	})
+
+	country, postal := "us", "10001"
+	request := Request{
+		Address: &models.Address{Country: &country, PinCode: &postal},
+		Lines:   []Line{{Tax_Class: models.TaxStandard, Amount: 1000}, {Tax_Class: models.TaxStandard, Amount: 500}},
+	}
+	summary, err := table.Calculate(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the most specific state rate applies, along with the city rate
+	if line := summary.Lines[0]; line.Basis_Points != 850 || line.Tax != 85 || line.Gross != 1085 {
+		t.Errorf("exclusive line = %+v, want 850 basis points, 85 tax and 1085 gross", line)
+	}
+	if summary.Net != 1500 || summary.Tax != 128 || summary.Gross != 1628 {
+		t.Errorf("exclusive summary = %d net, %d tax, %d gross, want 1500, 128, 1628", summary.Net, summary.Tax, summary.Gross)
+	}
+
+	country = "DE"
+	request.PricesIncludeTax = true
+	summary, err = table.Calculate(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Net != 1260 || summary.Tax != 240 || summary.Gross != 1500 {
+		t.Errorf("inclusive summary = %d net, %d tax, %d gross, want 1260, 240, 1500", summary.Net, summary.Tax, summary.Gross)
+	}
+
+	request.Address = &models.Address{}
+	if _, err := table.Calculate(context.Background(), request); err != ErrNoJurisdiction {
+		t.Errorf("Calculate without a country: err = %v, want %v", err, ErrNoJurisdiction)
+	}
+}
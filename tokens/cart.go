@@ -0,0 +1,48 @@
+package tokens
+
+import (
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+// cartAudience marks guest cart tokens so they can't pass for user tokens.
+const cartAudience = "guest_cart"
+
+// CartTokenLifetime is how long a guest cart token, and the cart behind it, lasts.
+const CartTokenLifetime = 30 * 24 * time.Hour
+
+// CartClaims identifies the guest cart a token was issued for.
+type CartClaims struct {
+	Cart_ID string
+	jwt.StandardClaims
+}
+
+// CartTokenGenerator signs a token for an anonymous visitor's cart
+func CartTokenGenerator(cartID string) (string, error) {
+	claims := &CartClaims{
+		Cart_ID: cartID,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  cartAudience,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(CartTokenLifetime).Unix(),
+		},
+	}
+	return sign(claims)
+}
+
+// ValidateCartToken takes a signed guest cart token and returns its claims and an error message
+func ValidateCartToken(signedToken string) (claims *CartClaims, message string) {
+	token, err := jwt.ParseWithClaims(signedToken, &CartClaims{}, verificationKey)
+	if err != nil {
+		message = err.Error()
+		return
+	}
+
+	claims, ok := token.Claims.(*CartClaims)
+	if !ok || !claims.VerifyAudience(cartAudience, true) || claims.Cart_ID == "" {
+		message = "the cart token is invalid!"
+		return
+	}
+	return claims, message
+}
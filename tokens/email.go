@@ -0,0 +1,51 @@
+package tokens
+
+import (
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+// emailAudience marks email verification tokens so they can't pass for user tokens.
+const emailAudience = "email_verification"
+
+// EmailTokenLifetime is how long an email verification link stays valid.
+const EmailTokenLifetime = 24 * time.Hour
+
+// EmailClaims names the user and the address a verification token was sent to, so the
+// token stops working once the user changes address.
+type EmailClaims struct {
+	Uid   string
+	Email string
+	jwt.StandardClaims
+}
+
+// EmailTokenGenerator signs a token proving the user received mail at the address
+func EmailTokenGenerator(uid string, email string) (string, error) {
+	claims := &EmailClaims{
+		Uid:   uid,
+		Email: email,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  emailAudience,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(EmailTokenLifetime).Unix(),
+		},
+	}
+	return sign(claims)
+}
+
+// ValidateEmailToken takes a signed email verification token and returns its claims and an error message
+func ValidateEmailToken(signedToken string) (claims *EmailClaims, message string) {
+	token, err := jwt.ParseWithClaims(signedToken, &EmailClaims{}, verificationKey)
+	if err != nil {
+		message = err.Error()
+		return
+	}
+
+	claims, ok := token.Claims.(*EmailClaims)
+	if !ok || !claims.VerifyAudience(emailAudience, true) || claims.Uid == "" || claims.Email == "" {
+		message = "the verification token is invalid!"
+		return
+	}
+	return claims, message
+}
@@ -0,0 +1,354 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrNoSigningKey   = errors.New("no signing key is configured, set JWT_SIGNING_KEY to a PEM private key")
+	ErrUnsupportedKey = errors.New("only RSA keys of at least 2048 bits and P-256 EC keys are supported")
+	ErrNoPEM          = errors.New("the file holds no PEM block")
+	ErrUnknownKey     = errors.New("the token is signed with an unknown or retired key")
+	ErrNoRetirement   = errors.New("previous keys must say when they were retired, as kid=file@2006-01-02T15:04:05Z")
+)
+
+// Key is a key tokens are signed or verified with, named by its kid.
+type Key struct {
+	ID        string
+	Algorithm string
+	// Public verifies the tokens signed with the key.
+	Public crypto.PublicKey
+	// private is only set on keys that can sign.
+	private crypto.Signer
+	// Retired_At is when the key stopped being the signing key; retired keys keep verifying
+	// tokens for the grace period of the key set.
+	Retired_At *time.Time
+}
+
+// NewKey wraps a private key, which can sign, or a public one, which can only verify. An empty
+// id defaults to the RFC 7638 thumbprint of the public key.
+func NewKey(id string, key interface{}) (*Key, error) {
+	k := &Key{ID: id}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.private, k.Public = key, &key.PublicKey
+	case *ecdsa.PrivateKey:
+		k.private, k.Public = key, &key.PublicKey
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		k.Public = key
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < 2048 {
+			return nil, ErrUnsupportedKey
+		}
+		k.Algorithm = jwt.SigningMethodRS256.Alg()
+	case *ecdsa.PublicKey:
+		if public.Curve != elliptic.P256() {
+			return nil, ErrUnsupportedKey
+		}
+		k.Algorithm = jwt.SigningMethodES256.Alg()
+	}
+
+	if k.ID == "" {
+		k.ID = thumbprint(k.JWK())
+	}
+	return k, nil
+}
+
+// ParseKeyPEM reads the first PEM block of data: a PKCS#1, PKCS#8 or SEC 1 private key, or a
+// PKIX or PKCS#1 public key.
+func ParseKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEM
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unreadable %s block: %w", block.Type, ErrUnsupportedKey)
+}
+
+// LoadKeyFile reads a PEM key file into a Key with the id, see NewKey.
+func LoadKeyFile(id, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := ParseKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, err := NewKey(id, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// method returns the jwt signing method of the key.
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// JWK is a public key as published in a JSON Web Key Set, RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public half of the key as a JWK.
+func (k *Key) JWK() JWK {
+	jwk := JWK{Use: "sig", Alg: k.Algorithm, Kid: k.ID}
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, 32)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, 32)))
+	}
+	return jwk
+}
+
+// thumbprint is the RFC 7638 thumbprint of a JWK: the sha256 of its required members in
+// lexicographic order.
+func thumbprint(jwk JWK) string {
+	var canonical string
+	if jwk.Kty == "RSA" {
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	} else {
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Crv, jwk.X, jwk.Y)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// KeySet holds the signing key and the keys still accepted when verifying. It is safe for
+// concurrent use, so keys can be rotated while serving.
+type KeySet struct {
+	mu     sync.RWMutex
+	active *Key
+	keys   map[string]*Key
+	// Grace is how long a retired key keeps verifying tokens. It should outlast the longest
+	// lived token signed with it.
+	Grace time.Duration
+}
+
+// Rotate makes active the signing key and keeps previous as verification keys. Previous keys
+// must carry their Retired_At, unless the set retired them already or they are the key being
+// replaced, which is retired at now. Keys retired for longer than the grace period are dropped.
+func (s *KeySet) Rotate(active *Key, previous []*Key, now time.Time) error {
+	if active == nil || active.private == nil {
+		return ErrNoSigningKey
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, listed := map[string]*Key{}, map[string]bool{}
+	retire := func(key *Key, at *time.Time) {
+		if key.ID == active.ID {
+			return
+		}
+		retired := *key
+		retired.Retired_At = at
+		if now.Sub(*at) <= s.Grace {
+			keys[key.ID] = &retired
+		}
+	}
+	for _, key := range previous {
+		at := key.Retired_At
+		if old, ok := s.keys[key.ID]; at == nil && ok && old.Retired_At != nil {
+			at = old.Retired_At
+		}
+		if at == nil && s.active != nil && s.active.ID == key.ID {
+			at = &now
+		}
+		if at == nil && key.ID != active.ID {
+			// a time made up here would start the grace period over on every restart
+			return fmt.Errorf("key %s: %w", key.ID, ErrNoRetirement)
+		}
+		retire(key, at)
+		listed[key.ID] = true
+	}
+	if s.active != nil && !listed[s.active.ID] {
+		retire(s.active, &now)
+	}
+
+	s.active = active
+	keys[active.ID] = active
+	s.keys = keys
+	return nil
+}
+
+// Signing returns the key new tokens are signed with.
+func (s *KeySet) Signing() (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.active == nil {
+		return nil, ErrNoSigningKey
+	}
+	return s.active, nil
+}
+
+// Lookup returns the key with the id while it still verifies tokens.
+func (s *KeySet) Lookup(id string, now time.Time) (*Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[id]
+	if !ok || (key.Retired_At != nil && now.Sub(*key.Retired_At) > s.Grace) {
+		return nil, false
+	}
+	return key, true
+}
+
+// JWKS returns the public keys still verifying tokens, the signing key first.
+func (s *KeySet) JWKS(now time.Time) JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set := JWKS{Keys: []JWK{}}
+	if s.active != nil {
+		set.Keys = append(set.Keys, s.active.JWK())
+	}
+	ids := make([]string, 0, len(s.keys))
+	for id, key := range s.keys {
+		if key != s.active && now.Sub(*key.Retired_At) <= s.Grace {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		set.Keys = append(set.Keys, s.keys[id].JWK())
+	}
+	return set
+}
+
+// Keys signs and verifies every token of the application, see LoadKeys.
+var Keys = &KeySet{}
+
+// LoadKeys (re)loads Keys from the environment: JWT_SIGNING_KEY is the PEM file of the private
+// signing key, named JWT_SIGNING_KID or its thumbprint, and JWT_PREVIOUS_KEYS lists the PEM files
+// of keys it replaced, separated by commas, each optionally prefixed with "kid=" and followed by
+// "@" and the RFC 3339 time it was retired at. Previous keys verify tokens for JWT_KEY_GRACE after
+// that time, whenever the application starts. Loading again, on SIGHUP for instance, rotates to
+// the configured signing key.
+func LoadKeys() error {
+	path := os.Getenv("JWT_SIGNING_KEY")
+	if path == "" {
+		return ErrNoSigningKey
+	}
+	active, err := LoadKeyFile(os.Getenv("JWT_SIGNING_KID"), path)
+	if err != nil {
+		return err
+	}
+	if active.private == nil {
+		return fmt.Errorf("%s: %w", path, ErrNoSigningKey)
+	}
+
+	var previous []*Key
+	for _, entry := range strings.Split(os.Getenv("JWT_PREVIOUS_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, file := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			id, file = entry[:i], entry[i+1:]
+		}
+		var retiredAt *time.Time
+		if i := strings.LastIndex(file, "@"); i >= 0 {
+			at, err := time.Parse(time.RFC3339, file[i+1:])
+			if err != nil {
+				return fmt.Errorf("%s: %w", entry, ErrNoRetirement)
+			}
+			file, retiredAt = file[:i], &at
+		}
+		key, err := LoadKeyFile(id, file)
+		if err != nil {
+			return err
+		}
+		key.Retired_At = retiredAt
+		previous = append(previous, key)
+	}
+
+	grace, err := time.ParseDuration(os.Getenv("JWT_KEY_GRACE"))
+	if err != nil || grace <= 0 {
+		grace = CartTokenLifetime
+	}
+	Keys.mu.Lock()
+	Keys.Grace = grace
+	Keys.mu.Unlock()
+	return Keys.Rotate(active, previous, time.Now())
+}
+
+// sign signs the claims with the signing key, naming it in the kid header.
+func sign(claims jwt.Claims) (string, error) {
+	key, err := Keys.Signing()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// verificationKey finds the key a token names in its kid header, refusing any algorithm other
+// than the one of the key so that a public key can't be passed off as an HMAC secret.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+	key, ok := Keys.Lookup(id, time.Now())
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.Public, nil
+}
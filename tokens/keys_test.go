@@ -0,0 +1,299 @@
+//go:build integration
+
+// The package connects to MongoDB when it is loaded, so its tests need the database too.
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+// ecKey returns a new P-256 signing key with the id.
+func ecKey(t *testing.T, id string) *Key {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewKey(id, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// rsaKey returns a new 2048 bit RSA signing key with the id.
+func rsaKey(t *testing.T, id string) *Key {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewKey(id, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// useKeys makes set the keys of the package for the test.
+func useKeys(t *testing.T, set *KeySet) {
+	saved := Keys
+	Keys = set
+	t.Cleanup(func() { Keys = saved })
+}
+
+func TestLookup(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	old, current := ecKey(t, "old"), ecKey(t, "current")
+	set := &KeySet{Grace: time.Hour}
+	if err := set.Rotate(old, nil, start); err != nil {
+		t.Fatal(err)
+	}
+	rotated := start.Add(10 * time.Minute)
+	if err := set.Rotate(current, nil, rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		id    string
+		at    time.Time
+		found bool
+	}{
+		{"signing key", "current", rotated.Add(24 * time.Hour), true},
+		{"retired key within its grace period", "old", rotated.Add(time.Hour), true},
+		{"retired key after its grace period", "old", rotated.Add(time.Hour + time.Second), false},
+		{"unknown kid", "other", rotated, false},
+		{"no kid", "", rotated, false},
+	}
+	for _, test := range tests {
+		if _, found := set.Lookup(test.id, test.at); found != test.found {
+			t.Errorf("%s: found = %v, want %v", test.name, found, test.found)
+		}
+	}
+}
+
+func TestRotatePrevious(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	retired := func(key *Key, at time.Time) *Key {
+		key.Retired_At = &at
+		return key
+	}
+
+	tests := []struct {
+		name     string
+		previous *Key
+		err      error
+		kept     bool
+	}{
+		{"retired within the grace period", retired(ecKey(t, "old"), now.Add(-time.Minute)), nil, true},
+		{"retired before the grace period", retired(ecKey(t, "old"), now.Add(-2*time.Hour)), nil, false},
+		{"without a retirement time", ecKey(t, "old"), ErrNoRetirement, false},
+	}
+	for _, test := range tests {
+		set := &KeySet{Grace: time.Hour}
+		err := set.Rotate(ecKey(t, "current"), []*Key{test.previous}, now)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if _, kept := set.Lookup("old", now); kept != test.kept {
+			t.Errorf("%s: kept = %v, want %v", test.name, kept, test.kept)
+		}
+	}
+}
+
+// The set keeps the retirement time of the keys it retired itself when they are listed again.
+func TestRotateKeepsRetirement(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	old, current := ecKey(t, "old"), ecKey(t, "current")
+	set := &KeySet{Grace: time.Hour}
+	if err := set.Rotate(old, nil, start); err != nil {
+		t.Fatal(err)
+	}
+	listed := *old
+	if err := set.Rotate(current, []*Key{&listed}, start); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Rotate(current, []*Key{&listed}, start.Add(50*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := set.Lookup("old", start.Add(time.Hour+time.Second)); found {
+		t.Error("reloading started the grace period of the retired key over")
+	}
+}
+
+func TestVerificationKey(t *testing.T) {
+	ec, rs := ecKey(t, "ec"), rsaKey(t, "rsa")
+	set := &KeySet{Grace: time.Hour}
+	if err := set.Rotate(rs, nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Rotate(ec, nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, set)
+
+	tests := []struct {
+		name   string
+		kid    string
+		method jwt.SigningMethod
+		valid  bool
+	}{
+		{"signing EC key", "ec", jwt.SigningMethodES256, true},
+		{"retired RSA key", "rsa", jwt.SigningMethodRS256, true},
+		{"RS256 header on the EC key", "ec", jwt.SigningMethodRS256, false},
+		{"ES256 header on the RSA key", "rsa", jwt.SigningMethodES256, false},
+		{"HS256 header on the EC key", "ec", jwt.SigningMethodHS256, false},
+		{"unknown kid", "other", jwt.SigningMethodES256, false},
+	}
+	for _, test := range tests {
+		token := &jwt.Token{Header: map[string]interface{}{"kid": test.kid}, Method: test.method}
+		if _, err := verificationKey(token); (err == nil) != test.valid {
+			t.Errorf("%s: err = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+
+	// a token signed with the EC key verifies, one signed with its public key as an HMAC secret doesn't
+	signed, err := sign(jwt.StandardClaims{Subject: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(signed, verificationKey); err != nil {
+		t.Errorf("the signed token doesn't verify: %v", err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "admin"})
+	forged.Header["kid"] = "ec"
+	secret, _ := x509.MarshalPKIXPublicKey(ec.Public)
+	forgedToken, err := forged.SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(forgedToken, verificationKey); err == nil {
+		t.Error("a token signed with the public key as an HMAC secret verifies")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	now := time.Now()
+	old, current := rsaKey(t, "old"), ecKey(t, "")
+	set := &KeySet{Grace: time.Hour}
+	if err := set.Rotate(old, nil, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Rotate(current, nil, now); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(set.JWKS(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published JWKS
+	if err := json.Unmarshal(data, &published); err != nil {
+		t.Fatal(err)
+	}
+	if len(published.Keys) != 2 || published.Keys[0].Kid != current.ID || published.Keys[1].Kid != "old" {
+		t.Fatalf("JWKS = %s, want the signing key then the retired one", data)
+	}
+	if current.ID != thumbprint(published.Keys[0]) {
+		t.Errorf("the kid %s of a key without an id isn't its thumbprint", current.ID)
+	}
+
+	for i, key := range []*Key{current, old} {
+		jwk := published.Keys[i]
+		var public interface{ Equal(x crypto.PublicKey) bool }
+		switch jwk.Kty {
+		case "EC":
+			public = &ecdsa.PublicKey{Curve: elliptic.P256(), X: decodeInt(t, jwk.X), Y: decodeInt(t, jwk.Y)}
+		case "RSA":
+			public = &rsa.PublicKey{N: decodeInt(t, jwk.N), E: int(decodeInt(t, jwk.E).Int64())}
+		}
+		if public == nil || !public.Equal(key.Public) || jwk.Alg != key.Algorithm || jwk.Use != "sig" {
+			t.Errorf("%s: the published key %+v isn't the key", key.ID, jwk)
+		}
+	}
+
+	if published := set.JWKS(now.Add(2 * time.Hour)); len(published.Keys) != 1 {
+		t.Errorf("JWKS after the grace period has %d keys, want the signing key only", len(published.Keys))
+	}
+}
+
+// decodeInt decodes a base64url big endian integer of a JWK.
+func decodeInt(t *testing.T, value string) *big.Int {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return new(big.Int).SetBytes(data)
+}
+
+// writeKey writes the private key of a new P-256 key to a PEM file and returns its path.
+func writeKey(t *testing.T, name string) string {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKeysPrevious(t *testing.T) {
+	active, previous := writeKey(t, "active.pem"), writeKey(t, "previous.pem")
+	retired := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	t.Setenv("JWT_SIGNING_KEY", active)
+	t.Setenv("JWT_SIGNING_KID", "active")
+	t.Setenv("JWT_KEY_GRACE", "1h")
+
+	tests := []struct {
+		name    string
+		entries string
+		valid   bool
+	}{
+		{"kid, file and retirement time", "old=" + previous + "@" + retired, true},
+		{"file and retirement time", previous + "@" + retired, true},
+		{"no retirement time", "old=" + previous, false},
+		{"malformed retirement time", "old=" + previous + "@yesterday", false},
+		{"retirement time without a zone", "old=" + previous + "@2024-01-01T00:00:00", false},
+		{"missing file", "old=" + previous + ".missing@" + retired, false},
+		{"empty entries", " , ", true},
+	}
+	for _, test := range tests {
+		useKeys(t, &KeySet{})
+		t.Setenv("JWT_PREVIOUS_KEYS", test.entries)
+		err := LoadKeys()
+		if (err == nil) != test.valid {
+			t.Errorf("%s: err = %v, want valid %v", test.name, err, test.valid)
+		}
+		if err == nil && test.name == "kid, file and retirement time" {
+			if _, found := Keys.Lookup("old", time.Now()); !found {
+				t.Errorf("%s: the previous key doesn't verify", test.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package tokens
+
+import (
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+// mfaAudience marks the tokens of a login waiting for its second factor, so they can't pass
+// for user tokens.
+const mfaAudience = "mfa_pending"
+
+// MfaTokenLifetime is how long a user has to enter the second factor after the password.
+const MfaTokenLifetime = 5 * time.Minute
+
+// MfaClaims names the user who got the password right, and their session version at the time
+// so that a password change in between voids the login.
+type MfaClaims struct {
+	Uid     string
+	Session int
+	jwt.StandardClaims
+}
+
+// MfaTokenGenerator signs a token standing for the first half of a two-factor login
+func MfaTokenGenerator(uid string, session int) (string, error) {
+	claims := &MfaClaims{
+		Uid:     uid,
+		Session: session,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  mfaAudience,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(MfaTokenLifetime).Unix(),
+		},
+	}
+	return sign(claims)
+}
+
+// ValidateMfaToken takes a signed mfa pending token and returns its claims and an error message
+func ValidateMfaToken(signedToken string) (claims *MfaClaims, message string) {
+	token, err := jwt.ParseWithClaims(signedToken, &MfaClaims{}, verificationKey)
+	if err != nil {
+		message = err.Error()
+		return
+	}
+
+	claims, ok := token.Claims.(*MfaClaims)
+	if !ok || !claims.VerifyAudience(mfaAudience, true) || claims.Uid == "" {
+		message = "the two-factor login token is invalid!"
+		return
+	}
+	return claims, message
+}
@@ -3,7 +3,6 @@ package tokens
 import (
 	"context"
 	"log"
-	"os"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt"
@@ -19,20 +18,23 @@ type SignedDetails struct {
 	First_Name string
 	Last_Name  string
 	Uid        string
+	// Session is the session version of the user when the token was issued, bumping the
+	// version revokes every token issued before
+	Session int
 	jwt.StandardClaims
 }
 
 var UserData *mongo.Collection = database.UserData(database.Client, "Users")
-var SECRET_KEY = os.Getenv("SECRET_KEY")
 
 // TokenGenerator generates a JWT and a refresh JWT
-func TokenGenerator(email string, firstName string, lastName string, uid string) (signedToken string, signedRefreshToken string, err error) {
+func TokenGenerator(email string, firstName string, lastName string, uid string, session int) (signedToken string, signedRefreshToken string, err error) {
 	// create a new instance of SignedDetails
 	claims := &SignedDetails{
 		Email:      email,
 		First_Name: firstName,
 		Last_Name:  lastName,
 		Uid:        uid,
+		Session:    session,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
 		},
@@ -40,20 +42,21 @@ func TokenGenerator(email string, firstName string, lastName string, uid string)
 
 	// create a new instance of SignedDetails for refresh token
 	refreshClaims := &SignedDetails{
+		Session: session,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
 		},
 	}
 
-	// create a new JWT using the claims and the secret key
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	// sign the JWT using the claims and the signing key
+	token, err := sign(claims)
 
 	if err != nil {
 		return "", "", err
 	}
 
-	// create a new refresh JWT using the refresh claims and the secret key
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS384, refreshClaims).SignedString([]byte(SECRET_KEY))
+	// sign the refresh JWT using the refresh claims and the signing key
+	refreshToken, err := sign(refreshClaims)
 	if err != nil {
 		log.Panic(err)
 		return
@@ -65,10 +68,8 @@ func TokenGenerator(email string, firstName string, lastName string, uid string)
 // ValidateToken takes a signed JWT token and returns the decoded claims and an error message
 func ValidateToken(signedToken string) (claims *SignedDetails, message string) {
 	// token is a jwt.Token struct that holds the parsed JWT token
-	token, err := jwt.ParseWithClaims(signedToken, &SignedDetails{}, func(t *jwt.Token) (interface{}, error) {
-		// SECRET_KEY is a string containing the secret key used to sign the JWT token
-		return []byte(SECRET_KEY), nil
-	})
+	// the key is picked by the kid header of the token, see verificationKey
+	token, err := jwt.ParseWithClaims(signedToken, &SignedDetails{}, verificationKey)
 
 	// if there is an error parsing the JWT token, return the error message
 	if err != nil {
@@ -84,6 +85,12 @@ func ValidateToken(signedToken string) (claims *SignedDetails, message string) {
 		return
 	}
 
+	// guest cart, email verification and mfa pending tokens are signed with the same keys but aren't logins
+	if claims.Audience != "" {
+		message = "the token is invalid!"
+		return
+	}
+
 	// check if the token has expired
 	if claims.ExpiresAt < time.Now().Local().Unix() {
 		message = "token is expired"
@@ -0,0 +1,127 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of RFC 6238 Appendix B, "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The SHA1 test vectors of RFC 6238 Appendix B, keeping the last 6 of their 8 digits.
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", test.unix, err)
+		}
+		if code != test.code {
+			t.Errorf("Code at %d = %s, want %s", test.unix, code, test.code)
+		}
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err != ErrInvalidSecret {
+		t.Errorf("Code with an invalid secret: err = %v, want %v", err, ErrInvalidSecret)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		at   time.Time
+		ok   bool
+		step int64
+	}{
+		{"current period", code, now, true, Step(now)},
+		{"surrounding spaces", " " + code + " ", now, true, Step(now)},
+		{"one period later", code, now.Add(Period), true, Step(now)},
+		{"one period earlier", code, now.Add(-Period), true, Step(now)},
+		{"two periods later", code, now.Add(2 * Period), false, 0},
+		{"two periods earlier", code, now.Add(-2 * Period), false, 0},
+		{"wrong code", "000000", now, false, 0},
+		{"too short", code[:5], now, false, 0},
+	}
+	for _, test := range tests {
+		step, ok := Verify(rfcSecret, test.code, test.at)
+		if ok != test.ok || step != test.step {
+			t.Errorf("%s: Verify = %d, %v, want %d, %v", test.name, step, ok, test.step, test.ok)
+		}
+	}
+}
+
+// Verify returns the step a code matched so callers can refuse it the second time: the same
+// code matches the same step however late it is replayed within the skew.
+func TestVerifyReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, ok := Verify(rfcSecret, code, now)
+	if !ok {
+		t.Fatal("the code of the current period was refused")
+	}
+	replayed, ok := Verify(rfcSecret, code, now.Add(Period))
+	if !ok || replayed != first {
+		t.Errorf("replayed code matched step %d, %v, want step %d", replayed, ok, first)
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"ABCDE-FGHJK", "ABCDE-FGHJK"},
+		{"abcde-fghjk", "ABCDE-FGHJK"},
+		{"ABCDEFGHJK", "ABCDE-FGHJK"},
+		{"abcde fghjk", "ABCDE-FGHJK"},
+		{" ab-cde fg-hjk ", "ABCDE-FGHJK"},
+		{"ABCDE", "ABCDE"},
+	}
+	for _, test := range tests {
+		if got := NormalizeRecoveryCode(test.code); got != test.want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", test.code, got, test.want)
+		}
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("got %d codes, want 10", len(codes))
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if NormalizeRecoveryCode(code) != code {
+			t.Errorf("code %q is not in its normal form", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q was issued twice", code)
+		}
+		seen[code] = true
+	}
+}